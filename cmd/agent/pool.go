@@ -63,7 +63,7 @@ func (sw *SendWorker) Run() {
 			sw.mtx.Lock()
 
 			options := sender.SendOptions{
-				MemStorage:    sw.mStor,
+				MemStorage:    &sw.mStor,
 				Client:        sw.cl,
 				ServerAddress: sw.serverAddress,
 				SignKey:       sw.signKey,
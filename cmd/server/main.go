@@ -2,11 +2,11 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"syscall"
 	"time"
 
@@ -15,6 +15,7 @@ import (
 	"github.com/dvkhr/metrix.git/internal/handlers"
 	"github.com/dvkhr/metrix.git/internal/logging"
 	"github.com/dvkhr/metrix.git/internal/routes"
+	"github.com/dvkhr/metrix.git/internal/tracing"
 	"github.com/go-chi/chi/v5"
 
 	_ "net/http/pprof" // Импортируем pprof
@@ -25,9 +26,10 @@ var buildDate string
 var buildCommit string
 
 var (
-	cfg          config.ConfigServ
-	MetricServer *handlers.MetricsServer
-	server       *http.Server
+	cfg            config.ConfigServ
+	MetricServer   *handlers.MetricsServer
+	server         *http.Server
+	shutdownTracer func(context.Context) error
 )
 
 // ./server -crypto-key= "/home/max/go/src/metrix/cmd/server/private_key.pem"
@@ -36,26 +38,31 @@ func init() {
 
 	var err error
 
-	// Установка рабочей директории в корень проекта
-	exeDir := filepath.Dir(os.Args[0])             // Директория исполняемого файла
-	projectRoot := filepath.Join(exeDir, "../../") // Поднимаемся на два уровня выше
-	if err := os.Chdir(projectRoot); err != nil {
-		fmt.Printf("Failed to change working directory to %s: %v", projectRoot, err)
-		os.Exit(1)
-	}
-	// Инициализация глобального логгера
+	// Инициализация глобального логгера. Если файла конфигурации нет в рабочем каталоге,
+	// используется встроенная конфигурация по умолчанию.
 	if err = logging.InitLogger("internal/config/logger_config.json"); err != nil {
 		// Логируем ошибку и завершаем программу с кодом ошибки
 		fmt.Printf("Failed to initialize logger: %v\n", err)
 		os.Exit(1) // Завершаем программу с кодом ошибки
 	}
-	go startPProf()
-
 	if err = cfg.ParseFlags(); err != nil {
 		logging.Logg.Error("Failed to parse configuration: %v", err)
 		os.Exit(1)
 	}
 
+	// Подкоманда migrate работает только со схемой БД и не запускает HTTP-сервер.
+	if flag.Arg(0) == migrateCommandName {
+		return
+	}
+
+	go startPProf()
+
+	shutdownTracer, err = tracing.Init(context.Background(), cfg.OTLPEndpoint, "metrix-server")
+	if err != nil {
+		logging.Logg.Error("Failed to initialize tracing", "error", err)
+		os.Exit(1)
+	}
+
 	MetricServer, err = handlers.NewMetricsServer(cfg)
 	if err != nil {
 		logging.Logg.Error("Unable to initialize storage: %v", err)
@@ -72,11 +79,19 @@ func init() {
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	// Shutdown не прерывает активные соединения, поэтому открытые потоки обновлений
+	// завершаются закрытием хаба.
+	server.RegisterOnShutdown(MetricServer.Hub.Close)
 }
 
 func main() {
 	buildinfo.PrintBuildInfo(buildVersion, buildDate, buildCommit)
 
+	if flag.Arg(0) == migrateCommandName {
+		runMigrate(flag.Args()[1:])
+		return
+	}
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 
@@ -96,7 +111,13 @@ func main() {
 		logging.Logg.Error("Server shutdown error", "error", err)
 	}
 
-	MetricServer.MetricStorage.FreeStorage()
+	if err := MetricServer.Close(); err != nil {
+		logging.Logg.Error("Storage shutdown error", "error", err)
+	}
+
+	if err := shutdownTracer(ctx); err != nil {
+		logging.Logg.Error("Tracer shutdown error", "error", err)
+	}
 
 	logging.Logg.Info("Server stopped")
 }
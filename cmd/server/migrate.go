@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/storage"
+)
+
+// migrateCommandName — имя подкоманды управления схемой БД.
+const migrateCommandName = "migrate"
+
+const migrateUsage = "usage: server -storage <dsn> migrate up | down [N] | status"
+
+// runMigrate выполняет подкоманду migrate и завершает процесс с кодом 1 при ошибке.
+//
+// Примеры:
+//
+//	./server -d "postgres://..." migrate up
+//	./server -d "postgres://..." migrate down 1
+//	./server -d "postgres://..." migrate status
+func runMigrate(args []string) {
+	dsn := cfg.DBDsn
+	if cfg.Storage != "" {
+		dsn = cfg.Storage
+	}
+	if err := migrateCommand(context.Background(), dsn, args, os.Stdout); err != nil {
+		logging.Logg.Error("Migration command failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// migrateCommand применяет (up), откатывает (down [N], по умолчанию одну версию)
+// или выводит состояние (status) миграций схемы PostgreSQL по адресу dsn.
+func migrateCommand(ctx context.Context, dsn string, args []string, out io.Writer) error {
+	if dsn == "" {
+		return errors.New("database DSN is required (-storage, -d or DATABASE_DSN)")
+	}
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	dsn, _ = storage.PgxDSN(dsn)
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := storage.NewPostgresMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(out, "applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q: %s", args[1], migrateUsage)
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, m := range rolledBack {
+			fmt.Fprintf(out, "rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown migrate command %q: %s", args[0], migrateUsage)
+}
@@ -0,0 +1,252 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/selfmetrics"
+	"github.com/dvkhr/metrix.git/internal/service"
+)
+
+// State — состояние оповещения.
+type State string
+
+// Состояния оповещения. Условие правила переводит оповещение из inactive или resolved
+// в pending, а после выдержки "for" — в firing; невыполненное условие переводит pending
+// в inactive, а firing — в resolved.
+const (
+	StateInactive State = "inactive"
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// Метрики самонаблюдения оповещений.
+var (
+	firingAlerts = selfmetrics.Default.Gauge("metrix_alerts_firing",
+		"Number of alerts in the firing state.")
+	notificationFailures = selfmetrics.Default.Counter("metrix_alert_notification_failures_total",
+		"Number of alert notifications that failed to send.")
+)
+
+// Alert — состояние оповещения одного правила.
+//
+// Поля:
+//   - Rule, Expr, Severity, Summary: Поля правила.
+//   - State: Текущее состояние.
+//   - Value: Значение из последней проверки (для rate — скорость в секунду); nil, если метрики нет
+//     или для rate еще нет предыдущего значения.
+//   - ActiveSince: Время, с которого условие выполняется подряд.
+//   - FiredAt: Время последнего срабатывания.
+//   - ResolvedAt: Время последнего снятия.
+type Alert struct {
+	Rule        string     `json:"rule"`
+	Expr        string     `json:"expr"`
+	Severity    string     `json:"severity,omitempty"`
+	Summary     string     `json:"summary,omitempty"`
+	State       State      `json:"state"`
+	Value       *float64   `json:"value,omitempty"`
+	ActiveSince *time.Time `json:"active_since,omitempty"`
+	FiredAt     *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// sample — значение метрики в момент проверки, нужное для rate.
+type sample struct {
+	value float64
+	at    time.Time
+}
+
+// ruleState — правило и состояние его оповещения.
+type ruleState struct {
+	cond  Condition
+	alert Alert
+	prev  *sample
+}
+
+// Engine проверяет правила и рассылает уведомления о смене состояния оповещений.
+// Безопасен для одновременного использования.
+type Engine struct {
+	notifiers []Notifier
+
+	mu    sync.RWMutex
+	rules []*ruleState
+}
+
+// NewEngine создает движок правил rules с уведомителями notifiers.
+//
+// Возвращаемые значения:
+// - *Engine: Движок, все оповещения которого в состоянии inactive.
+// - error: Ошибка, оборачивающая ErrInvalidRule, если у правила нет имени, имя повторяется
+// или выражение не разбирается.
+func NewEngine(rules []Rule, notifiers ...Notifier) (*Engine, error) {
+	e := &Engine{notifiers: notifiers}
+	seen := make(map[string]struct{}, len(rules))
+	for _, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("%w: rule %q has no name", ErrInvalidRule, r.Expr)
+		}
+		if _, dup := seen[r.Name]; dup {
+			return nil, fmt.Errorf("%w: duplicate rule name %q", ErrInvalidRule, r.Name)
+		}
+		seen[r.Name] = struct{}{}
+		cond, err := ParseCondition(r.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		e.rules = append(e.rules, &ruleState{cond: cond, alert: Alert{
+			Rule: r.Name, Expr: r.Expr, Severity: r.Severity, Summary: r.Summary, State: StateInactive,
+		}})
+	}
+	return e, nil
+}
+
+// observe возвращает значение, с которым сравнивается порог правила, или false,
+// если значения нет. Для rate запоминает текущее значение до следующей проверки;
+// уменьшение счетчика считается его сбросом.
+func (rs *ruleState) observe(metrics map[string]service.Metrics, now time.Time) (float64, bool) {
+	mt, ok := metrics[rs.cond.Metric]
+	if !ok || mt.MType != rs.cond.Type {
+		rs.prev = nil
+		return 0, false
+	}
+	v, ok := mt.Float()
+	if !ok || math.IsNaN(v) {
+		rs.prev = nil
+		return 0, false
+	}
+	if !rs.cond.Rate {
+		return v, true
+	}
+
+	prev := rs.prev
+	rs.prev = &sample{value: v, at: now}
+	if prev == nil || !now.After(prev.at) {
+		return 0, false
+	}
+	delta := v - prev.value
+	if rs.cond.Type == service.CounterMetric && delta < 0 {
+		delta = v
+	}
+	return delta / now.Sub(prev.at).Seconds(), true
+}
+
+// step переводит оповещение в следующее состояние и сообщает, нужно ли уведомление.
+func (rs *ruleState) step(v float64, ok bool, now time.Time) bool {
+	a := &rs.alert
+	a.Value = nil
+	if ok {
+		a.Value = &v
+	}
+
+	if ok && rs.cond.holds(v) {
+		if a.State == StateInactive || a.State == StateResolved {
+			a.State, a.ActiveSince = StatePending, &now
+		}
+		if a.State == StatePending && now.Sub(*a.ActiveSince) >= rs.cond.For {
+			a.State, a.FiredAt = StateFiring, &now
+			return true
+		}
+		return false
+	}
+
+	a.ActiveSince = nil
+	switch a.State {
+	case StatePending:
+		a.State = StateInactive
+	case StateFiring:
+		a.State, a.ResolvedAt = StateResolved, &now
+		return true
+	}
+	return false
+}
+
+// Evaluate проверяет все правила по значениям metrics на момент now и отправляет
+// уведомления о сработавших и снятых оповещениях. Если метрики правила нет в metrics,
+// условие считается невыполненным. Ошибки уведомителей записываются в журнал.
+func (e *Engine) Evaluate(ctx context.Context, metrics []service.Metrics, now time.Time) {
+	byID := make(map[string]service.Metrics, len(metrics))
+	for _, mt := range metrics {
+		byID[mt.ID] = mt
+	}
+
+	var changed []Alert
+	firing := 0
+	e.mu.Lock()
+	for _, rs := range e.rules {
+		v, ok := rs.observe(byID, now)
+		if rs.step(v, ok, now) {
+			changed = append(changed, rs.alert.clone())
+		}
+		if rs.alert.State == StateFiring {
+			firing++
+		}
+	}
+	e.mu.Unlock()
+	firingAlerts.Set(float64(firing))
+
+	for _, alert := range changed {
+		for _, n := range e.notifiers {
+			if err := n.Notify(ctx, alert); err != nil {
+				notificationFailures.Inc()
+				logging.Logg.ErrorContext(ctx, "Failed to send alert notification", "rule", alert.Rule, "state", alert.State, "error", err)
+			}
+		}
+	}
+}
+
+// Alerts возвращает копию состояния оповещений всех правил в порядке правил.
+func (e *Engine) Alerts() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	alerts := make([]Alert, len(e.rules))
+	for i, rs := range e.rules {
+		alerts[i] = rs.alert.clone()
+	}
+	return alerts
+}
+
+// clone возвращает копию оповещения, не разделяющую с ним указатели.
+func (a Alert) clone() Alert {
+	a.Value = clonePtr(a.Value)
+	a.ActiveSince = clonePtr(a.ActiveSince)
+	a.FiredAt = clonePtr(a.FiredAt)
+	a.ResolvedAt = clonePtr(a.ResolvedAt)
+	return a
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// Run проверяет правила по значениям всех метрик, полученным от list, раз в interval,
+// до отмены ctx. Ошибки list записываются в журнал; очередная проверка пропускается.
+func (e *Engine) Run(ctx context.Context, interval time.Duration, list func(ctx context.Context) ([]service.Metrics, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics, err := list(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					logging.Logg.WarnContext(ctx, "Failed to read metrics for alert rules", "error", err)
+				}
+				continue
+			}
+			e.Evaluate(ctx, metrics, time.Now())
+		}
+	}
+}
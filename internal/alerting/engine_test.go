@@ -0,0 +1,200 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(id string, v float64) service.Metrics {
+	value := service.GaugeMetricValue(v)
+	return service.Metrics{ID: id, MType: service.GaugeMetric, Value: &value}
+}
+
+func counter(id string, d int64) service.Metrics {
+	delta := service.CounterMetricValue(d)
+	return service.Metrics{ID: id, MType: service.CounterMetric, Delta: &delta}
+}
+
+// recorder — уведомитель, запоминающий полученные оповещения.
+type recorder struct {
+	mu     sync.Mutex
+	alerts []Alert
+	err    error
+}
+
+func (r *recorder) Notify(_ context.Context, alert Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, alert)
+	return r.err
+}
+
+func (r *recorder) states() []State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	states := make([]State, len(r.alerts))
+	for i, a := range r.alerts {
+		states[i] = a.State
+	}
+	return states
+}
+
+func TestEngine(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	t.Run("Pending, firing and resolved", func(t *testing.T) {
+		n := &recorder{}
+		e, err := NewEngine([]Rule{{Name: "LowMemory", Expr: "gauge FreeMemory < 500MB for 2m", Severity: "warning"}}, n)
+		require.NoError(t, err)
+		assert.Equal(t, StateInactive, e.Alerts()[0].State)
+
+		e.Evaluate(ctx, []service.Metrics{gauge("FreeMemory", 100<<20)}, at(0))
+		a := e.Alerts()[0]
+		assert.Equal(t, StatePending, a.State)
+		assert.Equal(t, float64(100<<20), *a.Value)
+		assert.Equal(t, at(0), *a.ActiveSince)
+
+		e.Evaluate(ctx, []service.Metrics{gauge("FreeMemory", 100<<20)}, at(time.Minute))
+		assert.Equal(t, StatePending, e.Alerts()[0].State)
+		assert.Empty(t, n.states())
+
+		e.Evaluate(ctx, []service.Metrics{gauge("FreeMemory", 100<<20)}, at(2*time.Minute))
+		a = e.Alerts()[0]
+		assert.Equal(t, StateFiring, a.State)
+		assert.Equal(t, at(2*time.Minute), *a.FiredAt)
+		assert.Equal(t, []State{StateFiring}, n.states())
+		assert.Equal(t, "warning", n.alerts[0].Severity)
+
+		e.Evaluate(ctx, []service.Metrics{gauge("FreeMemory", 100<<20)}, at(3*time.Minute))
+		assert.Equal(t, []State{StateFiring}, n.states(), "firing is notified once")
+
+		e.Evaluate(ctx, []service.Metrics{gauge("FreeMemory", 1<<30)}, at(4*time.Minute))
+		a = e.Alerts()[0]
+		assert.Equal(t, StateResolved, a.State)
+		assert.Nil(t, a.ActiveSince)
+		assert.Equal(t, at(4*time.Minute), *a.ResolvedAt)
+		assert.Equal(t, []State{StateFiring, StateResolved}, n.states())
+
+		e.Evaluate(ctx, []service.Metrics{gauge("FreeMemory", 100<<20)}, at(5*time.Minute))
+		assert.Equal(t, StatePending, e.Alerts()[0].State)
+	})
+
+	t.Run("Pending returns to inactive", func(t *testing.T) {
+		n := &recorder{}
+		e, err := NewEngine([]Rule{{Name: "LowMemory", Expr: "gauge FreeMemory < 10 for 1m"}}, n)
+		require.NoError(t, err)
+
+		e.Evaluate(ctx, []service.Metrics{gauge("FreeMemory", 1)}, at(0))
+		e.Evaluate(ctx, []service.Metrics{gauge("FreeMemory", 20)}, at(30*time.Second))
+		assert.Equal(t, StateInactive, e.Alerts()[0].State)
+		e.Evaluate(ctx, []service.Metrics{gauge("FreeMemory", 1)}, at(time.Minute))
+		assert.Equal(t, StatePending, e.Alerts()[0].State, "duration restarts")
+		assert.Empty(t, n.states())
+	})
+
+	t.Run("Without duration fires immediately", func(t *testing.T) {
+		n := &recorder{}
+		e, err := NewEngine([]Rule{{Name: "Hot", Expr: "gauge CPU > 90"}}, n)
+		require.NoError(t, err)
+
+		e.Evaluate(ctx, []service.Metrics{gauge("CPU", 95)}, at(0))
+		assert.Equal(t, StateFiring, e.Alerts()[0].State)
+		assert.Equal(t, []State{StateFiring}, n.states())
+	})
+
+	t.Run("Rate", func(t *testing.T) {
+		n := &recorder{}
+		e, err := NewEngine([]Rule{{Name: "AgentStopped", Expr: "rate(counter PollCount) == 0 for 1m"}}, n)
+		require.NoError(t, err)
+
+		e.Evaluate(ctx, []service.Metrics{counter("PollCount", 10)}, at(0))
+		assert.Nil(t, e.Alerts()[0].Value, "no rate before the second sample")
+		assert.Equal(t, StateInactive, e.Alerts()[0].State)
+
+		e.Evaluate(ctx, []service.Metrics{counter("PollCount", 30)}, at(10*time.Second))
+		assert.Equal(t, 2.0, *e.Alerts()[0].Value)
+		assert.Equal(t, StateInactive, e.Alerts()[0].State)
+
+		e.Evaluate(ctx, []service.Metrics{counter("PollCount", 30)}, at(20*time.Second))
+		e.Evaluate(ctx, []service.Metrics{counter("PollCount", 30)}, at(80*time.Second))
+		assert.Equal(t, StateFiring, e.Alerts()[0].State)
+
+		e.Evaluate(ctx, []service.Metrics{counter("PollCount", 5)}, at(90*time.Second))
+		assert.Equal(t, 0.5, *e.Alerts()[0].Value, "a decrease is a counter reset")
+		assert.Equal(t, StateResolved, e.Alerts()[0].State)
+	})
+
+	t.Run("Missing metric resolves", func(t *testing.T) {
+		n := &recorder{}
+		e, err := NewEngine([]Rule{{Name: "Hot", Expr: "gauge CPU > 90"}}, n)
+		require.NoError(t, err)
+
+		e.Evaluate(ctx, []service.Metrics{gauge("CPU", 95)}, at(0))
+		e.Evaluate(ctx, []service.Metrics{counter("CPU", 95)}, at(time.Second))
+		assert.Equal(t, StateResolved, e.Alerts()[0].State)
+		assert.Nil(t, e.Alerts()[0].Value)
+	})
+
+	t.Run("Notifier errors do not stop other notifiers", func(t *testing.T) {
+		failing := &recorder{err: errors.New("unavailable")}
+		ok := &recorder{}
+		e, err := NewEngine([]Rule{{Name: "Hot", Expr: "gauge CPU > 90"}}, failing, ok)
+		require.NoError(t, err)
+
+		failures := notificationFailures.Value()
+		e.Evaluate(ctx, []service.Metrics{gauge("CPU", 95)}, at(0))
+		assert.Equal(t, []State{StateFiring}, ok.states())
+		assert.Equal(t, failures+1, notificationFailures.Value())
+	})
+
+	t.Run("Alerts is a copy", func(t *testing.T) {
+		e, err := NewEngine([]Rule{{Name: "Hot", Expr: "gauge CPU > 90"}})
+		require.NoError(t, err)
+		e.Evaluate(ctx, []service.Metrics{gauge("CPU", 95)}, at(0))
+
+		*e.Alerts()[0].Value = 0
+		assert.Equal(t, 95.0, *e.Alerts()[0].Value)
+	})
+
+	t.Run("Invalid rules", func(t *testing.T) {
+		for _, rules := range [][]Rule{
+			{{Expr: "gauge CPU > 90"}},
+			{{Name: "Hot", Expr: "gauge CPU > 90"}, {Name: "Hot", Expr: "gauge CPU > 95"}},
+			{{Name: "Hot", Expr: "CPU > 90"}},
+		} {
+			_, err := NewEngine(rules)
+			assert.ErrorIs(t, err, ErrInvalidRule)
+		}
+	})
+
+	t.Run("Run", func(t *testing.T) {
+		n := &recorder{}
+		e, err := NewEngine([]Rule{{Name: "Hot", Expr: "gauge CPU > 90"}}, n)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			e.Run(ctx, time.Millisecond, func(context.Context) ([]service.Metrics, error) {
+				return []service.Metrics{gauge("CPU", 95)}, nil
+			})
+		}()
+		assert.Eventually(t, func() bool { return len(n.states()) == 1 }, 5*time.Second, time.Millisecond)
+		cancel()
+		<-done
+	})
+}
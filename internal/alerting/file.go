@@ -0,0 +1,76 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RulesFile — содержимое файла правил в формате JSON (расширение .json) или YAML.
+//
+// Поля:
+//   - Rules: Правила оповещений.
+//   - Notifiers: Уведомители; если не заданы, уведомления записываются в журнал (тип log).
+type RulesFile struct {
+	Rules     []Rule           `json:"rules" yaml:"rules"`
+	Notifiers []NotifierConfig `json:"notifiers" yaml:"notifiers"`
+}
+
+// ParseRulesFile разбирает файл правил. Формат выбирается по расширению name:
+// .json — JSON, остальные — YAML. Неизвестные поля считаются ошибкой.
+func ParseRulesFile(name string, data []byte) (*RulesFile, error) {
+	var rf RulesFile
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rf); err != nil {
+			return nil, fmt.Errorf("failed to parse alert rules %s: %w", name, err)
+		}
+		return &rf, nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rf); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules %s: %w", name, err)
+	}
+	return &rf, nil
+}
+
+// LoadFile читает файл правил и создает по нему движок с уведомителями из файла.
+//
+// Параметры:
+// - path: Путь к файлу правил в формате JSON или YAML.
+//
+// Возвращаемые значения:
+// - *Engine: Движок правил.
+// - error: Ошибка чтения или разбора файла, некорректное правило или уведомитель.
+func LoadFile(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %w", err)
+	}
+	rf, err := ParseRulesFile(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := rf.Notifiers
+	if len(configs) == 0 {
+		configs = []NotifierConfig{{Type: "log"}}
+	}
+	notifiers := make([]Notifier, 0, len(configs))
+	for _, cfg := range configs {
+		n, err := NewNotifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	return NewEngine(rf.Rules, notifiers...)
+}
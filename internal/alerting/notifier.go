@@ -0,0 +1,160 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+)
+
+// ErrUnknownNotifier возвращается для уведомителя незарегистрированного типа.
+var ErrUnknownNotifier = errors.New("unknown alert notifier")
+
+// DefaultWebhookTimeout — время ожидания ответа webhook, если timeout не задан.
+const DefaultWebhookTimeout = 5 * time.Second
+
+// Notifier отправляет уведомление о смене состояния оповещения: срабатывании (firing)
+// или снятии (resolved).
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// NotifierConfig — описание уведомителя в файле правил.
+//
+// Поля:
+//   - Type: Тип уведомителя: log, webhook или зарегистрированный через RegisterNotifier.
+//   - URL: Адрес, на который webhook отправляет оповещения.
+//   - Timeout: Время ожидания ответа webhook, например "5s" (по умолчанию DefaultWebhookTimeout).
+//   - Headers: Дополнительные заголовки запросов webhook, например Authorization.
+type NotifierConfig struct {
+	Type    string            `json:"type" yaml:"type"`
+	URL     string            `json:"url,omitempty" yaml:"url"`
+	Timeout string            `json:"timeout,omitempty" yaml:"timeout"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers"`
+}
+
+// NotifierFactory создает уведомитель по его описанию.
+type NotifierFactory func(cfg NotifierConfig) (Notifier, error)
+
+var notifiers = struct {
+	mu        sync.RWMutex
+	factories map[string]NotifierFactory
+}{factories: make(map[string]NotifierFactory)}
+
+// RegisterNotifier регистрирует тип уведомителя. Повторная регистрация типа
+// приводит к панике, как и в storage.Register.
+func RegisterNotifier(kind string, factory NotifierFactory) {
+	notifiers.mu.Lock()
+	defer notifiers.mu.Unlock()
+
+	if _, dup := notifiers.factories[kind]; dup {
+		panic("alerting: RegisterNotifier called twice for " + kind)
+	}
+	notifiers.factories[kind] = factory
+}
+
+// NewNotifier создает уведомитель по описанию cfg.
+func NewNotifier(cfg NotifierConfig) (Notifier, error) {
+	notifiers.mu.RLock()
+	factory, ok := notifiers.factories[cfg.Type]
+	kinds := make([]string, 0, len(notifiers.factories))
+	for kind := range notifiers.factories {
+		kinds = append(kinds, kind)
+	}
+	notifiers.mu.RUnlock()
+	if !ok {
+		sort.Strings(kinds)
+		return nil, fmt.Errorf("%w %q (registered: %v)", ErrUnknownNotifier, cfg.Type, kinds)
+	}
+	n, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s notifier: %w", cfg.Type, err)
+	}
+	return n, nil
+}
+
+func init() {
+	RegisterNotifier("log", func(NotifierConfig) (Notifier, error) { return LogNotifier{}, nil })
+	RegisterNotifier("webhook", newWebhookNotifier)
+}
+
+// LogNotifier записывает уведомления в журнал сервера: срабатывание — с уровнем Warn,
+// снятие — с уровнем Info.
+type LogNotifier struct{}
+
+// Notify записывает уведомление в журнал.
+func (LogNotifier) Notify(ctx context.Context, alert Alert) error {
+	attrs := []any{"rule", alert.Rule, "expr", alert.Expr, "state", alert.State}
+	if alert.Severity != "" {
+		attrs = append(attrs, "severity", alert.Severity)
+	}
+	if alert.Value != nil {
+		attrs = append(attrs, "value", *alert.Value)
+	}
+	if alert.State == StateFiring {
+		logging.Logg.WarnContext(ctx, "Alert firing", attrs...)
+	} else {
+		logging.Logg.InfoContext(ctx, "Alert resolved", attrs...)
+	}
+	return nil
+}
+
+// WebhookNotifier отправляет уведомления POST-запросом с оповещением в формате JSON.
+// Ответ со статусом не из диапазона 2xx считается ошибкой.
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// newWebhookNotifier создает WebhookNotifier по описанию из файла правил.
+func newWebhookNotifier(cfg NotifierConfig) (Notifier, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("webhook url is required")
+	}
+	timeout := DefaultWebhookTimeout
+	if cfg.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(cfg.Timeout); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid webhook timeout %q", cfg.Timeout)
+		}
+	}
+	return &WebhookNotifier{URL: cfg.URL, Headers: cfg.Headers, Client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Notify отправляет оповещение на адрес URL.
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var got Alert
+	var auth string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	n, err := NewNotifier(NotifierConfig{Type: "webhook", URL: srv.URL, Timeout: "1s", Headers: map[string]string{"Authorization": "Bearer secret"}})
+	require.NoError(t, err)
+
+	alert := Alert{Rule: "Hot", Expr: "gauge CPU > 90", State: StateFiring}
+	t.Run("Posts the alert", func(t *testing.T) {
+		require.NoError(t, n.Notify(context.Background(), alert))
+		assert.Equal(t, alert, got)
+		assert.Equal(t, "Bearer secret", auth)
+	})
+
+	t.Run("Non-2xx status is an error", func(t *testing.T) {
+		status = http.StatusBadGateway
+		assert.Error(t, n.Notify(context.Background(), alert))
+	})
+}
+
+func TestNewNotifier(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	n, err := NewNotifier(NotifierConfig{Type: "log"})
+	require.NoError(t, err)
+	assert.NoError(t, n.Notify(context.Background(), Alert{Rule: "Hot", State: StateFiring}))
+
+	_, err = NewNotifier(NotifierConfig{Type: "pager"})
+	assert.ErrorIs(t, err, ErrUnknownNotifier)
+
+	_, err = NewNotifier(NotifierConfig{Type: "webhook"})
+	assert.Error(t, err)
+
+	_, err = NewNotifier(NotifierConfig{Type: "webhook", URL: "http://localhost", Timeout: "soon"})
+	assert.Error(t, err)
+
+	assert.Panics(t, func() { RegisterNotifier("log", nil) })
+}
+
+func TestLoadFile(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	t.Run("YAML", func(t *testing.T) {
+		e, err := LoadFile(write("rules.yaml", `
+rules:
+  - name: LowFreeMemory
+    expr: gauge FreeMemory < 500MB for 2m
+    severity: warning
+notifiers:
+  - type: log
+  - type: webhook
+    url: http://localhost:9093/hooks
+`))
+		require.NoError(t, err)
+		assert.Len(t, e.notifiers, 2)
+		alerts := e.Alerts()
+		require.Len(t, alerts, 1)
+		assert.Equal(t, Alert{Rule: "LowFreeMemory", Expr: "gauge FreeMemory < 500MB for 2m", Severity: "warning", State: StateInactive}, alerts[0])
+	})
+
+	t.Run("JSON with default notifier", func(t *testing.T) {
+		e, err := LoadFile(write("rules.json", `{"rules": [{"name": "AgentStopped", "expr": "rate(counter PollCount) == 0 for 5m"}]}`))
+		require.NoError(t, err)
+		assert.Equal(t, []Notifier{LogNotifier{}}, e.notifiers)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := LoadFile(filepath.Join(dir, "missing.yaml"))
+		assert.Error(t, err)
+
+		_, err = LoadFile(write("unknown.json", `{"rules": [], "receivers": []}`))
+		assert.Error(t, err)
+
+		_, err = LoadFile(write("unknown.yaml", "rules: []\nreceivers: []\n"))
+		assert.Error(t, err)
+
+		_, err = LoadFile(write("bad.yaml", "rules:\n  - name: Hot\n    expr: gauge CPU >\n"))
+		assert.ErrorIs(t, err, ErrInvalidRule)
+
+		_, err = LoadFile(write("notifier.yaml", "notifiers:\n  - type: pager\n"))
+		assert.ErrorIs(t, err, ErrUnknownNotifier)
+	})
+}
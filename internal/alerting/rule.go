@@ -0,0 +1,144 @@
+// Package alerting проверяет правила оповещений по значениям метрик сервера и рассылает
+// уведомления о срабатывании и снятии оповещений.
+//
+// Правило задается выражением вида
+//
+//	gauge FreeMemory < 500MB for 2m
+//	rate(counter PollCount) == 0 for 5m
+//
+// Левая часть — метрика (тип и имя) или rate(метрика), скорость изменения значения в секунду
+// между двумя соседними проверками. Правая — порог: число с необязательной единицей
+// B, KB, MB, GB или TB (степени 1024). Необязательная часть "for" задает, сколько условие
+// должно выполняться подряд, прежде чем оповещение сработает.
+//
+// Правила проверяются через равные промежутки времени (см. Engine.Run) по значениям из
+// хранилища, поэтому работают с любым хранилищем и с несколькими серверами, которые пишут
+// в одну базу. Состояние оповещений хранится в памяти и после перезапуска начинается заново.
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+)
+
+// ErrInvalidRule возвращается для правила с некорректным выражением или без имени.
+var ErrInvalidRule = errors.New("invalid alert rule")
+
+// Operator — оператор сравнения значения метрики с порогом.
+type Operator string
+
+// Поддерживаемые операторы сравнения.
+const (
+	OpLess         Operator = "<"
+	OpLessEqual    Operator = "<="
+	OpGreater      Operator = ">"
+	OpGreaterEqual Operator = ">="
+	OpEqual        Operator = "=="
+	OpNotEqual     Operator = "!="
+)
+
+// Rule — правило оповещения в файле правил.
+//
+// Поля:
+//   - Name: Имя оповещения, уникальное в файле.
+//   - Expr: Условие, например "gauge FreeMemory < 500MB for 2m".
+//   - Severity: Необязательная важность оповещения (например, warning или critical).
+//   - Summary: Необязательное описание для уведомлений.
+type Rule struct {
+	Name     string `json:"name" yaml:"name"`
+	Expr     string `json:"expr" yaml:"expr"`
+	Severity string `json:"severity,omitempty" yaml:"severity"`
+	Summary  string `json:"summary,omitempty" yaml:"summary"`
+}
+
+// Condition — разобранное выражение правила.
+//
+// Поля:
+//   - Rate: Сравнивается скорость изменения метрики в секунду, а не ее значение.
+//   - Type: Тип метрики.
+//   - Metric: Имя метрики.
+//   - Op: Оператор сравнения.
+//   - Threshold: Порог с учетом единицы измерения.
+//   - For: Сколько условие должно выполняться подряд до срабатывания (0 — сразу).
+type Condition struct {
+	Rate      bool
+	Type      service.MetricType
+	Metric    string
+	Op        Operator
+	Threshold float64
+	For       time.Duration
+}
+
+var (
+	exprRe = regexp.MustCompile(`^(?:rate\(\s*(\w+)\s+([^\s()]+)\s*\)|(\w+)\s+([^\s()]+))` +
+		`\s*(<=|>=|==|!=|<|>)\s*(\S+)(?:\s+for\s+(\S+))?$`)
+	thresholdRe = regexp.MustCompile(`^([-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?)([A-Za-z]*)$`)
+)
+
+// units — множители единиц измерения порога.
+var units = map[string]float64{
+	"":   1,
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+// ParseCondition разбирает выражение правила. Ошибка оборачивает ErrInvalidRule.
+func ParseCondition(expr string) (Condition, error) {
+	m := exprRe.FindStringSubmatch(strings.TrimSpace(expr))
+	if m == nil {
+		return Condition{}, fmt.Errorf("%w: cannot parse %q", ErrInvalidRule, expr)
+	}
+
+	c := Condition{Rate: m[1] != "", Type: service.MetricType(m[1] + m[3]), Metric: m[2] + m[4], Op: Operator(m[5])}
+	if c.Type != service.GaugeMetric && c.Type != service.CounterMetric {
+		return Condition{}, fmt.Errorf("%w: unknown metric type %q in %q", ErrInvalidRule, c.Type, expr)
+	}
+
+	t := thresholdRe.FindStringSubmatch(m[6])
+	if t == nil {
+		return Condition{}, fmt.Errorf("%w: invalid threshold %q in %q", ErrInvalidRule, m[6], expr)
+	}
+	unit, ok := units[strings.ToUpper(t[2])]
+	if !ok {
+		return Condition{}, fmt.Errorf("%w: unknown unit %q in %q", ErrInvalidRule, t[2], expr)
+	}
+	v, err := strconv.ParseFloat(t[1], 64)
+	if err != nil {
+		return Condition{}, fmt.Errorf("%w: invalid threshold %q in %q", ErrInvalidRule, m[6], expr)
+	}
+	c.Threshold = v * unit
+
+	if m[7] != "" {
+		if c.For, err = time.ParseDuration(m[7]); err != nil || c.For < 0 {
+			return Condition{}, fmt.Errorf("%w: invalid duration %q in %q", ErrInvalidRule, m[7], expr)
+		}
+	}
+	return c, nil
+}
+
+// holds сообщает, выполняется ли условие для значения v.
+func (c Condition) holds(v float64) bool {
+	switch c.Op {
+	case OpLess:
+		return v < c.Threshold
+	case OpLessEqual:
+		return v <= c.Threshold
+	case OpGreater:
+		return v > c.Threshold
+	case OpGreaterEqual:
+		return v >= c.Threshold
+	case OpEqual:
+		return v == c.Threshold
+	default:
+		return v != c.Threshold
+	}
+}
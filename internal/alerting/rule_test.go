@@ -0,0 +1,77 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want Condition
+	}{
+		{
+			name: "Gauge with unit and duration",
+			expr: "gauge FreeMemory < 500MB for 2m",
+			want: Condition{Type: service.GaugeMetric, Metric: "FreeMemory", Op: OpLess, Threshold: 500 << 20, For: 2 * time.Minute},
+		},
+		{
+			name: "Rate of counter",
+			expr: "rate(counter PollCount) == 0 for 5m",
+			want: Condition{Rate: true, Type: service.CounterMetric, Metric: "PollCount", Op: OpEqual, For: 5 * time.Minute},
+		},
+		{
+			name: "Without duration and spaces",
+			expr: "  gauge CPUutilization1>=90.5 ",
+			want: Condition{Type: service.GaugeMetric, Metric: "CPUutilization1", Op: OpGreaterEqual, Threshold: 90.5},
+		},
+		{
+			name: "Negative threshold and lower case unit",
+			expr: "rate( gauge HeapAlloc ) <= -1kb",
+			want: Condition{Rate: true, Type: service.GaugeMetric, Metric: "HeapAlloc", Op: OpLessEqual, Threshold: -1024},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCondition(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("Invalid expressions", func(t *testing.T) {
+		for _, expr := range []string{
+			"",
+			"gauge FreeMemory",
+			"histogram Latency > 1",
+			"gauge FreeMemory < 500XB",
+			"gauge FreeMemory < lots",
+			"gauge FreeMemory =< 1",
+			"gauge FreeMemory < 1 for ever",
+			"gauge FreeMemory < 1 for -1m",
+			"rate(gauge FreeMemory < 1",
+		} {
+			_, err := ParseCondition(expr)
+			assert.ErrorIs(t, err, ErrInvalidRule, expr)
+		}
+	})
+}
+
+func TestConditionHolds(t *testing.T) {
+	for op, want := range map[Operator][3]bool{
+		OpLess:         {true, false, false},
+		OpLessEqual:    {true, true, false},
+		OpGreater:      {false, false, true},
+		OpGreaterEqual: {false, true, true},
+		OpEqual:        {false, true, false},
+		OpNotEqual:     {true, false, true},
+	} {
+		c := Condition{Op: op, Threshold: 10}
+		assert.Equal(t, want, [3]bool{c.holds(9), c.holds(10), c.holds(11)}, string(op))
+	}
+}
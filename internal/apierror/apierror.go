@@ -0,0 +1,106 @@
+// Package apierror описывает модель ошибок JSON API сервера метрик.
+//
+// Ошибка возвращается телом
+//
+//	{"code": "validation_error", "message": "...", "details": ..., "request_id": "..."}
+//
+// где code — машиночитаемый код из констант пакета, message — описание для человека,
+// details — необязательные подробности (например, отклоненные метрики пакета),
+// а request_id — идентификатор запроса (см. пакет requestid), по которому запрос
+// можно найти в журнале сервера.
+//
+// Маршруты старого текстового API (/update/{type}/{name}/{value}, /value/{type}/{name})
+// по-прежнему отвечают на ошибки простым текстом.
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dvkhr/metrix.git/internal/requestid"
+)
+
+// Code — машиночитаемый код ошибки API.
+type Code string
+
+const (
+	// CodeDecode — тело запроса не удалось прочитать или разобрать (400).
+	CodeDecode Code = "decode_error"
+
+	// CodeValidation — метрика или параметры запроса некорректны (400).
+	CodeValidation Code = "validation_error"
+
+	// CodeDecrypt — тело запроса не удалось расшифровать (400).
+	CodeDecrypt Code = "decrypt_error"
+
+	// CodeSignature — подпись HashSHA256 не совпала с телом запроса (400).
+	CodeSignature Code = "signature_error"
+
+	// CodeUnauthorized — токен администратора отсутствует или неверен (401).
+	CodeUnauthorized Code = "unauthorized"
+
+	// CodeForbidden — административный API отключен (403).
+	CodeForbidden Code = "forbidden"
+
+	// CodeNotFound — метрика не найдена (404).
+	CodeNotFound Code = "not_found"
+
+	// CodeMethodNotAllowed — метод HTTP не поддерживается маршрутом (405).
+	CodeMethodNotAllowed Code = "method_not_allowed"
+
+	// CodeNotAcceptable — ни один из форматов в заголовке Accept не поддерживается (406).
+	CodeNotAcceptable Code = "not_acceptable"
+
+	// CodeUnsupportedMediaType — тело запроса передано в неподдерживаемом формате (415).
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+
+	// CodeTypeConflict — метрика уже существует с другим типом (409).
+	CodeTypeConflict Code = "type_conflict"
+
+	// CodeStorageUnavailable — хранилище недоступно или не ответило вовремя (503, 504).
+	CodeStorageUnavailable Code = "storage_unavailable"
+
+	// CodeInternal — внутренняя ошибка сервера (500).
+	CodeInternal Code = "internal_error"
+)
+
+// Error — тело ответа с ошибкой.
+//
+// Поля:
+//   - Code: Код ошибки.
+//   - Message: Описание ошибки.
+//   - Details: Подробности; не выводятся, если равны nil.
+//   - RequestID: Идентификатор запроса; не выводится, если запрос его не получил.
+type Error struct {
+	Code      Code   `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Write отправляет клиенту ошибку со статусом status. Идентификатор запроса берется
+// из контекста r, куда его помещает requestid.Middleware.
+//
+// Параметры:
+// - w: HTTP-ответ, который будет отправлен клиенту.
+// - r: HTTP-запрос, на который отправляется ответ.
+// - status: Код статуса HTTP.
+// - code: Код ошибки API.
+// - message: Описание ошибки.
+// - details: Подробности или nil.
+func Write(w http.ResponseWriter, r *http.Request, status int, code Code, message string, details any) {
+	body, err := json.Marshal(Error{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: requestid.FromContext(r.Context()),
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
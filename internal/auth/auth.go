@@ -0,0 +1,50 @@
+// Package auth предоставляет проверку доступа к административным запросам сервера метрик.
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/dvkhr/metrix.git/internal/apierror"
+	"github.com/dvkhr/metrix.git/internal/selfmetrics"
+)
+
+// bearerPrefix — схема авторизации в заголовке Authorization.
+const bearerPrefix = "Bearer "
+
+// authFailures учитывает административные запросы, отклоненные из-за неверного токена.
+var authFailures = selfmetrics.Default.Counter("metrix_admin_auth_failures_total",
+	"Number of admin requests rejected because of a missing or invalid token.")
+
+// AdminOnly создает middleware, пропускающий только запросы с заголовком
+// "Authorization: Bearer <token>". В отличие от SignCheck, пустой токен не отключает
+// проверку, а запрещает доступ: административные запросы необратимо изменяют данные.
+//
+// Параметры:
+// - h: Обработчик HTTP-запроса, который будет вызван после успешной проверки.
+// - token: Токен администратора.
+//
+// Возвращаемое значение:
+// - http.HandlerFunc: Middleware, отвечающий 403 (Forbidden), если токен не настроен,
+// и 401 (Unauthorized), если токен в запросе отсутствует или не совпадает.
+// Ошибки возвращаются в формате JSON API (см. пакет apierror).
+func AdminOnly(h http.HandlerFunc, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden, "admin API is disabled", nil)
+			return
+		}
+
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, bearerPrefix) ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, bearerPrefix)), []byte(token)) != 1 {
+			authFailures.Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrix"`)
+			apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid or missing admin token", nil)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	}
+}
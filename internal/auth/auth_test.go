@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminOnly(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		status        int
+	}{
+		{name: "Valid token", token: "secret", authorization: "Bearer secret", status: http.StatusNoContent},
+		{name: "Wrong token", token: "secret", authorization: "Bearer other", status: http.StatusUnauthorized},
+		{name: "Token prefix", token: "secret", authorization: "Bearer secre", status: http.StatusUnauthorized},
+		{name: "No header", token: "secret", status: http.StatusUnauthorized},
+		{name: "Basic scheme", token: "secret", authorization: "Basic c2VjcmV0", status: http.StatusUnauthorized},
+		{name: "Disabled", token: "", authorization: "Bearer ", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/value/gauge/Alloc", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			AdminOnly(ok, tt.token)(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status != http.StatusNoContent {
+				assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			}
+			if tt.status == http.StatusUnauthorized {
+				assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
@@ -2,13 +2,16 @@
 package config
 
 import (
+	_ "embed"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,12 +23,99 @@ type ConfigServ struct {
 	Restore         bool
 	Key             string
 	CryptoKey       string
+	OTLPEndpoint    string
+	// StorageReadTimeout ограничивает время операций чтения из хранилища (0 — без ограничения).
+	StorageReadTimeout time.Duration
+	// StorageWriteTimeout ограничивает время операций записи в хранилище (0 — без ограничения).
+	StorageWriteTimeout time.Duration
+	// WAL включает файловое хранилище с журналом упреждающей записи вместо периодических снимков.
+	WAL bool
+	// WALFsync — политика сброса журнала на диск: always, interval или never.
+	WALFsync string
+	// WALCompactInterval — период свертки журнала в снимок.
+	WALCompactInterval time.Duration
+	// StorageDriver выбирает клиент PostgreSQL: sql (database/sql) или pgx (пул pgxpool).
+	StorageDriver string
+	// DBMaxConns и DBMinConns задают размер пула pgx (0 — по умолчанию).
+	DBMaxConns int
+	DBMinConns int
+	// DBHealthCheckPeriod — период проверки простаивающих соединений пула pgx (0 — по умолчанию).
+	DBHealthCheckPeriod time.Duration
+	// Storage — адрес хранилища (memory://, file:///path, postgres://..., sqlite:///path).
+	// Если не задан, адрес строится из DBDsn и FileStoragePath (см. StorageURL).
+	Storage string
+	// StorageWrappers — обертки хранилища в порядке применения, например cache?ttl=5s
+	// или expire?after=1h&purge=true (скрытие и удаление метрик, не обновлявшихся дольше часа).
+	StorageWrappers []string
+	// AdminToken — токен Bearer для административных запросов (удаление и обнуление метрик).
+	// Если не задан, административные запросы отклоняются.
+	AdminToken string
+	// HistoryInterval — период записи значений метрик в историю для графиков на HTML-странице
+	// (0 — история не ведется).
+	HistoryInterval time.Duration
+	// HistorySize — число последних значений каждой метрики, которые хранит история.
+	HistorySize int
+	// AlertRules — путь к файлу правил оповещений в формате JSON или YAML (пусто — оповещения
+	// не проверяются). Относительный путь из файла конфигурации отсчитывается от его каталога.
+	AlertRules string
+	// AlertInterval — период проверки правил оповещений.
+	AlertInterval time.Duration
 }
 
+// StorageURL возвращает адрес хранилища: значение Storage, если оно задано,
+// иначе DBDsn, иначе файл FileStoragePath, иначе хранилище в памяти.
+func (cfg *ConfigServ) StorageURL() string {
+	switch {
+	case cfg.Storage != "":
+		return cfg.Storage
+	case cfg.DBDsn != "":
+		return cfg.DBDsn
+	case cfg.FileStoragePath != "":
+		return "file://" + cfg.FileStoragePath
+	default:
+		return "memory://"
+	}
+}
+
+// splitList разбирает список через запятую, отбрасывая пустые элементы.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Значения таймаутов операций с хранилищем по умолчанию.
+// Они меньше WriteTimeout HTTP-сервера, чтобы клиент успел получить ответ 504.
+const (
+	DefaultStorageReadTimeout  = 5 * time.Second
+	DefaultStorageWriteTimeout = 10 * time.Second
+	DefaultWALFsync            = "interval"
+	DefaultWALCompactInterval  = time.Minute
+	DefaultHistoryInterval     = 10 * time.Second
+	DefaultHistorySize         = 60
+	DefaultAlertInterval       = 10 * time.Second
+)
+
+// Клиенты PostgreSQL, доступные в опции StorageDriver.
+const (
+	StorageDriverSQL = "sql"
+	StorageDriverPgx = "pgx"
+)
+
 var (
 	ErrStoreIntetrvalNegativ = errors.New("storeInterval is negativ or zero")
 	ErrAddressEmpty          = errors.New("address is an empty string")
 	ErrCryptoKeyFileNotFound = errors.New("crypto key file not found")
+	ErrStorageTimeoutNegativ = errors.New("storage timeout is negativ")
+	ErrWALFsyncInvalid       = errors.New("wal fsync policy must be always, interval or never")
+	ErrStorageDriverInvalid  = errors.New("storage driver must be sql or pgx")
+	ErrDBPoolSizeNegativ     = errors.New("db pool size is negativ")
+	ErrHistoryNegativ        = errors.New("history interval or size is negativ")
+	ErrAlertIntervalInvalid  = errors.New("alert interval must be positive")
 )
 
 func (cfg *ConfigServ) check() error {
@@ -40,6 +130,28 @@ func (cfg *ConfigServ) check() error {
 	} else if cfg.StoreInterval < 0*time.Microsecond {
 		errs = append(errs, ErrStoreIntetrvalNegativ)
 	}
+	if cfg.StorageReadTimeout < 0 || cfg.StorageWriteTimeout < 0 {
+		errs = append(errs, ErrStorageTimeoutNegativ)
+	}
+	switch cfg.WALFsync {
+	case "", "always", "interval", "never":
+	default:
+		errs = append(errs, ErrWALFsyncInvalid)
+	}
+	switch cfg.StorageDriver {
+	case "", StorageDriverSQL, StorageDriverPgx:
+	default:
+		errs = append(errs, ErrStorageDriverInvalid)
+	}
+	if cfg.DBMaxConns < 0 || cfg.DBMinConns < 0 {
+		errs = append(errs, ErrDBPoolSizeNegativ)
+	}
+	if cfg.HistoryInterval < 0 || cfg.HistorySize < 0 {
+		errs = append(errs, ErrHistoryNegativ)
+	}
+	if cfg.AlertRules != "" && cfg.AlertInterval <= 0 {
+		errs = append(errs, ErrAlertIntervalInvalid)
+	}
 	if cfg.CryptoKey != "" {
 		if _, err := os.Stat(cfg.CryptoKey); os.IsNotExist(err) {
 			errs = append(errs, fmt.Errorf("%w: %s", ErrCryptoKeyFileNotFound, cfg.CryptoKey))
@@ -51,15 +163,32 @@ func (cfg *ConfigServ) check() error {
 func (cfg *ConfigServ) ParseFlags() error {
 	var storInt int64
 	var configFile string
+	var wrappers string
 
 	flag.StringVar(&cfg.Address, "a", "localhost:8080", "Endpoint HTTP-server")
 	flag.StringVar(&cfg.FileStoragePath, "f", "", "The path to the file with metrics")
 	flag.StringVar(&cfg.DBDsn, "d", "", "The data source")
-	// Next 2 parametrs are useless in current implementation, left here just not to break autotests
-	flag.Int64Var(&storInt, "i", 0, "Frequency of saving to disk in seconds")
-	flag.BoolVar(&cfg.Restore, "r", true, "loading saved values")
+	flag.Int64Var(&storInt, "i", 0, "Frequency of saving metrics snapshot to disk in seconds (0 saves on every write)")
+	flag.BoolVar(&cfg.Restore, "r", true, "Load metrics from the snapshot file on startup")
 	flag.StringVar(&cfg.Key, "k", "", "Key")
 	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "Path to the private key file for decryption (optional)")
+	flag.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector endpoint for trace export (tracing is disabled if empty)")
+	flag.DurationVar(&cfg.StorageReadTimeout, "storage-read-timeout", DefaultStorageReadTimeout, "Timeout of a single storage read operation (0 disables)")
+	flag.DurationVar(&cfg.StorageWriteTimeout, "storage-write-timeout", DefaultStorageWriteTimeout, "Timeout of a single storage write operation (0 disables)")
+	flag.BoolVar(&cfg.WAL, "wal", false, "Store metrics in the file with a write-ahead log instead of periodic snapshots")
+	flag.StringVar(&cfg.WALFsync, "wal-fsync", DefaultWALFsync, "WAL fsync policy: always, interval or never")
+	flag.DurationVar(&cfg.WALCompactInterval, "wal-compact-interval", DefaultWALCompactInterval, "Period of compacting the WAL into the snapshot file")
+	flag.StringVar(&cfg.StorageDriver, "storage-driver", StorageDriverSQL, "PostgreSQL client: sql (database/sql) or pgx (native pgxpool); a pgx:// DSN selects pgx too")
+	flag.IntVar(&cfg.DBMaxConns, "db-max-conns", 0, "Maximum size of the pgx connection pool (0 uses the pgxpool default)")
+	flag.IntVar(&cfg.DBMinConns, "db-min-conns", 0, "Minimum size of the pgx connection pool")
+	flag.DurationVar(&cfg.DBHealthCheckPeriod, "db-health-check-period", 0, "Period of pgx pool idle connection health checks (0 uses the pgxpool default)")
+	flag.StringVar(&cfg.Storage, "storage", "", "Storage URL: memory://, file:///path, postgres://..., pgx://..., sqlite:///path (overrides -d and -f)")
+	flag.StringVar(&wrappers, "storage-wrappers", "", "Comma-separated storage wrappers applied in order, e.g. expire?after=1h&purge=true,cache?ttl=5s,replicate?peer=standby:8080")
+	flag.StringVar(&cfg.AdminToken, "admin-token", "", "Bearer token for the admin API (metric deletion and reset); the admin API is disabled if empty")
+	flag.DurationVar(&cfg.HistoryInterval, "history-interval", DefaultHistoryInterval, "Period of sampling metric values for the HTML page charts (0 disables history)")
+	flag.IntVar(&cfg.HistorySize, "history-size", DefaultHistorySize, "Number of recent values kept per metric for the HTML page charts")
+	flag.StringVar(&cfg.AlertRules, "alert-rules", "", "Path to the JSON or YAML file with alert rules (alerting is disabled if empty)")
+	flag.DurationVar(&cfg.AlertInterval, "alert-interval", DefaultAlertInterval, "Period of evaluating alert rules")
 	flag.StringVar(&configFile, "c", "", "Path to the JSON configuration file")
 	flag.StringVar(&configFile, "config", "", "Path to the JSON configuration file")
 
@@ -79,7 +208,7 @@ func (cfg *ConfigServ) ParseFlags() error {
 	if envStorInt := os.Getenv("STORE_INTERVAL"); envStorInt != "" {
 		storInt, _ = strconv.ParseInt(envStorInt, 10, 64)
 	}
-	if envReStor := os.Getenv("POLL_INTERVAL"); envReStor != "" {
+	if envReStor := os.Getenv("RESTORE"); envReStor != "" {
 		cfg.Restore, _ = strconv.ParseBool(envReStor)
 	}
 	if envVarKey := os.Getenv("KEY"); envVarKey != "" {
@@ -90,6 +219,78 @@ func (cfg *ConfigServ) ParseFlags() error {
 		cfg.CryptoKey = envVarCryptoKey
 	}
 
+	if envVarOTLP := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); envVarOTLP != "" {
+		cfg.OTLPEndpoint = envVarOTLP
+	}
+
+	if envVarReadTimeout := os.Getenv("STORAGE_READ_TIMEOUT"); envVarReadTimeout != "" {
+		if duration, err := time.ParseDuration(envVarReadTimeout); err == nil {
+			cfg.StorageReadTimeout = duration
+		}
+	}
+	if envVarWriteTimeout := os.Getenv("STORAGE_WRITE_TIMEOUT"); envVarWriteTimeout != "" {
+		if duration, err := time.ParseDuration(envVarWriteTimeout); err == nil {
+			cfg.StorageWriteTimeout = duration
+		}
+	}
+
+	if envVarWAL := os.Getenv("WAL"); envVarWAL != "" {
+		cfg.WAL, _ = strconv.ParseBool(envVarWAL)
+	}
+	if envVarWALFsync := os.Getenv("WAL_FSYNC"); envVarWALFsync != "" {
+		cfg.WALFsync = envVarWALFsync
+	}
+	if envVarWALCompact := os.Getenv("WAL_COMPACT_INTERVAL"); envVarWALCompact != "" {
+		if duration, err := time.ParseDuration(envVarWALCompact); err == nil {
+			cfg.WALCompactInterval = duration
+		}
+	}
+
+	if envVarDriver := os.Getenv("STORAGE_DRIVER"); envVarDriver != "" {
+		cfg.StorageDriver = envVarDriver
+	}
+	if envVarMaxConns := os.Getenv("DB_MAX_CONNS"); envVarMaxConns != "" {
+		cfg.DBMaxConns, _ = strconv.Atoi(envVarMaxConns)
+	}
+	if envVarMinConns := os.Getenv("DB_MIN_CONNS"); envVarMinConns != "" {
+		cfg.DBMinConns, _ = strconv.Atoi(envVarMinConns)
+	}
+	if envVarHealthCheck := os.Getenv("DB_HEALTH_CHECK_PERIOD"); envVarHealthCheck != "" {
+		if duration, err := time.ParseDuration(envVarHealthCheck); err == nil {
+			cfg.DBHealthCheckPeriod = duration
+		}
+	}
+
+	if envVarStorage := os.Getenv("STORAGE"); envVarStorage != "" {
+		cfg.Storage = envVarStorage
+	}
+	if envVarWrappers := os.Getenv("STORAGE_WRAPPERS"); envVarWrappers != "" {
+		wrappers = envVarWrappers
+	}
+	cfg.StorageWrappers = splitList(wrappers)
+
+	if envVarAdminToken := os.Getenv("ADMIN_TOKEN"); envVarAdminToken != "" {
+		cfg.AdminToken = envVarAdminToken
+	}
+
+	if envVarHistoryInterval := os.Getenv("HISTORY_INTERVAL"); envVarHistoryInterval != "" {
+		if duration, err := time.ParseDuration(envVarHistoryInterval); err == nil {
+			cfg.HistoryInterval = duration
+		}
+	}
+	if envVarHistorySize := os.Getenv("HISTORY_SIZE"); envVarHistorySize != "" {
+		cfg.HistorySize, _ = strconv.Atoi(envVarHistorySize)
+	}
+
+	if envVarAlertRules := os.Getenv("ALERT_RULES"); envVarAlertRules != "" {
+		cfg.AlertRules = envVarAlertRules
+	}
+	if envVarAlertInterval := os.Getenv("ALERT_INTERVAL"); envVarAlertInterval != "" {
+		if duration, err := time.ParseDuration(envVarAlertInterval); err == nil {
+			cfg.AlertInterval = duration
+		}
+	}
+
 	if configFileEnv := os.Getenv("CONFIG"); configFileEnv != "" && configFile == "" {
 		configFile = configFileEnv
 	}
@@ -110,16 +311,25 @@ type LoggerConfig struct {
 	FilePattern   string `json:"file_pattern"`
 }
 
-// LoadLoggerConfig загружает конфигурацию логгера из JSON-файла.
+// defaultLoggerConfig — конфигурация логгера, встроенная в исполняемый файл.
+//
+//go:embed logger_config.json
+var defaultLoggerConfig []byte
+
+// LoadLoggerConfig загружает конфигурацию логгера из JSON-файла. Если файла нет,
+// используется встроенная копия logger_config.json, так что программа не зависит
+// от рабочего каталога.
 func LoadLoggerConfig(filePath string) (*LoggerConfig, error) {
-	file, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = defaultLoggerConfig, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
 	var cfg LoggerConfig
-	if err := json.NewDecoder(file).Decode(&cfg); err != nil {
+	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
 
@@ -127,12 +337,29 @@ func LoadLoggerConfig(filePath string) (*LoggerConfig, error) {
 }
 
 type ServerConfigFile struct {
-	Address       string `json:"address"`
-	Restore       bool   `json:"restore"`
-	StoreInterval string `json:"store_interval"`
-	StoreFile     string `json:"store_file"`
-	DatabaseDsn   string `json:"database_dsn"`
-	CryptoKey     string `json:"crypto_key"`
+	Address       string   `json:"address"`
+	Restore       bool     `json:"restore"`
+	StoreInterval string   `json:"store_interval"`
+	StoreFile     string   `json:"store_file"`
+	DatabaseDsn   string   `json:"database_dsn"`
+	CryptoKey     string   `json:"crypto_key"`
+	OTLPEndpoint  string   `json:"otlp_endpoint"`
+	ReadTimeout   string   `json:"storage_read_timeout"`
+	WriteTimeout  string   `json:"storage_write_timeout"`
+	WAL           bool     `json:"wal"`
+	WALFsync      string   `json:"wal_fsync"`
+	WALCompact    string   `json:"wal_compact_interval"`
+	StorageDriver string   `json:"storage_driver"`
+	DBMaxConns    int      `json:"db_max_conns"`
+	DBMinConns    int      `json:"db_min_conns"`
+	DBHealthCheck string   `json:"db_health_check_period"`
+	Storage       string   `json:"storage"`
+	Wrappers      []string `json:"storage_wrappers"`
+	AdminToken    string   `json:"admin_token"`
+	History       string   `json:"history_interval"`
+	HistorySize   int      `json:"history_size"`
+	AlertRules    string   `json:"alert_rules"`
+	AlertInterval string   `json:"alert_interval"`
 }
 
 // LoadServerConfig загружает конфигурацию сервера из JSON-файла.
@@ -168,6 +395,78 @@ func (cfg *ConfigServ) LoadServerConfig(filePath string) error {
 	if configFile.CryptoKey != "" && cfg.CryptoKey == "" {
 		cfg.CryptoKey = configFile.CryptoKey
 	}
+	if configFile.OTLPEndpoint != "" && cfg.OTLPEndpoint == "" {
+		cfg.OTLPEndpoint = configFile.OTLPEndpoint
+	}
+	if configFile.ReadTimeout != "" && cfg.StorageReadTimeout == DefaultStorageReadTimeout {
+		duration, err := time.ParseDuration(configFile.ReadTimeout)
+		if err == nil {
+			cfg.StorageReadTimeout = duration
+		}
+	}
+	if configFile.WriteTimeout != "" && cfg.StorageWriteTimeout == DefaultStorageWriteTimeout {
+		duration, err := time.ParseDuration(configFile.WriteTimeout)
+		if err == nil {
+			cfg.StorageWriteTimeout = duration
+		}
+	}
+	if configFile.WAL && !cfg.WAL {
+		cfg.WAL = configFile.WAL
+	}
+	if configFile.WALFsync != "" && cfg.WALFsync == DefaultWALFsync {
+		cfg.WALFsync = configFile.WALFsync
+	}
+	if configFile.WALCompact != "" && cfg.WALCompactInterval == DefaultWALCompactInterval {
+		duration, err := time.ParseDuration(configFile.WALCompact)
+		if err == nil {
+			cfg.WALCompactInterval = duration
+		}
+	}
+	if configFile.StorageDriver != "" && cfg.StorageDriver == StorageDriverSQL {
+		cfg.StorageDriver = configFile.StorageDriver
+	}
+	if configFile.DBMaxConns != 0 && cfg.DBMaxConns == 0 {
+		cfg.DBMaxConns = configFile.DBMaxConns
+	}
+	if configFile.DBMinConns != 0 && cfg.DBMinConns == 0 {
+		cfg.DBMinConns = configFile.DBMinConns
+	}
+	if configFile.DBHealthCheck != "" && cfg.DBHealthCheckPeriod == 0 {
+		duration, err := time.ParseDuration(configFile.DBHealthCheck)
+		if err == nil {
+			cfg.DBHealthCheckPeriod = duration
+		}
+	}
+	if configFile.Storage != "" && cfg.Storage == "" {
+		cfg.Storage = configFile.Storage
+	}
+	if len(configFile.Wrappers) > 0 && len(cfg.StorageWrappers) == 0 {
+		cfg.StorageWrappers = configFile.Wrappers
+	}
+	if configFile.AdminToken != "" && cfg.AdminToken == "" {
+		cfg.AdminToken = configFile.AdminToken
+	}
+	if configFile.History != "" && cfg.HistoryInterval == DefaultHistoryInterval {
+		duration, err := time.ParseDuration(configFile.History)
+		if err == nil {
+			cfg.HistoryInterval = duration
+		}
+	}
+	if configFile.HistorySize != 0 && cfg.HistorySize == DefaultHistorySize {
+		cfg.HistorySize = configFile.HistorySize
+	}
+	if configFile.AlertRules != "" && cfg.AlertRules == "" {
+		cfg.AlertRules = configFile.AlertRules
+		if !filepath.IsAbs(cfg.AlertRules) {
+			cfg.AlertRules = filepath.Join(filepath.Dir(filePath), cfg.AlertRules)
+		}
+	}
+	if configFile.AlertInterval != "" && cfg.AlertInterval == DefaultAlertInterval {
+		duration, err := time.ParseDuration(configFile.AlertInterval)
+		if err == nil {
+			cfg.AlertInterval = duration
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,138 @@
+// Package dashboard отображает HTML-страницу со списком метрик.
+//
+// Шаблон страницы и статические файлы (скрипт и стили) встроены в исполняемый файл,
+// поэтому сервер не зависит от рабочего каталога. Шаблон разбирается один раз
+// при запуске программы.
+//
+// Страница показывает метрики с фильтром по имени и типу, сортировкой по заголовкам
+// столбцов, временем последнего обновления и, если сервер ведет историю значений
+// (пакет history), небольшими графиками. Скрипт страницы обновляет таблицу
+// с выбранным периодом без перезагрузки страницы.
+package dashboard
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+)
+
+//go:embed templates static
+var files embed.FS
+
+// page — шаблон страницы, разобранный при запуске.
+var page = template.Must(template.New("index.html.tmpl").Funcs(template.FuncMap{
+	"value":     service.Metrics.FormatValue,
+	"sparkline": sparkline,
+}).ParseFS(files, "templates/index.html.tmpl"))
+
+// Размеры графика в строке таблицы в пикселях.
+const (
+	sparklineWidth  = 120
+	sparklineHeight = 24
+)
+
+// Row — строка таблицы метрик.
+//
+// Поля:
+//   - Metrics: Метрика.
+//   - History: Последние значения метрики от старых к новым; nil, если история не ведется.
+type Row struct {
+	service.Metrics
+	History []float64
+}
+
+// View — данные страницы.
+//
+// Поля:
+//   - Rows: Метрики страницы.
+//   - Query: Параметры запроса страницы (фильтр, сортировка, курсор).
+//   - Next: Ссылка на следующую страницу; пуста на последней странице.
+//   - GeneratedAt: Время формирования страницы.
+type View struct {
+	Rows        []Row
+	Query       url.Values
+	Next        template.URL
+	GeneratedAt time.Time
+}
+
+// SortURL возвращает ссылку на первую страницу, упорядоченную по столбцу field
+// (name или type). Повторный выбор того же столбца меняет направление сортировки.
+func (v View) SortURL(field string) template.URL {
+	query := url.Values{}
+	for k, values := range v.Query {
+		query[k] = values
+	}
+	query.Del("cursor")
+	if v.Query.Get("sort") == field {
+		query.Set("sort", "-"+field)
+	} else {
+		query.Set("sort", field)
+	}
+	return template.URL("/?" + query.Encode())
+}
+
+// SortMark возвращает стрелку направления сортировки для заголовка столбца field
+// или пустую строку, если страница упорядочена по другому столбцу.
+func (v View) SortMark(field string) string {
+	switch sort := v.Query.Get("sort"); {
+	case sort == field, sort == "" && field == "name":
+		return "▲"
+	case sort == "-"+field:
+		return "▼"
+	default:
+		return ""
+	}
+}
+
+// Render записывает страницу view в w.
+func Render(w io.Writer, view View) error {
+	return page.Execute(w, view)
+}
+
+// Static возвращает обработчик статических файлов страницы. Пути запросов
+// указываются без префикса, например "dashboard.js".
+func Static() http.Handler {
+	static, err := fs.Sub(files, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(static))
+}
+
+// sparkline рисует values линией в SVG. Для истории короче двух значений
+// возвращает пустую строку.
+func sparkline(values []float64) template.HTML {
+	if len(values) < 2 {
+		return ""
+	}
+	low, high := values[0], values[0]
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return ""
+		}
+		low, high = math.Min(low, v), math.Max(high, v)
+	}
+
+	points := make([]string, len(values))
+	step := float64(sparklineWidth) / float64(len(values)-1)
+	for i, v := range values {
+		y := float64(sparklineHeight) / 2
+		if high > low {
+			// Отступ в 1 пиксель, чтобы линия на границах не обрезалась.
+			y = 1 + (high-v)/(high-low)*float64(sparklineHeight-2)
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", float64(i)*step, y)
+	}
+	return template.HTML(fmt.Sprintf(
+		`<svg class="sparkline" width="%d" height="%d" viewBox="0 0 %d %d" aria-hidden="true"><polyline points="%s"/></svg>`,
+		sparklineWidth, sparklineHeight, sparklineWidth, sparklineHeight, strings.Join(points, " ")))
+}
@@ -0,0 +1,86 @@
+package dashboard
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	value := service.GaugeMetricValue(1.5)
+	delta := service.CounterMetricValue(7)
+	updated := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	view := View{
+		Rows: []Row{
+			{Metrics: service.Metrics{ID: "Alloc", MType: service.GaugeMetric, Value: &value, UpdatedAt: &updated}, History: []float64{1, 3, 2}},
+			{Metrics: service.Metrics{ID: "<script>", MType: service.CounterMetric, Delta: &delta}},
+		},
+		Query:       url.Values{"glob": {"A*"}, "sort": {"-name"}},
+		Next:        "/?cursor=abc",
+		GeneratedAt: updated,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Render(&buf, view))
+	page := buf.String()
+
+	assert.Contains(t, page, "<td>Alloc</td>")
+	assert.Contains(t, page, "<td class='value'>1.5</td>")
+	assert.Contains(t, page, "<td class='value'>7</td>")
+	assert.Contains(t, page, "2024-05-01 12:30:00 UTC")
+	assert.Contains(t, page, "datetime='2024-05-01T12:30:00Z'")
+	assert.Contains(t, page, "&lt;script&gt;")
+	assert.NotContains(t, page, "<td><script>")
+	assert.Equal(t, 1, strings.Count(page, `<svg class="sparkline"`))
+	assert.Contains(t, page, "value='A*'")
+	assert.Contains(t, page, "href='/?cursor=abc'")
+	assert.Contains(t, page, "Name ▼")
+}
+
+func TestRenderEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Render(&buf, View{}))
+	assert.Contains(t, buf.String(), "No metrics")
+	assert.NotContains(t, buf.String(), "Next page")
+}
+
+func TestSort(t *testing.T) {
+	view := View{Query: url.Values{"glob": {"Heap*"}, "cursor": {"abc"}}}
+	assert.Equal(t, "▲", view.SortMark("name"))
+	assert.Equal(t, "", view.SortMark("type"))
+	assert.Equal(t, "/?glob=Heap%2A&sort=type", string(view.SortURL("type")))
+
+	view.Query.Set("sort", "type")
+	assert.Equal(t, "", view.SortMark("name"))
+	assert.Equal(t, "▲", view.SortMark("type"))
+	assert.Equal(t, "/?glob=Heap%2A&sort=-type", string(view.SortURL("type")))
+	assert.Equal(t, "type", view.Query.Get("sort"), "SortURL must not modify the view query")
+}
+
+func TestSparkline(t *testing.T) {
+	assert.Empty(t, sparkline(nil))
+	assert.Empty(t, sparkline([]float64{1}))
+
+	svg := string(sparkline([]float64{0, 10}))
+	assert.Contains(t, svg, `points="0.0,23.0 120.0,1.0"`)
+
+	svg = string(sparkline([]float64{5, 5, 5}))
+	assert.Contains(t, svg, `points="0.0,12.0 60.0,12.0 120.0,12.0"`)
+}
+
+func TestStatic(t *testing.T) {
+	for _, name := range []string{"dashboard.js", "dashboard.css"} {
+		res := httptest.NewRecorder()
+		Static().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/"+name, nil))
+		assert.Equal(t, http.StatusOK, res.Code, name)
+		assert.NotEmpty(t, res.Body.String(), name)
+	}
+}
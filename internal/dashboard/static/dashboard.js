@@ -0,0 +1,74 @@
+// Скрипт страницы метрик: периодически перечитывает страницу с теми же параметрами
+// и заменяет таблицу, а также показывает время обновления метрик относительно текущего.
+(function () {
+    'use strict';
+
+    const storageKey = 'metrix.refresh';
+    let timer = null;
+
+    // age возвращает время, прошедшее с момента date, в виде "12s ago".
+    function age(date) {
+        const seconds = Math.max(0, Math.round((Date.now() - date.getTime()) / 1000));
+        if (seconds < 60) {
+            return seconds + 's ago';
+        }
+        if (seconds < 3600) {
+            return Math.floor(seconds / 60) + 'm ago';
+        }
+        if (seconds < 86400) {
+            return Math.floor(seconds / 3600) + 'h ago';
+        }
+        return Math.floor(seconds / 86400) + 'd ago';
+    }
+
+    function showAges() {
+        document.querySelectorAll('time.age').forEach(function (el) {
+            const date = new Date(el.getAttribute('datetime'));
+            if (!el.title) {
+                el.title = el.textContent;
+            }
+            el.textContent = age(date);
+        });
+    }
+
+    async function refresh() {
+        try {
+            const response = await fetch(window.location.href, { headers: { Accept: 'text/html' } });
+            if (!response.ok) {
+                return;
+            }
+            const doc = new DOMParser().parseFromString(await response.text(), 'text/html');
+            for (const selector of ['#metrics tbody', '#generated', '#pages']) {
+                const fresh = doc.querySelector(selector);
+                const current = document.querySelector(selector);
+                if (fresh && current) {
+                    current.replaceWith(fresh);
+                }
+            }
+            showAges();
+        } catch (e) {
+            // Сервер недоступен: следующая попытка будет через период обновления.
+        }
+    }
+
+    function schedule(seconds) {
+        if (timer !== null) {
+            clearInterval(timer);
+            timer = null;
+        }
+        if (seconds > 0) {
+            timer = setInterval(refresh, seconds * 1000);
+        }
+    }
+
+    document.addEventListener('DOMContentLoaded', function () {
+        const select = document.getElementById('refresh');
+        select.value = localStorage.getItem(storageKey) || '0';
+        select.addEventListener('change', function () {
+            localStorage.setItem(storageKey, select.value);
+            schedule(Number(select.value));
+        });
+        schedule(Number(select.value));
+        showAges();
+    });
+})();
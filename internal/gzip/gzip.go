@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/dvkhr/metrix.git/internal/tracing"
 )
 
 type compressWriter struct {
@@ -72,6 +74,13 @@ func (c *compressReader) Close() error {
 }
 func GzipMiddleware(h http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Без трассировки запрос не копируется, чтобы middleware ничего не стоил.
+		if tracing.Enabled() {
+			ctx, span := tracing.Start(r.Context(), "gzip")
+			defer span.End()
+			r = r.WithContext(ctx)
+		}
+
 		ow := w
 		acceptEncoding := r.Header.Get("Accept-Encoding")
 		supportsGzip := strings.Contains(acceptEncoding, "gzip")
@@ -0,0 +1,21 @@
+package gzip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dvkhr/metrix.git/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzipMiddleware_TracingDisabled(t *testing.T) {
+	require.False(t, tracing.Enabled())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	var got *http.Request
+	GzipMiddleware(func(w http.ResponseWriter, r *http.Request) { got = r })(httptest.NewRecorder(), req)
+
+	assert.Same(t, req, got, "request must not be copied when tracing is disabled")
+}
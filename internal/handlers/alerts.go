@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dvkhr/metrix.git/internal/alerting"
+	"github.com/dvkhr/metrix.git/internal/apierror"
+)
+
+// HandleAlerts возвращает состояние оповещений всех правил в формате JSON в порядке
+// правил в файле. Параметр запроса state (inactive, pending, firing или resolved)
+// оставляет только оповещения в этом состоянии. Если правила оповещений не заданы,
+// возвращается пустой список.
+//
+// Ошибки возвращаются в формате JSON API: 400 (Bad Request) с кодом validation_error
+// для неизвестного состояния.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос с необязательным параметром state.
+func (ms *MetricsServer) HandleAlerts(res http.ResponseWriter, req *http.Request) {
+	state := alerting.State(req.URL.Query().Get("state"))
+	switch state {
+	case "", alerting.StateInactive, alerting.StatePending, alerting.StateFiring, alerting.StateResolved:
+	default:
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, "unknown alert state "+string(state), nil)
+		return
+	}
+
+	alerts := make([]alerting.Alert, 0)
+	if ms.Alerts != nil {
+		for _, a := range ms.Alerts.Alerts() {
+			if state == "" || a.State == state {
+				alerts = append(alerts, a)
+			}
+		}
+	}
+	writeJSON(res, req, alerts)
+}
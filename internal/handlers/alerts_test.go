@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/alerting"
+	"github.com/dvkhr/metrix.git/internal/config"
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleAlerts(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	rules := filepath.Join(t.TempDir(), "alert_rules.yaml")
+	require.NoError(t, os.WriteFile(rules, []byte(`
+rules:
+  - name: Hot
+    expr: gauge CPU > 90
+  - name: LowFreeMemory
+    expr: gauge FreeMemory < 500MB for 1h
+`), 0o600))
+
+	server, err := NewMetricsServer(config.ConfigServ{AlertRules: rules, AlertInterval: time.Millisecond})
+	require.NoError(t, err)
+	defer server.Close()
+	require.NotNil(t, server.Alerts)
+
+	cpu, free := service.GaugeMetricValue(95), service.GaugeMetricValue(1<<20)
+	require.NoError(t, server.MetricStorage.Save(context.Background(), service.Metrics{ID: "CPU", MType: service.GaugeMetric, Value: &cpu}))
+	require.NoError(t, server.MetricStorage.Save(context.Background(), service.Metrics{ID: "FreeMemory", MType: service.GaugeMetric, Value: &free}))
+
+	get := func(s *MetricsServer, target string) (*httptest.ResponseRecorder, []alerting.Alert) {
+		res := httptest.NewRecorder()
+		s.HandleAlerts(res, httptest.NewRequest(http.MethodGet, target, nil))
+		var alerts []alerting.Alert
+		if res.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &alerts))
+		}
+		return res, alerts
+	}
+
+	t.Run("All alerts", func(t *testing.T) {
+		assert.Eventually(t, func() bool {
+			_, alerts := get(server, "/alerts")
+			return len(alerts) == 2 && alerts[0].State == alerting.StateFiring && alerts[1].State == alerting.StatePending
+		}, 5*time.Second, time.Millisecond)
+	})
+
+	t.Run("Filter by state", func(t *testing.T) {
+		res, alerts := get(server, "/alerts?state=firing")
+		assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+		require.Len(t, alerts, 1)
+		assert.Equal(t, "Hot", alerts[0].Rule)
+
+		_, alerts = get(server, "/alerts?state=resolved")
+		assert.Empty(t, alerts)
+	})
+
+	t.Run("Unknown state", func(t *testing.T) {
+		res, _ := get(server, "/alerts?state=broken")
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("Without rules", func(t *testing.T) {
+		res, _ := get(&MetricsServer{}, "/alerts")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.JSONEq(t, "[]", res.Body.String())
+	})
+
+	t.Run("Invalid rules file", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "alert_rules.json")
+		require.NoError(t, os.WriteFile(bad, []byte(`{"rules": [{"name": "Hot", "expr": "CPU > 90"}]}`), 0o600))
+		_, err := NewMetricsServer(config.ConfigServ{AlertRules: bad, AlertInterval: time.Second})
+		assert.ErrorIs(t, err, alerting.ErrInvalidRule)
+	})
+}
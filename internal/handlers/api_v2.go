@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/dvkhr/metrix.git/internal/apierror"
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/go-chi/chi/v5"
+)
+
+// openAPISpec — описание API версии 2 в формате OpenAPI 3, встроенное в исполняемый файл.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Размер страницы списка метрик API v2 и HTML-страницы.
+const (
+	// DefaultPageSize — число метрик на странице, если параметр limit не задан.
+	DefaultPageSize = 100
+	// MaxPageSize — наибольшее допустимое значение параметра limit.
+	MaxPageSize = 1000
+)
+
+// Форматы ответа API v2.
+const (
+	mediaJSON = "application/json"
+	mediaText = "text/plain"
+)
+
+// metricsPage — страница списка метрик API v2.
+//
+// Поля:
+//   - Metrics: Метрики страницы в запрошенном порядке.
+//   - NextCursor: Курсор следующей страницы; пуст на последней странице.
+type metricsPage struct {
+	Metrics    []service.Metrics `json:"metrics"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// negotiate выбирает формат ответа по заголовку Accept: application/json (по умолчанию)
+// или text/plain. Варианты перебираются в порядке перечисления, варианты с q=0 пропускаются.
+// Если ни один вариант не поддерживается, отправляет 406 (Not Acceptable) и возвращает false.
+func negotiate(res http.ResponseWriter, req *http.Request) (string, bool) {
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return mediaJSON, true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case mediaJSON, "application/*", "*/*":
+			return mediaJSON, true
+		case mediaText, "text/*":
+			return mediaText, true
+		}
+	}
+	apierror.Write(res, req, http.StatusNotAcceptable, apierror.CodeNotAcceptable,
+		fmt.Sprintf("supported formats: %s, %s", mediaJSON, mediaText), nil)
+	return "", false
+}
+
+// HandleOpenAPI отдает описание API версии 2 в формате OpenAPI 3 (JSON).
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос.
+func (ms *MetricsServer) HandleOpenAPI(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", mediaJSON)
+	res.WriteHeader(http.StatusOK)
+	res.Write(openAPISpec)
+}
+
+// HandleListMetricsV2 обрабатывает запросы GET /api/v2/metrics: возвращает страницу
+// метрик. Отбор, сортировку и разбиение на страницы выполняет хранилище (ListPage).
+//
+// Параметры запроса:
+//   - type: Тип метрик (gauge или counter); по умолчанию все типы.
+//   - prefix, glob, regex: Шаблон имени метрики (не больше одного, см. storage.MatchMode).
+//   - sort: Порядок метрик: name (по умолчанию), -name, type или -type.
+//   - limit: Размер страницы, от 1 до MaxPageSize (по умолчанию DefaultPageSize).
+//   - cursor: Курсор из next_cursor предыдущей страницы.
+//
+// В формате JSON возвращается {"metrics": [...], "next_cursor": "..."}, в формате text/plain —
+// строки "имя тип значение". Курсор следующей страницы также передается в заголовке X-Next-Cursor.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос с параметрами фильтрации и пагинации.
+func (ms *MetricsServer) HandleListMetricsV2(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageReadTimeout)
+	defer cancel()
+
+	media, ok := negotiate(res, req)
+	if !ok {
+		return
+	}
+	q, err := parseListQuery(req)
+	if err != nil {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, err.Error(), nil)
+		return
+	}
+	found, err := ms.MetricStorage.ListPage(ctx, q)
+	if err != nil {
+		writeStorageError(res, req, err, "failed to list metrics")
+		return
+	}
+	page := metricsPage{Metrics: found.Metrics, NextCursor: found.NextCursor}
+
+	if page.NextCursor != "" {
+		res.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	if media == mediaText {
+		res.Header().Set("Content-Type", mediaText+"; charset=utf-8")
+		for _, mt := range page.Metrics {
+			fmt.Fprintf(res, "%s %s %s\n", mt.ID, mt.MType, mt.FormatValue())
+		}
+		return
+	}
+	writeJSON(res, req, page)
+}
+
+// HandleGetMetricV2 обрабатывает запросы GET /api/v2/metrics/{name}: возвращает метрику
+// в формате JSON или, для Accept: text/plain, ее значение простым текстом.
+// Если метрики нет, возвращается 404 (Not Found) с кодом not_found.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос с параметром пути "name".
+func (ms *MetricsServer) HandleGetMetricV2(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageReadTimeout)
+	defer cancel()
+
+	media, ok := negotiate(res, req)
+	if !ok {
+		return
+	}
+	mt, err := ms.MetricStorage.Get(ctx, chi.URLParam(req, "name"))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMetricName) {
+			err = service.ErrUnknownMetric
+		}
+		writeStorageError(res, req, err, "failed to read metric")
+		return
+	}
+	if media == mediaText {
+		res.Header().Set("Content-Type", mediaText+"; charset=utf-8")
+		fmt.Fprint(res, mt.FormatValue())
+		return
+	}
+	writeJSON(res, req, mt)
+}
+
+// HandleUpsertMetricsV2 обрабатывает запросы POST /api/v2/metrics: сохраняет массив метрик
+// из тела запроса (Content-Type: application/json). Счетчики накапливаются, gauge заменяются.
+//
+// Метрики проверяются пакетом validation; без параметра partial=true пакет с некорректными
+// метриками отклоняется целиком, с ним сохраняются только корректные. В ответ возвращается
+// отчет {"accepted": 2, "rejected": [...]}.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос с массивом метрик в формате JSON в теле.
+func (ms *MetricsServer) HandleUpsertMetricsV2(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
+
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != mediaJSON {
+			apierror.Write(res, req, http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMediaType,
+				"request body must be "+mediaJSON, nil)
+			return
+		}
+	}
+	var metrics []service.Metrics
+	if err := ReadAndUnmarshal(req, &metrics); err != nil {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeDecode, "failed to parse metrics: "+err.Error(), nil)
+		return
+	}
+	batchSizeHist.Observe(float64(len(metrics)))
+
+	report, ok := ms.acceptBatch(ctx, res, req, metrics, isPartial(req))
+	if !ok {
+		return
+	}
+	writeJSON(res, req, report)
+}
+
+// HandleDeleteMetricV2 обрабатывает запросы DELETE /api/v2/metrics/{name}: удаляет метрику
+// любого типа. Отвечает 204 (No Content) или 404 (Not Found), если метрики нет.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос с параметром пути "name".
+func (ms *MetricsServer) HandleDeleteMetricV2(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
+
+	name := chi.URLParam(req, "name")
+	deleted, err := ms.MetricStorage.Delete(ctx, name)
+	if errors.Is(err, service.ErrInvalidMetricName) {
+		deleted, err = 0, nil
+	}
+	if err != nil {
+		writeStorageError(res, req, err, "failed to delete metric")
+		return
+	}
+	if deleted == 0 {
+		writeStorageError(res, req, service.ErrUnknownMetric, "")
+		return
+	}
+	logging.Logg.InfoContext(ctx, "Metric deleted", "name", name)
+	res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dvkhr/metrix.git/internal/apierror"
+	"github.com/dvkhr/metrix.git/internal/config"
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/routes"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIv2(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	newRouter := func(t *testing.T) *chi.Mux {
+		cfg := config.ConfigServ{AdminToken: "secret"}
+		server, err := NewMetricsServer(cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { server.MetricStorage.FreeStorage() })
+
+		ctx := context.Background()
+		for i, id := range []string{"Alloc", "Frees", "HeapAlloc", "HeapIdle", "Mallocs"} {
+			value := service.GaugeMetricValue(i)
+			require.NoError(t, server.MetricStorage.Save(ctx, service.Metrics{ID: id, MType: service.GaugeMetric, Value: &value}))
+		}
+		delta := service.CounterMetricValue(5)
+		require.NoError(t, server.MetricStorage.Save(ctx, service.Metrics{ID: "PollCount", MType: service.CounterMetric, Delta: &delta}))
+		return routes.SetupRoutes(chi.NewRouter(), logging.Logg, cfg, server)
+	}
+
+	do := func(router *chi.Mux, method, target, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	ids := func(t *testing.T, res *httptest.ResponseRecorder) ([]string, string) {
+		t.Helper()
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		var page metricsPage
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &page))
+		names := make([]string, 0, len(page.Metrics))
+		for _, mt := range page.Metrics {
+			names = append(names, mt.ID)
+		}
+		return names, page.NextCursor
+	}
+
+	t.Run("List with filters", func(t *testing.T) {
+		router := newRouter(t)
+		names, cursor := ids(t, do(router, http.MethodGet, "/api/v2/metrics", ""))
+		assert.Equal(t, []string{"Alloc", "Frees", "HeapAlloc", "HeapIdle", "Mallocs", "PollCount"}, names)
+		assert.Empty(t, cursor)
+
+		names, _ = ids(t, do(router, http.MethodGet, "/api/v2/metrics?prefix=Heap", ""))
+		assert.Equal(t, []string{"HeapAlloc", "HeapIdle"}, names)
+		names, _ = ids(t, do(router, http.MethodGet, "/api/v2/metrics?type=counter", ""))
+		assert.Equal(t, []string{"PollCount"}, names)
+	})
+
+	t.Run("Glob, regex and sort", func(t *testing.T) {
+		router := newRouter(t)
+		names, _ := ids(t, do(router, http.MethodGet, "/api/v2/metrics?glob=*Alloc", ""))
+		assert.Equal(t, []string{"Alloc", "HeapAlloc"}, names)
+		names, _ = ids(t, do(router, http.MethodGet, "/api/v2/metrics?regex=^(Frees|Mallocs)$&sort=-name", ""))
+		assert.Equal(t, []string{"Mallocs", "Frees"}, names)
+		names, _ = ids(t, do(router, http.MethodGet, "/api/v2/metrics?sort=type&limit=2", ""))
+		assert.Equal(t, []string{"PollCount", "Alloc"}, names)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		router := newRouter(t)
+		var all []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 4)
+			res := do(router, http.MethodGet, "/api/v2/metrics?limit=2&cursor="+cursor, "")
+			names, next := ids(t, res)
+			assert.Equal(t, next, res.Header().Get("X-Next-Cursor"))
+			all = append(all, names...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		assert.Equal(t, []string{"Alloc", "Frees", "HeapAlloc", "HeapIdle", "Mallocs", "PollCount"}, all)
+	})
+
+	t.Run("Invalid list parameters", func(t *testing.T) {
+		router := newRouter(t)
+		for _, target := range []string{
+			"/api/v2/metrics?limit=0",
+			"/api/v2/metrics?limit=x",
+			"/api/v2/metrics?type=histogram",
+			"/api/v2/metrics?sort=value",
+			"/api/v2/metrics?regex=(",
+			"/api/v2/metrics?glob=[",
+			"/api/v2/metrics?prefix=Heap&glob=*",
+			"/api/v2/metrics?cursor=%25%25",
+		} {
+			res := do(router, http.MethodGet, target, "")
+			assert.Equal(t, http.StatusBadRequest, res.Code, target)
+			assert.Equal(t, apierror.CodeValidation, decodeAPIError(t, res).Code, target)
+		}
+	})
+
+	t.Run("Content negotiation", func(t *testing.T) {
+		router := newRouter(t)
+		res := do(router, http.MethodGet, "/api/v2/metrics/PollCount", "", "Accept", "text/plain")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "5", res.Body.String())
+
+		res = do(router, http.MethodGet, "/api/v2/metrics?type=counter", "", "Accept", "text/html, text/*;q=0.5")
+		assert.Equal(t, "PollCount counter 5\n", res.Body.String())
+
+		res = do(router, http.MethodGet, "/api/v2/metrics/PollCount", "", "Accept", "text/html, application/json")
+		var mt service.Metrics
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &mt))
+		assert.Equal(t, service.CounterMetricValue(5), *mt.Delta)
+
+		res = do(router, http.MethodGet, "/api/v2/metrics/PollCount", "", "Accept", "application/xml")
+		assert.Equal(t, http.StatusNotAcceptable, res.Code)
+		assert.Equal(t, apierror.CodeNotAcceptable, decodeAPIError(t, res).Code)
+	})
+
+	t.Run("Get missing metric", func(t *testing.T) {
+		router := newRouter(t)
+		res := do(router, http.MethodGet, "/api/v2/metrics/missing", "")
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Equal(t, apierror.CodeNotFound, decodeAPIError(t, res).Code)
+	})
+
+	t.Run("Batch upsert", func(t *testing.T) {
+		router := newRouter(t)
+		body := `[{"id":"PollCount","type":"counter","delta":2},{"id":"Alloc","type":"gauge","value":9.5}]`
+		res := do(router, http.MethodPost, "/api/v2/metrics", body, "Content-Type", "application/json")
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.JSONEq(t, `{"accepted": 2, "rejected": []}`, res.Body.String())
+		assert.Equal(t, "7", do(router, http.MethodGet, "/api/v2/metrics/PollCount", "", "Accept", "text/plain").Body.String())
+		assert.Equal(t, "9.5", do(router, http.MethodGet, "/api/v2/metrics/Alloc", "", "Accept", "text/plain").Body.String())
+
+		res = do(router, http.MethodPost, "/api/v2/metrics?partial=true", `[{"id":"New","type":"gauge","value":1},{"id":"bad","type":"counter","delta":-1}]`)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), `"accepted":1`)
+
+		res = do(router, http.MethodPost, "/api/v2/metrics", `[{"id":"Alloc","type":"counter","delta":1}]`)
+		assert.Equal(t, http.StatusConflict, res.Code)
+
+		res = do(router, http.MethodPost, "/api/v2/metrics", "id=Alloc", "Content-Type", "application/x-www-form-urlencoded")
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.Code)
+		assert.Equal(t, apierror.CodeUnsupportedMediaType, decodeAPIError(t, res).Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		router := newRouter(t)
+		assert.Equal(t, http.StatusUnauthorized, do(router, http.MethodDelete, "/api/v2/metrics/Alloc", "").Code)
+		res := do(router, http.MethodDelete, "/api/v2/metrics/Alloc", "", "Authorization", "Bearer secret")
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		res = do(router, http.MethodDelete, "/api/v2/metrics/Alloc", "", "Authorization", "Bearer secret")
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodGet, "/api/v2/metrics/Alloc", "").Code)
+	})
+
+	t.Run("v1 routes are intact", func(t *testing.T) {
+		router := newRouter(t)
+		assert.Equal(t, http.StatusOK, do(router, http.MethodPost, "/update/counter/PollCount/1", "").Code)
+		assert.Equal(t, "6", do(router, http.MethodGet, "/value/counter/PollCount", "").Body.String())
+	})
+}
+
+// TestOpenAPISpec проверяет, что встроенный документ OpenAPI описывает все маршруты /api/v2.
+func TestOpenAPISpec(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	server, err := NewMetricsServer(config.ConfigServ{})
+	require.NoError(t, err)
+	defer server.MetricStorage.FreeStorage()
+	router := routes.SetupRoutes(chi.NewRouter(), logging.Logg, server.Config, server)
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/api/v2/openapi.json", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &spec))
+	assert.True(t, strings.HasPrefix(spec.OpenAPI, "3."))
+
+	var documented int
+	err = chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		path, ok := strings.CutPrefix(route, "/api/v2")
+		if !ok {
+			return nil
+		}
+		documented++
+		assert.Contains(t, spec.Paths[path], strings.ToLower(method), "%s %s is not documented", method, route)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, documented)
+}
@@ -3,32 +3,36 @@ package handlers
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
 	"strconv"
-	"text/template"
+	"time"
 
+	"github.com/dvkhr/metrix.git/internal/apierror"
+	"github.com/dvkhr/metrix.git/internal/dashboard"
 	"github.com/dvkhr/metrix.git/internal/logging"
 	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/dvkhr/metrix.git/internal/storage"
+	"github.com/dvkhr/metrix.git/internal/validation"
 	"github.com/go-chi/chi/v5"
-	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 // HandlePutGaugeMetric обрабатывает HTTP-запросы на сохранение метрики типа "gauge".
 //
 // Метод извлекает имя метрики и её значение из параметров запроса, проверяет
-// их корректность, и сохраняет метрику в хранилище.
+// их корректность (см. пакет validation), и сохраняет метрику в хранилище. Если метрика с таким именем
+// уже существует с другим типом, возвращается 409 (Conflict). Ошибки возвращаются простым
+// текстом, как и раньше.
 //
 // Параметры:
 // - res: HTTP-ответ, который будет отправлен клиенту.
 // - req: HTTP-запрос, содержащий параметры пути "name" и "value".
 func (ms *MetricsServer) HandlePutGaugeMetric(res http.ResponseWriter, req *http.Request) {
-	ms.syncMutex.Lock()
-	defer ms.syncMutex.Unlock()
-
-	ctx := context.TODO()
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
 
 	n := req.PathValue("name")
 	if len(n) == 0 {
@@ -46,25 +50,36 @@ func (ms *MetricsServer) HandlePutGaugeMetric(res http.ResponseWriter, req *http
 	vtemp := service.GaugeMetricValue(v)
 	mTemp.Value = &vtemp
 	mTemp.MType = service.GaugeMetric
+	if err := validation.Metric(*mTemp); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	ms.MetricStorage.Save(ctx, *mTemp)
-	ms.MetricStorage.Get(ctx, req.PathValue("name"))
+	if err := ms.MetricStorage.Save(ctx, *mTemp); err != nil {
+		if errors.Is(err, service.ErrTypeConflict) {
+			http.Error(res, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(res, "Failed to save metric!", storageErrorStatus(err, http.StatusInternalServerError))
+		return
+	}
+	ms.publish(ctx, n)
 	res.WriteHeader(http.StatusOK)
 }
 
 // HandlePutCounterMetric обрабатывает HTTP-запросы на сохранение метрики типа "counter".
 //
 // Метод извлекает имя метрики и её значение из параметров запроса, проверяет
-// их корректность, и сохраняет метрику в хранилище.
+// их корректность (см. пакет validation), и сохраняет метрику в хранилище. Если метрика с таким именем
+// уже существует с другим типом, возвращается 409 (Conflict). Ошибки возвращаются простым
+// текстом, как и раньше.
 //
 // Параметры:
 // - res: HTTP-ответ, который будет отправлен клиенту.
 // - req: HTTP-запрос, содержащий параметры пути "name" и "value".
 func (ms *MetricsServer) HandlePutCounterMetric(res http.ResponseWriter, req *http.Request) {
-	ms.syncMutex.Lock()
-	defer ms.syncMutex.Unlock()
-
-	ctx := context.TODO()
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
 
 	n := req.PathValue("name")
 	if len(n) == 0 {
@@ -82,30 +97,41 @@ func (ms *MetricsServer) HandlePutCounterMetric(res http.ResponseWriter, req *ht
 	vtemp := service.CounterMetricValue(v)
 	mTemp.Delta = &vtemp
 	mTemp.MType = service.CounterMetric
+	if err := validation.Metric(*mTemp); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	ms.MetricStorage.Save(ctx, *mTemp)
-	ms.MetricStorage.Get(ctx, req.PathValue("name"))
+	if err := ms.MetricStorage.Save(ctx, *mTemp); err != nil {
+		if errors.Is(err, service.ErrTypeConflict) {
+			http.Error(res, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(res, "Failed to save metric!", storageErrorStatus(err, http.StatusInternalServerError))
+		return
+	}
+	ms.publish(ctx, n)
 	res.WriteHeader(http.StatusOK)
 }
 
 // UpdateMetric обрабатывает HTTP-запросы на обновление метрик через JSON.
 //
 // Метод принимает метрику в формате JSON, проверяет её корректность, сохраняет
-// в хранилище и возвращает обновленную метрику в ответе.
+// в хранилище и возвращает обновленную метрику в ответе. Ошибки возвращаются
+// в формате JSON API (см. пакет apierror): 400 (Bad Request) с кодом decode_error
+// или validation_error для некорректного запроса, 409 (Conflict) с кодом type_conflict,
+// если метрика с таким именем уже существует с другим типом (сменить тип метрики можно
+// только через HandleReplaceMetric), и storage_unavailable, если хранилище недоступно.
 //
 // Параметры:
 // - res: HTTP-ответ, который будет отправлен клиенту.
 // - req: HTTP-запрос, содержащий метрику в формате JSON в теле запроса
 func (ms *MetricsServer) UpdateMetric(res http.ResponseWriter, req *http.Request) {
-	ms.syncMutex.Lock()
-	defer ms.syncMutex.Unlock()
-
-	ctx := context.TODO()
-
-	res.Header().Set("Content-Type", "application/json")
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
 
 	if req.Method != http.MethodPost {
-		http.Error(res, "Only POST requests are allowed!", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(res, req, http.MethodPost)
 		return
 	}
 	mTemp := &service.Metrics{}
@@ -113,49 +139,48 @@ func (ms *MetricsServer) UpdateMetric(res http.ResponseWriter, req *http.Request
 
 	_, err := bufJSON.ReadFrom(req.Body)
 	if err != nil {
-		res.WriteHeader(http.StatusBadRequest)
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeDecode, "failed to read request body", nil)
 		return
 	}
 	defer req.Body.Close()
 	if err := json.Unmarshal(bufJSON.Bytes(), mTemp); err != nil {
-		res.WriteHeader(http.StatusBadRequest)
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeDecode, "failed to parse metric: "+err.Error(), nil)
 		return
 	}
-	if err := ms.MetricStorage.Save(ctx, *mTemp); err != nil {
-		res.WriteHeader(http.StatusBadRequest)
+	if err := validation.Metric(*mTemp); err != nil {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, err.Error(), nil)
 		return
 	}
-
-	if mTemp, err = ms.MetricStorage.Get(ctx, mTemp.ID); err != nil {
-		res.WriteHeader(http.StatusBadRequest)
+	if err := ms.MetricStorage.Save(ctx, *mTemp); err != nil {
+		writeStorageError(res, req, err, "failed to save metric")
 		return
 	}
 
-	bufResp, err := json.Marshal(mTemp)
-	if err != nil {
-		res.WriteHeader(http.StatusBadRequest)
+	if mTemp, err = ms.MetricStorage.Get(ctx, mTemp.ID); err != nil {
+		writeStorageError(res, req, err, "failed to read saved metric")
 		return
 	}
-	res.WriteHeader(http.StatusOK)
-
-	res.Write(bufResp)
-
+	ms.Hub.Publish(*mTemp)
+	writeJSON(res, req, mTemp)
 }
 
 // ExtractMetric обрабатывает HTTP-запросы на получение метрик через JSON.
 //
 // Метод принимает метрику в формате JSON, проверяет её корректность, извлекает
-// метрику из хранилища и возвращает её в ответе.
+// метрику из хранилища и возвращает её в ответе. Ошибки возвращаются в формате
+// JSON API (см. пакет apierror): 400 (Bad Request) для некорректного запроса и
+// 404 (Not Found), если метрики нет или она сохранена с другим типом. Конфликт типа
+// (409) возвращают только запросы на запись.
 //
 // Параметры:
 // - res: HTTP-ответ, который будет отправлен клиенту.
 // - req: HTTP-запрос, содержащий метрику в формате JSON в теле запроса.
 func (ms *MetricsServer) ExtractMetric(res http.ResponseWriter, req *http.Request) {
-	ctx := context.TODO()
-	res.Header().Set("Content-Type", "application/json")
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageReadTimeout)
+	defer cancel()
 
 	if req.Method != http.MethodPost {
-		http.Error(res, "Only POST requests are allowed!", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(res, req, http.MethodPost)
 		return
 	}
 	mTemp := &service.Metrics{}
@@ -163,35 +188,31 @@ func (ms *MetricsServer) ExtractMetric(res http.ResponseWriter, req *http.Reques
 
 	_, err := bufJSON.ReadFrom(req.Body)
 	if err != nil {
-		res.WriteHeader(http.StatusNotFound)
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeDecode, "failed to read request body", nil)
 		return
 	}
 	defer req.Body.Close()
 	if err := json.Unmarshal(bufJSON.Bytes(), mTemp); err != nil {
-		res.WriteHeader(http.StatusNotFound)
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeDecode, "failed to parse metric: "+err.Error(), nil)
+		return
+	}
+	if err := validation.Name(mTemp.ID); err != nil {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, err.Error(), nil)
 		return
 	}
 
 	mType := mTemp.MType
 
 	if mTemp, err = ms.MetricStorage.Get(ctx, mTemp.ID); err != nil {
-		res.WriteHeader(http.StatusNotFound)
+		writeStorageError(res, req, err, "failed to read metric")
 		return
 	}
 
 	if mTemp.MType != mType {
-		res.WriteHeader(http.StatusNotFound)
+		writeStorageError(res, req, service.ErrUnknownMetric, "failed to read metric")
 		return
 	}
-
-	bufResp, err := json.Marshal(mTemp)
-	if err != nil {
-		res.WriteHeader(http.StatusNotFound)
-		return
-	}
-	res.WriteHeader(http.StatusOK)
-
-	res.Write(bufResp)
+	writeJSON(res, req, mTemp)
 }
 
 // HandleGetMetric обрабатывает HTTP-запросы на получение значения метрики.
@@ -204,7 +225,8 @@ func (ms *MetricsServer) ExtractMetric(res http.ResponseWriter, req *http.Reques
 // - res: HTTP-ответ, который будет отправлен клиенту.
 // - req: HTTP-запрос, содержащий параметры пути "type" и "name".
 func (ms *MetricsServer) HandleGetMetric(res http.ResponseWriter, req *http.Request) {
-	ctx := context.TODO()
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageReadTimeout)
+	defer cancel()
 	res.Header().Set("Content-Type", "text/html")
 	if req.Method != http.MethodGet {
 		http.Error(res, "Only GET requests are allowed!", http.StatusMethodNotAllowed)
@@ -218,54 +240,87 @@ func (ms *MetricsServer) HandleGetMetric(res http.ResponseWriter, req *http.Requ
 	n := chi.URLParam(req, "name")
 	mTemp, err := ms.MetricStorage.Get(ctx, n)
 	if err != nil {
+		if status := storageErrorStatus(err, http.StatusNotFound); status != http.StatusNotFound {
+			http.Error(res, http.StatusText(status), status)
+			return
+		}
 		http.Error(res, "Metric not found!", http.StatusNotFound)
 		return
 	}
 	switch mTemp.MType {
 	case service.GaugeMetric:
 		value := mTemp.Value
-		logging.Logg.Info("res", "%v", *value)
+		logging.Logg.InfoContext(ctx, "res", "value", *value)
 		fmt.Fprintf(res, "%v", *value)
 
 	case service.CounterMetric:
 		value := mTemp.Delta
-		logging.Logg.Info("res", "%v", *value)
+		logging.Logg.InfoContext(ctx, "res", "value", *value)
 		fmt.Fprintf(res, "%v", *value)
 	}
 }
 
-// HandleGetAllMetrics обрабатывает HTTP-запросы на получение всех метрик в виде HTML-страницы.
+// HandleGetAllMetrics обрабатывает HTTP-запросы на получение списка метрик в виде HTML-страницы.
 //
-// Метод извлекает все метрики из хранилища, записывает их в HTML-шаблон и
-// возвращает результат клиенту.
+// Метод принимает те же параметры выборки, что и GET /api/v2/metrics (type, prefix, glob,
+// regex, sort, limit, cursor), получает страницу метрик из хранилища (ListPage) и отображает
+// ее вместе с историей значений, если сервер ее ведет (см. пакет dashboard).
+// Некорректные параметры выборки дают 400 (Bad Request).
 //
 // Параметры:
 // - res: HTTP-ответ, который будет отправлен клиенту.
-// - req: HTTP-запрос, содержащий запрос на получение всех метрик.
+// - req: HTTP-запрос, содержащий запрос на получение метрик.
 func (ms *MetricsServer) HandleGetAllMetrics(res http.ResponseWriter, req *http.Request) {
-	ctx := context.TODO()
-	res.Header().Set("Content-Type", "text/html")
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageReadTimeout)
+	defer cancel()
 
 	if req.Method != http.MethodGet {
 		http.Error(res, "Only GET requests are allowed!", http.StatusMethodNotAllowed)
 		return
 	}
-	tmpl, err := template.ParseFiles("cmd/server/static/index.html.tmpl")
+	q, err := parseListQuery(req)
 	if err != nil {
-		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
-	mtrx, err := ms.MetricStorage.List(ctx)
-	if err != nil {
-		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	page, err := ms.MetricStorage.ListPage(ctx, q)
+	if errors.Is(err, storage.ErrInvalidQuery) {
+		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
-	err = tmpl.Execute(res, *mtrx)
 	if err != nil {
+		status := storageErrorStatus(err, http.StatusInternalServerError)
+		http.Error(res, http.StatusText(status), status)
+		return
+	}
+
+	view := dashboard.View{
+		Rows:        make([]dashboard.Row, 0, len(page.Metrics)),
+		Query:       req.URL.Query(),
+		GeneratedAt: time.Now(),
+	}
+	for _, mt := range page.Metrics {
+		row := dashboard.Row{Metrics: mt}
+		if ms.History != nil {
+			row.History = ms.History.Values(mt.ID)
+		}
+		view.Rows = append(view.Rows, row)
+	}
+	if page.NextCursor != "" {
+		next := req.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		view.Next = template.URL("/?" + next.Encode())
+	}
+
+	var buf bytes.Buffer
+	if err := dashboard.Render(&buf, view); err != nil {
+		logging.Logg.ErrorContext(ctx, "Failed to render metrics page", "error", err)
 		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
 	res.WriteHeader(http.StatusOK)
+	res.Write(buf.Bytes())
 }
 
 // CheckDBConnect обрабатывает HTTP-запросы на проверку подключения к базе данных.
@@ -280,8 +335,11 @@ func (ms *MetricsServer) CheckDBConnect(res http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	if err := ms.MetricStorage.CheckStorage(); err != nil {
-		http.Error(res, "database connection failed", http.StatusInternalServerError)
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageReadTimeout)
+	defer cancel()
+
+	if err := ms.MetricStorage.CheckStorage(ctx); err != nil {
+		http.Error(res, "database connection failed", storageErrorStatus(err, http.StatusInternalServerError))
 		return
 	}
 	res.WriteHeader(http.StatusOK)
@@ -292,63 +350,79 @@ func (ms *MetricsServer) CheckDBConnect(res http.ResponseWriter, req *http.Reque
 //
 // Метод принимает зашифрованный массив метрик в формате JSON, проверяет их корректность,
 // сохраняет в хранилище и возвращает обновленный список всех метрик в ответе.
+// Пакет сохраняется целиком или не сохраняется вовсе: если хотя бы одна метрика
+// конфликтует по типу с сохраненной, возвращается 409 (Conflict) с кодом type_conflict.
+//
+// Каждая метрика проверяется пакетом validation. По умолчанию пакет с некорректными
+// метриками отклоняется целиком: 400 (Bad Request) с кодом validation_error, а в details
+// перечисляются отклоненные метрики: [{"index": 1, "id": "...", "error": "..."}].
+// С параметром запроса partial=true корректные метрики сохраняются, а в ответе вместо
+// списка всех метрик возвращается отчет {"accepted": 2, "rejected": [...]}.
+//
+// Остальные ошибки также возвращаются в формате JSON API (см. пакет apierror):
+// decode_error для нечитаемого тела, decrypt_error, если тело не удалось расшифровать,
+// и storage_unavailable, если хранилище недоступно.
 //
 // Параметры:
 // - res: HTTP-ответ, который будет отправлен клиенту.
 // - req: HTTP-запрос, содержащий массив метрик в формате JSON в теле запроса.
 func (ms *MetricsServer) UpdateBatch(res http.ResponseWriter, req *http.Request) {
-	ms.syncMutex.Lock()
-	defer ms.syncMutex.Unlock()
-
-	ctx := context.TODO()
-
-	res.Header().Set("Content-Type", "application/json")
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
 
 	if err := ms.checkPostMethod(req); err != nil {
-		http.Error(res, err.Error(), http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(res, req, http.MethodPost)
 		return
 	}
 
 	body, err := ms.readRequestBody(req)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeDecode, err.Error(), nil)
 		return
 	}
 
 	privateKey, err := ms.loadPrivateKey()
 	if err != nil {
-		logging.Logg.Error("Failed to load private key: %v", err)
-		http.Error(res, "Failed to load private key", http.StatusInternalServerError)
+		logging.Logg.ErrorContext(ctx, "Failed to load private key", "error", err)
+		apierror.Write(res, req, http.StatusInternalServerError, apierror.CodeInternal, "failed to load private key", nil)
 		return
 	}
-	decryptedData, err := ms.decryptData(body, privateKey)
+	decryptedData, err := ms.decryptData(ctx, body, privateKey)
 	if err != nil {
-		logging.Logg.Error("Failed to decrypt data: %v", err)
-		http.Error(res, "Failed to decrypt data", http.StatusInternalServerError)
+		logging.Logg.ErrorContext(ctx, "Failed to decrypt data", "error", err)
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeDecrypt, "failed to decrypt data", nil)
 		return
 	}
 
-	metrics, err := ms.parseMetrics(decryptedData)
+	metrics, err := ms.parseMetrics(ctx, decryptedData)
 	if err != nil {
-		logging.Logg.Error("Failed to parse metrics: %v", err)
-		http.Error(res, "Failed to parse metrics", http.StatusBadRequest)
+		logging.Logg.ErrorContext(ctx, "Failed to parse metrics", "error", err)
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeDecode, err.Error(), nil)
 		return
 	}
+	batchSizeHist.Observe(float64(len(metrics)))
 
-	if err := ms.saveMetrics(ctx, metrics); err != nil {
-		http.Error(res, "Failed to save metrics", http.StatusBadRequest)
+	partial := isPartial(req)
+	report, ok := ms.acceptBatch(ctx, res, req, metrics, partial)
+	if !ok {
 		return
 	}
 
-	allMetrics, err := ms.getAllMetrics(ctx)
-	if err != nil {
-		http.Error(res, "Failed to retrieve metrics", http.StatusBadRequest)
-		return
+	var reply any
+	if partial {
+		reply = report
+	} else {
+		allMetrics, err := ms.getAllMetrics(ctx)
+		if err != nil {
+			writeStorageError(res, req, err, "failed to retrieve metrics")
+			return
+		}
+		reply = allMetrics
 	}
 
-	response, hash, err := ms.prepareResponse(allMetrics, ms.Config.Key)
+	response, hash, err := ms.prepareResponse(reply, ms.Config.Key)
 	if err != nil {
-		http.Error(res, "Failed to prepare response", http.StatusBadRequest)
+		apierror.Write(res, req, http.StatusInternalServerError, apierror.CodeInternal, "failed to prepare response", nil)
 		return
 	}
 
@@ -356,6 +430,200 @@ func (ms *MetricsServer) UpdateBatch(res http.ResponseWriter, req *http.Request)
 		res.Header().Set("HashSHA256", hash)
 	}
 
+	res.Header().Set("Content-Type", "application/json")
 	res.WriteHeader(http.StatusOK)
 	res.Write(response)
 }
+
+// HandleDeleteMetric обрабатывает HTTP-запросы на удаление метрики.
+//
+// Метод удаляет метрику с указанными именем и типом; тип проверяется хранилищем вместе
+// с удалением, поэтому метрика, замененная метрикой другого типа, не удаляется.
+// Счетчик удаляется вместе с накопленным значением; чтобы обнулить счетчик, не удаляя его,
+// используется HandleResetCounter. Ошибки возвращаются в формате JSON API.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос, содержащий параметры пути "type" и "name".
+func (ms *MetricsServer) HandleDeleteMetric(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
+	if req.Method != http.MethodDelete {
+		writeMethodNotAllowed(res, req, http.MethodDelete)
+		return
+	}
+	t := service.MetricType(chi.URLParam(req, "type"))
+	if t != service.GaugeMetric && t != service.CounterMetric {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation,
+			fmt.Sprintf("%v %q", validation.ErrInvalidType, t), nil)
+		return
+	}
+	n := chi.URLParam(req, "name")
+	deleted, err := ms.MetricStorage.DeleteWhere(ctx, storage.DeleteFilter{MType: t}, n)
+	if errors.Is(err, service.ErrInvalidMetricName) {
+		deleted, err = 0, nil
+	}
+	if err != nil {
+		writeStorageError(res, req, err, "failed to delete metric")
+		return
+	}
+	if deleted == 0 {
+		writeStorageError(res, req, service.ErrUnknownMetric, "")
+		return
+	}
+	logging.Logg.InfoContext(ctx, "Metric deleted", "type", t, "name", n)
+	res.WriteHeader(http.StatusOK)
+}
+
+// HandleDeleteMetrics обрабатывает HTTP-запросы на удаление всех метрик, имена которых
+// соответствуют шаблону из параметра запроса "pattern" (синтаксис path.Match: *, ?, [a-z]).
+//
+// В ответ возвращается JSON с числом удаленных метрик: {"deleted": 3}.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос с параметром "pattern".
+func (ms *MetricsServer) HandleDeleteMetrics(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
+	if req.Method != http.MethodDelete {
+		writeMethodNotAllowed(res, req, http.MethodDelete)
+		return
+	}
+	names, err := ms.matchMetrics(ctx, req.URL.Query().Get("pattern"), "")
+	if err != nil {
+		writeMatchError(res, req, err)
+		return
+	}
+	deleted, err := ms.MetricStorage.Delete(ctx, names...)
+	if err != nil {
+		writeStorageError(res, req, err, "failed to delete metrics")
+		return
+	}
+	logging.Logg.InfoContext(ctx, "Metrics deleted", "pattern", req.URL.Query().Get("pattern"), "count", deleted)
+	writeJSON(res, req, map[string]int{"deleted": deleted})
+}
+
+// HandleResetCounter обрабатывает HTTP-запросы на обнуление счетчика.
+//
+// Счетчик остается в хранилище со значением 0 и продолжает накапливать приращения.
+// Для метрики типа "gauge" возвращается ошибка 400 (Bad Request) с кодом validation_error.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос, содержащий параметр пути "name".
+func (ms *MetricsServer) HandleResetCounter(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
+	if err := ms.checkPostMethod(req); err != nil {
+		writeMethodNotAllowed(res, req, http.MethodPost)
+		return
+	}
+	n := chi.URLParam(req, "name")
+	mt, ok := ms.lookupMetric(res, req, n)
+	if !ok {
+		return
+	}
+	if mt.MType != service.CounterMetric {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, "only counters can be reset", nil)
+		return
+	}
+	reset, err := ms.MetricStorage.Reset(ctx, n)
+	if err != nil {
+		writeStorageError(res, req, err, "failed to reset counter")
+		return
+	}
+	if reset == 0 {
+		// Счетчик удален или заменен gauge после проверки.
+		writeStorageError(res, req, service.ErrUnknownMetric, "")
+		return
+	}
+	logging.Logg.InfoContext(ctx, "Counter reset", "name", n)
+	res.WriteHeader(http.StatusOK)
+}
+
+// HandleResetCounters обрабатывает HTTP-запросы на обнуление всех счетчиков, имена которых
+// соответствуют шаблону из параметра запроса "pattern". Метрики типа "gauge" не затрагиваются.
+//
+// В ответ возвращается JSON с числом обнуленных счетчиков: {"reset": 2}.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос с параметром "pattern".
+func (ms *MetricsServer) HandleResetCounters(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
+	if err := ms.checkPostMethod(req); err != nil {
+		writeMethodNotAllowed(res, req, http.MethodPost)
+		return
+	}
+	names, err := ms.matchMetrics(ctx, req.URL.Query().Get("pattern"), service.CounterMetric)
+	if err != nil {
+		writeMatchError(res, req, err)
+		return
+	}
+	reset, err := ms.MetricStorage.Reset(ctx, names...)
+	if err != nil {
+		writeStorageError(res, req, err, "failed to reset counters")
+		return
+	}
+	logging.Logg.InfoContext(ctx, "Counters reset", "pattern", req.URL.Query().Get("pattern"), "count", reset)
+	writeJSON(res, req, map[string]int{"reset": reset})
+}
+
+// HandleReplaceMetric обрабатывает HTTP-запросы на замену метрики, в том числе со сменой типа.
+//
+// Метод принимает метрику в формате JSON и записывает ее вместо сохраненной метрики
+// с тем же именем (накопленное значение счетчика отбрасывается) одной операцией
+// хранилища (MetricStorage.Replace): если запись не удалась, прежняя метрика остается.
+// В ответе возвращается сохраненная метрика. Ошибки возвращаются в формате JSON API.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос, содержащий метрику в формате JSON в теле запроса.
+func (ms *MetricsServer) HandleReplaceMetric(res http.ResponseWriter, req *http.Request) {
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageWriteTimeout)
+	defer cancel()
+	if req.Method != http.MethodPut {
+		writeMethodNotAllowed(res, req, http.MethodPut)
+		return
+	}
+	var mt service.Metrics
+	if err := ReadAndUnmarshal(req, &mt); err != nil {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeDecode, "failed to parse metric: "+err.Error(), nil)
+		return
+	}
+	if err := validation.Metric(mt); err != nil {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, err.Error(), nil)
+		return
+	}
+	if err := ms.MetricStorage.Replace(ctx, mt); err != nil {
+		writeStorageError(res, req, err, "failed to replace metric")
+		return
+	}
+	stored, err := ms.MetricStorage.Get(ctx, mt.ID)
+	if err != nil {
+		writeStorageError(res, req, err, "failed to read saved metric")
+		return
+	}
+	logging.Logg.InfoContext(ctx, "Metric replaced", "type", mt.MType, "name", mt.ID)
+	ms.Hub.Publish(*stored)
+	writeJSON(res, req, stored)
+}
+
+// lookupMetric читает метрику name перед ее изменением. Если метрики нет, отвечает
+// 404 (Not Found), при ошибке хранилища — соответствующей ошибкой JSON API.
+func (ms *MetricsServer) lookupMetric(res http.ResponseWriter, req *http.Request, name string) (*service.Metrics, bool) {
+	ctx, cancel := ms.storageContext(req, ms.Config.StorageReadTimeout)
+	defer cancel()
+
+	mt, err := ms.MetricStorage.Get(ctx, name)
+	if errors.Is(err, service.ErrInvalidMetricName) {
+		err = service.ErrUnknownMetric
+	}
+	if err != nil {
+		writeStorageError(res, req, err, "failed to read metric")
+		return nil, false
+	}
+	return mt, true
+}
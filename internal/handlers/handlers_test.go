@@ -2,19 +2,34 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/dvkhr/metrix.git/internal/apierror"
 	"github.com/dvkhr/metrix.git/internal/config"
+	"github.com/dvkhr/metrix.git/internal/history"
 	"github.com/dvkhr/metrix.git/internal/logging"
 	"github.com/dvkhr/metrix.git/internal/mocks"
+	"github.com/dvkhr/metrix.git/internal/requestid"
+	"github.com/dvkhr/metrix.git/internal/routes"
 	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/dvkhr/metrix.git/internal/storage"
+	"github.com/dvkhr/metrix.git/internal/stream"
 	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandlePutGaugeMetric(t *testing.T) {
@@ -25,9 +40,12 @@ func TestHandlePutGaugeMetric(t *testing.T) {
 
 	server := &MetricsServer{
 		MetricStorage: mockStorage,
+		Hub:           stream.NewHub(0),
 	}
 
 	t.Run("Successful POST Request", func(t *testing.T) {
+		sub := server.Hub.Subscribe(nil)
+		defer sub.Close()
 
 		metricName := "test_metric"
 		metricValue := 42.0
@@ -58,6 +76,8 @@ func TestHandlePutGaugeMetric(t *testing.T) {
 		router.ServeHTTP(res, req)
 
 		assert.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, sub.C, 1)
+		assert.Equal(t, service.Metrics{ID: metricName, MType: service.GaugeMetric, Value: &gaugeValue}, <-sub.C)
 	})
 
 	t.Run("Unsupported HTTP Method", func(t *testing.T) {
@@ -110,9 +130,12 @@ func TestHandlePutCounterMetric(t *testing.T) {
 
 	server := &MetricsServer{
 		MetricStorage: mockStorage,
+		Hub:           stream.NewHub(0),
 	}
 
 	t.Run("Successful POST Request", func(t *testing.T) {
+		sub := server.Hub.Subscribe(nil)
+		defer sub.Close()
 
 		metricName := "test_metric"
 		metricValue := int64(42)
@@ -143,6 +166,8 @@ func TestHandlePutCounterMetric(t *testing.T) {
 		router.ServeHTTP(res, req)
 
 		assert.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, sub.C, 1)
+		assert.Equal(t, service.Metrics{ID: metricName, MType: service.CounterMetric, Delta: &counterValue}, <-sub.C)
 	})
 
 	t.Run("Unsupported HTTP Method", func(t *testing.T) {
@@ -317,7 +342,8 @@ func TestUpdateBatch(t *testing.T) {
 
 		server.UpdateBatch(res, req)
 
-		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, http.StatusInternalServerError, res.Code)
+		assert.Equal(t, apierror.CodeInternal, decodeAPIError(t, res).Code)
 	})
 
 	t.Run("List Error", func(t *testing.T) {
@@ -353,10 +379,85 @@ func TestUpdateBatch(t *testing.T) {
 
 		server.UpdateBatch(res, req)
 
-		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, http.StatusInternalServerError, res.Code)
+	})
+}
+
+func TestStorageTimeout(t *testing.T) {
+	t.Run("Deadline exceeded returns 504", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockStorage := mocks.NewMockMetricStorage(ctrl)
+
+		server := &MetricsServer{
+			MetricStorage: mockStorage,
+			Config:        config.ConfigServ{StorageReadTimeout: 10 * time.Millisecond},
+		}
+
+		mockStorage.EXPECT().
+			Get(gomock.Any(), "slow_metric").
+			DoAndReturn(func(ctx context.Context, name string) (*service.Metrics, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			})
+
+		router := chi.NewRouter()
+		router.Get("/value/{type}/{name}", server.HandleGetMetric)
+
+		req := httptest.NewRequest(http.MethodGet, "/value/gauge/slow_metric", nil)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+
+		assert.Equal(t, http.StatusGatewayTimeout, res.Code)
+	})
+
+	t.Run("Client cancel returns 503", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockStorage := mocks.NewMockMetricStorage(ctrl)
+
+		server := &MetricsServer{
+			MetricStorage: mockStorage,
+		}
+
+		mockStorage.EXPECT().
+			CheckStorage(gomock.Any()).
+			DoAndReturn(func(ctx context.Context) error {
+				return ctx.Err()
+			})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil).WithContext(ctx)
+		res := httptest.NewRecorder()
+
+		server.CheckDBConnect(res, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, res.Code)
 	})
 }
 
+func TestStorageErrorStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{name: "Deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded), expected: http.StatusGatewayTimeout},
+		{name: "Canceled", err: context.Canceled, expected: http.StatusServiceUnavailable},
+		{name: "Uninitialized", err: service.ErrUninitializedStorage, expected: http.StatusServiceUnavailable},
+		{name: "Other", err: service.ErrUnknownMetric, expected: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, storageErrorStatus(tt.err, http.StatusNotFound))
+		})
+	}
+}
+
 // go test -bench=. -memprofile=mem.pprof
 // go tool pprof -http=":9090" handlers.test mem.pprof
 
@@ -408,3 +509,622 @@ func BenchmarkUpdateBatch(b *testing.B) {
 		}
 	}
 }
+
+func TestConcurrentRequests(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	server, err := NewMetricsServer(config.ConfigServ{})
+	assert.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Post("/update/{type}/{name}/{value}", server.HandlePutCounterMetric)
+	router.Post("/updates/", server.UpdateBatch)
+	router.Get("/value/{type}/{name}", server.HandleGetMetric)
+
+	delta := service.CounterMetricValue(1)
+	batch, _ := json.Marshal([]service.Metrics{{ID: "hits", MType: service.CounterMetric, Delta: &delta}})
+
+	const workers = 8
+	const iterations = 50
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				res := httptest.NewRecorder()
+				router.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/update/counter/hits/1", nil))
+				assert.Equal(t, http.StatusOK, res.Code)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				res := httptest.NewRecorder()
+				router.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(batch)))
+				assert.Equal(t, http.StatusOK, res.Code)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				res := httptest.NewRecorder()
+				router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/value/counter/hits", nil))
+			}
+		}()
+	}
+	wg.Wait()
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/value/counter/hits", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, fmt.Sprint(2*workers*iterations), res.Body.String())
+}
+
+func TestNewMetricsServerStorage(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		cfg      config.ConfigServ
+		expected MetricStorage
+	}{
+		{"Default memory", config.ConfigServ{}, &storage.MemStorage{}},
+		{"Legacy file path", config.ConfigServ{FileStoragePath: filepath.Join(dir, "legacy.json")}, &storage.SnapshotStorage{}},
+		{"Legacy WAL", config.ConfigServ{FileStoragePath: filepath.Join(dir, "wal.json"), WAL: true}, &storage.FileStorage{}},
+		{"Storage URL overrides legacy", config.ConfigServ{Storage: "sqlite://" + filepath.Join(dir, "metrix.db"), FileStoragePath: filepath.Join(dir, "ignored.json")}, &storage.SQLiteStorage{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewMetricsServer(tt.cfg)
+			require.NoError(t, err)
+			defer server.MetricStorage.FreeStorage()
+
+			observed, ok := server.MetricStorage.(*observedStorage)
+			require.True(t, ok)
+			assert.IsType(t, tt.expected, observed.MetricStorage)
+		})
+	}
+
+	t.Run("Error: Unknown scheme", func(t *testing.T) {
+		_, err := NewMetricsServer(config.ConfigServ{Storage: "redis://localhost"})
+		assert.ErrorIs(t, err, storage.ErrUnknownStorageScheme)
+	})
+}
+
+func TestDeleteAndReset(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	newRouter := func(t *testing.T) *chi.Mux {
+		server, err := NewMetricsServer(config.ConfigServ{})
+		require.NoError(t, err)
+		t.Cleanup(func() { server.MetricStorage.FreeStorage() })
+
+		ctx := context.Background()
+		delta := service.CounterMetricValue(5)
+		value := service.GaugeMetricValue(1.5)
+		for _, mt := range []service.Metrics{
+			{ID: "requests_total", MType: service.CounterMetric, Delta: &delta},
+			{ID: "errors_total", MType: service.CounterMetric, Delta: &delta},
+			{ID: "requests_inflight", MType: service.GaugeMetric, Value: &value},
+			{ID: "Alloc", MType: service.GaugeMetric, Value: &value},
+		} {
+			require.NoError(t, server.MetricStorage.Save(ctx, mt))
+		}
+
+		router := chi.NewRouter()
+		router.Get("/value/{type}/{name}", server.HandleGetMetric)
+		router.Delete("/value/", server.HandleDeleteMetrics)
+		router.Delete("/value/{type}/{name}", server.HandleDeleteMetric)
+		router.Post("/reset/", server.HandleResetCounters)
+		router.Post("/reset/counter/{name}", server.HandleResetCounter)
+		return router
+	}
+
+	do := func(router *chi.Mux, method, target string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(method, target, nil))
+		return res
+	}
+
+	t.Run("Delete metric", func(t *testing.T) {
+		router := newRouter(t)
+		assert.Equal(t, http.StatusOK, do(router, http.MethodDelete, "/value/gauge/Alloc").Code)
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodGet, "/value/gauge/Alloc").Code)
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodDelete, "/value/gauge/Alloc").Code)
+	})
+
+	t.Run("Delete metric with wrong type", func(t *testing.T) {
+		router := newRouter(t)
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodDelete, "/value/counter/Alloc").Code)
+		assert.Equal(t, http.StatusBadRequest, do(router, http.MethodDelete, "/value/histogram/Alloc").Code)
+		assert.Equal(t, http.StatusOK, do(router, http.MethodGet, "/value/gauge/Alloc").Code)
+	})
+
+	t.Run("Delete by pattern", func(t *testing.T) {
+		router := newRouter(t)
+		res := do(router, http.MethodDelete, "/value/?pattern=requests_*")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.JSONEq(t, `{"deleted": 2}`, res.Body.String())
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodGet, "/value/counter/requests_total").Code)
+		assert.Equal(t, http.StatusOK, do(router, http.MethodGet, "/value/counter/errors_total").Code)
+	})
+
+	t.Run("Delete by invalid pattern", func(t *testing.T) {
+		router := newRouter(t)
+		assert.Equal(t, http.StatusBadRequest, do(router, http.MethodDelete, "/value/").Code)
+		assert.Equal(t, http.StatusBadRequest, do(router, http.MethodDelete, "/value/?pattern=%5B").Code)
+	})
+
+	t.Run("Reset counter", func(t *testing.T) {
+		router := newRouter(t)
+		assert.Equal(t, http.StatusOK, do(router, http.MethodPost, "/reset/counter/requests_total").Code)
+		res := do(router, http.MethodGet, "/value/counter/requests_total")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "0", res.Body.String())
+
+		assert.Equal(t, http.StatusBadRequest, do(router, http.MethodPost, "/reset/counter/Alloc").Code)
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodPost, "/reset/counter/missing").Code)
+	})
+
+	t.Run("Reset by pattern skips gauges", func(t *testing.T) {
+		router := newRouter(t)
+		res := do(router, http.MethodPost, "/reset/?pattern=*_total")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.JSONEq(t, `{"reset": 2}`, res.Body.String())
+
+		res = do(router, http.MethodPost, "/reset/?pattern=requests_*")
+		assert.JSONEq(t, `{"reset": 1}`, res.Body.String())
+		res = do(router, http.MethodGet, "/value/gauge/requests_inflight")
+		assert.Equal(t, "1.5", res.Body.String())
+	})
+
+	t.Run("Storage error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockStorage := mocks.NewMockMetricStorage(ctrl)
+		server := &MetricsServer{MetricStorage: mockStorage}
+		mockStorage.EXPECT().ListPage(gomock.Any(), storage.ListQuery{Pattern: "*", Match: storage.MatchGlob}).Return(nil, context.DeadlineExceeded)
+
+		router := chi.NewRouter()
+		router.Delete("/value/", server.HandleDeleteMetrics)
+		assert.Equal(t, http.StatusGatewayTimeout, do(router, http.MethodDelete, "/value/?pattern=*").Code)
+	})
+
+	t.Run("Missing metric in database", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockStorage := mocks.NewMockMetricStorage(ctrl)
+		server := &MetricsServer{MetricStorage: mockStorage}
+		mockStorage.EXPECT().DeleteWhere(gomock.Any(), storage.DeleteFilter{MType: service.GaugeMetric}, "missing").Return(0, nil)
+		mockStorage.EXPECT().Get(gomock.Any(), "missing").Return(nil, service.ErrUnknownMetric)
+
+		router := chi.NewRouter()
+		router.Delete("/value/{type}/{name}", server.HandleDeleteMetric)
+		router.Post("/reset/counter/{name}", server.HandleResetCounter)
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodDelete, "/value/gauge/missing").Code)
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodPost, "/reset/counter/missing").Code)
+	})
+
+	t.Run("Counter replaced before reset", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockStorage := mocks.NewMockMetricStorage(ctrl)
+		server := &MetricsServer{MetricStorage: mockStorage}
+		delta := service.CounterMetricValue(5)
+		mockStorage.EXPECT().Get(gomock.Any(), "requests_total").
+			Return(&service.Metrics{ID: "requests_total", MType: service.CounterMetric, Delta: &delta}, nil)
+		mockStorage.EXPECT().Reset(gomock.Any(), "requests_total").Return(0, nil)
+
+		router := chi.NewRouter()
+		router.Post("/reset/counter/{name}", server.HandleResetCounter)
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodPost, "/reset/counter/requests_total").Code)
+	})
+}
+
+func TestMetricUpdatedAt(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	server, err := NewMetricsServer(config.ConfigServ{})
+	require.NoError(t, err)
+	defer server.MetricStorage.FreeStorage()
+
+	router := chi.NewRouter()
+	router.Post("/update/", server.UpdateMetric)
+	router.Post("/value/", server.ExtractMetric)
+
+	// Время, переданное клиентом, заменяется временем записи в хранилище.
+	before := time.Now()
+	body := `{"id":"Alloc","type":"gauge","value":1.5,"updated_at":"2001-01-01T00:00:00Z"}`
+	for _, target := range []string{"/update/", "/value/"} {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(http.MethodPost, target, bytes.NewBufferString(body)))
+		require.Equal(t, http.StatusOK, res.Code)
+
+		var mt service.Metrics
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &mt))
+		require.NotNil(t, mt.UpdatedAt, target)
+		assert.False(t, mt.UpdatedAt.Before(before), target)
+	}
+}
+
+func TestTypeConflict(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	newRouter := func(t *testing.T) *chi.Mux {
+		server, err := NewMetricsServer(config.ConfigServ{})
+		require.NoError(t, err)
+		t.Cleanup(func() { server.MetricStorage.FreeStorage() })
+
+		value := service.GaugeMetricValue(1.5)
+		require.NoError(t, server.MetricStorage.Save(context.Background(),
+			service.Metrics{ID: "Alloc", MType: service.GaugeMetric, Value: &value}))
+
+		router := chi.NewRouter()
+		router.Get("/value/{type}/{name}", server.HandleGetMetric)
+		router.Post("/value/", server.ExtractMetric)
+		router.Put("/value/", server.HandleReplaceMetric)
+		router.Post("/update/", server.UpdateMetric)
+		router.Post("/update/counter/{name}/{value}", server.HandlePutCounterMetric)
+		router.Post("/updates/", server.UpdateBatch)
+		return router
+	}
+
+	do := func(router *chi.Mux, method, target, body string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(method, target, bytes.NewBufferString(body)))
+		return res
+	}
+
+	assertConflict := func(t *testing.T, res *httptest.ResponseRecorder) {
+		t.Helper()
+		assert.Equal(t, http.StatusConflict, res.Code)
+		apiErr := decodeAPIError(t, res)
+		assert.Equal(t, apierror.CodeTypeConflict, apiErr.Code)
+		assert.Contains(t, apiErr.Message, "Alloc")
+	}
+
+	t.Run("Conflicting writes are rejected", func(t *testing.T) {
+		router := newRouter(t)
+		res := do(router, http.MethodPost, "/update/counter/Alloc/1", "")
+		assert.Equal(t, http.StatusConflict, res.Code)
+		assert.Contains(t, res.Body.String(), "metric type conflict")
+		assertConflict(t, do(router, http.MethodPost, "/update/", `{"id":"Alloc","type":"counter","delta":1}`))
+		assertConflict(t, do(router, http.MethodPost, "/updates/",
+			`[{"id":"Other","type":"gauge","value":1},{"id":"Alloc","type":"counter","delta":1}]`))
+
+		assert.Equal(t, "1.5", do(router, http.MethodGet, "/value/gauge/Alloc", "").Body.String())
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodGet, "/value/gauge/Other", "").Code)
+	})
+
+	t.Run("Reads with another type are not found", func(t *testing.T) {
+		router := newRouter(t)
+		res := do(router, http.MethodPost, "/value/", `{"id":"Alloc","type":"counter"}`)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Equal(t, apierror.CodeNotFound, decodeAPIError(t, res).Code)
+	})
+
+	t.Run("Replace changes type", func(t *testing.T) {
+		router := newRouter(t)
+		res := do(router, http.MethodPut, "/value/", `{"id":"Alloc","type":"counter","delta":7}`)
+		require.Equal(t, http.StatusOK, res.Code)
+		var mt service.Metrics
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &mt))
+		assert.Equal(t, service.CounterMetric, mt.MType)
+		assert.Equal(t, service.CounterMetricValue(7), *mt.Delta)
+
+		assert.Equal(t, http.StatusOK, do(router, http.MethodPost, "/update/counter/Alloc/1", "").Code)
+		assert.Equal(t, "8", do(router, http.MethodGet, "/value/counter/Alloc", "").Body.String())
+	})
+
+	t.Run("Replace with invalid metric", func(t *testing.T) {
+		router := newRouter(t)
+		for _, body := range []string{
+			`{"id":"Alloc","type":"counter","value":1}`,
+			`{"id":"Alloc","type":"histogram"}`,
+			`{"type":"gauge","value":1}`,
+			`not json`,
+		} {
+			assert.Equal(t, http.StatusBadRequest, do(router, http.MethodPut, "/value/", body).Code, body)
+		}
+		assert.Equal(t, "1.5", do(router, http.MethodGet, "/value/gauge/Alloc", "").Body.String())
+	})
+
+	t.Run("Failed replace keeps the metric", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockStorage := mocks.NewMockMetricStorage(ctrl)
+		server := &MetricsServer{MetricStorage: mockStorage}
+		// Delete не ожидается: замена выполняется одной операцией хранилища.
+		mockStorage.EXPECT().Replace(gomock.Any(), gomock.Any()).Return(errors.New("disk failure"))
+
+		router := chi.NewRouter()
+		router.Put("/value/", server.HandleReplaceMetric)
+		res := do(router, http.MethodPut, "/value/", `{"id":"Alloc","type":"counter","delta":7}`)
+		assert.Equal(t, http.StatusInternalServerError, res.Code)
+		assert.Equal(t, apierror.CodeInternal, decodeAPIError(t, res).Code)
+	})
+}
+
+func TestValidation(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	newRouter := func(t *testing.T) *chi.Mux {
+		server, err := NewMetricsServer(config.ConfigServ{})
+		require.NoError(t, err)
+		t.Cleanup(func() { server.MetricStorage.FreeStorage() })
+
+		router := chi.NewRouter()
+		router.Get("/value/{type}/{name}", server.HandleGetMetric)
+		router.Post("/update/", server.UpdateMetric)
+		router.Post("/update/gauge/{name}/{value}", server.HandlePutGaugeMetric)
+		router.Post("/update/counter/{name}/{value}", server.HandlePutCounterMetric)
+		router.Post("/updates/", server.UpdateBatch)
+		return router
+	}
+
+	do := func(router *chi.Mux, method, target, body string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(method, target, bytes.NewBufferString(body)))
+		return res
+	}
+
+	t.Run("Single updates", func(t *testing.T) {
+		router := newRouter(t)
+		for _, target := range []string{
+			"/update/gauge/Alloc/NaN",
+			"/update/gauge/Alloc/+Inf",
+			"/update/counter/PollCount/-1",
+			"/update/gauge/heap%20alloc/1",
+		} {
+			assert.Equal(t, http.StatusBadRequest, do(router, http.MethodPost, target, "").Code, target)
+		}
+		for _, body := range []string{
+			`{"id":"PollCount","type":"counter"}`,
+			`{"id":"PollCount","type":"counter","delta":1,"value":1}`,
+			`{"id":"","type":"gauge","value":1}`,
+		} {
+			res := do(router, http.MethodPost, "/update/", body)
+			assert.Equal(t, http.StatusBadRequest, res.Code, body)
+			assert.Equal(t, apierror.CodeValidation, decodeAPIError(t, res).Code, body)
+		}
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodGet, "/value/counter/PollCount", "").Code)
+	})
+
+	batch := `[
+		{"id":"Alloc","type":"gauge","value":1.5},
+		{"id":"PollCount","type":"counter"},
+		{"id":"Requests","type":"counter","delta":2},
+		{"id":"bad name","type":"gauge","value":1}
+	]`
+
+	t.Run("Batch is rejected by default", func(t *testing.T) {
+		router := newRouter(t)
+		res := do(router, http.MethodPost, "/updates/", batch)
+		require.Equal(t, http.StatusBadRequest, res.Code)
+
+		apiErr := decodeAPIError(t, res)
+		assert.Equal(t, apierror.CodeValidation, apiErr.Code)
+		assert.Contains(t, apiErr.Message, "PollCount")
+		rejected, ok := apiErr.Details.([]any)
+		require.True(t, ok)
+		require.Len(t, rejected, 2)
+		assert.Equal(t, "bad name", rejected[1].(map[string]any)["id"])
+		assert.Equal(t, http.StatusNotFound, do(router, http.MethodGet, "/value/gauge/Alloc", "").Code)
+	})
+
+	t.Run("Partial accept", func(t *testing.T) {
+		router := newRouter(t)
+		res := do(router, http.MethodPost, "/updates/?partial=true", batch)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.JSONEq(t, `{
+			"accepted": 2,
+			"rejected": [
+				{"index": 1, "id": "PollCount", "error": "missing metric value: counter requires delta"},
+				{"index": 3, "id": "bad name", "error": "invalid metric name \"bad name\": character ' ' is not allowed"}
+			]
+		}`, res.Body.String())
+		assert.Equal(t, "1.5", do(router, http.MethodGet, "/value/gauge/Alloc", "").Body.String())
+		assert.Equal(t, "2", do(router, http.MethodGet, "/value/counter/Requests", "").Body.String())
+
+		res = do(router, http.MethodPost, "/updates/?partial=true", `[{"id":"PollCount","type":"counter"}]`)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), `"accepted":0`)
+
+		res = do(router, http.MethodPost, "/updates/?partial=true", `[{"id":"Alloc","type":"gauge","value":2}]`)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.JSONEq(t, `{"accepted": 1, "rejected": []}`, res.Body.String())
+	})
+}
+
+// decodeAPIError разбирает тело ответа с ошибкой JSON API.
+func decodeAPIError(t *testing.T, res *httptest.ResponseRecorder) apierror.Error {
+	t.Helper()
+	require.Equal(t, "application/json", res.Header().Get("Content-Type"))
+	var apiErr apierror.Error
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &apiErr))
+	return apiErr
+}
+
+func TestAPIErrors(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	server, err := NewMetricsServer(config.ConfigServ{Key: "secret"})
+	require.NoError(t, err)
+	defer server.MetricStorage.FreeStorage()
+	router := routes.SetupRoutes(chi.NewRouter(), logging.Logg, server.Config, server)
+
+	do := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		for k, values := range header {
+			for _, v := range values {
+				req.Header.Add(k, v)
+			}
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		header http.Header
+		status int
+		code   apierror.Code
+	}{
+		{name: "Decode", method: http.MethodPost, target: "/update/", body: "{", status: http.StatusBadRequest, code: apierror.CodeDecode},
+		{name: "Validation", method: http.MethodPost, target: "/update/", body: `{"id":"a b","type":"gauge","value":1}`, status: http.StatusBadRequest, code: apierror.CodeValidation},
+		{name: "Extract decode", method: http.MethodPost, target: "/value/", body: "not json", status: http.StatusBadRequest, code: apierror.CodeDecode},
+		{name: "Extract not found", method: http.MethodPost, target: "/value/", body: `{"id":"missing","type":"gauge"}`, status: http.StatusNotFound, code: apierror.CodeNotFound},
+		{name: "Signature", method: http.MethodPost, target: "/updates/", body: `[]`, header: http.Header{"HashSHA256": {"00"}}, status: http.StatusBadRequest, code: apierror.CodeSignature},
+		{name: "Admin disabled", method: http.MethodDelete, target: "/value/?pattern=*", status: http.StatusForbidden, code: apierror.CodeForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := do(tt.method, tt.target, tt.body, tt.header)
+			assert.Equal(t, tt.status, res.Code)
+			apiErr := decodeAPIError(t, res)
+			assert.Equal(t, tt.code, apiErr.Code)
+			assert.NotEmpty(t, apiErr.Message)
+			assert.Equal(t, res.Header().Get(requestid.Header), apiErr.RequestID)
+		})
+	}
+
+	t.Run("Request ID from client", func(t *testing.T) {
+		res := do(http.MethodPost, "/update/", "{", http.Header{requestid.Header: {"req-42"}})
+		assert.Equal(t, "req-42", decodeAPIError(t, res).RequestID)
+	})
+
+	t.Run("Legacy endpoints keep plain text", func(t *testing.T) {
+		res := do(http.MethodPost, "/update/gauge/Alloc/NaN", "", nil)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Contains(t, res.Header().Get("Content-Type"), "text/plain")
+		res = do(http.MethodGet, "/value/gauge/missing", "", nil)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Equal(t, "Metric not found!\n", res.Body.String())
+	})
+
+	t.Run("Storage unavailable", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockStorage := mocks.NewMockMetricStorage(ctrl)
+		mockStorage.EXPECT().Get(gomock.Any(), "Alloc").Return(nil, fmt.Errorf("get: %w", service.ErrUninitializedStorage))
+		server := &MetricsServer{MetricStorage: mockStorage}
+
+		res := httptest.NewRecorder()
+		server.ExtractMetric(res, httptest.NewRequest(http.MethodPost, "/value/", bytes.NewBufferString(`{"id":"Alloc","type":"gauge"}`)))
+		assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+		assert.Equal(t, apierror.CodeStorageUnavailable, decodeAPIError(t, res).Code)
+	})
+
+	t.Run("Missing row in database", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockStorage := mocks.NewMockMetricStorage(ctrl)
+		mockStorage.EXPECT().Get(gomock.Any(), "missing").Return(nil, fmt.Errorf("get: %w", service.ErrUnknownMetric)).Times(2)
+		server := &MetricsServer{MetricStorage: mockStorage}
+
+		res := httptest.NewRecorder()
+		server.ExtractMetric(res, httptest.NewRequest(http.MethodPost, "/value/", bytes.NewBufferString(`{"id":"missing","type":"gauge"}`)))
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Equal(t, apierror.CodeNotFound, decodeAPIError(t, res).Code)
+
+		router := chi.NewRouter()
+		router.Get("/value/{type}/{name}", server.HandleGetMetric)
+		res = httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/value/gauge/missing", nil))
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
+
+func TestHandleGetAllMetrics(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	server, err := NewMetricsServer(config.ConfigServ{})
+	require.NoError(t, err)
+	defer server.MetricStorage.FreeStorage()
+	ctx := context.Background()
+	for i, id := range []string{"Alloc", "HeapAlloc", "HeapIdle", "<b>"} {
+		value := service.GaugeMetricValue(i)
+		require.NoError(t, server.MetricStorage.Save(ctx, service.Metrics{ID: id, MType: service.GaugeMetric, Value: &value}))
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.HandleGetAllMetrics(res, httptest.NewRequest(http.MethodGet, target, nil))
+		return res
+	}
+
+	t.Run("Filter and next page", func(t *testing.T) {
+		res := get("/?glob=Heap*&limit=1")
+		require.Equal(t, http.StatusOK, res.Code)
+		body := res.Body.String()
+		assert.Contains(t, body, "<td>HeapAlloc</td>")
+		assert.NotContains(t, body, "<td>HeapIdle</td>")
+		assert.NotContains(t, body, "<td>Alloc</td>")
+		assert.Contains(t, body, `value='Heap*'`)
+
+		next := regexp.MustCompile(`href='([^']+)'`).FindStringSubmatch(body)
+		require.Len(t, next, 2)
+		res = get(html.UnescapeString(next[1]))
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), "<td>HeapIdle</td>")
+		assert.NotContains(t, res.Body.String(), "Next page")
+	})
+
+	t.Run("Names are escaped", func(t *testing.T) {
+		res := get("/")
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), "<td>&lt;b&gt;</td>")
+	})
+
+	t.Run("History", func(t *testing.T) {
+		assert.NotContains(t, get("/").Body.String(), "<svg")
+
+		server.History = history.NewRecorder(10)
+		defer func() { server.History = nil }()
+		metrics, err := server.MetricStorage.ListSlice(ctx)
+		require.NoError(t, err)
+		server.History.Record(metrics)
+		server.History.Record(metrics)
+		assert.Equal(t, 4, strings.Count(get("/").Body.String(), `<svg class="sparkline"`))
+	})
+
+	t.Run("Error: Invalid query", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/?regex=(").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/?limit=-1").Code)
+	})
+}
+
+func TestMetricsServerHistory(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	server, err := NewMetricsServer(config.ConfigServ{HistoryInterval: time.Millisecond, HistorySize: 5})
+	require.NoError(t, err)
+	require.NotNil(t, server.History)
+
+	value := service.GaugeMetricValue(1)
+	require.NoError(t, server.MetricStorage.Save(context.Background(), service.Metrics{ID: "g", MType: service.GaugeMetric, Value: &value}))
+	assert.Eventually(t, func() bool { return len(server.History.Values("g")) == 5 }, 5*time.Second, time.Millisecond)
+	require.NoError(t, server.Close())
+
+	server, err = NewMetricsServer(config.ConfigServ{})
+	require.NoError(t, err)
+	assert.Nil(t, server.History)
+	require.NoError(t, server.Close())
+}
@@ -6,89 +6,183 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/dvkhr/metrix.git/internal/alerting"
+	"github.com/dvkhr/metrix.git/internal/apierror"
 	"github.com/dvkhr/metrix.git/internal/config"
 	"github.com/dvkhr/metrix.git/internal/crypto"
+	"github.com/dvkhr/metrix.git/internal/history"
 	"github.com/dvkhr/metrix.git/internal/logging"
 	"github.com/dvkhr/metrix.git/internal/service"
 	"github.com/dvkhr/metrix.git/internal/storage"
+	"github.com/dvkhr/metrix.git/internal/stream"
+	"github.com/dvkhr/metrix.git/internal/tracing"
+	"github.com/dvkhr/metrix.git/internal/validation"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// MetricStorage представляет интерфейс для работы с хранилищем метрик.
-//
-// Он определяет набор методов для сохранения, получения и управления метриками.
-// Реализации этого интерфейса могут использовать различные типы хранилищ,
-// такие как база данных, файловое хранилище или оперативная память.
-//
-// Методы:
-// - Save: Сохраняет одну метрику в хранилище.
-// - SaveAll: Сохраняет массив метрик в хранилище.
-// - Get: Получает метрику по её имени.
-// - List: Возвращает все метрики в виде мапы, где ключ — имя метрики.
-// - ListSlice: Возвращает все метрики в виде слайса.
-// - NewStorage: Инициализирует хранилище.
-// - FreeStorage: Освобождает ресурсы, связанные с хранилищем.
-// - CheckStorage: Проверяет доступность хранилища.
-type MetricStorage interface {
-	Save(ctx context.Context, mt service.Metrics) error
-	SaveAll(ctx context.Context, mt *[]service.Metrics) error
-	Get(ctx context.Context, metricName string) (*service.Metrics, error)
-	List(ctx context.Context) (*map[string]service.Metrics, error)
-	ListSlice(ctx context.Context) ([]service.Metrics, error)
-	NewStorage() error
-	FreeStorage() error
-	CheckStorage() error
-}
-
-//mockgen -source=internal/handlers/handlers.go -destination=internal/mocks/mock_storage.go -package=mocks
+// MetricStorage — интерфейс хранилища метрик, которым пользуются обработчики.
+// Определен в пакете storage рядом с реализациями и реестром хранилищ (см. storage.Open).
+type MetricStorage = storage.MetricStorage
 
 // MetricsServer представляет сервер для обработки метрик.
 // Он управляет хранилищем метрик и предоставляет методы для их сохранения, получения и обработки.
+// Хранилища сами обеспечивают потокобезопасность, поэтому обработчики не сериализуются.
 // Поля:
 //   - MetricStorage: Интерфейс хранилища метрик (база данных, файловое хранилище или память).
 //     Используется для выполнения операций с метриками.
 //   - Config: Конфигурация сервера, содержащая параметры подключения и настройки.
-//   - syncMutex: Мьютекс для обеспечения потокобезопасности при работе с общими ресурсами.
+//   - History: История значений метрик для графиков HTML-страницы; nil, если не ведется.
+//   - Hub: Рассылка сохраненных метрик подписчикам GET "/stream"; если nil, обновления не публикуются.
+//   - Alerts: Правила оповещений и их состояние; nil, если правила не заданы.
 type MetricsServer struct {
 	MetricStorage MetricStorage
 	Config        config.ConfigServ
-	syncMutex     sync.Mutex
+	History       *history.Recorder
+	Hub           *stream.Hub
+	Alerts        *alerting.Engine
+
+	stop       context.CancelFunc
+	background sync.WaitGroup
 }
 
 // NewMetricsServer создает новый экземпляр MetricsServer с выбранным хранилищем метрик.
 //
-// Выбор хранилища зависит от конфигурации:
-// - Если Config.DBDsn не пустой, используется хранилище на основе PostgreSQL (DBStorage).
-// - Если Config.FileStoragePath не пустой, используется файловое хранилище (FileStorage).
+// Хранилище выбирается по схеме адреса Config.StorageURL() в реестре storage (см. storage.Open):
+// - Если задан Config.Storage, используется он: memory://, file:///path, postgres://, pgx://, sqlite:///path.
+// - Иначе, если Config.DBDsn не пустой, используется он: PostgreSQL через database/sql (DBStorage)
+//   или через пул pgxpool (PgxStorage), если DSN имеет схему pgx:// или Config.StorageDriver равен "pgx";
+//   DSN вида sqlite:///path/to/file.db выбирает хранилище SQLite (SQLiteStorage).
+// - Иначе, если Config.FileStoragePath не пустой, используется хранилище в памяти со снимками на диск
+//   (SnapshotStorage) или, при Config.WAL, файловое хранилище с журналом упреждающей записи (FileStorage).
 // - Если ни один из вышеперечисленных параметров не задан, используется хранилище в оперативной памяти (MemStorage).
 //
+// К хранилищу применяются обертки из Config.StorageWrappers, а снаружи — обертка
+// для сбора метрик самонаблюдения (задержки и ошибки операций).
+//
+// Если заданы Config.HistoryInterval и Config.HistorySize, сервер ведет историю значений
+// метрик (поле History) до вызова Close. Сохраненные метрики рассылаются подписчикам
+// потока обновлений через Hub. Если задан Config.AlertRules, правила оповещений из этого
+// файла проверяются раз в Config.AlertInterval (поле Alerts).
+//
 // Параметры:
 // - Config: Конфигурация сервера, содержащая параметры для подключения к хранилищу.
 
 // Возвращаемые значения:
 // - *MetricsServer: Указатель на созданный экземпляр MetricsServer.
-// - error: Ошибка, если произошла проблема при инициализации хранилища.
+// - error: Ошибка, если произошла проблема при инициализации хранилища или загрузке правил оповещений.
 func NewMetricsServer(Config config.ConfigServ) (*MetricsServer, error) {
-	var ms MetricStorage
-	if len(Config.DBDsn) > 0 {
-		ms = &storage.DBStorage{DBDSN: Config.DBDsn}
-
-	} else if len(Config.FileStoragePath) > 0 {
-		ms = &storage.FileStorage{FileStoragePath: Config.FileStoragePath}
+	var alerts *alerting.Engine
+	if Config.AlertRules != "" {
+		var err error
+		if alerts, err = alerting.LoadFile(Config.AlertRules); err != nil {
+			return nil, err
+		}
+	}
 
-	} else {
-		ms = &storage.MemStorage{}
+	ms, err := storage.Open(Config.StorageURL(), storageOptions(Config))
+	if err != nil {
+		return nil, err
 	}
 
 	if err := ms.NewStorage(); err != nil {
 		return nil, err
 	}
 
-	return &MetricsServer{MetricStorage: ms, Config: Config}, nil
+	server := &MetricsServer{MetricStorage: newObservedStorage(ms), Config: Config, Hub: stream.NewHub(0), Alerts: alerts}
+	ctx, cancel := context.WithCancel(context.Background())
+	server.stop = cancel
+	if Config.HistoryInterval > 0 && Config.HistorySize > 0 {
+		server.History = history.NewRecorder(Config.HistorySize)
+		server.run(func() { server.History.Run(ctx, Config.HistoryInterval, server.listAll) })
+	}
+	if alerts != nil {
+		server.run(func() { alerts.Run(ctx, Config.AlertInterval, server.listAll) })
+	}
+	return server, nil
+}
+
+// run запускает фоновую задачу сервера; Close дожидается ее завершения.
+func (ms *MetricsServer) run(task func()) {
+	ms.background.Add(1)
+	go func() {
+		defer ms.background.Done()
+		task()
+	}()
+}
+
+// listAll читает все метрики для фоновых задач с ограничением Config.StorageReadTimeout.
+func (ms *MetricsServer) listAll(ctx context.Context) ([]service.Metrics, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	if ms.Config.StorageReadTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, ms.Config.StorageReadTimeout)
+	}
+	defer cancel()
+	return ms.MetricStorage.ListSlice(ctx)
+}
+
+// Close останавливает запись истории значений метрик и проверку правил оповещений,
+// завершает потоки обновлений и освобождает хранилище.
+func (ms *MetricsServer) Close() error {
+	if ms.Hub != nil {
+		ms.Hub.Close()
+	}
+	if ms.stop != nil {
+		ms.stop()
+		ms.background.Wait()
+		ms.stop = nil
+	}
+	return ms.MetricStorage.FreeStorage()
+}
+
+// publish читает из хранилища сохраненные метрики ids и рассылает их подписчикам Hub,
+// чтобы счетчики приходили с накопленным значением. Если подписчиков нет, хранилище
+// не читается. Ошибки чтения записываются в журнал и не влияют на ответ клиенту:
+// метрики к этому моменту уже сохранены.
+func (ms *MetricsServer) publish(ctx context.Context, ids ...string) {
+	if !ms.Hub.HasSubscribers() {
+		return
+	}
+	seen := make(map[string]struct{}, len(ids))
+	metrics := make([]service.Metrics, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		mt, err := ms.MetricStorage.Get(ctx, id)
+		if err != nil {
+			logging.Logg.WarnContext(ctx, "Failed to read saved metric for stream", "name", id, "error", err)
+			continue
+		}
+		metrics = append(metrics, *mt)
+	}
+	ms.Hub.Publish(metrics...)
+}
+
+// storageOptions переносит настройки хранилища из конфигурации сервера в storage.Options.
+func storageOptions(Config config.ConfigServ) storage.Options {
+	return storage.Options{
+		StoreInterval:      Config.StoreInterval,
+		Restore:            Config.Restore,
+		WAL:                Config.WAL,
+		WALFsync:           storage.FsyncPolicy(Config.WALFsync),
+		WALCompactInterval: Config.WALCompactInterval,
+		Driver:             Config.StorageDriver,
+		MaxConns:           int32(Config.DBMaxConns),
+		MinConns:           int32(Config.DBMinConns),
+		HealthCheckPeriod:  Config.DBHealthCheckPeriod,
+		SignKey:            []byte(Config.Key),
+		Wrappers:           Config.StorageWrappers,
+	}
 }
 
 // IncorrectMetricRq обрабатывает некорректные запросы на обновление метрик.
@@ -107,6 +201,194 @@ func (ms *MetricsServer) NotfoundMetricRq(res http.ResponseWriter, req *http.Req
 	http.Error(res, "Metric not found!", http.StatusNotFound)
 }
 
+// storageContext возвращает контекст запроса, ограниченный временем timeout,
+// для операций с хранилищем. Нулевой timeout не ограничивает время операции,
+// но отмена запроса клиентом по-прежнему прерывает ее.
+func (ms *MetricsServer) storageContext(req *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(req.Context())
+	}
+	return context.WithTimeout(req.Context(), timeout)
+}
+
+// storageErrorStatus подбирает HTTP-статус для ошибки операции с хранилищем:
+// - 504 (Gateway Timeout), если истекло время операции;
+// - 503 (Service Unavailable), если операция была отменена или хранилище недоступно;
+// - fallback во всех остальных случаях.
+func storageErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled), storage.IsUnavailable(err):
+		return http.StatusServiceUnavailable
+	default:
+		return fallback
+	}
+}
+
+// writeStorageError отправляет ошибку операции с хранилищем в формате JSON API,
+// подбирая код и статус:
+// - конфликт типа метрики — 409 (Conflict), type_conflict;
+// - метрика не найдена — 404 (Not Found), not_found;
+// - некорректная метрика или параметры выборки — 400 (Bad Request), validation_error;
+// - хранилище недоступно или не ответило вовремя — 503 или 504, storage_unavailable;
+// - иначе 500 (Internal Server Error), internal_error с описанием message.
+func writeStorageError(res http.ResponseWriter, req *http.Request, err error, message string) {
+	switch {
+	case errors.Is(err, service.ErrTypeConflict):
+		apierror.Write(res, req, http.StatusConflict, apierror.CodeTypeConflict, err.Error(), nil)
+	case errors.Is(err, service.ErrUnknownMetric):
+		apierror.Write(res, req, http.StatusNotFound, apierror.CodeNotFound, "metric not found", nil)
+	case errors.Is(err, service.ErrInvalidMetricName), errors.Is(err, storage.ErrInvalidQuery):
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, err.Error(), nil)
+	default:
+		status := storageErrorStatus(err, http.StatusInternalServerError)
+		if status == http.StatusInternalServerError {
+			logging.Logg.ErrorContext(req.Context(), message, "error", err)
+			apierror.Write(res, req, status, apierror.CodeInternal, message, nil)
+			return
+		}
+		apierror.Write(res, req, status, apierror.CodeStorageUnavailable, "storage is unavailable", nil)
+	}
+}
+
+// writeMatchError отправляет ошибку matchMetrics: 400 (Bad Request) для некорректного
+// шаблона, иначе ошибку хранилища.
+func writeMatchError(res http.ResponseWriter, req *http.Request, err error) {
+	if errors.Is(err, errInvalidPattern) {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, err.Error(), nil)
+		return
+	}
+	writeStorageError(res, req, err, "failed to list metrics")
+}
+
+// writeMethodNotAllowed отправляет ошибку 405 (Method Not Allowed) для метода,
+// отличного от allowed.
+func writeMethodNotAllowed(res http.ResponseWriter, req *http.Request, allowed string) {
+	res.Header().Set("Allow", allowed)
+	apierror.Write(res, req, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed,
+		fmt.Sprintf("only %s requests are allowed", allowed), nil)
+}
+
+// errInvalidPattern возвращается, если шаблон имени для группового удаления или обнуления
+// не задан или некорректен. Пустой шаблон не означает "все метрики": случайный запрос
+// без параметра не должен затрагивать все хранилище.
+var errInvalidPattern = errors.New("invalid pattern")
+
+// matchMetrics возвращает имена метрик, соответствующие шаблону pattern (синтаксис path.Match).
+// Если mtype не пустой, отбираются только метрики этого типа. Отбор выполняет хранилище.
+func (ms *MetricsServer) matchMetrics(ctx context.Context, pattern string, mtype service.MetricType) ([]string, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("%w: pattern query parameter is required", errInvalidPattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%w %q: %v", errInvalidPattern, pattern, err)
+	}
+
+	page, err := ms.MetricStorage.ListPage(ctx, storage.ListQuery{Pattern: pattern, Match: storage.MatchGlob, Type: mtype})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(page.Metrics))
+	for _, mt := range page.Metrics {
+		names = append(names, mt.ID)
+	}
+	return names, nil
+}
+
+// parseListQuery читает параметры выборки метрик из строки запроса: type, sort, limit,
+// cursor и не больше одного из шаблонов имени prefix, glob или regex. Размер страницы
+// по умолчанию — DefaultPageSize, наибольший — MaxPageSize. Остальные параметры
+// проверяет хранилище; ошибки в обоих случаях оборачивают storage.ErrInvalidQuery.
+func parseListQuery(req *http.Request) (storage.ListQuery, error) {
+	query := req.URL.Query()
+	q := storage.ListQuery{
+		Type:   service.MetricType(query.Get("type")),
+		Sort:   storage.SortOrder(query.Get("sort")),
+		Cursor: query.Get("cursor"),
+		Limit:  DefaultPageSize,
+	}
+	for _, match := range []storage.MatchMode{storage.MatchPrefix, storage.MatchGlob, storage.MatchRegex} {
+		pattern := query.Get(string(match))
+		if pattern == "" {
+			continue
+		}
+		if q.Pattern != "" {
+			return q, fmt.Errorf("%w: only one of prefix, glob and regex may be set", storage.ErrInvalidQuery)
+		}
+		q.Pattern, q.Match = pattern, match
+	}
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > MaxPageSize {
+			return q, fmt.Errorf("%w: limit must be an integer from 1 to %d", storage.ErrInvalidQuery, MaxPageSize)
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// writeJSON отправляет v клиенту в формате JSON со статусом 200 (OK).
+func writeJSON(res http.ResponseWriter, req *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		apierror.Write(res, req, http.StatusInternalServerError, apierror.CodeInternal, "failed to marshal response", nil)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(body)
+}
+
+// batchReport — ответ на пакетное обновление в режиме частичного приема.
+//
+// Поля:
+//   - Accepted: Число сохраненных метрик.
+//   - Rejected: Отклоненные метрики с позицией в пакете и причиной.
+type batchReport struct {
+	Accepted int               `json:"accepted"`
+	Rejected validation.Errors `json:"rejected"`
+}
+
+// acceptBatch проверяет пакет метрик и сохраняет корректные. Без partial пакет с хотя бы
+// одной некорректной метрикой отклоняется целиком (400, validation_error, в details —
+// отклоненные метрики); с partial сохраняются только корректные. Пустой пакет без partial
+// считается ошибкой. Если пакет не сохранен, ответ с ошибкой уже отправлен и ok равен false.
+func (ms *MetricsServer) acceptBatch(ctx context.Context, res http.ResponseWriter, req *http.Request,
+	metrics []service.Metrics, partial bool) (report batchReport, ok bool) {
+	valid, rejected := validation.Batch(metrics)
+	if len(rejected) > 0 {
+		rejectedMetrics.Add(uint64(len(rejected)))
+		logging.Logg.WarnContext(ctx, "Rejected invalid metrics", "count", len(rejected), "error", rejected)
+		if !partial {
+			apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, rejected.Error(), rejected)
+			return batchReport{}, false
+		}
+	}
+
+	if len(valid) > 0 || !partial {
+		if err := ms.saveMetrics(ctx, valid); err != nil {
+			writeStorageError(res, req, err, "failed to save metrics")
+			return batchReport{}, false
+		}
+		ids := make([]string, len(valid))
+		for i, mt := range valid {
+			ids[i] = mt.ID
+		}
+		ms.publish(ctx, ids...)
+	}
+	if rejected == nil {
+		rejected = validation.Errors{}
+	}
+	return batchReport{Accepted: len(valid), Rejected: rejected}, true
+}
+
+// isPartial сообщает, запрошен ли частичный прием пакета параметром запроса "partial".
+func isPartial(req *http.Request) bool {
+	partial, _ := strconv.ParseBool(req.URL.Query().Get("partial"))
+	return partial
+}
+
 // checkPostMethod проверяет, является ли HTTP-метод запроса POST.
 // Если метод отличается от POST, возвращается ошибка.
 func (ms *MetricsServer) checkPostMethod(req *http.Request) error {
@@ -119,8 +401,11 @@ func (ms *MetricsServer) checkPostMethod(req *http.Request) error {
 // readRequestBody читает тело HTTP-запроса и возвращает его в виде массива байтов.
 // Если возникает ошибка при чтении тела запроса, она возвращается.
 // После чтения тело запроса закрывается.
-func (ms *MetricsServer) readRequestBody(req *http.Request) ([]byte, error) {
-	body, err := io.ReadAll(req.Body)
+func (ms *MetricsServer) readRequestBody(req *http.Request) (body []byte, err error) {
+	_, span := tracing.Start(req.Context(), "UpdateBatch.readRequestBody")
+	defer func() { tracing.End(span, err) }()
+
+	body, err = io.ReadAll(req.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read request body")
 	}
@@ -146,12 +431,15 @@ func (ms *MetricsServer) loadPrivateKey() (*rsa.PrivateKey, error) {
 // decryptData расшифровывает данные с использованием предоставленного приватного ключа.
 // Если ключ отсутствует, возвращаются исходные данные без изменений.
 // В случае ошибки расшифровки возвращается соответствующая ошибка.
-func (ms *MetricsServer) decryptData(data []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
+func (ms *MetricsServer) decryptData(ctx context.Context, data []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
 	if privateKey == nil {
 		return data, nil
 	}
+	_, span := tracing.Start(ctx, "UpdateBatch.decryptData")
 	decryptedData, err := crypto.DecryptData(string(data), privateKey)
+	tracing.End(span, err)
 	if err != nil {
+		decryptFailures.Inc()
 		return nil, fmt.Errorf("failed to decrypt data: %w", err)
 	}
 	return decryptedData, nil
@@ -160,20 +448,27 @@ func (ms *MetricsServer) decryptData(data []byte, privateKey *rsa.PrivateKey) ([
 // parseMetrics десериализует JSON-данные в массив метрик.
 // Если данные не могут быть преобразованы в формат []service.Metrics,
 // возвращается соответствующая ошибка.
-func (ms *MetricsServer) parseMetrics(data []byte) ([]service.Metrics, error) {
+func (ms *MetricsServer) parseMetrics(ctx context.Context, data []byte) ([]service.Metrics, error) {
+	_, span := tracing.Start(ctx, "UpdateBatch.parseMetrics")
 	var metrics []service.Metrics
 	if err := json.Unmarshal(data, &metrics); err != nil {
+		tracing.End(span, err)
 		return nil, fmt.Errorf("failed to unmarshal metrics: %w", err)
 	}
+	span.SetAttributes(attribute.Int("metrix.batch_size", len(metrics)))
+	span.End()
 	return metrics, nil
 }
 
 // saveMetrics сохраняет массив метрик в хранилище.
 // Если сохранение завершается ошибкой, она возвращается.
 func (ms *MetricsServer) saveMetrics(ctx context.Context, metrics []service.Metrics) error {
+	ctx, span := tracing.Start(ctx, "UpdateBatch.saveMetrics")
 	if err := ms.MetricStorage.SaveAll(ctx, &metrics); err != nil {
+		tracing.End(span, err)
 		return fmt.Errorf("failed to save metrics: %w", err)
 	}
+	span.End()
 	return nil
 }
 
@@ -188,16 +483,16 @@ func (ms *MetricsServer) getAllMetrics(ctx context.Context) (*map[string]service
 }
 
 // prepareResponse подготавливает ответ клиенту:
-// 1. Преобразует метрики в формат JSON.
+// 1. Преобразует ответ (метрики или отчет о пакете) в формат JSON.
 // 2. Генерирует хэш SHA-256 на основе JSON-данных и ключа (если ключ предоставлен).
 //
 // Возвращает:
 // - Сериализованные данные метрик.
 // - Хэш (если ключ предоставлен).
 // - Ошибку, если что-то пошло не так.
-func (ms *MetricsServer) prepareResponse(metrics *map[string]service.Metrics, key string) ([]byte, string, error) {
+func (ms *MetricsServer) prepareResponse(v any, key string) ([]byte, string, error) {
 	// Преобразование данных в JSON
-	bufResp, err := json.Marshal(metrics)
+	bufResp, err := json.Marshal(v)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to marshal response: %w", err)
 	}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/selfmetrics"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/dvkhr/metrix.git/internal/storage"
+)
+
+// Метрики самонаблюдения, которые записывают обработчики.
+var (
+	batchSizeHist = selfmetrics.Default.Histogram("metrix_batch_size",
+		"Number of metrics in a batch update request.", selfmetrics.SizeBuckets)
+	decryptFailures = selfmetrics.Default.Counter("metrix_decrypt_failures_total",
+		"Number of batch requests that failed to decrypt.")
+	rejectedMetrics = selfmetrics.Default.Counter("metrix_rejected_metrics_total",
+		"Number of incoming metrics rejected by validation.")
+)
+
+// observedStorage — обертка над MetricStorage, которая измеряет длительность
+// и учитывает ошибки каждой операции с хранилищем в реестре selfmetrics.Default.
+type observedStorage struct {
+	MetricStorage
+}
+
+// newObservedStorage оборачивает хранилище для сбора метрик самонаблюдения.
+func newObservedStorage(ms MetricStorage) MetricStorage {
+	return &observedStorage{MetricStorage: ms}
+}
+
+// observe записывает длительность операции и, при ошибке, увеличивает счетчик ошибок.
+func (ms *observedStorage) observe(operation string, start time.Time, err error) {
+	selfmetrics.Default.Histogram("metrix_storage_operation_duration_seconds",
+		"Storage operation latency in seconds.", selfmetrics.DurationBuckets,
+		"operation", operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		selfmetrics.Default.Counter("metrix_storage_errors_total",
+			"Number of failed storage operations.", "operation", operation).Inc()
+	}
+}
+
+func (ms *observedStorage) Save(ctx context.Context, mt service.Metrics) error {
+	start := time.Now()
+	err := ms.MetricStorage.Save(ctx, mt)
+	ms.observe("save", start, err)
+	return err
+}
+
+func (ms *observedStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
+	start := time.Now()
+	err := ms.MetricStorage.SaveAll(ctx, mt)
+	ms.observe("save_all", start, err)
+	return err
+}
+
+func (ms *observedStorage) Get(ctx context.Context, metricName string) (*service.Metrics, error) {
+	start := time.Now()
+	m, err := ms.MetricStorage.Get(ctx, metricName)
+	ms.observe("get", start, err)
+	return m, err
+}
+
+func (ms *observedStorage) List(ctx context.Context) (*map[string]service.Metrics, error) {
+	start := time.Now()
+	m, err := ms.MetricStorage.List(ctx)
+	ms.observe("list", start, err)
+	return m, err
+}
+
+func (ms *observedStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
+	start := time.Now()
+	m, err := ms.MetricStorage.ListSlice(ctx)
+	ms.observe("list_slice", start, err)
+	return m, err
+}
+
+func (ms *observedStorage) ListPage(ctx context.Context, q storage.ListQuery) (*storage.Page, error) {
+	start := time.Now()
+	p, err := ms.MetricStorage.ListPage(ctx, q)
+	ms.observe("list_page", start, err)
+	return p, err
+}
+
+func (ms *observedStorage) CheckStorage(ctx context.Context) error {
+	start := time.Now()
+	err := ms.MetricStorage.CheckStorage(ctx)
+	ms.observe("check", start, err)
+	return err
+}
+
+func (ms *observedStorage) Delete(ctx context.Context, metricNames ...string) (int, error) {
+	start := time.Now()
+	n, err := ms.MetricStorage.Delete(ctx, metricNames...)
+	ms.observe("delete", start, err)
+	return n, err
+}
+
+func (ms *observedStorage) DeleteWhere(ctx context.Context, f storage.DeleteFilter, metricNames ...string) (int, error) {
+	start := time.Now()
+	n, err := ms.MetricStorage.DeleteWhere(ctx, f, metricNames...)
+	ms.observe("delete", start, err)
+	return n, err
+}
+
+func (ms *observedStorage) Reset(ctx context.Context, metricNames ...string) (int, error) {
+	start := time.Now()
+	n, err := ms.MetricStorage.Reset(ctx, metricNames...)
+	ms.observe("reset", start, err)
+	return n, err
+}
+
+func (ms *observedStorage) Replace(ctx context.Context, mt service.Metrics) error {
+	start := time.Now()
+	err := ms.MetricStorage.Replace(ctx, mt)
+	ms.observe("replace", start, err)
+	return err
+}
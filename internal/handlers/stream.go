@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/apierror"
+	"github.com/dvkhr/metrix.git/internal/logging"
+)
+
+// streamHeartbeat — интервал комментариев-пульсов в потоке обновлений, по которым
+// клиенты и прокси понимают, что соединение живо.
+var streamHeartbeat = 15 * time.Second
+
+// HandleStream отправляет клиенту обновления метрик по мере их сохранения в формате
+// Server-Sent Events (text/event-stream).
+//
+// Каждое обновление — событие "metric", поле data которого содержит метрику в формате JSON
+// с сохраненным значением (для счетчика — накопленным). Фильтры задаются теми же параметрами,
+// что и у GET "/api/v2/metrics": type и один из шаблонов имени prefix, glob или regex.
+// Поток отдает только обновления, сделанные после подключения, и держит соединение открытым,
+// пока клиент не отключится или сервер не остановится; раз в streamHeartbeat отправляется
+// комментарий. Если клиент не успевает читать, часть обновлений для него пропускается.
+//
+// Ошибки возвращаются в формате JSON API: 400 (Bad Request) с кодом validation_error
+// для некорректного фильтра и 500 (Internal Server Error), если у сервера нет Hub.
+//
+// Параметры:
+// - res: HTTP-ответ, который будет отправлен клиенту.
+// - req: HTTP-запрос с необязательными параметрами фильтрации.
+func (ms *MetricsServer) HandleStream(res http.ResponseWriter, req *http.Request) {
+	q, err := parseListQuery(req)
+	if err != nil {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, err.Error(), nil)
+		return
+	}
+	match, err := q.Matcher()
+	if err != nil {
+		apierror.Write(res, req, http.StatusBadRequest, apierror.CodeValidation, err.Error(), nil)
+		return
+	}
+
+	if ms.Hub == nil {
+		apierror.Write(res, req, http.StatusInternalServerError, apierror.CodeInternal, "metric stream is not available", nil)
+		return
+	}
+
+	rc := http.NewResponseController(res)
+	// Поток живет дольше WriteTimeout сервера, поэтому срок записи снимается.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logging.Logg.WarnContext(req.Context(), "Failed to reset stream write deadline", "error", err)
+	}
+
+	sub := ms.Hub.Subscribe(match)
+	defer sub.Close()
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprint(res, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		logging.Logg.ErrorContext(req.Context(), "Metric stream is not supported by the connection", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(res, ": heartbeat\n\n")
+		case mt, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(mt)
+			if err != nil {
+				logging.Logg.ErrorContext(req.Context(), "Failed to marshal stream event", "name", mt.ID, "error", err)
+				continue
+			}
+			fmt.Fprintf(res, "event: metric\ndata: %s\n\n", data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/config"
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readEvent читает из потока следующее событие "metric", пропуская комментарии.
+func readEvent(t *testing.T, r *bufio.Reader) service.Metrics {
+	t.Helper()
+	var event string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "event: metric":
+			event = line
+		case strings.HasPrefix(line, "data: "):
+			require.Equal(t, "event: metric", event)
+			var mt service.Metrics
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &mt))
+			return mt
+		}
+	}
+}
+
+func TestHandleStream(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	server, err := NewMetricsServer(config.ConfigServ{})
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Get("/stream", server.HandleStream)
+	r.Post("/update/", server.UpdateMetric)
+	r.Post("/updates/", server.UpdateBatch)
+	r.Post("/update/gauge/{name}/{value}", server.HandlePutGaugeMetric)
+	r.Post("/update/counter/{name}/{value}", server.HandlePutCounterMetric)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	post := func(path, body string) {
+		t.Helper()
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("Invalid filter", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/stream?regex=(")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	})
+
+	resp, err := http.Get(ts.URL + "/stream?type=counter&glob=Poll*")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := bufio.NewReader(resp.Body)
+	require.Eventually(t, server.Hub.HasSubscribers, time.Second, time.Millisecond)
+
+	t.Run("Path updates", func(t *testing.T) {
+		post("/update/gauge/PollGauge/1.5", "")
+		post("/update/counter/Other/1", "")
+		post("/update/counter/PollCount/5", "")
+		post("/update/counter/PollCount/2", "")
+
+		assert.Equal(t, int64(5), int64(*readEvent(t, events).Delta))
+		mt := readEvent(t, events)
+		assert.Equal(t, "PollCount", mt.ID)
+		assert.Equal(t, int64(7), int64(*mt.Delta))
+	})
+
+	t.Run("JSON and batch updates", func(t *testing.T) {
+		post("/update/", `{"id":"PollCount","type":"counter","delta":3}`)
+		post("/updates/", `[{"id":"PollCount","type":"counter","delta":1},{"id":"PollCount","type":"counter","delta":1},{"id":"Alloc","type":"gauge","value":1}]`)
+
+		assert.Equal(t, int64(10), int64(*readEvent(t, events).Delta))
+		mt := readEvent(t, events)
+		assert.Equal(t, "PollCount", mt.ID)
+		assert.Equal(t, int64(12), int64(*mt.Delta))
+	})
+
+	t.Run("Close ends the stream", func(t *testing.T) {
+		require.NoError(t, server.Close())
+		_, err := events.ReadString(0)
+		assert.Error(t, err)
+	})
+}
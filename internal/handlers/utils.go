@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/dvkhr/metrix.git/internal/replication"
 	"github.com/dvkhr/metrix.git/internal/storage"
 )
 
@@ -42,7 +43,13 @@ func ReadAndUnmarshal(req *http.Request, v interface{}) error {
 func CheckImplementations() {
 	var (
 		_ MetricStorage = (*storage.DBStorage)(nil)
+		_ MetricStorage = (*storage.PgxStorage)(nil)
+		_ MetricStorage = (*storage.SQLiteStorage)(nil)
 		_ MetricStorage = (*storage.FileStorage)(nil)
 		_ MetricStorage = (*storage.MemStorage)(nil)
+		_ MetricStorage = (*storage.SnapshotStorage)(nil)
+		_ MetricStorage = (*storage.CachedStorage)(nil)
+		_ MetricStorage = (*storage.ExpiringStorage)(nil)
+		_ MetricStorage = (*replication.ReplicatedStorage)(nil)
 	)
 }
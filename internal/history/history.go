@@ -0,0 +1,99 @@
+// Package history хранит в памяти последние значения метрик, чтобы HTML-страница сервера
+// могла показать их динамику.
+//
+// История не сохраняется в хранилище и начинается заново после перезапуска сервера.
+// Значения записываются через равные промежутки времени (см. Recorder.Run), а не при каждом
+// обновлении метрики, поэтому история одинаково работает с любым хранилищем и с несколькими
+// серверами, которые пишут в одну базу.
+package history
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+)
+
+// Recorder — история значений метрик: для каждой метрики хранится не больше size
+// последних значений. Безопасен для одновременного использования.
+type Recorder struct {
+	size int
+
+	mu     sync.RWMutex
+	series map[string][]float64
+}
+
+// NewRecorder создает историю, которая хранит size последних значений каждой метрики.
+func NewRecorder(size int) *Recorder {
+	return &Recorder{size: size, series: make(map[string][]float64)}
+}
+
+// Record добавляет в историю текущие значения метрик. История метрик, которых нет
+// в metrics (удаленных или скрытых хранилищем), удаляется.
+func (r *Recorder) Record(metrics []service.Metrics) {
+	if r.size <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := make(map[string]struct{}, len(metrics))
+	for _, mt := range metrics {
+		v, ok := mt.Float()
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		seen[mt.ID] = struct{}{}
+		values := r.series[mt.ID]
+		if len(values) == r.size {
+			copy(values, values[1:])
+			values = values[:len(values)-1]
+		}
+		r.series[mt.ID] = append(values, v)
+	}
+	for id := range r.series {
+		if _, ok := seen[id]; !ok {
+			delete(r.series, id)
+		}
+	}
+}
+
+// Values возвращает копию истории метрики от старых значений к новым или nil,
+// если история метрики пуста.
+func (r *Recorder) Values(id string) []float64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	values := r.series[id]
+	if len(values) == 0 {
+		return nil
+	}
+	return append([]float64(nil), values...)
+}
+
+// Run записывает в историю значения всех метрик, полученные от list, раз в interval,
+// до отмены ctx. Ошибки list записываются в журнал; очередная запись пропускается.
+func (r *Recorder) Run(ctx context.Context, interval time.Duration, list func(ctx context.Context) ([]service.Metrics, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics, err := list(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					logging.Logg.WarnContext(ctx, "Failed to sample metrics history", "error", err)
+				}
+				continue
+			}
+			r.Record(metrics)
+		}
+	}
+}
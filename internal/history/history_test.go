@@ -0,0 +1,80 @@
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func gauge(id string, v float64) service.Metrics {
+	value := service.GaugeMetricValue(v)
+	return service.Metrics{ID: id, MType: service.GaugeMetric, Value: &value}
+}
+
+func counter(id string, d int64) service.Metrics {
+	delta := service.CounterMetricValue(d)
+	return service.Metrics{ID: id, MType: service.CounterMetric, Delta: &delta}
+}
+
+func TestRecorder(t *testing.T) {
+	t.Run("Keeps last values", func(t *testing.T) {
+		r := NewRecorder(3)
+		for i := 1; i <= 5; i++ {
+			r.Record([]service.Metrics{gauge("g", float64(i)), counter("c", int64(i*10))})
+		}
+		assert.Equal(t, []float64{3, 4, 5}, r.Values("g"))
+		assert.Equal(t, []float64{30, 40, 50}, r.Values("c"))
+		assert.Nil(t, r.Values("missing"))
+	})
+
+	t.Run("Values is a copy", func(t *testing.T) {
+		r := NewRecorder(3)
+		r.Record([]service.Metrics{gauge("g", 1)})
+		r.Values("g")[0] = 100
+		assert.Equal(t, []float64{1}, r.Values("g"))
+	})
+
+	t.Run("Missing metrics are forgotten", func(t *testing.T) {
+		r := NewRecorder(3)
+		r.Record([]service.Metrics{gauge("g", 1), gauge("h", 1)})
+		r.Record([]service.Metrics{gauge("h", 2)})
+		assert.Nil(t, r.Values("g"))
+		assert.Equal(t, []float64{1, 2}, r.Values("h"))
+	})
+
+	t.Run("Zero size keeps nothing", func(t *testing.T) {
+		r := NewRecorder(0)
+		r.Record([]service.Metrics{gauge("g", 1)})
+		assert.Nil(t, r.Values("g"))
+	})
+}
+
+func TestRecorderRun(t *testing.T) {
+	r := NewRecorder(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	calls := 0
+	go func() {
+		defer close(done)
+		r.Run(ctx, time.Millisecond, func(context.Context) ([]service.Metrics, error) {
+			calls++
+			if calls == 3 {
+				cancel()
+			}
+			if calls > 3 {
+				return nil, context.Canceled
+			}
+			return []service.Metrics{gauge("g", float64(calls))}, nil
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop after cancel")
+	}
+	assert.Equal(t, []float64{1, 2, 3}, r.Values("g"))
+}
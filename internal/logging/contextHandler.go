@@ -0,0 +1,45 @@
+// Package logging предоставляет инструменты для настройки и управления логированием в приложении.
+//
+// Пакет использует библиотеку slog для структурированного логирования
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/dvkhr/metrix.git/internal/requestid"
+)
+
+// ContextHandler — обработчик, который добавляет в каждую запись атрибуты из контекста,
+// например идентификатор запроса, и передает запись вложенному обработчику.
+type ContextHandler struct {
+	next slog.Handler
+}
+
+// NewContextHandler создает новый ContextHandler поверх указанного обработчика
+func NewContextHandler(next slog.Handler) *ContextHandler {
+	return &ContextHandler{next: next}
+}
+
+// Enabled проверяет, включен ли уровень логирования во вложенном обработчике
+func (h *ContextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle добавляет атрибут request_id, если он есть в контексте, и передает запись дальше
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestid.FromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs добавляет атрибуты к вложенному обработчику
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return NewContextHandler(h.next.WithAttrs(attrs))
+}
+
+// WithGroup добавляет группу к вложенному обработчику
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return NewContextHandler(h.next.WithGroup(name))
+}
@@ -4,6 +4,7 @@
 package logging
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -106,9 +107,10 @@ func InitLogger(configPath string) error {
 			}))
 		}
 
-		// Создаем логгер с несколькими обработчиками
+		// Создаем логгер с несколькими обработчиками;
+		// ContextHandler добавляет в записи идентификатор запроса из контекста
 		Logg = &Logger{
-			logger: slog.New(NewMultiHandler(handlers...)),
+			logger: slog.New(NewContextHandler(NewMultiHandler(handlers...))),
 		}
 	})
 	return err
@@ -193,6 +195,29 @@ func (l *Logger) Debug(msg string, attrs ...any) {
 	l.logger.Debug(msg, attrs...)
 }
 
+// InfoContext логирует информационное сообщение с атрибутами из контекста
+func (l *Logger) InfoContext(ctx context.Context, msg string, attrs ...any) {
+	if l == nil || l.logger == nil {
+		panic("logger is not initialized")
+	}
+	l.logger.InfoContext(ctx, msg, attrs...)
+}
+
+// WarnContext логирует предупреждение с атрибутами из контекста
+func (l *Logger) WarnContext(ctx context.Context, msg string, attrs ...any) {
+	l.logger.WarnContext(ctx, msg, attrs...)
+}
+
+// ErrorContext логирует сообщение об ошибке с атрибутами из контекста
+func (l *Logger) ErrorContext(ctx context.Context, msg string, attrs ...any) {
+	l.logger.ErrorContext(ctx, msg, attrs...)
+}
+
+// DebugContext логирует отладочное сообщение с атрибутами из контекста
+func (l *Logger) DebugContext(ctx context.Context, msg string, attrs ...any) {
+	l.logger.DebugContext(ctx, msg, attrs...)
+}
+
 // Маскировка чувствительных данных
 func MaskSensitiveData(body string) string {
 	re := regexp.MustCompile(`("(password|token)"\s*:\s*")([^"]*)`)
@@ -27,7 +27,13 @@ func (rw *responseWriterWrapper) WriteHeader(statusCode int) {
 	rw.ResponseWriter.WriteHeader(statusCode)
 }
 
-// LoggingMiddleware логирование HTTP-запросов
+// Unwrap возвращает исходный ResponseWriter для http.ResponseController.
+func (rw *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// LoggingMiddleware логирование HTTP-запросов.
+// Идентификатор запроса, сохраненный в контексте requestid.Middleware, попадает в каждую запись
 func LoggingMiddleware(logger *Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -57,7 +63,7 @@ func LoggingMiddleware(logger *Logger) func(http.Handler) http.Handler {
 
 			maskedBody := logging.MaskSensitiveData(string(bodyBytes))*/
 
-			logger.Info("incoming request",
+			logger.InfoContext(ctx, "incoming request",
 				//"username", username,
 				"method", r.Method,
 				"url", r.URL.String(),
@@ -70,7 +76,7 @@ func LoggingMiddleware(logger *Logger) func(http.Handler) http.Handler {
 			next.ServeHTTP(rww, r)
 
 			duration := time.Since(start)
-			logger.Info("request completed",
+			logger.InfoContext(ctx, "request completed",
 				//	"username", username,
 				"method", r.Method,
 				"url", r.URL.String(),
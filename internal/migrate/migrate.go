@@ -0,0 +1,267 @@
+// Package migrate применяет версионированные SQL-миграции схемы базы данных.
+//
+// Миграции хранятся в файлах вида NNNN_name.up.sql и NNNN_name.down.sql (обычно встроенных
+// через embed). Номера примененных версий записываются в таблицу schema_version.
+// Применение и откат выполняются под блокировкой базы данных, поэтому несколько экземпляров
+// сервера, стартующих одновременно, не применяют одну миграцию дважды.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migration — одна версия схемы.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status — состояние миграции в базе данных.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var (
+	// ErrNoDownMigration возвращается при откате версии без файла .down.sql.
+	ErrNoDownMigration = errors.New("migration has no down script")
+	// ErrUnknownVersion возвращается, если в базе применена версия, которой нет среди миграций.
+	ErrUnknownVersion = errors.New("database schema version is unknown to this build")
+)
+
+var fileNameRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Load читает миграции из корня fsys. Файлы с другими именами игнорируются.
+// Каждая версия должна иметь скрипт .up.sql; скрипт .down.sql необязателен.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileNameRe.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// DB — источник соединений с базой данных; ему удовлетворяет *sql.DB.
+type DB interface {
+	Conn(ctx context.Context) (*sql.Conn, error)
+}
+
+// Dialect описывает особенности конкретной СУБД, нужные мигратору.
+type Dialect interface {
+	// CreateVersionTable возвращает запрос создания таблицы schema_version, если ее нет.
+	CreateVersionTable() string
+	// InsertVersion возвращает запрос записи примененной версии с параметрами (version, name).
+	InsertVersion() string
+	// DeleteVersion возвращает запрос удаления версии с параметром (version).
+	DeleteVersion() string
+	// Lock захватывает блокировку миграций на соединении conn, ожидая ее освобождения.
+	Lock(ctx context.Context, conn *sql.Conn) error
+	// Unlock освобождает блокировку миграций.
+	Unlock(ctx context.Context, conn *sql.Conn) error
+}
+
+// Migrator применяет и откатывает миграции.
+type Migrator struct {
+	db         DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// New создает мигратор для набора миграций, отсортированного по версии (см. Load).
+func New(db DB, dialect Dialect, migrations []Migration) *Migrator {
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}
+}
+
+// withLock выполняет f на выделенном соединении под блокировкой миграций.
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := m.dialect.Lock(ctx, conn); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		// Блокировку нужно освободить, даже если контекст уже отменен.
+		if unlockErr := m.dialect.Unlock(context.WithoutCancel(ctx), conn); unlockErr != nil && err == nil {
+			err = fmt.Errorf("failed to release migration lock: %w", unlockErr)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, m.dialect.CreateVersionTable()); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+	return f(conn)
+}
+
+// applied возвращает примененные версии и время их применения.
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "select version, applied_at from schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+	return versions, rows.Err()
+}
+
+// exec выполняет скрипт миграции и изменение schema_version в одной транзакции.
+func (m *Migrator) exec(ctx context.Context, conn *sql.Conn, script, versionQuery string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, versionQuery, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up применяет все еще не примененные миграции по возрастанию версии.
+//
+// Возвращаемые значения:
+// - []Migration: Примененные в этом вызове миграции.
+// - error: Ошибка первой неудачной миграции; предыдущие миграции остаются примененными.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			if _, ok := versions[mg.Version]; ok {
+				continue
+			}
+			if err := m.exec(ctx, conn, mg.Up, m.dialect.InsertVersion(), mg.Version, mg.Name); err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", mg.Version, mg.Name, err)
+			}
+			done = append(done, mg)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down откатывает steps последних примененных миграций.
+//
+// Возвращаемые значения:
+// - []Migration: Откаченные миграции в порядке отката.
+// - error: Ошибка, если версия неизвестна, не имеет скрипта отката или откат не удался.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		known := make(map[int64]Migration, len(m.migrations))
+		for _, mg := range m.migrations {
+			known[mg.Version] = mg
+		}
+
+		applied := make([]int64, 0, len(versions))
+		for version := range versions {
+			applied = append(applied, version)
+		}
+		sort.Slice(applied, func(i, j int) bool { return applied[i] > applied[j] })
+
+		for i := 0; i < steps && i < len(applied); i++ {
+			mg, ok := known[applied[i]]
+			if !ok {
+				return fmt.Errorf("%w: %d", ErrUnknownVersion, applied[i])
+			}
+			if mg.Down == "" {
+				return fmt.Errorf("%w: %d_%s", ErrNoDownMigration, mg.Version, mg.Name)
+			}
+			if err := m.exec(ctx, conn, mg.Down, m.dialect.DeleteVersion(), mg.Version); err != nil {
+				return fmt.Errorf("rollback of %d_%s failed: %w", mg.Version, mg.Name, err)
+			}
+			done = append(done, mg)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status возвращает состояние всех известных миграций.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			appliedAt, ok := versions[mg.Version]
+			statuses = append(statuses, Status{Migration: mg, Applied: ok, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
@@ -0,0 +1,152 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // Импортируем драйвер pgx
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite" // Импортируем драйвер sqlite
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("Success: Sorted by version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0002_add_index.up.sql":      {Data: []byte("create index i on t (a);")},
+			"0002_add_index.down.sql":    {Data: []byte("drop index i;")},
+			"0001_create_table.up.sql":   {Data: []byte("create table t (a int);")},
+			"0010_without_down.up.sql":   {Data: []byte("alter table t add b int;")},
+			"README.md":                  {Data: []byte("not a migration")},
+			"0003_bad-name.up.sql":       {Data: []byte("ignored")},
+			"0001_create_table.down.sql": {Data: []byte("drop table t;")},
+		}
+
+		migrations, err := Load(fsys)
+		require.NoError(t, err)
+		require.Len(t, migrations, 3)
+
+		assert.Equal(t, int64(1), migrations[0].Version)
+		assert.Equal(t, "create_table", migrations[0].Name)
+		assert.Equal(t, "drop table t;", migrations[0].Down)
+		assert.Equal(t, int64(2), migrations[1].Version)
+		assert.Equal(t, int64(10), migrations[2].Version)
+		assert.Empty(t, migrations[2].Down)
+	})
+
+	t.Run("Error: Missing up script", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_create_table.down.sql": {Data: []byte("drop table t;")},
+		}
+		_, err := Load(fsys)
+		assert.Error(t, err)
+	})
+
+	t.Run("Error: Conflicting names", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_create_table.up.sql": {Data: []byte("create table t (a int);")},
+			"0001_other_name.down.sql": {Data: []byte("drop table t;")},
+		}
+		_, err := Load(fsys)
+		assert.Error(t, err)
+	})
+}
+
+func TestMigrator_Postgres(t *testing.T) {
+	t.Skip("Skipping this test for CI")
+	dsn := "host=localhost port=5432 user=postgres password=postgres dbname=praktikum sslmode=disable"
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	migrations := []Migration{
+		{Version: 1, Name: "create_t", Up: "create table migrate_test (a int)", Down: "drop table migrate_test"},
+		{Version: 2, Name: "add_b", Up: "alter table migrate_test add column b int", Down: "alter table migrate_test drop column b"},
+	}
+	m := New(db, Postgres, migrations)
+
+	applied, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, applied, 2)
+
+	applied, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.True(t, statuses[1].Applied)
+
+	rolledBack, err := m.Down(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rolledBack[0].Version)
+	assert.Equal(t, int64(1), rolledBack[1].Version)
+}
+
+func TestMigrator_SQLite(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "migrate.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	migrations := []Migration{
+		{Version: 1, Name: "create_t", Up: "create table migrate_test (a int); create index migrate_test_a on migrate_test (a);", Down: "drop table migrate_test"},
+		{Version: 2, Name: "add_b", Up: "alter table migrate_test add column b int", Down: "alter table migrate_test drop column b"},
+		{Version: 3, Name: "no_down", Up: "alter table migrate_test add column c int"},
+	}
+	m := New(db, SQLite, migrations)
+
+	t.Run("Success: Up is idempotent", func(t *testing.T) {
+		applied, err := m.Up(ctx)
+		require.NoError(t, err)
+		assert.Len(t, applied, 3)
+
+		applied, err = m.Up(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, applied)
+	})
+
+	t.Run("Success: Status", func(t *testing.T) {
+		statuses, err := m.Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, 3)
+		for _, st := range statuses {
+			assert.True(t, st.Applied)
+			assert.False(t, st.AppliedAt.IsZero())
+		}
+	})
+
+	t.Run("Error: No down script", func(t *testing.T) {
+		_, err := m.Down(ctx, 1)
+		assert.ErrorIs(t, err, ErrNoDownMigration)
+	})
+
+	t.Run("Error: Failed migration is rolled back", func(t *testing.T) {
+		broken := New(db, SQLite, append(migrations, Migration{Version: 4, Name: "broken", Up: "alter table missing add column d int"}))
+		_, err := broken.Up(ctx)
+		assert.Error(t, err)
+
+		statuses, err := broken.Status(ctx)
+		require.NoError(t, err)
+		assert.False(t, statuses[3].Applied)
+	})
+
+	t.Run("Success: Down", func(t *testing.T) {
+		m := New(db, SQLite, migrations[:2])
+		_, err := db.ExecContext(ctx, "delete from schema_version where version = 3")
+		require.NoError(t, err)
+
+		rolledBack, err := m.Down(ctx, 2)
+		require.NoError(t, err)
+		require.Len(t, rolledBack, 2)
+		assert.Equal(t, int64(2), rolledBack[0].Version)
+		assert.Equal(t, int64(1), rolledBack[1].Version)
+
+		_, err = db.ExecContext(ctx, "select a from migrate_test")
+		assert.Error(t, err)
+	})
+}
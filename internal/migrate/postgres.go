@@ -0,0 +1,41 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+)
+
+// postgresLockID — ключ рекомендательной блокировки (pg_advisory_lock) миграций.
+const postgresLockID int64 = 0x6d6574726978
+
+// Postgres — диалект PostgreSQL. Блокировка реализована через pg_advisory_lock
+// и действует в пределах сессии, поэтому держится на выделенном соединении.
+var Postgres Dialect = postgresDialect{}
+
+type postgresDialect struct{}
+
+func (postgresDialect) CreateVersionTable() string {
+	return `create table if not exists schema_version (
+	version bigint primary key,
+	name text not null,
+	applied_at timestamptz not null default now()
+)`
+}
+
+func (postgresDialect) InsertVersion() string {
+	return "insert into schema_version (version, name) values ($1, $2)"
+}
+
+func (postgresDialect) DeleteVersion() string {
+	return "delete from schema_version where version = $1"
+}
+
+func (postgresDialect) Lock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "select pg_advisory_lock($1)", postgresLockID)
+	return err
+}
+
+func (postgresDialect) Unlock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "select pg_advisory_unlock($1)", postgresLockID)
+	return err
+}
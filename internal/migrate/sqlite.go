@@ -0,0 +1,38 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SQLite — диалект SQLite. Рекомендательных блокировок в SQLite нет; база данных
+// рассчитана на один узел, а каждая миграция выполняется в транзакции вместе с записью
+// в schema_version, поэтому параллельный запуск второго процесса завершится ошибкой
+// уникальности версии, не применив миграцию повторно.
+var SQLite Dialect = sqliteDialect{}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) CreateVersionTable() string {
+	return `create table if not exists schema_version (
+	version integer primary key,
+	name text not null,
+	applied_at timestamp not null default current_timestamp
+)`
+}
+
+func (sqliteDialect) InsertVersion() string {
+	return "insert into schema_version (version, name) values (?, ?)"
+}
+
+func (sqliteDialect) DeleteVersion() string {
+	return "delete from schema_version where version = ?"
+}
+
+func (sqliteDialect) Lock(ctx context.Context, conn *sql.Conn) error {
+	return nil
+}
+
+func (sqliteDialect) Unlock(ctx context.Context, conn *sql.Conn) error {
+	return nil
+}
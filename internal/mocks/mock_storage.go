@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: internal/handlers/handlers.go
+// Source: internal/storage/storage.go
 
 // Package mocks is a generated GoMock package.
 package mocks
@@ -9,6 +9,7 @@ import (
 	reflect "reflect"
 
 	service "github.com/dvkhr/metrix.git/internal/service"
+	storage "github.com/dvkhr/metrix.git/internal/storage"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -36,17 +37,57 @@ func (m *MockMetricStorage) EXPECT() *MockMetricStorageMockRecorder {
 }
 
 // CheckStorage mocks base method.
-func (m *MockMetricStorage) CheckStorage() error {
+func (m *MockMetricStorage) CheckStorage(ctx context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CheckStorage")
+	ret := m.ctrl.Call(m, "CheckStorage", ctx)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CheckStorage indicates an expected call of CheckStorage.
-func (mr *MockMetricStorageMockRecorder) CheckStorage() *gomock.Call {
+func (mr *MockMetricStorageMockRecorder) CheckStorage(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckStorage", reflect.TypeOf((*MockMetricStorage)(nil).CheckStorage))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckStorage", reflect.TypeOf((*MockMetricStorage)(nil).CheckStorage), ctx)
+}
+
+// Delete mocks base method.
+func (m *MockMetricStorage) Delete(ctx context.Context, metricNames ...string) (int, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx}
+	for _, a := range metricNames {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Delete", varargs...)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockMetricStorageMockRecorder) Delete(ctx interface{}, metricNames ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx}, metricNames...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockMetricStorage)(nil).Delete), varargs...)
+}
+
+// DeleteWhere mocks base method.
+func (m *MockMetricStorage) DeleteWhere(ctx context.Context, f storage.DeleteFilter, metricNames ...string) (int, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, f}
+	for _, a := range metricNames {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "DeleteWhere", varargs...)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteWhere indicates an expected call of DeleteWhere.
+func (mr *MockMetricStorageMockRecorder) DeleteWhere(ctx, f interface{}, metricNames ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, f}, metricNames...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWhere", reflect.TypeOf((*MockMetricStorage)(nil).DeleteWhere), varargs...)
 }
 
 // FreeStorage mocks base method.
@@ -93,6 +134,21 @@ func (mr *MockMetricStorageMockRecorder) List(ctx interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockMetricStorage)(nil).List), ctx)
 }
 
+// ListPage mocks base method.
+func (m *MockMetricStorage) ListPage(ctx context.Context, q storage.ListQuery) (*storage.Page, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPage", ctx, q)
+	ret0, _ := ret[0].(*storage.Page)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPage indicates an expected call of ListPage.
+func (mr *MockMetricStorageMockRecorder) ListPage(ctx, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPage", reflect.TypeOf((*MockMetricStorage)(nil).ListPage), ctx, q)
+}
+
 // ListSlice mocks base method.
 func (m *MockMetricStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
 	m.ctrl.T.Helper()
@@ -122,6 +178,40 @@ func (mr *MockMetricStorageMockRecorder) NewStorage() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewStorage", reflect.TypeOf((*MockMetricStorage)(nil).NewStorage))
 }
 
+// Replace mocks base method.
+func (m *MockMetricStorage) Replace(ctx context.Context, mt service.Metrics) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Replace", ctx, mt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Replace indicates an expected call of Replace.
+func (mr *MockMetricStorageMockRecorder) Replace(ctx, mt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Replace", reflect.TypeOf((*MockMetricStorage)(nil).Replace), ctx, mt)
+}
+
+// Reset mocks base method.
+func (m *MockMetricStorage) Reset(ctx context.Context, metricNames ...string) (int, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx}
+	for _, a := range metricNames {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Reset", varargs...)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reset indicates an expected call of Reset.
+func (mr *MockMetricStorageMockRecorder) Reset(ctx interface{}, metricNames ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx}, metricNames...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reset", reflect.TypeOf((*MockMetricStorage)(nil).Reset), varargs...)
+}
+
 // Save mocks base method.
 func (m *MockMetricStorage) Save(ctx context.Context, mt service.Metrics) error {
 	m.ctrl.T.Helper()
@@ -149,3 +239,40 @@ func (mr *MockMetricStorageMockRecorder) SaveAll(ctx, mt interface{}) *gomock.Ca
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveAll", reflect.TypeOf((*MockMetricStorage)(nil).SaveAll), ctx, mt)
 }
+
+// MockListener is a mock of Listener interface.
+type MockListener struct {
+	ctrl     *gomock.Controller
+	recorder *MockListenerMockRecorder
+}
+
+// MockListenerMockRecorder is the mock recorder for MockListener.
+type MockListenerMockRecorder struct {
+	mock *MockListener
+}
+
+// NewMockListener creates a new mock instance.
+func NewMockListener(ctrl *gomock.Controller) *MockListener {
+	mock := &MockListener{ctrl: ctrl}
+	mock.recorder = &MockListenerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockListener) EXPECT() *MockListenerMockRecorder {
+	return m.recorder
+}
+
+// Listen mocks base method.
+func (m *MockListener) Listen(ctx context.Context, handler func([]string)) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Listen", ctx, handler)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Listen indicates an expected call of Listen.
+func (mr *MockListenerMockRecorder) Listen(ctx, handler interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Listen", reflect.TypeOf((*MockListener)(nil).Listen), ctx, handler)
+}
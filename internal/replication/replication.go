@@ -0,0 +1,411 @@
+// Package replication пересылает принятые сервером метрики на другие серверы (реплики).
+//
+// ReplicatedStorage оборачивает основное хранилище: каждая успешная запись асинхронно
+// отправляется на все серверы-реплики тем же протоколом, что использует агент (POST /updates/,
+// gzip, подпись HashSHA256). Для каждой реплики ведется ограниченная очередь; пока реплика
+// недоступна, отправка повторяется с нарастающей паузой, а при переполнении очереди
+// отбрасываются самые старые пакеты.
+//
+// Пакеты, пришедшие от другой реплики, помечаются заголовком Header и повторно не пересылаются,
+// поэтому серверы можно настроить на взаимную репликацию.
+package replication
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/requestid"
+	"github.com/dvkhr/metrix.git/internal/selfmetrics"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/dvkhr/metrix.git/internal/sign"
+	"github.com/dvkhr/metrix.git/internal/storage"
+)
+
+// Header — заголовок запроса, которым реплика помечает пересланный пакет.
+const Header = "X-Metrix-Replicated"
+
+// Значения по умолчанию.
+const (
+	DefaultQueueSize    = 1024
+	DefaultTimeout      = 5 * time.Second
+	DefaultDrainTimeout = 5 * time.Second
+
+	// maxCoalesce ограничивает число метрик, объединяемых из очереди в один запрос.
+	maxCoalesce = 10000
+	// minBackoff и maxBackoff — пределы паузы между повторами отправки.
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+var (
+	// ErrNoPeers возвращается, если обертке не указаны реплики.
+	ErrNoPeers = errors.New("replication requires at least one peer")
+	// errPermanent отмечает ответ реплики, после которого повтор бессмысленен.
+	errPermanent = errors.New("peer rejected the batch")
+)
+
+func init() {
+	storage.RegisterWrapper("replicate", func(inner storage.MetricStorage, params url.Values, opts storage.Options) (storage.MetricStorage, error) {
+		ms := &ReplicatedStorage{
+			MetricStorage: inner,
+			Peers:         params["peer"],
+			SignKey:       opts.SignKey,
+		}
+		if params.Has("queue") {
+			size, err := strconv.Atoi(params.Get("queue"))
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("%w queue=%q", storage.ErrInvalidStorageParam, params.Get("queue"))
+			}
+			ms.QueueSize = size
+		}
+		for name, field := range map[string]*time.Duration{"timeout": &ms.Timeout, "drain_timeout": &ms.DrainTimeout} {
+			if params.Has(name) {
+				d, err := time.ParseDuration(params.Get(name))
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("%w %s=%q", storage.ErrInvalidStorageParam, name, params.Get(name))
+				}
+				*field = d
+			}
+		}
+		if len(ms.Peers) == 0 {
+			return nil, ErrNoPeers
+		}
+		return ms, nil
+	})
+}
+
+type ctxKey struct{}
+
+// WithReplicated помечает контекст записи как пришедшей от другой реплики.
+func WithReplicated(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKey{}, true)
+}
+
+// IsReplicated сообщает, пришла ли запись от другой реплики.
+func IsReplicated(ctx context.Context) bool {
+	replicated, _ := ctx.Value(ctxKey{}).(bool)
+	return replicated
+}
+
+// Middleware помечает контекст запросов с заголовком Header, чтобы ReplicatedStorage
+// не переслал их обратно. Заголовок учитывается только у запросов, подпись которых
+// проверена sign.SignCheck (см. sign.Verified): иначе любой клиент мог бы им исключить
+// свой пакет из репликации. Middleware должен вызываться внутри sign.SignCheck.
+//
+// Параметры:
+// - h: Обработчик HTTP-запроса.
+//
+// Возвращаемое значение:
+// - http.HandlerFunc: Обработчик, передающий h запрос с помеченным контекстом.
+func Middleware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(Header) != "" && sign.Verified(r.Context()) {
+			r = r.WithContext(WithReplicated(r.Context()))
+		}
+		h(w, r)
+	}
+}
+
+// pending — пакет, ожидающий отправки.
+type pending struct {
+	metrics  []service.Metrics
+	enqueued time.Time
+}
+
+// peer — реплика и ее очередь.
+type peer struct {
+	url   string
+	queue chan pending
+
+	queueLen *selfmetrics.Gauge
+	lag      *selfmetrics.Gauge
+	sent     *selfmetrics.Counter
+	failures *selfmetrics.Counter
+	dropped  *selfmetrics.Counter
+}
+
+func newPeer(address string, queueSize int) *peer {
+	target := strings.TrimSuffix(address, "/")
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	target += "/updates/"
+
+	return &peer{
+		url:   target,
+		queue: make(chan pending, queueSize),
+		queueLen: selfmetrics.Default.Gauge("metrix_replication_queue_length",
+			"Number of batches waiting to be sent to a peer.", "peer", address),
+		lag: selfmetrics.Default.Gauge("metrix_replication_lag_seconds",
+			"Age of the oldest batch not yet delivered to a peer.", "peer", address),
+		sent: selfmetrics.Default.Counter("metrix_replication_sent_total",
+			"Number of batches delivered to a peer.", "peer", address),
+		failures: selfmetrics.Default.Counter("metrix_replication_failures_total",
+			"Number of failed attempts to deliver a batch to a peer.", "peer", address),
+		dropped: selfmetrics.Default.Counter("metrix_replication_dropped_total",
+			"Number of batches dropped because the queue was full or the peer rejected them.", "peer", address),
+	}
+}
+
+// enqueue ставит пакет в очередь; при переполнении отбрасывает самый старый пакет.
+func (p *peer) enqueue(batch pending) {
+	for {
+		select {
+		case p.queue <- batch:
+			p.queueLen.Set(float64(len(p.queue)))
+			return
+		default:
+		}
+		select {
+		case <-p.queue:
+			p.dropped.Inc()
+		default:
+		}
+	}
+}
+
+// ReplicatedStorage записывает метрики в основное хранилище и асинхронно пересылает
+// успешно записанные пакеты на реплики. Чтения обслуживаются основным хранилищем.
+//
+// Реплики не должны требовать шифрования (-crypto-key): пакеты отправляются без него.
+// Пакет от реплики распознается по заголовку Header только вместе с проверенной подписью,
+// поэтому для взаимной репликации серверам нужен общий ключ -k: без него пересланные
+// пакеты считаются обычными и отправляются дальше.
+// Удаление, обнуление и замена метрик (Delete, Reset, Replace) выполняются только в основном хранилище:
+// протокол /updates/ их не передает, поэтому на репликах их нужно повторить отдельно.
+//
+// Поля:
+//   - MetricStorage: Основное хранилище.
+//   - Peers: Адреса реплик (host:port или http://host:port).
+//   - QueueSize: Размер очереди каждой реплики в пакетах (0 — DefaultQueueSize).
+//   - SignKey: Ключ подписи HashSHA256 (пустой — без подписи).
+//   - Timeout: Таймаут одного запроса к реплике (0 — DefaultTimeout).
+//   - DrainTimeout: Время на отправку очереди при остановке (0 — DefaultDrainTimeout).
+type ReplicatedStorage struct {
+	storage.MetricStorage
+	Peers        []string
+	QueueSize    int
+	SignKey      []byte
+	Timeout      time.Duration
+	DrainTimeout time.Duration
+
+	client  *http.Client
+	peers   []*peer
+	ctx     context.Context
+	cancel  context.CancelFunc
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+func (ms *ReplicatedStorage) NewStorage() error {
+	if len(ms.Peers) == 0 {
+		return ErrNoPeers
+	}
+	if err := ms.MetricStorage.NewStorage(); err != nil {
+		return err
+	}
+
+	if ms.QueueSize <= 0 {
+		ms.QueueSize = DefaultQueueSize
+	}
+	if ms.Timeout <= 0 {
+		ms.Timeout = DefaultTimeout
+	}
+	if ms.DrainTimeout <= 0 {
+		ms.DrainTimeout = DefaultDrainTimeout
+	}
+	ms.client = &http.Client{Timeout: ms.Timeout}
+	ms.ctx, ms.cancel = context.WithCancel(context.Background())
+	ms.closing = make(chan struct{})
+
+	ms.peers = make([]*peer, 0, len(ms.Peers))
+	for _, address := range ms.Peers {
+		p := newPeer(address, ms.QueueSize)
+		ms.peers = append(ms.peers, p)
+		ms.wg.Add(1)
+		go ms.worker(p)
+	}
+	return nil
+}
+
+// replicate ставит копию пакета в очереди всех реплик.
+func (ms *ReplicatedStorage) replicate(ctx context.Context, metrics []service.Metrics) {
+	if IsReplicated(ctx) || ms.peers == nil || len(metrics) == 0 {
+		return
+	}
+	batch := pending{metrics: make([]service.Metrics, len(metrics)), enqueued: time.Now()}
+	for i, mt := range metrics {
+		batch.metrics[i] = cloneMetric(mt)
+	}
+	for _, p := range ms.peers {
+		p.enqueue(batch)
+	}
+}
+
+func (ms *ReplicatedStorage) Save(ctx context.Context, mt service.Metrics) error {
+	if err := ms.MetricStorage.Save(ctx, mt); err != nil {
+		return err
+	}
+	ms.replicate(ctx, []service.Metrics{mt})
+	return nil
+}
+
+func (ms *ReplicatedStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
+	if err := ms.MetricStorage.SaveAll(ctx, mt); err != nil {
+		return err
+	}
+	ms.replicate(ctx, *mt)
+	return nil
+}
+
+// worker отправляет пакеты из очереди реплики, объединяя накопившиеся пакеты в один запрос.
+// После начала остановки worker отправляет оставшуюся очередь и завершается.
+func (ms *ReplicatedStorage) worker(p *peer) {
+	defer ms.wg.Done()
+	for {
+		var batch pending
+		select {
+		case batch = <-p.queue:
+		case <-ms.closing:
+			select {
+			case batch = <-p.queue:
+			default:
+				return
+			}
+		}
+
+	coalesce:
+		for len(batch.metrics) < maxCoalesce {
+			select {
+			case next := <-p.queue:
+				batch.metrics = append(batch.metrics, next.metrics...)
+			default:
+				break coalesce
+			}
+		}
+		p.queueLen.Set(float64(len(p.queue)))
+
+		if !ms.deliver(p, batch) && ms.ctx.Err() != nil {
+			// Время на остановку истекло: оставшиеся пакеты не будут доставлены.
+			p.dropped.Add(uint64(len(p.queue)) + 1)
+			return
+		}
+		p.lag.Set(0)
+	}
+}
+
+// deliver отправляет пакет, повторяя попытки до успеха, отказа реплики или отмены ms.ctx.
+func (ms *ReplicatedStorage) deliver(p *peer, batch pending) bool {
+	backoff := minBackoff
+	for {
+		p.lag.Set(time.Since(batch.enqueued).Seconds())
+		err := ms.send(ms.ctx, p, batch.metrics)
+		if err == nil {
+			p.sent.Inc()
+			return true
+		}
+		p.failures.Inc()
+		if errors.Is(err, errPermanent) {
+			logging.Logg.Error("Replica rejected metrics batch, dropping it", "peer", p.url, "count", len(batch.metrics), "error", err)
+			p.dropped.Inc()
+			return true
+		}
+		logging.Logg.Warn("Failed to replicate metrics batch, retrying", "peer", p.url, "retry_in", backoff, "error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ms.ctx.Done():
+			return false
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// send выполняет один запрос POST /updates/ к реплике.
+func (ms *ReplicatedStorage) send(ctx context.Context, p *peer, metrics []service.Metrics) error {
+	body, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(body); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, &compressed)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	requestID := requestid.New()
+	req.Header.Set(requestid.Header, requestID)
+	req.Header.Set(requestid.TraceparentHeader, requestid.NewTraceparent(requestID))
+	req.Header.Set(Header, "1")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	if len(ms.SignKey) > 0 {
+		sign := sha256.Sum256(append(append(body, ','), ms.SignKey...))
+		req.Header.Set("HashSHA256", hex.EncodeToString(sign[:]))
+	}
+
+	resp, err := ms.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: status %d", errPermanent, resp.StatusCode)
+	default:
+		return fmt.Errorf("peer responded with status %d", resp.StatusCode)
+	}
+}
+
+// FreeStorage отправляет оставшиеся пакеты в течение DrainTimeout, останавливает отправку
+// и освобождает основное хранилище.
+func (ms *ReplicatedStorage) FreeStorage() error {
+	if ms.closing != nil {
+		close(ms.closing)
+		timer := time.AfterFunc(ms.DrainTimeout, ms.cancel)
+		ms.wg.Wait()
+		timer.Stop()
+		ms.cancel()
+		ms.closing = nil
+	}
+	return ms.MetricStorage.FreeStorage()
+}
+
+// cloneMetric возвращает копию метрики с собственными значениями Delta и Value.
+func cloneMetric(mt service.Metrics) service.Metrics {
+	if mt.Delta != nil {
+		delta := *mt.Delta
+		mt.Delta = &delta
+	}
+	if mt.Value != nil {
+		value := *mt.Value
+		mt.Value = &value
+	}
+	return mt
+}
@@ -0,0 +1,243 @@
+package replication
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/dvkhr/metrix.git/internal/sign"
+	"github.com/dvkhr/metrix.git/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePeer — реплика, запоминающая принятые пакеты.
+type fakePeer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	batches  [][]service.Metrics
+	requests []*http.Request
+	status   atomic.Int32
+}
+
+func newFakePeer(t *testing.T) *fakePeer {
+	p := &fakePeer{}
+	p.status.Store(http.StatusOK)
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := int(p.status.Load()); status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+
+		var metrics []service.Metrics
+		require.NoError(t, json.Unmarshal(body, &metrics))
+		r.Header.Set("X-Body", string(body))
+
+		p.mu.Lock()
+		p.batches = append(p.batches, metrics)
+		p.requests = append(p.requests, r)
+		p.mu.Unlock()
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakePeer) received() (metrics []service.Metrics, requests []*http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, batch := range p.batches {
+		metrics = append(metrics, batch...)
+	}
+	return metrics, append([]*http.Request(nil), p.requests...)
+}
+
+func gauge(id string, v float64) service.Metrics {
+	value := service.GaugeMetricValue(v)
+	return service.Metrics{ID: id, MType: service.GaugeMetric, Value: &value}
+}
+
+func TestReplicatedStorage(t *testing.T) {
+	require.NoError(t, logging.InitTestLogger())
+	ctx := context.Background()
+
+	t.Run("Forwards signed batches to all peers", func(t *testing.T) {
+		peers := []*fakePeer{newFakePeer(t), newFakePeer(t)}
+		ms := &ReplicatedStorage{
+			MetricStorage: &storage.MemStorage{},
+			Peers:         []string{peers[0].URL, peers[1].Listener.Addr().String()},
+			SignKey:       []byte("secret"),
+		}
+		require.NoError(t, ms.NewStorage())
+
+		batch := []service.Metrics{gauge("a", 1), gauge("b", 2)}
+		require.NoError(t, ms.SaveAll(ctx, &batch))
+		require.NoError(t, ms.Save(ctx, gauge("c", 3)))
+		require.NoError(t, ms.FreeStorage())
+
+		for _, p := range peers {
+			metrics, requests := p.received()
+			assert.Len(t, metrics, 3)
+			require.NotEmpty(t, requests)
+
+			req := requests[0]
+			assert.Equal(t, "/updates/", req.URL.Path)
+			assert.Equal(t, "1", req.Header.Get(Header))
+			sign := sha256.Sum256([]byte(req.Header.Get("X-Body") + ",secret"))
+			assert.Equal(t, hex.EncodeToString(sign[:]), req.Header.Get("HashSHA256"))
+		}
+
+		mt, err := ms.MetricStorage.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, service.GaugeMetricValue(1), *mt.Value)
+	})
+
+	t.Run("Does not forward replicated writes", func(t *testing.T) {
+		p := newFakePeer(t)
+		ms := &ReplicatedStorage{MetricStorage: &storage.MemStorage{}, Peers: []string{p.URL}}
+		require.NoError(t, ms.NewStorage())
+
+		batch := []service.Metrics{gauge("a", 1)}
+		require.NoError(t, ms.SaveAll(WithReplicated(ctx), &batch))
+		require.NoError(t, ms.FreeStorage())
+
+		metrics, _ := p.received()
+		assert.Empty(t, metrics)
+	})
+
+	t.Run("Retries until the peer recovers", func(t *testing.T) {
+		p := newFakePeer(t)
+		p.status.Store(http.StatusServiceUnavailable)
+		ms := &ReplicatedStorage{MetricStorage: &storage.MemStorage{}, Peers: []string{p.URL}}
+		require.NoError(t, ms.NewStorage())
+
+		require.NoError(t, ms.Save(ctx, gauge("a", 1)))
+		time.Sleep(150 * time.Millisecond)
+		assert.Greater(t, ms.peers[0].failures.Value(), uint64(0))
+		assert.Greater(t, ms.peers[0].lag.Value(), 0.0)
+
+		p.status.Store(http.StatusOK)
+		require.NoError(t, ms.FreeStorage())
+
+		metrics, _ := p.received()
+		assert.Len(t, metrics, 1)
+		assert.Equal(t, 0.0, ms.peers[0].lag.Value())
+	})
+
+	t.Run("Drops batches rejected by the peer", func(t *testing.T) {
+		p := newFakePeer(t)
+		p.status.Store(http.StatusBadRequest)
+		ms := &ReplicatedStorage{MetricStorage: &storage.MemStorage{}, Peers: []string{p.URL}}
+		require.NoError(t, ms.NewStorage())
+
+		dropped := ms.peers[0].dropped.Value()
+		require.NoError(t, ms.Save(ctx, gauge("a", 1)))
+		require.NoError(t, ms.FreeStorage())
+		assert.Equal(t, dropped+1, ms.peers[0].dropped.Value())
+	})
+
+	t.Run("Gives up after the drain timeout", func(t *testing.T) {
+		p := newFakePeer(t)
+		p.status.Store(http.StatusInternalServerError)
+		ms := &ReplicatedStorage{MetricStorage: &storage.MemStorage{}, Peers: []string{p.URL}, DrainTimeout: 50 * time.Millisecond}
+		require.NoError(t, ms.NewStorage())
+
+		require.NoError(t, ms.Save(ctx, gauge("a", 1)))
+		start := time.Now()
+		require.NoError(t, ms.FreeStorage())
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("Error: No peers", func(t *testing.T) {
+		ms := &ReplicatedStorage{MetricStorage: &storage.MemStorage{}}
+		assert.ErrorIs(t, ms.NewStorage(), ErrNoPeers)
+	})
+}
+
+func TestPeerQueueOverflow(t *testing.T) {
+	p := newPeer("overflow:8080", 2)
+	assert.Equal(t, "http://overflow:8080/updates/", p.url)
+
+	dropped := p.dropped.Value()
+	for i := 0; i < 3; i++ {
+		p.enqueue(pending{metrics: []service.Metrics{gauge("g", float64(i))}})
+	}
+	assert.Equal(t, dropped+1, p.dropped.Value())
+	assert.Equal(t, 2.0, p.queueLen.Value())
+
+	first := <-p.queue
+	assert.Equal(t, service.GaugeMetricValue(1), *first.metrics[0].Value)
+}
+
+func TestMiddleware(t *testing.T) {
+	var replicated bool
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		replicated = IsReplicated(r.Context())
+	}
+	request := func(header, hash string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("[]"))
+		if header != "" {
+			req.Header.Set(Header, header)
+		}
+		if hash != "" {
+			req.Header.Set("HashSHA256", hash)
+		}
+		return req
+	}
+	signature := sha256.Sum256([]byte("[],secret"))
+	signed := hex.EncodeToString(signature[:])
+
+	t.Run("Signed replica batch", func(t *testing.T) {
+		h := sign.SignCheck(Middleware(handler), []byte("secret"))
+		h(httptest.NewRecorder(), request("", signed))
+		assert.False(t, replicated)
+		h(httptest.NewRecorder(), request("1", signed))
+		assert.True(t, replicated)
+	})
+
+	t.Run("Unsigned header is ignored", func(t *testing.T) {
+		h := sign.SignCheck(Middleware(handler), []byte("secret"))
+		h(httptest.NewRecorder(), request("1", ""))
+		assert.False(t, replicated)
+
+		// Без ключа подпись не проверяется, и заголовку доверять нельзя.
+		h = sign.SignCheck(Middleware(handler), nil)
+		h(httptest.NewRecorder(), request("1", signed))
+		assert.False(t, replicated)
+	})
+}
+
+func TestReplicateWrapper(t *testing.T) {
+	ms, err := storage.Open("memory://", storage.Options{
+		SignKey:  []byte("key"),
+		Wrappers: []string{"replicate?peer=a:8080&peer=b:8080&queue=10&timeout=1s"},
+	})
+	require.NoError(t, err)
+	require.IsType(t, &ReplicatedStorage{}, ms)
+
+	rs := ms.(*ReplicatedStorage)
+	assert.Equal(t, []string{"a:8080", "b:8080"}, rs.Peers)
+	assert.Equal(t, 10, rs.QueueSize)
+	assert.Equal(t, time.Second, rs.Timeout)
+	assert.Equal(t, []byte("key"), rs.SignKey)
+
+	_, err = storage.Open("memory://", storage.Options{Wrappers: []string{"replicate"}})
+	assert.ErrorIs(t, err, ErrNoPeers)
+	_, err = storage.Open("memory://", storage.Options{Wrappers: []string{"replicate?peer=a:8080&queue=0"}})
+	assert.ErrorIs(t, err, storage.ErrInvalidStorageParam)
+}
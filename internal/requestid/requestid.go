@@ -0,0 +1,130 @@
+// Package requestid предоставляет идентификаторы запросов и разбор контекста трассировки W3C.
+//
+// Идентификатор запроса передается агентом в заголовках X-Request-ID и traceparent,
+// генерируется сервером при их отсутствии, хранится в context.Context и попадает
+// во все записи журнала, сделанные в рамках запроса.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Header — заголовок HTTP с идентификатором запроса.
+	Header = "X-Request-ID"
+
+	// TraceparentHeader — заголовок W3C Trace Context.
+	TraceparentHeader = "traceparent"
+
+	// maxIDLength ограничивает длину идентификатора, принятого от клиента.
+	maxIDLength = 128
+)
+
+// ctxKey — тип ключа контекста, недоступный другим пакетам.
+type ctxKey struct{}
+
+// New генерирует новый случайный идентификатор запроса.
+// Формат совпадает с trace-id W3C: 32 шестнадцатеричных символа.
+func New() string {
+	return randomHex(16)
+}
+
+// NewTraceparent формирует значение заголовка traceparent для указанного trace-id
+// со случайным parent-id и установленным флагом sampled.
+func NewTraceparent(traceID string) string {
+	return "00-" + traceID + "-" + randomHex(8) + "-01"
+}
+
+// ParseTraceparent разбирает значение заголовка traceparent (версия 00).
+// Возвращает trace-id и parent-id; ok равен false, если значение некорректно.
+func ParseTraceparent(value string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return "", "", false
+	}
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return "", "", false
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// WithID возвращает копию контекста с идентификатором запроса.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext возвращает идентификатор запроса из контекста или пустую строку.
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Middleware определяет идентификатор запроса, сохраняет его в контексте запроса
+// и возвращает клиенту в заголовке X-Request-ID.
+//
+// Порядок выбора идентификатора:
+// 1. Заголовок X-Request-ID, если он задан и корректен.
+// 2. trace-id из заголовка traceparent.
+// 3. Новый случайный идентификатор.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !isValidID(id) {
+			id = ""
+		}
+		if id == "" {
+			if traceID, _, ok := ParseTraceparent(r.Header.Get(TraceparentHeader)); ok {
+				id = traceID
+			}
+		}
+		if id == "" {
+			id = New()
+		}
+
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(WithID(r.Context(), id)))
+	})
+}
+
+// isValidID проверяет, что идентификатор от клиента не пустой, не слишком длинный
+// и состоит только из видимых ASCII-символов.
+func isValidID(id string) bool {
+	if len(id) == 0 || len(id) > maxIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
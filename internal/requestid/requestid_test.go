@@ -0,0 +1,86 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		traceID string
+		ok      bool
+	}{
+		{
+			name:    "Valid",
+			value:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			ok:      true,
+		},
+		{name: "Unknown version", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "Zero trace id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "Upper case hex", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "Too few parts", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-01"},
+		{name: "Empty", value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traceID, _, ok := ParseTraceparent(tt.value)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.traceID, traceID)
+		})
+	}
+
+	t.Run("Round trip", func(t *testing.T) {
+		id := New()
+		traceID, _, ok := ParseTraceparent(NewTraceparent(id))
+		assert.True(t, ok)
+		assert.Equal(t, id, traceID)
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+	}))
+
+	t.Run("Uses X-Request-ID header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(Header, "agent-42")
+		req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		res := httptest.NewRecorder()
+
+		h.ServeHTTP(res, req)
+
+		assert.Equal(t, "agent-42", seen)
+		assert.Equal(t, "agent-42", res.Header().Get(Header))
+	})
+
+	t.Run("Falls back to traceparent", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		res := httptest.NewRecorder()
+
+		h.ServeHTTP(res, req)
+
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", seen)
+		assert.Equal(t, seen, res.Header().Get(Header))
+	})
+
+	t.Run("Generates id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(Header, strings.Repeat("x", maxIDLength+1))
+		res := httptest.NewRecorder()
+
+		h.ServeHTTP(res, req)
+
+		assert.Len(t, seen, 32)
+		assert.Equal(t, seen, res.Header().Get(Header))
+	})
+}
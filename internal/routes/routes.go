@@ -4,10 +4,16 @@ package routes
 import (
 	"net/http"
 
+	"github.com/dvkhr/metrix.git/internal/auth"
 	"github.com/dvkhr/metrix.git/internal/config"
+	"github.com/dvkhr/metrix.git/internal/dashboard"
 	"github.com/dvkhr/metrix.git/internal/gzip"
 	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/replication"
+	"github.com/dvkhr/metrix.git/internal/requestid"
+	"github.com/dvkhr/metrix.git/internal/selfmetrics"
 	"github.com/dvkhr/metrix.git/internal/sign"
+	"github.com/dvkhr/metrix.git/internal/tracing"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -23,6 +29,18 @@ type MetricServer interface {
 	NotfoundMetricRq(w http.ResponseWriter, r *http.Request)
 	HandlePutGaugeMetric(w http.ResponseWriter, r *http.Request)
 	HandlePutCounterMetric(w http.ResponseWriter, r *http.Request)
+	HandleDeleteMetric(w http.ResponseWriter, r *http.Request)
+	HandleDeleteMetrics(w http.ResponseWriter, r *http.Request)
+	HandleResetCounter(w http.ResponseWriter, r *http.Request)
+	HandleResetCounters(w http.ResponseWriter, r *http.Request)
+	HandleReplaceMetric(w http.ResponseWriter, r *http.Request)
+	HandleOpenAPI(w http.ResponseWriter, r *http.Request)
+	HandleListMetricsV2(w http.ResponseWriter, r *http.Request)
+	HandleGetMetricV2(w http.ResponseWriter, r *http.Request)
+	HandleUpsertMetricsV2(w http.ResponseWriter, r *http.Request)
+	HandleDeleteMetricV2(w http.ResponseWriter, r *http.Request)
+	HandleStream(w http.ResponseWriter, r *http.Request)
+	HandleAlerts(w http.ResponseWriter, r *http.Request)
 }
 
 // SetupRoutes настраивает маршруты HTTP-сервера для обработки запросов метрик.
@@ -35,33 +53,73 @@ type MetricServer interface {
 //
 // Логика работы:
 // 1. Создается новый маршрутизатор chi.
-// 2. Добавляется middleware для логирования всех запросов.
+// 2. Добавляются middleware для идентификации, трассировки, логирования запросов и метрик самонаблюдения.
 // 3. Настраиваются маршруты:
-//   - GET "/": Возвращает HTML-страницу со всеми метриками.
+//   - GET "/": Возвращает HTML-страницу со списком метрик; принимает те же параметры
+//     фильтрации, сортировки и пагинации, что и GET "/api/v2/metrics".
+//   - GET "/static/*": Скрипт и стили HTML-страницы, встроенные в исполняемый файл.
+//   - GET "/debug/metrics": Возвращает метрики самонаблюдения сервера в формате Prometheus.
 //   - GET "/value/{type}/{name}": Получает значение метрики по её типу и имени.
 //   - GET "/ping": Проверяет подключение к базе данных.
+//   - GET "/alerts": Состояние оповещений (inactive, pending, firing, resolved); параметр state фильтрует по состоянию.
+//   - GET "/stream": Поток обновлений метрик (Server-Sent Events) с фильтрами type, prefix, glob и regex.
 //   - POST "/value/": Извлекает метрику из JSON-тела запроса и помещает ее в хранилище.
 //   - POST "/updates/": Обновляет метрики пакетно с возможностью проверки подписи.
+//     С параметром partial=true сохраняет только корректные метрики и возвращает отчет об отклоненных.
 //   - POST "/update/*": Обрабатывает некорректные запросы на обновление метрик.
 //   - POST "/update/gauge/{name}/{value}": Обновляет метрику типа "gauge".
 //   - POST "/update/counter/{name}/{value}": Обновляет метрику типа "counter".
+//   - PUT "/value/": Заменяет метрику из JSON-тела запроса, в том числе со сменой типа.
+//   - DELETE "/value/{type}/{name}": Удаляет метрику.
+//   - DELETE "/value/?pattern=...": Удаляет метрики, имена которых соответствуют шаблону.
+//   - POST "/reset/counter/{name}": Обнуляет счетчик, не удаляя его.
+//   - POST "/reset/?pattern=...": Обнуляет счетчики, имена которых соответствуют шаблону.
+//   - "/api/v2": REST API версии 2 (описание — GET "/api/v2/openapi.json"):
+//     GET "/metrics" — список с фильтрами, сортировкой и пагинацией, POST "/metrics" — пакетная запись,
+//     GET "/metrics/{name}" — метрика, DELETE "/metrics/{name}" — удаление.
 //
-// 4. Для некоторых маршрутов применяется middleware GzipMiddleware для сжатия ответов.
-// 5. Для маршрута "/updates/" также применяется middleware SignCheck для проверки подписи запроса.
+// 4. Для некоторых маршрутов применяется middleware GzipMiddleware для сжатия ответов;
+//    поток "/stream" не сжимается, чтобы события доходили до клиента сразу.
+// 5. Для маршрута "/updates/" также применяется middleware SignCheck для проверки подписи запроса
+//    и replication.Middleware, чтобы пакеты от реплик с проверенной подписью не пересылались повторно.
+// 6. Маршруты замены, удаления и обнуления (в том числе DELETE "/api/v2/metrics/{name}") доступны только с токеном администратора cfg.AdminToken
+//    (middleware auth.AdminOnly); если токен не задан, они отвечают 403 (Forbidden).
+// 7. JSON-маршруты и middleware проверки подписи и доступа возвращают ошибки в формате
+//    {code, message, details, request_id} (пакет apierror); маршруты текстового API
+//    POST "/update/{type}/{name}/{value}" и GET "/value/{type}/{name}" отвечают простым текстом.
 //
 // Возвращаемое значение:
 // - *chi.Mux: Настроенный маршрутизатор chi с определенными маршрутами.
 func SetupRoutes(r *chi.Mux, logger *logging.Logger, cfg config.ConfigServ, metricServer MetricServer) *chi.Mux {
 
 	// Middleware
+	r.Use(requestid.Middleware)
+	r.Use(tracing.Middleware)
 	r.Use(logging.LoggingMiddleware(logging.Logg))
+	r.Use(selfmetrics.Middleware(selfmetrics.Default))
 
 	// Routes
 	r.Get("/", gzip.GzipMiddleware(metricServer.HandleGetAllMetrics))
+	r.Get("/static/*", gzip.GzipMiddleware(http.StripPrefix("/static/", dashboard.Static()).ServeHTTP))
+	r.Get("/debug/metrics", selfmetrics.Handler(selfmetrics.Default))
 	r.Get("/value/{type}/{name}", metricServer.HandleGetMetric)
 	r.Get("/ping", metricServer.CheckDBConnect)
+	r.Get("/stream", metricServer.HandleStream)
+	r.Get("/alerts", gzip.GzipMiddleware(metricServer.HandleAlerts))
 	r.Post("/value/", gzip.GzipMiddleware(metricServer.ExtractMetric))
-	r.Post("/updates/", gzip.GzipMiddleware(sign.SignCheck(metricServer.UpdateBatch, []byte(cfg.Key))))
+	r.Post("/updates/", gzip.GzipMiddleware(sign.SignCheck(replication.Middleware(metricServer.UpdateBatch), []byte(cfg.Key))))
+	r.Put("/value/", auth.AdminOnly(metricServer.HandleReplaceMetric, cfg.AdminToken))
+	r.Delete("/value/", auth.AdminOnly(metricServer.HandleDeleteMetrics, cfg.AdminToken))
+	r.Delete("/value/{type}/{name}", auth.AdminOnly(metricServer.HandleDeleteMetric, cfg.AdminToken))
+	r.Post("/reset/", auth.AdminOnly(metricServer.HandleResetCounters, cfg.AdminToken))
+	r.Post("/reset/counter/{name}", auth.AdminOnly(metricServer.HandleResetCounter, cfg.AdminToken))
+	r.Route("/api/v2", func(r chi.Router) {
+		r.Get("/openapi.json", metricServer.HandleOpenAPI)
+		r.Get("/metrics", gzip.GzipMiddleware(metricServer.HandleListMetricsV2))
+		r.Post("/metrics", gzip.GzipMiddleware(sign.SignCheck(metricServer.HandleUpsertMetricsV2, []byte(cfg.Key))))
+		r.Get("/metrics/{name}", metricServer.HandleGetMetricV2)
+		r.Delete("/metrics/{name}", auth.AdminOnly(metricServer.HandleDeleteMetricV2, cfg.AdminToken))
+	})
 	r.Route("/update", func(r chi.Router) {
 		r.Post("/", gzip.GzipMiddleware(metricServer.UpdateMetric))
 		r.Post("/*", metricServer.IncorrectMetricRq)
@@ -0,0 +1,89 @@
+package selfmetrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// statusRecorder — обертка для ResponseWriter, которая запоминает HTTP-статус ответа.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+// WriteHeader перехватывает вызов WriteHeader для записи статуса.
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	if sr.statusCode == 0 {
+		sr.statusCode = statusCode
+	}
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write фиксирует статус 200, если обработчик не вызвал WriteHeader явно.
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.statusCode == 0 {
+		sr.statusCode = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(p)
+}
+
+// Unwrap возвращает исходный ResponseWriter для http.ResponseController.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// Middleware создает middleware, которое учитывает в реестре количество запросов,
+// их длительность и число одновременно обрабатываемых запросов.
+//
+// В качестве метки route используется шаблон маршрута chi (например, "/value/{type}/{name}"),
+// а не фактический путь, чтобы имена метрик не порождали неограниченное число серий.
+func Middleware(reg *Registry) func(http.Handler) http.Handler {
+	inFlight := reg.Gauge("metrix_http_requests_in_flight", "Number of HTTP requests currently being served.")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			inFlight.Add(1)
+			defer inFlight.Add(-1)
+
+			sr := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(sr, r)
+
+			if sr.statusCode == 0 {
+				sr.statusCode = http.StatusOK
+			}
+			route := routePattern(r)
+
+			reg.Counter("metrix_http_requests_total", "Total number of HTTP requests.",
+				"method", r.Method, "route", route, "code", strconv.Itoa(sr.statusCode)).Inc()
+			reg.Histogram("metrix_http_request_duration_seconds", "HTTP request handling latency in seconds.",
+				DurationBuckets, "method", r.Method, "route", route).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// routePattern возвращает шаблон маршрута chi, совпавший с запросом.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return "unmatched"
+}
+
+// Handler возвращает обработчик, отдающий содержимое реестра в текстовом формате Prometheus.
+func Handler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET requests are allowed!", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		reg.WritePrometheus(w)
+	}
+}
@@ -0,0 +1,281 @@
+// Package selfmetrics предоставляет внутренний реестр метрик самонаблюдения сервера.
+//
+// Метрики реестра (частота запросов, задержки обработчиков, размеры пакетов,
+// ошибки расшифровки и проверки подписи, задержки хранилища) хранятся отдельно
+// от пользовательских метрик в MetricStorage и отдаются в текстовом формате Prometheus.
+package selfmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Типы семейств метрик в терминах формата Prometheus.
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+var (
+	// DurationBuckets — границы корзин гистограмм длительности в секундах.
+	DurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	// SizeBuckets — границы корзин гистограмм размеров (например, количества метрик в пакете).
+	SizeBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000}
+)
+
+// Default — реестр по умолчанию, в который пишут обработчики и middleware сервера.
+var Default = NewRegistry()
+
+// Registry хранит семейства метрик самонаблюдения.
+// Все методы безопасны для конкурентного использования.
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*family
+}
+
+// family — семейство метрик с общим именем, типом и описанием.
+type family struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+	series  map[string]any
+}
+
+// NewRegistry создает пустой реестр метрик.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Counter возвращает монотонный счетчик с указанным именем и набором меток.
+// Метки передаются парами ключ-значение. Повторный вызов с теми же меткой и именем
+// возвращает тот же счетчик.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return r.series(name, help, kindCounter, nil, labels, func() any { return &Counter{} }).(*Counter)
+}
+
+// Gauge возвращает метрику-значение с указанным именем и набором меток.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return r.series(name, help, kindGauge, nil, labels, func() any { return &Gauge{} }).(*Gauge)
+}
+
+// Histogram возвращает гистограмму с указанными границами корзин и набором меток.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return r.series(name, help, kindHistogram, buckets, labels, func() any {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	}).(*Histogram)
+}
+
+// series находит или создает серию внутри семейства.
+func (r *Registry) series(name, help, kind string, buckets []float64, labels []string, create func() any) any {
+	key := formatLabels(labels)
+
+	r.mu.RLock()
+	f, ok := r.families[name]
+	if ok {
+		if s, ok := f.series[key]; ok {
+			r.mu.RUnlock()
+			return s
+		}
+	}
+	r.mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok = r.families[name]
+	if !ok {
+		f = &family{name: name, help: help, kind: kind, buckets: buckets, series: make(map[string]any)}
+		r.families[name] = f
+	}
+	if f.kind != kind {
+		panic(fmt.Sprintf("selfmetrics: metric %q registered as %s, requested as %s", name, f.kind, kind))
+	}
+	s, ok := f.series[key]
+	if !ok {
+		s = create()
+		f.series[key] = s
+	}
+	return s
+}
+
+// WritePrometheus записывает все метрики реестра в текстовом формате Prometheus.
+// Семейства и серии выводятся в отсортированном порядке.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	r.mu.RLock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			switch s := f.series[key].(type) {
+			case *Counter:
+				fmt.Fprintf(bw, "%s%s %d\n", f.name, wrapLabels(key), s.Value())
+			case *Gauge:
+				fmt.Fprintf(bw, "%s%s %s\n", f.name, wrapLabels(key), formatFloat(s.Value()))
+			case *Histogram:
+				s.write(bw, f.name, key)
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	return bw.Flush()
+}
+
+// Counter — монотонно возрастающий счетчик.
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Inc увеличивает счетчик на единицу.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add увеличивает счетчик на n.
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value возвращает текущее значение счетчика.
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// Gauge — метрика, значение которой может как расти, так и уменьшаться.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+// Set устанавливает значение метрики.
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// Add прибавляет к значению метрики v (v может быть отрицательным).
+func (g *Gauge) Add(v float64) {
+	for {
+		old := g.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if g.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// Value возвращает текущее значение метрики.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// Histogram — гистограмма распределения наблюдаемых значений.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe добавляет наблюдение в гистограмму.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Count возвращает общее количество наблюдений.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// write выводит гистограмму в текстовом формате Prometheus с накопительными корзинами.
+func (h *Histogram) write(w io.Writer, name, key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(key, `le="`+formatFloat(bound)+`"`)), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(key, `le="+Inf"`)), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, wrapLabels(key), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, wrapLabels(key), h.count)
+}
+
+// formatLabels преобразует пары ключ-значение в строку меток вида k1="v1",k2="v2".
+// Пары сортируются по ключу, чтобы порядок передачи не влиял на идентичность серии.
+func formatLabels(labels []string) string {
+	if len(labels)%2 != 0 {
+		panic("selfmetrics: labels must be key/value pairs")
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escapeLabelValue(labels[i+1])+`"`)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// escapeLabelValue экранирует значение метки по правилам формата Prometheus.
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,100 @@
+package selfmetrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryCounter(t *testing.T) {
+	t.Run("Same labels return same series", func(t *testing.T) {
+		reg := NewRegistry()
+		a := reg.Counter("requests_total", "help", "code", "200", "method", "GET")
+		b := reg.Counter("requests_total", "help", "method", "GET", "code", "200")
+		assert.Same(t, a, b)
+	})
+
+	t.Run("Concurrent increments", func(t *testing.T) {
+		reg := NewRegistry()
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					reg.Counter("hits_total", "help").Inc()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, uint64(5000), reg.Counter("hits_total", "help").Value())
+	})
+
+	t.Run("Kind mismatch panics", func(t *testing.T) {
+		reg := NewRegistry()
+		reg.Counter("metric", "help")
+		assert.Panics(t, func() { reg.Gauge("metric", "help") })
+	})
+}
+
+func TestRegistryWritePrometheus(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("metrix_test_total", "Test counter.", "route", `/a"b`).Add(3)
+	reg.Gauge("metrix_test_gauge", "Test gauge.").Set(1.5)
+	h := reg.Histogram("metrix_test_seconds", "Test histogram.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	var buf bytes.Buffer
+	require.NoError(t, reg.WritePrometheus(&buf))
+
+	expected := `# HELP metrix_test_gauge Test gauge.
+# TYPE metrix_test_gauge gauge
+metrix_test_gauge 1.5
+# HELP metrix_test_seconds Test histogram.
+# TYPE metrix_test_seconds histogram
+metrix_test_seconds_bucket{le="0.1"} 1
+metrix_test_seconds_bucket{le="1"} 2
+metrix_test_seconds_bucket{le="+Inf"} 3
+metrix_test_seconds_sum 5.55
+metrix_test_seconds_count 3
+# HELP metrix_test_total Test counter.
+# TYPE metrix_test_total counter
+metrix_test_total{route="/a\"b"} 3
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestMiddleware(t *testing.T) {
+	reg := NewRegistry()
+
+	r := chi.NewRouter()
+	r.Use(Middleware(reg))
+	r.Get("/value/{type}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Get("/debug/metrics", Handler(reg))
+
+	for i := 0; i < 2; i++ {
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/value/gauge/x", nil))
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	}
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/metrics", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.True(t, strings.HasPrefix(res.Header().Get("Content-Type"), "text/plain"))
+
+	body := res.Body.String()
+	assert.Contains(t, body, `metrix_http_requests_total{code="404",method="GET",route="/value/{type}/{name}"} 2`)
+	assert.Contains(t, body, `metrix_http_request_duration_seconds_count{method="GET",route="/value/{type}/{name}"} 2`)
+}
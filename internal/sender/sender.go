@@ -16,12 +16,13 @@ import (
 
 	"github.com/dvkhr/metrix.git/internal/crypto"
 	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/requestid"
 	"github.com/dvkhr/metrix.git/internal/storage"
 )
 
 // SendOptions содержит параметры для отправки метрик.
 type SendOptions struct {
-	MemStorage    storage.MemStorage
+	MemStorage    *storage.MemStorage
 	Client        *http.Client
 	ServerAddress string
 	SignKey       []byte
@@ -56,10 +57,18 @@ func SendMetrics(ctx context.Context, options SendOptions) error {
 		gz.Write([]byte(encryptedData))
 		gz.Close()
 
-		req, err := http.NewRequest("POST", buildAllMetricsURL(options.ServerAddress), &requestBody)
+		// Идентификатор пакета совпадает с trace-id, чтобы строки журнала агента
+		// и сервера можно было сопоставить по любому из заголовков
+		requestID := requestid.New()
+		ctx = requestid.WithID(ctx, requestID)
+
+		req, err := http.NewRequestWithContext(ctx, "POST", buildAllMetricsURL(options.ServerAddress), &requestBody)
 		if err != nil {
-			fmt.Println(err)
+			return err
 		}
+		req.Header.Set(requestid.Header, requestID)
+		req.Header.Set(requestid.TraceparentHeader, requestid.NewTraceparent(requestID))
+		logging.Logg.InfoContext(ctx, "Sending metrics batch", "count", len(allMetrics))
 
 		if len(options.SignKey) > 0 {
 			signBuf := jsonMetric
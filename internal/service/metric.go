@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"os"
 	"runtime"
+	"time"
 
 	//----
 	"github.com/dvkhr/metrix.git/internal/logging"
@@ -51,6 +52,37 @@ type Metrics struct {
 	// Value — значение метрики в случае, если тип метрики — gauge.
 	// Может быть nil, если метрика имеет тип counter.
 	Value *GaugeMetricValue `json:"value,omitempty"`
+
+	// UpdatedAt — время последнего изменения метрики. Заполняется хранилищем при каждой
+	// записи; значение, переданное клиентом, игнорируется. Может быть nil для метрик,
+	// сохраненных до появления этого поля.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Float возвращает значение метрики числом: Value для gauge, накопленное Delta для counter.
+// ok равен false, если значение, соответствующее типу, не задано.
+func (m Metrics) Float() (value float64, ok bool) {
+	switch {
+	case m.MType == GaugeMetric && m.Value != nil:
+		return float64(*m.Value), true
+	case m.MType == CounterMetric && m.Delta != nil:
+		return float64(*m.Delta), true
+	default:
+		return 0, false
+	}
+}
+
+// FormatValue возвращает значение метрики в текстовом виде, как в GET /value/{type}/{name};
+// счетчик выводится целым числом без потери точности. Для метрики без значения
+// возвращает пустую строку.
+func (m Metrics) FormatValue() string {
+	if _, ok := m.Float(); !ok {
+		return ""
+	}
+	if m.MType == CounterMetric {
+		return fmt.Sprint(*m.Delta)
+	}
+	return fmt.Sprint(*m.Value)
 }
 
 var (
@@ -62,6 +94,10 @@ var (
 
 	// ErrUnknownMetric возвращается, если запрашиваемая метрика не найдена в хранилище.
 	ErrUnknownMetric = errors.New("unknown metric")
+
+	// ErrTypeConflict возвращается, если метрика с таким именем уже существует и имеет
+	// другой тип. Хранилища оборачивают ее, добавляя имя метрики; проверять через errors.Is.
+	ErrTypeConflict = errors.New("metric type conflict")
 )
 
 type MetricStorage interface {
@@ -100,18 +136,26 @@ func DumpMetrics(ms MetricStorage, wr io.Writer) error {
 	return err
 }
 
+// MetricReplacer — хранилище, которое атомарно записывает метрику вместо сохраненной
+// с тем же именем, в том числе другого типа (см. storage.MetricStorage.Replace).
+type MetricReplacer interface {
+	Replace(ctx context.Context, mt Metrics) error
+}
+
 // RestoreMetrics восстанавливает метрики из данных, прочитанных из указанного reader.
 //
 // Функция используется для импорта состояния метрик из JSON-данных в хранилище.
+// Каждая метрика записывается через Replace: восстановленное значение заменяет сохраненное
+// вместе с типом, поэтому повторное восстановление того же снимка не удваивает счетчики.
 //
 // Параметры:
-// - ms: Интерфейс MetricStorage, предоставляющий доступ к хранилищу метрик.
+// - ms: Хранилище, поддерживающее замену метрик.
 // - rd: Reader, откуда будут считаны сериализованные метрики (например, файл или HTTP-запрос).
 //
 // Возвращаемое значение:
-//   - error: Ошибка, если произошла проблема при чтении, получении состояния хранилища или десериализации данных.
+//   - error: Ошибка, если произошла проблема при чтении, десериализации данных или записи метрики.
 //     Если операция выполнена успешно, возвращается nil.
-func RestoreMetrics(ms MetricStorage, rd io.Reader) error {
+func RestoreMetrics(ms MetricReplacer, rd io.Reader) error {
 	ctx := context.TODO()
 	var data []byte
 
@@ -120,13 +164,16 @@ func RestoreMetrics(ms MetricStorage, rd io.Reader) error {
 		return err
 	}
 
-	stor, err := ms.List(ctx)
-	if err != nil {
+	var mtrx map[string]Metrics
+	if err = json.Unmarshal(data, &mtrx); err != nil {
 		return err
 	}
-	err = json.Unmarshal(data, stor)
-
-	return err
+	for _, metric := range mtrx {
+		if err = ms.Replace(ctx, metric); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // CollectMetricsOS собирает метрики операционной системы и отправляет их в канал.
@@ -3,15 +3,40 @@ package sign
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"io"
 	"net/http"
+
+	"github.com/dvkhr/metrix.git/internal/apierror"
+	"github.com/dvkhr/metrix.git/internal/selfmetrics"
+	"github.com/dvkhr/metrix.git/internal/tracing"
 )
 
+// errInvalidSignature отмечает спан проверки подписи, если подпись не совпала.
+var errInvalidSignature = errors.New("invalid HashSHA256 signature")
+
+// signatureFailures учитывает запросы, отклоненные из-за неверной подписи.
+var signatureFailures = selfmetrics.Default.Counter("metrix_signature_failures_total",
+	"Number of requests rejected because of an invalid HashSHA256 signature.")
+
+type verifiedKey struct{}
+
+// Verified сообщает, что подпись запроса с контекстом ctx проверена SignCheck.
+// Запросы без подписи и запросы к серверу без ключа подписанными не считаются.
+func Verified(ctx context.Context) bool {
+	verified, _ := ctx.Value(verifiedKey{}).(bool)
+	return verified
+}
+
 // SignCheck создает middleware для проверки подписи HTTP-запроса.
 // Middleware проверяет подпись запроса с использованием ключа (signKey).
 // Если ключ пустой, проверка пропускается, и запрос передается дальше.
+// Если подпись не совпала, возвращается 400 (Bad Request) с кодом signature_error
+// в формате JSON API (см. пакет apierror). Контекст запроса с совпавшей подписью
+// помечается (см. Verified).
 //
 // Параметры:
 // - h: Обработчик HTTP-запроса, который будет вызван после проверки подписи.
@@ -26,9 +51,11 @@ func SignCheck(h http.HandlerFunc, signKey []byte) http.HandlerFunc {
 			return
 		}
 
+		_, span := tracing.Start(r.Context(), "sign.check")
 		tempBuf, err := readRequestBody(r)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			tracing.End(span, err)
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeDecode, "failed to read request body", nil)
 			return
 		}
 
@@ -38,10 +65,14 @@ func SignCheck(h http.HandlerFunc, signKey []byte) http.HandlerFunc {
 		if len(agentSignStr) > 0 {
 			signatureValid := validateSignature(tempBuf.Bytes(), agentSignStr, signKey)
 			if !signatureValid {
-				w.WriteHeader(http.StatusBadRequest)
+				signatureFailures.Inc()
+				tracing.End(span, errInvalidSignature)
+				apierror.Write(w, r, http.StatusBadRequest, apierror.CodeSignature, errInvalidSignature.Error(), nil)
 				return
 			}
+			r = r.WithContext(context.WithValue(r.Context(), verifiedKey{}, true))
 		}
+		span.End()
 
 		h.ServeHTTP(w, r)
 	})
@@ -0,0 +1,441 @@
+// Package storage предоставляет реализации хранилищ метрик для различных типов данных.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/selfmetrics"
+	"github.com/dvkhr/metrix.git/internal/service"
+)
+
+// ErrListenUnsupported возвращается, если для инвалидации кэша запрошена подписка,
+// а обернутое хранилище не реализует Listener.
+var ErrListenUnsupported = errors.New("storage does not support change notifications")
+
+// cacheListenRetryDelay — пауза перед повторной подпиской после разрыва соединения.
+const cacheListenRetryDelay = time.Second
+
+// Счетчики попаданий и промахов кэша по всем экземплярам CachedStorage.
+var (
+	cacheHits = map[string]*selfmetrics.Counter{
+		"get":  selfmetrics.Default.Counter("metrix_storage_cache_hits_total", "Number of storage reads served from the cache.", "operation", "get"),
+		"list": selfmetrics.Default.Counter("metrix_storage_cache_hits_total", "Number of storage reads served from the cache.", "operation", "list"),
+	}
+	cacheMisses = map[string]*selfmetrics.Counter{
+		"get":  selfmetrics.Default.Counter("metrix_storage_cache_misses_total", "Number of storage reads that went to the underlying storage.", "operation", "get"),
+		"list": selfmetrics.Default.Counter("metrix_storage_cache_misses_total", "Number of storage reads that went to the underlying storage.", "operation", "list"),
+	}
+)
+
+func init() {
+	RegisterWrapper("cache", func(inner MetricStorage, params url.Values, opts Options) (MetricStorage, error) {
+		ttl, err := durationParam(params, "ttl", 0)
+		if err != nil {
+			return nil, err
+		}
+		subscribe, err := boolParam(params, "listen", false)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := inner.(Listener); subscribe && !ok {
+			return nil, fmt.Errorf("%w: %T", ErrListenUnsupported, inner)
+		}
+		return &CachedStorage{MetricStorage: inner, TTL: ttl, Subscribe: subscribe}, nil
+	})
+}
+
+// cacheEntry — закэшированная метрика и момент, после которого она считается устаревшей.
+type cacheEntry struct {
+	metric  service.Metrics
+	expires time.Time
+}
+
+// CachedStorage — кэш последних значений метрик перед другим хранилищем (read-through).
+//
+// Чтения (Get, List, ListSlice) обслуживаются из памяти, а при промахе идут в обернутое
+// хранилище и сохраняют результат. Записи выполняются в обернутом хранилище и сразу
+// применяются к кэшу: gauge заменяется, счетчик увеличивается на приращение. Если запись
+// не удалась, затронутые метрики удаляются из кэша. Записи одной метрики выполняются
+// по очереди вместе с обновлением кэша, поэтому кэш применяет их в том же порядке,
+// что и обернутое хранилище. Выборка ListPage обслуживается
+// из памяти, только если в кэше загружен полный список метрик.
+//
+// Кэш видит только записи, сделанные через него. Если с одной базой работают несколько
+// серверов, нужно включить Subscribe: тогда изменения, опубликованные любым сервером
+// (см. Listener), удаляют метрики из кэша. TTL ограничивает время жизни записей,
+// если уведомления недоступны.
+//
+// Поля:
+//   - MetricStorage: Обернутое хранилище.
+//   - TTL: Время жизни закэшированных значений (0 — без ограничения).
+//   - Subscribe: Инвалидировать кэш по уведомлениям обернутого хранилища (требует Listener).
+type CachedStorage struct {
+	MetricStorage
+	TTL       time.Duration
+	Subscribe bool
+
+	mu      sync.RWMutex
+	entries map[string]cacheEntry
+	// complete — в entries загружены все метрики хранилища (после ListSlice);
+	// listExpires — момент устаревания полного списка.
+	complete    bool
+	listExpires time.Time
+	// gen увеличивается при каждом изменении; результат промаха сохраняется, только если
+	// за время чтения из хранилища изменений не было, иначе он мог устареть.
+	gen uint64
+
+	// writeMu упорядочивает записи метрик одного сегмента (см. shardIndex) от вызова
+	// обернутого хранилища до обновления кэша.
+	writeMu [shardCount]sync.Mutex
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func (ms *CachedStorage) NewStorage() error {
+	if err := ms.MetricStorage.NewStorage(); err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	ms.entries = make(map[string]cacheEntry)
+	ms.complete = false
+	ms.mu.Unlock()
+
+	if ms.Subscribe {
+		listener, ok := ms.MetricStorage.(Listener)
+		if !ok {
+			return fmt.Errorf("%w: %T", ErrListenUnsupported, ms.MetricStorage)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		ms.stop = cancel
+		ms.done = make(chan struct{})
+		go ms.listenLoop(ctx, listener)
+	}
+	return nil
+}
+
+// listenLoop держит подписку на изменения и переподписывается после ошибок.
+// Перед каждой подпиской кэш очищается: уведомления, пришедшие во время разрыва, потеряны.
+func (ms *CachedStorage) listenLoop(ctx context.Context, listener Listener) {
+	defer close(ms.done)
+	for {
+		ms.invalidate(nil)
+		err := listener.Listen(ctx, ms.invalidate)
+		if ctx.Err() != nil {
+			return
+		}
+		logging.Logg.Warn("Cache invalidation subscription lost, resubscribing", "error", err)
+
+		select {
+		case <-time.After(cacheListenRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// invalidate удаляет из кэша метрики ids; nil очищает кэш полностью.
+func (ms *CachedStorage) invalidate(ids []string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.gen++
+	ms.complete = false
+	if ids == nil {
+		ms.entries = make(map[string]cacheEntry)
+		return
+	}
+	for _, id := range ids {
+		delete(ms.entries, id)
+	}
+}
+
+// Stats возвращает число попаданий и промахов кэша этого хранилища.
+func (ms *CachedStorage) Stats() (hits, misses uint64) {
+	return ms.hits.Load(), ms.misses.Load()
+}
+
+func (ms *CachedStorage) hit(operation string) {
+	ms.hits.Add(1)
+	cacheHits[operation].Inc()
+}
+
+func (ms *CachedStorage) miss(operation string) {
+	ms.misses.Add(1)
+	cacheMisses[operation].Inc()
+}
+
+// expiry возвращает момент устаревания записи, созданной сейчас.
+func (ms *CachedStorage) expiry() time.Time {
+	if ms.TTL <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ms.TTL)
+}
+
+func expired(expires time.Time) bool {
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+// apply применяет успешно записанную метрику к кэшу; вызывающий должен удерживать ms.mu.
+// Счетчик обновляется, только если его текущее значение известно кэшу; иначе метрика
+// удаляется, и следующее чтение возьмет итоговое значение из хранилища. Временем изменения
+// считается now — оно может незначительно отличаться от времени, записанного хранилищем.
+func (ms *CachedStorage) apply(mt service.Metrics, now time.Time) {
+	old, cached := ms.entries[mt.ID]
+	if cached && expired(old.expires) {
+		delete(ms.entries, mt.ID)
+		cached = false
+	}
+
+	switch {
+	case mt.MType == service.GaugeMetric && mt.Value != nil:
+		ms.entries[mt.ID] = cacheEntry{metric: stamp(cloneMetric(mt), now), expires: ms.expiry()}
+	case mt.MType == service.CounterMetric && mt.Delta != nil && cached &&
+		old.metric.MType == service.CounterMetric && old.metric.Delta != nil:
+		sum := *old.metric.Delta + *mt.Delta
+		old.metric.Delta = &sum
+		old.metric = stamp(old.metric, now)
+		ms.entries[mt.ID] = old
+	case mt.MType == service.CounterMetric && mt.Delta != nil && !cached && ms.complete:
+		// Полный список загружен, значит метрики в хранилище не было.
+		ms.entries[mt.ID] = cacheEntry{metric: stamp(cloneMetric(mt), now), expires: ms.expiry()}
+	default:
+		delete(ms.entries, mt.ID)
+		ms.complete = false
+	}
+}
+
+// afterWrite обновляет кэш после записи metrics в обернутое хранилище.
+func (ms *CachedStorage) afterWrite(metrics []service.Metrics, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.entries == nil {
+		return
+	}
+	ms.gen++
+	now := time.Now()
+	for _, mt := range metrics {
+		if err != nil {
+			delete(ms.entries, mt.ID)
+			ms.complete = false
+			continue
+		}
+		ms.apply(mt, now)
+	}
+}
+
+// lockWrites блокирует сегменты записи метрик ids в порядке номеров, чтобы конкурентные
+// пакеты не взаимоблокировались, и возвращает функцию разблокировки.
+func (ms *CachedStorage) lockWrites(ids []string) func() {
+	var locked [shardCount]bool
+	for _, id := range ids {
+		locked[shardIndex(id)] = true
+	}
+	for i := range ms.writeMu {
+		if locked[i] {
+			ms.writeMu[i].Lock()
+		}
+	}
+	return func() {
+		for i := range ms.writeMu {
+			if locked[i] {
+				ms.writeMu[i].Unlock()
+			}
+		}
+	}
+}
+
+// metricIDs возвращает имена метрик пакета.
+func metricIDs(metrics []service.Metrics) []string {
+	ids := make([]string, len(metrics))
+	for i, mt := range metrics {
+		ids[i] = mt.ID
+	}
+	return ids
+}
+
+func (ms *CachedStorage) Save(ctx context.Context, mt service.Metrics) error {
+	defer ms.lockWrites([]string{mt.ID})()
+	err := ms.MetricStorage.Save(ctx, mt)
+	ms.afterWrite([]service.Metrics{mt}, err)
+	return err
+}
+
+func (ms *CachedStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
+	defer ms.lockWrites(metricIDs(*mt))()
+	err := ms.MetricStorage.SaveAll(ctx, mt)
+	ms.afterWrite(*mt, err)
+	return err
+}
+
+// Delete удаляет метрики из хранилища и из кэша.
+func (ms *CachedStorage) Delete(ctx context.Context, metricNames ...string) (int, error) {
+	defer ms.lockWrites(metricNames)()
+	n, err := ms.MetricStorage.Delete(ctx, metricNames...)
+	ms.afterModify(metricNames)
+	return n, err
+}
+
+// DeleteWhere удаляет метрики, удовлетворяющие f, из хранилища и из кэша.
+func (ms *CachedStorage) DeleteWhere(ctx context.Context, f DeleteFilter, metricNames ...string) (int, error) {
+	defer ms.lockWrites(metricNames)()
+	n, err := ms.MetricStorage.DeleteWhere(ctx, f, metricNames...)
+	ms.afterModify(metricNames)
+	return n, err
+}
+
+// Reset обнуляет счетчики в хранилище и удаляет их из кэша.
+func (ms *CachedStorage) Reset(ctx context.Context, metricNames ...string) (int, error) {
+	defer ms.lockWrites(metricNames)()
+	n, err := ms.MetricStorage.Reset(ctx, metricNames...)
+	ms.afterModify(metricNames)
+	return n, err
+}
+
+// Replace заменяет метрику в хранилище и удаляет ее из кэша.
+func (ms *CachedStorage) Replace(ctx context.Context, mt service.Metrics) error {
+	defer ms.lockWrites([]string{mt.ID})()
+	err := ms.MetricStorage.Replace(ctx, mt)
+	ms.afterModify([]string{mt.ID})
+	return err
+}
+
+// afterModify удаляет из кэша метрики, затронутые удалением, обнулением или заменой;
+// следующее чтение возьмет их итоговое состояние из хранилища.
+func (ms *CachedStorage) afterModify(names []string) {
+	if len(names) > 0 {
+		ms.invalidate(names)
+	}
+}
+
+func (ms *CachedStorage) Get(ctx context.Context, metricName string) (*service.Metrics, error) {
+	ms.mu.RLock()
+	if ms.entries == nil {
+		ms.mu.RUnlock()
+		return ms.MetricStorage.Get(ctx, metricName)
+	}
+	entry, ok := ms.entries[metricName]
+	gen := ms.gen
+	ms.mu.RUnlock()
+
+	if ok && !expired(entry.expires) {
+		ms.hit("get")
+		mt := cloneMetric(entry.metric)
+		return &mt, nil
+	}
+	ms.miss("get")
+
+	mt, err := ms.MetricStorage.Get(ctx, metricName)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	if ms.gen == gen && ms.entries != nil {
+		ms.entries[metricName] = cacheEntry{metric: cloneMetric(*mt), expires: ms.expiry()}
+	}
+	ms.mu.Unlock()
+	return mt, nil
+}
+
+func (ms *CachedStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
+	ms.mu.RLock()
+	if ms.entries == nil {
+		ms.mu.RUnlock()
+		return ms.MetricStorage.ListSlice(ctx)
+	}
+	if ms.complete && !expired(ms.listExpires) {
+		metrics := make([]service.Metrics, 0, len(ms.entries))
+		for _, entry := range ms.entries {
+			metrics = append(metrics, cloneMetric(entry.metric))
+		}
+		ms.mu.RUnlock()
+
+		ms.hit("list")
+		sort.Slice(metrics, func(i, j int) bool { return metrics[i].ID < metrics[j].ID })
+		return metrics, nil
+	}
+	gen := ms.gen
+	ms.mu.RUnlock()
+	ms.miss("list")
+
+	metrics, err := ms.MetricStorage.ListSlice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	if ms.gen == gen && ms.entries != nil {
+		expires := ms.expiry()
+		ms.entries = make(map[string]cacheEntry, len(metrics))
+		for _, mt := range metrics {
+			ms.entries[mt.ID] = cacheEntry{metric: cloneMetric(mt), expires: expires}
+		}
+		ms.complete = true
+		ms.listExpires = expires
+	}
+	ms.mu.Unlock()
+	return metrics, nil
+}
+
+// ListPage выполняет выборку по кэшу, если в нем есть полный список метрик, иначе
+// передает запрос обернутому хранилищу. Результат выборки в кэш не записывается:
+// страница не дает полного списка.
+func (ms *CachedStorage) ListPage(ctx context.Context, q ListQuery) (*Page, error) {
+	ms.mu.RLock()
+	cached := ms.entries != nil && ms.complete && !expired(ms.listExpires)
+	enabled := ms.entries != nil
+	ms.mu.RUnlock()
+
+	if !cached {
+		if enabled {
+			ms.miss("list")
+		}
+		return ms.MetricStorage.ListPage(ctx, q)
+	}
+	metrics, err := ms.ListSlice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return listPage(metrics, q)
+}
+
+func (ms *CachedStorage) List(ctx context.Context) (*map[string]service.Metrics, error) {
+	metrics, err := ms.ListSlice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mtrx := make(map[string]service.Metrics, len(metrics))
+	for _, metric := range metrics {
+		mtrx[metric.ID] = metric
+	}
+	return &mtrx, nil
+}
+
+func (ms *CachedStorage) FreeStorage() error {
+	if ms.stop != nil {
+		ms.stop()
+		<-ms.done
+		ms.stop = nil
+	}
+
+	ms.mu.Lock()
+	ms.entries = nil
+	ms.mu.Unlock()
+	return ms.MetricStorage.FreeStorage()
+}
@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ Listener = (*DBStorage)(nil)
+	_ Listener = (*PgxStorage)(nil)
+)
+
+// spyStorage считает обращения к обернутому хранилищу и умеет имитировать ошибку записи
+// и уведомления об изменениях.
+type spyStorage struct {
+	MemStorage
+	gets, lists int
+	failWrites  bool
+	// saved вызывается после записи метрики в хранилище, до возврата из Save.
+	saved func(mt service.Metrics)
+	// listed вызывается после чтения метрик, до возврата из ListSlice.
+	listed func()
+
+	mu      sync.Mutex
+	handler func(ids []string)
+	ready   chan struct{}
+}
+
+func (ms *spyStorage) Get(ctx context.Context, metricName string) (*service.Metrics, error) {
+	ms.gets++
+	return ms.MemStorage.Get(ctx, metricName)
+}
+
+func (ms *spyStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
+	ms.lists++
+	metrics, err := ms.MemStorage.ListSlice(ctx)
+	if ms.listed != nil {
+		ms.listed()
+	}
+	return metrics, err
+}
+
+func (ms *spyStorage) Save(ctx context.Context, mt service.Metrics) error {
+	if ms.failWrites {
+		return errors.New("write failed")
+	}
+	err := ms.MemStorage.Save(ctx, mt)
+	if ms.saved != nil {
+		ms.saved(mt)
+	}
+	return err
+}
+
+func (ms *spyStorage) Listen(ctx context.Context, handler func(ids []string)) error {
+	ms.mu.Lock()
+	ms.handler = handler
+	ms.mu.Unlock()
+	close(ms.ready)
+	<-ctx.Done()
+	return nil
+}
+
+func (ms *spyStorage) notify(ids []string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.handler(ids)
+}
+
+func gauge(id string, v float64) service.Metrics {
+	value := service.GaugeMetricValue(v)
+	return service.Metrics{ID: id, MType: service.GaugeMetric, Value: &value}
+}
+
+func counter(id string, d int64) service.Metrics {
+	delta := service.CounterMetricValue(d)
+	return service.Metrics{ID: id, MType: service.CounterMetric, Delta: &delta}
+}
+
+func TestCachedStorage(t *testing.T) {
+	require.NoError(t, logging.InitTestLogger())
+	ctx := context.Background()
+
+	newCache := func(t *testing.T, ttl time.Duration) (*CachedStorage, *spyStorage) {
+		inner := &spyStorage{}
+		cache := &CachedStorage{MetricStorage: inner, TTL: ttl}
+		require.NoError(t, cache.NewStorage())
+		t.Cleanup(func() { cache.FreeStorage() })
+		return cache, inner
+	}
+
+	t.Run("Read-through Get", func(t *testing.T) {
+		cache, inner := newCache(t, 0)
+		require.NoError(t, inner.MemStorage.Save(ctx, gauge("g", 1)))
+
+		for i := 0; i < 3; i++ {
+			mt, err := cache.Get(ctx, "g")
+			require.NoError(t, err)
+			assert.Equal(t, service.GaugeMetricValue(1), *mt.Value)
+		}
+		assert.Equal(t, 1, inner.gets)
+		hits, misses := cache.Stats()
+		assert.Equal(t, uint64(2), hits)
+		assert.Equal(t, uint64(1), misses)
+
+		_, err := cache.Get(ctx, "missing")
+		assert.Equal(t, service.ErrUnknownMetric, err)
+	})
+
+	t.Run("Delete and reset invalidate entries", func(t *testing.T) {
+		cache, _ := newCache(t, 0)
+		require.NoError(t, cache.Save(ctx, gauge("g", 1)))
+		require.NoError(t, cache.Save(ctx, counter("c", 4)))
+		_, err := cache.ListSlice(ctx)
+		require.NoError(t, err)
+
+		n, err := cache.Reset(ctx, "c")
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		mt, err := cache.Get(ctx, "c")
+		require.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(0), *mt.Delta)
+
+		n, err = cache.Delete(ctx, "g")
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		_, err = cache.Get(ctx, "g")
+		assert.Equal(t, service.ErrUnknownMetric, err)
+		all, err := cache.ListSlice(ctx)
+		require.NoError(t, err)
+		assert.Len(t, all, 1)
+	})
+
+	t.Run("Writes update cached values", func(t *testing.T) {
+		cache, inner := newCache(t, 0)
+		require.NoError(t, cache.Save(ctx, counter("c", 2)))
+		require.NoError(t, cache.Save(ctx, gauge("g", 1)))
+
+		// Счетчик еще не читался, поэтому первое чтение идет в хранилище.
+		mt, err := cache.Get(ctx, "c")
+		require.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(2), *mt.Delta)
+
+		batch := []service.Metrics{counter("c", 3), counter("c", 5), gauge("g", 7)}
+		require.NoError(t, cache.SaveAll(ctx, &batch))
+
+		mt, err = cache.Get(ctx, "c")
+		require.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(10), *mt.Delta)
+		mt, err = cache.Get(ctx, "g")
+		require.NoError(t, err)
+		assert.Equal(t, service.GaugeMetricValue(7), *mt.Value)
+		assert.Equal(t, 1, inner.gets)
+	})
+
+	t.Run("List is cached and kept complete on writes", func(t *testing.T) {
+		cache, inner := newCache(t, 0)
+		require.NoError(t, cache.Save(ctx, gauge("g", 1)))
+
+		all, err := cache.ListSlice(ctx)
+		require.NoError(t, err)
+		assert.Len(t, all, 1)
+
+		require.NoError(t, cache.Save(ctx, counter("new", 4)))
+		all, err = cache.ListSlice(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		assert.Equal(t, "g", all[0].ID)
+		assert.Equal(t, service.CounterMetricValue(4), *all[1].Delta)
+
+		mtrx, err := cache.List(ctx)
+		require.NoError(t, err)
+		assert.Len(t, *mtrx, 2)
+		assert.Equal(t, 1, inner.lists)
+	})
+
+	t.Run("Cached values are copies", func(t *testing.T) {
+		cache, _ := newCache(t, 0)
+		require.NoError(t, cache.Save(ctx, gauge("g", 1)))
+
+		mt, err := cache.Get(ctx, "g")
+		require.NoError(t, err)
+		*mt.Value = 100
+
+		mt, err = cache.Get(ctx, "g")
+		require.NoError(t, err)
+		assert.Equal(t, service.GaugeMetricValue(1), *mt.Value)
+	})
+
+	t.Run("Failed write invalidates", func(t *testing.T) {
+		cache, inner := newCache(t, 0)
+		require.NoError(t, cache.Save(ctx, gauge("g", 1)))
+
+		inner.failWrites = true
+		assert.Error(t, cache.Save(ctx, gauge("g", 2)))
+
+		mt, err := cache.Get(ctx, "g")
+		require.NoError(t, err)
+		assert.Equal(t, service.GaugeMetricValue(1), *mt.Value)
+		assert.Equal(t, 1, inner.gets)
+	})
+
+	t.Run("Concurrent writes keep storage order", func(t *testing.T) {
+		cache, inner := newCache(t, 0)
+		entered, release := make(chan struct{}), make(chan struct{})
+		inner.saved = func(mt service.Metrics) {
+			if *mt.Value == 1 {
+				close(entered)
+				<-release
+			}
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, cache.Save(ctx, gauge("g", 1)))
+		}()
+		<-entered
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, cache.Save(ctx, gauge("g", 2)))
+		}()
+		// Вторая запись не должна обогнать первую между хранилищем и кэшем.
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		stored, err := inner.MemStorage.Get(ctx, "g")
+		require.NoError(t, err)
+		mt, err := cache.Get(ctx, "g")
+		require.NoError(t, err)
+		assert.Equal(t, *stored.Value, *mt.Value)
+	})
+
+	t.Run("TTL expires entries", func(t *testing.T) {
+		cache, inner := newCache(t, 20*time.Millisecond)
+		require.NoError(t, cache.Save(ctx, gauge("g", 1)))
+
+		_, err := cache.Get(ctx, "g")
+		require.NoError(t, err)
+		assert.Equal(t, 0, inner.gets)
+
+		time.Sleep(30 * time.Millisecond)
+		_, err = cache.Get(ctx, "g")
+		require.NoError(t, err)
+		assert.Equal(t, 1, inner.gets)
+	})
+
+	t.Run("Notifications invalidate", func(t *testing.T) {
+		inner := &spyStorage{ready: make(chan struct{})}
+		cache := &CachedStorage{MetricStorage: inner, Subscribe: true}
+		require.NoError(t, cache.NewStorage())
+		defer cache.FreeStorage()
+		<-inner.ready
+
+		require.NoError(t, cache.Save(ctx, gauge("a", 1)))
+		require.NoError(t, cache.Save(ctx, gauge("b", 1)))
+		// Другой сервер изменил метрику в общем хранилище.
+		require.NoError(t, inner.MemStorage.Save(ctx, gauge("a", 2)))
+
+		inner.notify([]string{"a"})
+		mt, err := cache.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, service.GaugeMetricValue(2), *mt.Value)
+		_, err = cache.Get(ctx, "b")
+		require.NoError(t, err)
+		assert.Equal(t, 1, inner.gets)
+
+		inner.notify(nil)
+		_, err = cache.Get(ctx, "b")
+		require.NoError(t, err)
+		assert.Equal(t, 2, inner.gets)
+	})
+
+	t.Run("Error: Subscribe without listener", func(t *testing.T) {
+		cache := &CachedStorage{MetricStorage: &MemStorage{}, Subscribe: true}
+		assert.ErrorIs(t, cache.NewStorage(), ErrListenUnsupported)
+	})
+}
+
+func TestCacheWrapper(t *testing.T) {
+	ms, err := Open("memory://", Options{Wrappers: []string{"cache?ttl=1s"}})
+	require.NoError(t, err)
+	require.IsType(t, &CachedStorage{}, ms)
+	assert.Equal(t, time.Second, ms.(*CachedStorage).TTL)
+	assert.IsType(t, &MemStorage{}, ms.(*CachedStorage).MetricStorage)
+
+	_, err = Open("memory://", Options{Wrappers: []string{"cache?listen=true"}})
+	assert.ErrorIs(t, err, ErrListenUnsupported)
+
+	_, err = Open("memory://", Options{Wrappers: []string{"cache?ttl=forever"}})
+	assert.ErrorIs(t, err, ErrInvalidStorageParam)
+}
@@ -4,28 +4,39 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/dvkhr/metrix.git/internal/logging"
 	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/dvkhr/metrix.git/internal/tracing"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib" // Регистрирует драйвер pgx для database/sql
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type DB interface {
-	Ping() error
-	Exec(query string, args ...interface{}) (sql.Result, error)
+	PingContext(ctx context.Context) error
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
-	Prepare(query string) (*sql.Stmt, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	Conn(ctx context.Context) (*sql.Conn, error)
 	Close() error
 }
 
 type Stmt interface {
-	Exec(args ...interface{}) (sql.Result, error)
-	QueryRow(args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, args ...interface{}) (*sql.Rows, error)
 }
 
+// DBStorage хранит метрики в PostgreSQL в таблице metrix с типизированными столбцами
+// (mtype, delta, value, updated_at). Конкурентные записи не требуют блокировок
+// на стороне сервера: счетчики увеличиваются одним upsert, который выполняется
+// под блокировкой строки в базе данных.
 type DBStorage struct {
 	DBDSN           string
 	db              DB
@@ -36,6 +47,7 @@ type DBStorage struct {
 }
 
 func (ms *DBStorage) NewStorage() error {
+	ctx := context.Background()
 
 	if ms.db == nil {
 		var err error
@@ -44,56 +56,58 @@ func (ms *DBStorage) NewStorage() error {
 		}
 	}
 
-	err := ms.retry(func() error {
-		return ms.db.Ping()
+	err := retry(ctx, "ping", func() error {
+		return ms.db.PingContext(ctx)
 	}, 3)
 	if err != nil {
 		return err
 	}
 
-	createStmt := "create table if not exists metrix (id varchar(32) PRIMARY KEY, value jsonb not null)"
-	err = ms.retry(func() error {
-		_, err := ms.db.Exec(createStmt)
+	migrator, err := NewPostgresMigrator(ms.db)
+	if err != nil {
+		return err
+	}
+	err = retry(ctx, "migrate", func() error {
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			logging.Logg.Info("Applied schema migration", "version", m.Version, "name", m.Name)
+		}
 		return err
 	}, 3)
 	if err != nil {
 		return err
 	}
 
-	saveGaugeQuery := "insert into metrix values($1::varchar, jsonb_build_object('id', $1::varchar, 'type', $2::varchar, 'value', $3::double precision)) on conflict(id) do update set value = jsonb_build_object('id', $1::varchar, 'type', $2::varchar, 'value', $3::double precision) where metrix.id = $1::varchar;"
-	err = ms.retry(func() error {
+	err = retry(ctx, "prepare", func() error {
 		var err error
-		ms.saveGaugeStmt, err = ms.db.Prepare(saveGaugeQuery)
+		ms.saveGaugeStmt, err = ms.db.PrepareContext(ctx, saveGaugeQuery)
 		return err
 	}, 3)
 	if err != nil {
 		return err
 	}
 
-	saveCounterQuery := "insert into metrix values($1::varchar, jsonb_build_object('id', $1::varchar, 'type', $2::varchar, 'delta', $3::bigint)) on conflict(id) do update set value = jsonb_set(metrix.value, '{delta}', ((metrix.value ->> 'delta')::bigint + $3::bigint)::text::jsonb, false) where metrix.id = $1::varchar;"
-	err = ms.retry(func() error {
+	err = retry(ctx, "prepare", func() error {
 		var err error
-		ms.saveCounterStmt, err = ms.db.Prepare(saveCounterQuery)
+		ms.saveCounterStmt, err = ms.db.PrepareContext(ctx, saveCounterQuery)
 		return err
 	}, 3)
 	if err != nil {
 		return err
 	}
 
-	getQuery := "select value from metrix where id = $1::varchar;"
-	err = ms.retry(func() error {
+	err = retry(ctx, "prepare", func() error {
 		var err error
-		ms.getStmt, err = ms.db.Prepare(getQuery)
+		ms.getStmt, err = ms.db.PrepareContext(ctx, getQuery)
 		return err
 	}, 3)
 	if err != nil {
 		return err
 	}
 
-	listQuery := "select jsonb_object_agg(k,v) from metrix, jsonb_each(jsonb_build_object(id, value)) as t(k,v);"
-	err = ms.retry(func() error {
+	err = retry(ctx, "prepare", func() error {
 		var err error
-		ms.listStmt, err = ms.db.Prepare(listQuery)
+		ms.listStmt, err = ms.db.PrepareContext(ctx, listQuery)
 		return err
 	}, 3)
 	if err != nil {
@@ -112,23 +126,32 @@ func isPgTransportError(err error) bool {
 	return false
 }
 
-func (ms *DBStorage) retry(f func() error, maxRetries int) error {
-	var err error
+// retry выполняет операцию с базой данных, повторяя ее при транспортных ошибках Postgres.
+// Ожидание между попытками прерывается при отмене контекста.
+// Каждая операция, включая все повторы, оборачивается в спан трассировки "postgres <op>".
+func retry(ctx context.Context, op string, f func() error, maxRetries int) (err error) {
+	_, span := tracing.Start(ctx, "postgres "+op, attribute.String("db.system", "postgresql"), attribute.String("db.operation", op))
+	defer func() { tracing.End(span, err) }()
+
 	for i := 0; i < maxRetries; i++ {
 		err = f()
-		if err == nil || !isPgTransportError(err) {
+		if err == nil || !isPgTransportError(err) || i == maxRetries-1 {
 			return err
 		}
-		logging.Logg.Error("Postgres retry after error %v\n", err)
+		logging.Logg.ErrorContext(ctx, "Postgres retry after error", "error", err)
 
-		time.Sleep(time.Duration(2*i+1) * time.Second)
+		select {
+		case <-time.After(time.Duration(2*i+1) * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	return err
 }
 
 func (ms *DBStorage) Save(ctx context.Context, mt service.Metrics) error {
-	err := ms.retry(func() error {
-		err := ms.db.Ping()
+	err := retry(ctx, "ping", func() error {
+		err := ms.db.PingContext(ctx)
 		return err
 	}, 3)
 	if err != nil {
@@ -139,24 +162,216 @@ func (ms *DBStorage) Save(ctx context.Context, mt service.Metrics) error {
 		return service.ErrInvalidMetricName
 	}
 
-	if mt.MType == service.GaugeMetric {
+	var stmt Stmt
+	var arg any
+	switch {
+	case mt.MType == service.GaugeMetric && mt.Value != nil:
+		stmt, arg = ms.saveGaugeStmt, float64(*mt.Value)
+	case mt.MType == service.CounterMetric && mt.Delta != nil:
+		stmt, arg = ms.saveCounterStmt, int64(*mt.Delta)
+	default:
+		return service.ErrInvalidMetricName
+	}
 
-		if _, err := ms.saveGaugeStmt.Exec(mt.ID, mt.MType, mt.Value); err != nil {
-			return err
-		}
-	} else if mt.MType == service.CounterMetric {
-		if _, err := ms.saveCounterStmt.Exec(mt.ID, mt.MType, mt.Delta); err != nil {
+	var affected int64
+	err = retry(ctx, "save_"+string(mt.MType), func() error {
+		res, err := stmt.ExecContext(ctx, mt.ID, mt.MType, arg)
+		if err != nil {
 			return err
 		}
-	} else {
-		return service.ErrInvalidMetricName
+		affected, err = res.RowsAffected()
+		return err
+	}, 1)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return typeConflict(mt.ID)
+	}
+
+	// Метрика уже записана, поэтому ошибка уведомления не возвращается: повтор записи
+	// учел бы приращение счетчика дважды. Подписчики получат следующее уведомление.
+	if _, err := ms.db.ExecContext(ctx, notifyQuery, MetricsNotifyChannel, notifyPayload([]string{mt.ID})); err != nil {
+		logging.Logg.WarnContext(ctx, "Failed to publish metric update notification", "error", err)
 	}
 	return nil
 }
 
+// Запросы одиночной записи и чтения метрик, общие для DBStorage и PgxStorage.
+const (
+	saveGaugeQuery   = "insert into metrix (id, mtype, value) values ($1, $2, $3) on conflict (id) do update set value = excluded.value, updated_at = now() where " + sameTypeCond + ";"
+	saveCounterQuery = "insert into metrix (id, mtype, delta) values ($1, $2, $3) on conflict (id) do update set delta = metrix.delta + excluded.delta, updated_at = now() where " + sameTypeCond + ";"
+	getQuery         = "select id, mtype, delta, value, updated_at from metrix where id = $1;"
+	listQuery        = "select id, mtype, delta, value, updated_at from metrix order by id;"
+	// conflictQuery находит метрику пакета ($1), сохраненную с типом, отличным от $2.
+	conflictQuery = "select id from metrix where id = any($1::varchar[]) and mtype <> $2 order by id limit 1;"
+)
+
+// Запросы удаления и обнуления метрик по списку id, общие для DBStorage и PgxStorage.
+// deleteQuery удаляет только метрики типа $2, если он не пуст, и обновленные раньше $3,
+// если он не NULL (см. DeleteFilter).
+const (
+	deleteQuery = "delete from metrix where id = any($1::varchar[]) and ($2::varchar = '' or mtype = $2::varchar) and ($3::timestamptz is null or updated_at < $3::timestamptz)"
+	resetQuery  = "update metrix set delta = 0, updated_at = now() where mtype = 'counter' and id = any($1::varchar[])"
+)
+
+// replaceQuery записывает метрику вместо сохраненной независимо от ее типа. Запрос один,
+// поэтому строка либо заменяется целиком, либо остается прежней.
+const replaceQuery = `insert into metrix (id, mtype, delta, value) values ($1, $2, $3, $4)
+on conflict (id) do update set mtype = excluded.mtype, delta = excluded.delta, value = excluded.value,
+labels = excluded.labels, updated_at = now()`
+
+// replaceArgs возвращает значения столбцов delta и value для replaceQuery;
+// столбец другого типа остается NULL.
+func replaceArgs(mt service.Metrics) (delta, value any) {
+	if mt.MType == service.CounterMetric {
+		return int64(*mt.Delta), nil
+	}
+	return nil, float64(*mt.Value)
+}
+
+// Запросы пакетной записи. Метрики передаются массивами и разворачиваются через unnest,
+// поэтому пакет любого размера записывается одним запросом на каждый тип метрик.
+const (
+	saveGaugeBatchQuery = `insert into metrix (id, mtype, value)
+select id, 'gauge', v
+from unnest($1::varchar[], $2::double precision[]) as t(id, v)
+on conflict (id) do update set value = excluded.value, updated_at = now() where ` + sameTypeCond
+
+	saveCounterBatchQuery = `insert into metrix (id, mtype, delta)
+select id, 'counter', d
+from unnest($1::varchar[], $2::bigint[]) as t(id, d)
+on conflict (id) do update set delta = metrix.delta + excluded.delta, updated_at = now() where ` + sameTypeCond
+)
+
+// sameTypeCond ограничивает обновление в upsert метриками того же типа. Строка другого
+// типа не изменяется и не учитывается в числе затронутых строк, по которому запись
+// распознает конфликт типов (ErrTypeConflict).
+const sameTypeCond = "metrix.mtype = excluded.mtype"
+
+// rowScanner — общий интерфейс *sql.Row и *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMetric читает метрику из строки запроса "select id, mtype, delta, value, updated_at".
+func scanMetric(row rowScanner) (service.Metrics, error) {
+	var mt service.Metrics
+	var delta sql.NullInt64
+	var value sql.NullFloat64
+	var updatedAt sql.NullTime
+	if err := row.Scan(&mt.ID, &mt.MType, &delta, &value, &updatedAt); err != nil {
+		return mt, err
+	}
+	if updatedAt.Valid {
+		mt.UpdatedAt = &updatedAt.Time
+	}
+	if delta.Valid {
+		d := service.CounterMetricValue(delta.Int64)
+		mt.Delta = &d
+	}
+	if value.Valid {
+		v := service.GaugeMetricValue(value.Float64)
+		mt.Value = &v
+	}
+	return mt, nil
+}
+
+// scanStoredMetric читает метрику из результата QueryRow запроса getQuery. Если строки нет
+// (sql.ErrNoRows), возвращается service.ErrUnknownMetric, как у остальных хранилищ.
+func scanStoredMetric(row rowScanner) (*service.Metrics, error) {
+	mt, err := scanMetric(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrUnknownMetric
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mt, nil
+}
+
+// rowsScanner — общий интерфейс *sql.Rows и pgx.Rows.
+type rowsScanner interface {
+	rowScanner
+	Next() bool
+	Err() error
+}
+
+// scanMetrics читает все строки запроса "select id, mtype, delta, value, updated_at".
+// Закрывать rows должен вызывающий.
+func scanMetrics(rows rowsScanner) ([]service.Metrics, error) {
+	metrics := make([]service.Metrics, 0)
+	for rows.Next() {
+		metric, err := scanMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, rows.Err()
+}
+
+// metricBatch — пакет метрик, разложенный по типам в массивы параметров запросов.
+type metricBatch struct {
+	gaugeIDs      []string
+	gaugeValues   []float64
+	counterIDs    []string
+	counterDeltas []int64
+}
+
+// aggregateBatch проверяет пакет (checkBatch) и объединяет повторяющиеся метрики: для gauge
+// остается последнее значение, приращения counter суммируются. Повтор одного id в одном upsert Postgres
+// не допускает. Идентификаторы сортируются, чтобы конкурентные пакеты блокировали строки
+// в одном порядке и не приводили к взаимоблокировкам.
+func aggregateBatch(metrics []service.Metrics) (metricBatch, error) {
+	if err := checkBatch(metrics, func(string) (service.MetricType, bool) { return "", false }); err != nil {
+		return metricBatch{}, err
+	}
+
+	gauges := make(map[string]float64)
+	counters := make(map[string]int64)
+	for _, metric := range metrics {
+		if metric.MType == service.GaugeMetric {
+			gauges[metric.ID] = float64(*metric.Value)
+		} else {
+			counters[metric.ID] += int64(*metric.Delta)
+		}
+	}
+
+	var batch metricBatch
+	batch.gaugeIDs = sortedKeys(gauges)
+	for _, id := range batch.gaugeIDs {
+		batch.gaugeValues = append(batch.gaugeValues, gauges[id])
+	}
+	batch.counterIDs = sortedKeys(counters)
+	for _, id := range batch.counterIDs {
+		batch.counterDeltas = append(batch.counterDeltas, counters[id])
+	}
+	return batch, nil
+}
+
+// ids возвращает id всех метрик пакета.
+func (b metricBatch) ids() []string {
+	ids := make([]string, 0, len(b.gaugeIDs)+len(b.counterIDs))
+	ids = append(ids, b.gaugeIDs...)
+	return append(ids, b.counterIDs...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SaveAll записывает пакет метрик в одной транзакции: не больше одного запроса на каждый тип.
+// Транзакция повторяется целиком при транспортных ошибках до фиксации. Ошибка фиксации
+// не повторяется, так как для счетчиков повтор мог бы учесть приращения дважды.
 func (ms *DBStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
-	err := ms.retry(func() error {
-		err := ms.db.Ping()
+	err := retry(ctx, "ping", func() error {
+		err := ms.db.PingContext(ctx)
 		return err
 	}, 3)
 	if err != nil {
@@ -167,54 +382,78 @@ func (ms *DBStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
 		return service.ErrInvalidMetricName
 	}
 
-	var pgTx *sql.Tx
-
-	err = ms.retry(func() error {
-		pgTx, err = ms.db.BeginTx(ctx, nil)
-		return err
-	}, 3)
+	batch, err := aggregateBatch(*mt)
 	if err != nil {
 		return err
 	}
-	for _, metric := range *mt {
-		if metric.MType == service.GaugeMetric {
-			err = ms.retry(func() error {
-				_, err := ms.saveGaugeStmt.Exec(metric.ID, metric.MType, metric.Value)
-				return err
-			}, 3)
-			if err != nil {
-				pgTx.Rollback()
-				return err
-			}
-		} else if metric.MType == service.CounterMetric {
-			err = ms.retry(func() error {
-				_, err := ms.saveCounterStmt.Exec(metric.ID, metric.MType, metric.Delta)
-				return err
-			}, 3)
-			if err != nil {
-				pgTx.Rollback()
-				return err
-			}
-		} else {
-			pgTx.Rollback()
-			return service.ErrInvalidMetricName
-		}
-	}
 
-	err = ms.retry(func() error {
-		pgTx.Commit()
-		return err
+	var pgTx *sql.Tx
+	err = retry(ctx, "save_batch", func() error {
+		tx, err := ms.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := execBatch(ctx, tx, batch); err != nil {
+			tx.Rollback()
+			return err
+		}
+		pgTx = tx
+		return nil
 	}, 3)
 	if err != nil {
 		return err
 	}
 
+	err = retry(ctx, "commit", func() error {
+		return pgTx.Commit()
+	}, 1)
+	if err != nil {
+		return fmt.Errorf("failed to commit metrics batch: %w", err)
+	}
+
 	return nil
 }
 
+// execBatch выполняет запросы пакетной записи внутри транзакции tx.
+// Уведомление об изменении метрик доставляется подписчикам только после фиксации.
+// Если upsert затронул не все строки, часть метрик имеет другой тип: возвращается
+// ErrTypeConflict, и вызывающий откатывает транзакцию.
+func execBatch(ctx context.Context, tx *sql.Tx, batch metricBatch) error {
+	for _, q := range []struct {
+		query string
+		mtype service.MetricType
+		ids   []string
+		args  any
+	}{
+		{saveGaugeBatchQuery, service.GaugeMetric, batch.gaugeIDs, batch.gaugeValues},
+		{saveCounterBatchQuery, service.CounterMetric, batch.counterIDs, batch.counterDeltas},
+	} {
+		if len(q.ids) == 0 {
+			continue
+		}
+		res, err := tx.ExecContext(ctx, q.query, q.ids, q.args)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected < int64(len(q.ids)) {
+			var id string
+			if err := tx.QueryRowContext(ctx, conflictQuery, q.ids, q.mtype).Scan(&id); err != nil {
+				return err
+			}
+			return typeConflict(id)
+		}
+	}
+	_, err := tx.ExecContext(ctx, notifyQuery, MetricsNotifyChannel, notifyPayload(batch.ids()))
+	return err
+}
+
 func (ms *DBStorage) Get(ctx context.Context, metricName string) (*service.Metrics, error) {
-	err := ms.retry(func() error {
-		err := ms.db.Ping()
+	err := retry(ctx, "ping", func() error {
+		err := ms.db.PingContext(ctx)
 		return err
 	}, 3)
 	if err != nil {
@@ -225,60 +464,73 @@ func (ms *DBStorage) Get(ctx context.Context, metricName string) (*service.Metri
 		return nil, service.ErrInvalidMetricName
 	}
 
-	var data []byte
-	var mtrx service.Metrics
-	err = ms.retry(func() error {
-		err := ms.getStmt.QueryRow(metricName).Scan(&data)
+	var mtrx *service.Metrics
+	err = retry(ctx, "get", func() error {
+		var err error
+		mtrx, err = scanStoredMetric(ms.getStmt.QueryRowContext(ctx, metricName))
 		return err
 	}, 3)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := json.Unmarshal(data, &mtrx); err != nil {
-		return nil, err
-	}
-
-	return &mtrx, nil
+	return mtrx, nil
 }
 
 func (ms *DBStorage) List(ctx context.Context) (*map[string]service.Metrics, error) {
-	err := ms.retry(func() error {
-		err := ms.db.Ping()
+	err := retry(ctx, "ping", func() error {
+		err := ms.db.PingContext(ctx)
 		return err
 	}, 3)
 	if err != nil {
 		return nil, err
 	}
 
-	var data []byte
-	var mtrx map[string]service.Metrics
-	err = ms.retry(func() error {
-		err := ms.listStmt.QueryRow().Scan(&data)
-		return err
-	}, 3)
+	metrics, err := ms.listMetrics(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(data) == 0 {
-		mtrx = make(map[string]service.Metrics)
-		return &mtrx, nil
+	mtrx := make(map[string]service.Metrics, len(metrics))
+	for _, metric := range metrics {
+		mtrx[metric.ID] = metric
 	}
+	return &mtrx, nil
+}
 
-	if err := json.Unmarshal(data, &mtrx); err != nil {
+// listMetrics читает все метрики, отсортированные по id.
+func (ms *DBStorage) listMetrics(ctx context.Context) ([]service.Metrics, error) {
+	var metrics []service.Metrics
+	err := retry(ctx, "list", func() error {
+		rows, err := ms.listStmt.QueryContext(ctx)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		metrics = make([]service.Metrics, 0)
+		for rows.Next() {
+			metric, err := scanMetric(rows)
+			if err != nil {
+				return err
+			}
+			metrics = append(metrics, metric)
+		}
+		return rows.Err()
+	}, 3)
+	if err != nil {
 		return nil, err
 	}
-	return &mtrx, nil
+	return metrics, nil
 }
 
 func (ms *DBStorage) FreeStorage() error {
 	return ms.db.Close()
 }
 
-func (ms *DBStorage) CheckStorage() error {
-	err := ms.retry(func() error {
-		err := ms.db.Ping()
+func (ms *DBStorage) CheckStorage(ctx context.Context) error {
+	err := retry(ctx, "ping", func() error {
+		err := ms.db.PingContext(ctx)
 		return err
 	}, 3)
 	if err != nil {
@@ -288,32 +540,157 @@ func (ms *DBStorage) CheckStorage() error {
 }
 
 func (ms *DBStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
-	err := ms.retry(func() error {
-		err := ms.db.Ping()
+	err := retry(ctx, "ping", func() error {
+		err := ms.db.PingContext(ctx)
 		return err
 	}, 3)
 	if err != nil {
 		return nil, err
 	}
 
-	var data []byte
-	var mtrx []service.Metrics
+	return ms.listMetrics(ctx)
+}
+
+// ListPage выполняет выборку одним запросом: фильтры, сортировка, курсор и размер
+// страницы передаются в SQL, так что база возвращает только метрики страницы.
+func (ms *DBStorage) ListPage(ctx context.Context, q ListQuery) (*Page, error) {
+	p, err := q.plan()
+	if err != nil {
+		return nil, err
+	}
 
-	err = ms.retry(func() error {
-		err := ms.listStmt.QueryRow().Scan(&data)
+	err = retry(ctx, "ping", func() error {
+		return ms.db.PingContext(ctx)
+	}, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	query, args := p.sql(postgresDialect)
+	var metrics []service.Metrics
+	err = retry(ctx, "list_page", func() error {
+		rows, err := ms.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		metrics, err = scanMetrics(rows)
 		return err
 	}, 3)
 	if err != nil {
 		return nil, err
 	}
+	return p.page(metrics), nil
+}
 
-	if len(data) == 0 {
-		mtrx = make([]service.Metrics, 0, len(data))
-		return mtrx, nil
+func (ms *DBStorage) Delete(ctx context.Context, metricNames ...string) (int, error) {
+	return ms.DeleteWhere(ctx, DeleteFilter{}, metricNames...)
+}
+
+func (ms *DBStorage) DeleteWhere(ctx context.Context, f DeleteFilter, metricNames ...string) (int, error) {
+	return ms.modify(ctx, "delete", deleteQuery, metricNames, string(f.MType), f.updatedBefore())
+}
+
+func (ms *DBStorage) Reset(ctx context.Context, metricNames ...string) (int, error) {
+	return ms.modify(ctx, "reset", resetQuery, metricNames)
+}
+
+// Replace записывает метрику одним upsert (replaceQuery). Запрос идемпотентен,
+// поэтому, в отличие от Save, повторяется при транспортных ошибках.
+func (ms *DBStorage) Replace(ctx context.Context, mt service.Metrics) error {
+	err := retry(ctx, "ping", func() error {
+		return ms.db.PingContext(ctx)
+	}, 3)
+	if err != nil {
+		return err
+	}
+	if err := checkReplacement(mt); err != nil {
+		return err
 	}
 
-	if err := json.Unmarshal(data, &mtrx); err != nil {
-		return nil, err
+	delta, value := replaceArgs(mt)
+	err = retry(ctx, "replace", func() error {
+		_, err := ms.db.ExecContext(ctx, replaceQuery, mt.ID, string(mt.MType), delta, value)
+		return err
+	}, 3)
+	if err != nil {
+		return err
 	}
-	return mtrx, nil
+
+	if _, err := ms.db.ExecContext(ctx, notifyQuery, MetricsNotifyChannel, notifyPayload([]string{mt.ID})); err != nil {
+		logging.Logg.WarnContext(ctx, "Failed to publish metric update notification", "error", err)
+	}
+	return nil
+}
+
+// modify выполняет query с аргументами names и args в транзакции вместе с уведомлением
+// об изменении и возвращает число затронутых строк.
+func (ms *DBStorage) modify(ctx context.Context, op, query string, names []string, args ...any) (int, error) {
+	err := retry(ctx, "ping", func() error {
+		return ms.db.PingContext(ctx)
+	}, 3)
+	if err != nil {
+		return 0, err
+	}
+
+	names, err = uniqueNames(names)
+	if err != nil || len(names) == 0 {
+		return 0, err
+	}
+
+	var pgTx *sql.Tx
+	var affected int64
+	err = retry(ctx, op, func() error {
+		tx, err := ms.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, query, append([]any{names}, args...)...)
+		if err == nil {
+			affected, err = res.RowsAffected()
+		}
+		if err == nil && affected > 0 {
+			_, err = tx.ExecContext(ctx, notifyQuery, MetricsNotifyChannel, notifyPayload(names))
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		pgTx = tx
+		return nil
+	}, 3)
+	if err != nil {
+		return 0, err
+	}
+
+	err = retry(ctx, "commit", func() error {
+		return pgTx.Commit()
+	}, 1)
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit %s: %w", op, err)
+	}
+	return int(affected), nil
+}
+
+// Listen подписывается на уведомления об изменении метрик (см. PgxStorage.Listen).
+// Метод блокируется до отмены ctx или ошибки соединения и держит одно соединение пула.
+func (ms *DBStorage) Listen(ctx context.Context, handler func(ids []string)) error {
+	if ms.db == nil {
+		return service.ErrUninitializedStorage
+	}
+
+	conn, err := ms.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pgxConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("listen requires the pgx driver, got %T", driverConn)
+		}
+		return listen(ctx, pgxConn.Conn(), handler)
+	})
 }
@@ -3,9 +3,9 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/dvkhr/metrix.git/internal/service"
 	_ "github.com/jackc/pgx/v5/stdlib" // Импортируем драйвер pgx
@@ -20,15 +20,6 @@ func TestNewStorage_Postgres_TemporaryTable(t *testing.T) {
 	require.NoError(t, err)
 	defer dbConn.Close()
 
-	createTempTable := `
-    CREATE TEMP TABLE metrix (
-        id VARCHAR(32) PRIMARY KEY,
-        value JSONB
-    );
-    `
-	_, err = dbConn.Exec(createTempTable)
-	require.NoError(t, err)
-
 	storage := &DBStorage{
 		DBDSN: dsn,
 		db:    dbConn,
@@ -37,26 +28,28 @@ func TestNewStorage_Postgres_TemporaryTable(t *testing.T) {
 	err = storage.NewStorage()
 	require.NoError(t, err)
 
-	_, err = storage.saveGaugeStmt.Exec("test_id", "gauge", 42.0)
+	_, err = storage.saveGaugeStmt.ExecContext(context.Background(), "test_id", "gauge", 42.0)
 	assert.NoError(t, err)
 
-	_, err = storage.saveCounterStmt.Exec("test_id", "counter", int64(100))
+	_, err = storage.saveCounterStmt.ExecContext(context.Background(), "test_id", "counter", int64(100))
 	assert.NoError(t, err)
 
-	_, err = storage.saveGaugeStmt.Exec("test_gauge", "gauge", 42.0)
+	_, err = storage.saveGaugeStmt.ExecContext(context.Background(), "test_gauge", "gauge", 42.0)
 	require.NoError(t, err)
 
-	var valueJSON string
-	err = storage.getStmt.QueryRow("test_gauge").Scan(&valueJSON)
+	data, err := scanMetric(storage.getStmt.QueryRowContext(context.Background(), "test_gauge"))
 	assert.NoError(t, err)
 
-	var data map[string]interface{}
-	err = json.Unmarshal([]byte(valueJSON), &data)
-	assert.NoError(t, err)
+	assert.Equal(t, "test_gauge", data.ID)
+	assert.Equal(t, service.GaugeMetric, data.MType)
+	assert.Equal(t, service.GaugeMetricValue(42.0), *data.Value)
+	assert.Nil(t, data.Delta)
 
-	assert.Equal(t, "test_gauge", data["id"])
-	assert.Equal(t, "gauge", data["type"])
-	assert.Equal(t, 42.0, data["value"])
+	counter, err := scanMetric(storage.getStmt.QueryRowContext(context.Background(), "test_id"))
+	assert.NoError(t, err)
+	assert.Equal(t, service.CounterMetric, counter.MType)
+	assert.Equal(t, service.CounterMetricValue(100), *counter.Delta)
+	assert.Nil(t, counter.Value)
 }
 
 func TestNewStorage_Postgres_ErrorHandling(t *testing.T) {
@@ -70,6 +63,69 @@ func TestNewStorage_Postgres_ErrorHandling(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestAggregateBatch(t *testing.T) {
+	g1, g2 := service.GaugeMetricValue(1.5), service.GaugeMetricValue(2.5)
+	c1, c2 := service.CounterMetricValue(10), service.CounterMetricValue(5)
+
+	t.Run("Duplicates are merged", func(t *testing.T) {
+		batch, err := aggregateBatch([]service.Metrics{
+			{ID: "g", MType: service.GaugeMetric, Value: &g1},
+			{ID: "c", MType: service.CounterMetric, Delta: &c1},
+			{ID: "b", MType: service.CounterMetric, Delta: &c2},
+			{ID: "g", MType: service.GaugeMetric, Value: &g2},
+			{ID: "c", MType: service.CounterMetric, Delta: &c2},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"g"}, batch.gaugeIDs)
+		assert.Equal(t, []float64{2.5}, batch.gaugeValues)
+		assert.Equal(t, []string{"b", "c"}, batch.counterIDs)
+		assert.Equal(t, []int64{5, 15}, batch.counterDeltas)
+	})
+
+	t.Run("Error: Invalid metric type", func(t *testing.T) {
+		_, err := aggregateBatch([]service.Metrics{{ID: "x", MType: "unknown"}})
+		assert.Equal(t, service.ErrInvalidMetricName, err)
+	})
+
+	t.Run("Error: Missing value", func(t *testing.T) {
+		_, err := aggregateBatch([]service.Metrics{{ID: "g", MType: service.GaugeMetric}})
+		assert.Equal(t, service.ErrInvalidMetricName, err)
+	})
+}
+
+func TestSaveAll_Postgres_Duplicates(t *testing.T) {
+	t.Skip("Skipping this test for CI")
+	dsn := "host=localhost port=5432 user=postgres password=postgres dbname=praktikum sslmode=disable"
+
+	storage := &DBStorage{DBDSN: dsn}
+	require.NoError(t, storage.NewStorage())
+	defer storage.FreeStorage()
+
+	ctx := context.Background()
+	_, err := storage.db.ExecContext(ctx, "delete from metrix where id in ('batch_gauge', 'batch_counter')")
+	require.NoError(t, err)
+
+	g1, g2 := service.GaugeMetricValue(1), service.GaugeMetricValue(2)
+	delta := service.CounterMetricValue(3)
+	metrics := []service.Metrics{
+		{ID: "batch_gauge", MType: service.GaugeMetric, Value: &g1},
+		{ID: "batch_counter", MType: service.CounterMetric, Delta: &delta},
+		{ID: "batch_gauge", MType: service.GaugeMetric, Value: &g2},
+		{ID: "batch_counter", MType: service.CounterMetric, Delta: &delta},
+	}
+	require.NoError(t, storage.SaveAll(ctx, &metrics))
+	require.NoError(t, storage.SaveAll(ctx, &metrics))
+
+	gauge, err := storage.Get(ctx, "batch_gauge")
+	require.NoError(t, err)
+	assert.Equal(t, g2, *gauge.Value)
+
+	counter, err := storage.Get(ctx, "batch_counter")
+	require.NoError(t, err)
+	assert.Equal(t, service.CounterMetricValue(12), *counter.Delta)
+}
+
 func BenchmarkSave(b *testing.B) {
 
 	b.Skip("Skipping this test for CI")
@@ -137,3 +193,79 @@ func BenchmarkSaveAll(b *testing.B) {
 		}
 	}
 }
+
+func TestPostgresMigrations(t *testing.T) {
+	migrations, err := PostgresMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, int64(i+1), m.Version, "migration versions must be contiguous")
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down, "migration %d_%s must be reversible", m.Version, m.Name)
+	}
+}
+
+// fakeRow подставляет значения столбцов в Scan так же, как database/sql.
+type fakeRow []any
+
+func (r fakeRow) Scan(dest ...any) error {
+	for i, d := range dest {
+		switch p := d.(type) {
+		case *string:
+			*p = r[i].(string)
+		case *service.MetricType:
+			*p = service.MetricType(r[i].(string))
+		case *sql.NullInt64:
+			if err := p.Scan(r[i]); err != nil {
+				return err
+			}
+		case *sql.NullFloat64:
+			if err := p.Scan(r[i]); err != nil {
+				return err
+			}
+		case *sql.NullTime:
+			if err := p.Scan(r[i]); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected destination %T", d)
+		}
+	}
+	return nil
+}
+
+func TestScanMetric(t *testing.T) {
+	t.Run("Gauge", func(t *testing.T) {
+		updatedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		mt, err := scanMetric(fakeRow{"g", "gauge", nil, 1.25, updatedAt})
+		require.NoError(t, err)
+		assert.Equal(t, service.GaugeMetric, mt.MType)
+		assert.Equal(t, service.GaugeMetricValue(1.25), *mt.Value)
+		assert.Nil(t, mt.Delta)
+		require.NotNil(t, mt.UpdatedAt)
+		assert.Equal(t, updatedAt, *mt.UpdatedAt)
+	})
+
+	t.Run("Counter", func(t *testing.T) {
+		mt, err := scanMetric(fakeRow{"c", "counter", int64(7), nil, nil})
+		require.NoError(t, err)
+		assert.Equal(t, service.CounterMetric, mt.MType)
+		assert.Equal(t, service.CounterMetricValue(7), *mt.Delta)
+		assert.Nil(t, mt.Value)
+		assert.Nil(t, mt.UpdatedAt)
+	})
+
+	t.Run("Missing row", func(t *testing.T) {
+		_, err := scanStoredMetric(errRow{sql.ErrNoRows})
+		assert.ErrorIs(t, err, service.ErrUnknownMetric)
+
+		_, err = scanStoredMetric(errRow{sql.ErrConnDone})
+		assert.ErrorIs(t, err, sql.ErrConnDone)
+	})
+}
+
+// errRow — строка запроса, Scan которой возвращает ошибку, как *sql.Row без результата.
+type errRow struct{ err error }
+
+func (r errRow) Scan(...any) error { return r.err }
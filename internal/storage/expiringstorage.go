@@ -0,0 +1,230 @@
+// Package storage предоставляет реализации хранилищ метрик для различных типов данных.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/selfmetrics"
+	"github.com/dvkhr/metrix.git/internal/service"
+)
+
+// Значения по умолчанию для ExpiringStorage.
+const (
+	// MaxJanitorInterval — наибольший период очистки по умолчанию; при меньшем TTL
+	// очистка выполняется раз в TTL.
+	MaxJanitorInterval = time.Minute
+	// DefaultJanitorTimeout ограничивает время одного прохода очистки.
+	DefaultJanitorTimeout = 30 * time.Second
+)
+
+// expiredPurged учитывает метрики, удаленные очисткой устаревших значений.
+var expiredPurged = selfmetrics.Default.Counter("metrix_storage_expired_purged_total",
+	"Number of stale metrics deleted by the expiration janitor.")
+
+func init() {
+	RegisterWrapper("expire", func(inner MetricStorage, params url.Values, opts Options) (MetricStorage, error) {
+		ttl, err := durationParam(params, "after", 0)
+		if err != nil {
+			return nil, err
+		}
+		if ttl <= 0 {
+			return nil, fmt.Errorf("%w: expire requires after=<duration>", ErrInvalidStorageParam)
+		}
+		purge, err := boolParam(params, "purge", false)
+		if err != nil {
+			return nil, err
+		}
+		interval, err := durationParam(params, "interval", 0)
+		if err != nil {
+			return nil, err
+		}
+		return &ExpiringStorage{MetricStorage: inner, TTL: ttl, Purge: purge, Interval: interval}, nil
+	})
+}
+
+// ExpiringStorage скрывает метрики, которые не обновлялись дольше TTL: Get возвращает
+// для них ErrUnknownMetric, а List и ListSlice их пропускают. Если включен Purge,
+// фоновая очистка периодически удаляет такие метрики из обернутого хранилища.
+//
+// Без Purge устаревшая метрика остается в хранилище и снова становится видимой после
+// следующей записи (счетчик продолжит накопленное значение). С Purge счетчик после
+// удаления начинается заново. Метрики без времени изменения (сохраненные прежними
+// версиями) не считаются устаревшими.
+//
+// Очистка сначала читает список метрик, а затем удаляет устаревшие, поэтому метрика,
+// обновленная между этими шагами, тоже будет удалена.
+//
+// Поля:
+//   - MetricStorage: Обернутое хранилище.
+//   - TTL: Время, после которого метрика без обновлений считается устаревшей.
+//   - Purge: Удалять устаревшие метрики из хранилища.
+//   - Interval: Период очистки (0 — TTL, но не реже раза в MaxJanitorInterval).
+type ExpiringStorage struct {
+	MetricStorage
+	TTL      time.Duration
+	Purge    bool
+	Interval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func (ms *ExpiringStorage) NewStorage() error {
+	if err := ms.MetricStorage.NewStorage(); err != nil {
+		return err
+	}
+	if ms.Purge {
+		ms.stop = make(chan struct{})
+		ms.done = make(chan struct{})
+		go ms.janitor()
+	}
+	return nil
+}
+
+// interval возвращает период очистки.
+func (ms *ExpiringStorage) interval() time.Duration {
+	if ms.Interval > 0 {
+		return ms.Interval
+	}
+	return min(ms.TTL, MaxJanitorInterval)
+}
+
+// janitor периодически удаляет устаревшие метрики до вызова FreeStorage.
+func (ms *ExpiringStorage) janitor() {
+	defer close(ms.done)
+
+	ticker := time.NewTicker(ms.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultJanitorTimeout)
+			n, err := ms.PurgeExpired(ctx)
+			cancel()
+			if err != nil {
+				logging.Logg.Warn("Failed to purge stale metrics", "error", err)
+			} else if n > 0 {
+				logging.Logg.Info("Purged stale metrics", "count", n)
+			}
+		case <-ms.stop:
+			return
+		}
+	}
+}
+
+// PurgeExpired удаляет из обернутого хранилища метрики, не обновлявшиеся дольше TTL,
+// и возвращает их число.
+func (ms *ExpiringStorage) PurgeExpired(ctx context.Context) (int, error) {
+	metrics, err := ms.MetricStorage.ListSlice(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-ms.TTL)
+	var stale []string
+	for _, mt := range metrics {
+		if isStale(mt, cutoff) {
+			stale = append(stale, mt.ID)
+		}
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	// Условие повторяется в самом удалении: метрика, обновленная после ListSlice, остается.
+	n, err := ms.MetricStorage.DeleteWhere(ctx, DeleteFilter{UpdatedBefore: cutoff}, stale...)
+	expiredPurged.Add(uint64(n))
+	return n, err
+}
+
+// isStale сообщает, обновлялась ли метрика последний раз раньше cutoff.
+func isStale(mt service.Metrics, cutoff time.Time) bool {
+	return mt.UpdatedAt != nil && mt.UpdatedAt.Before(cutoff)
+}
+
+func (ms *ExpiringStorage) Get(ctx context.Context, metricName string) (*service.Metrics, error) {
+	mt, err := ms.MetricStorage.Get(ctx, metricName)
+	if err != nil {
+		return nil, err
+	}
+	if isStale(*mt, time.Now().Add(-ms.TTL)) {
+		return nil, service.ErrUnknownMetric
+	}
+	return mt, nil
+}
+
+func (ms *ExpiringStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
+	metrics, err := ms.MetricStorage.ListSlice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-ms.TTL)
+	fresh := make([]service.Metrics, 0, len(metrics))
+	for _, mt := range metrics {
+		if !isStale(mt, cutoff) {
+			fresh = append(fresh, mt)
+		}
+	}
+	return fresh, nil
+}
+
+// ListPage передает выборку обернутому хранилищу и отбрасывает устаревшие метрики.
+// Если после этого страница неполна, недостающие метрики дочитываются со следующих
+// страниц обернутого хранилища.
+func (ms *ExpiringStorage) ListPage(ctx context.Context, q ListQuery) (*Page, error) {
+	cutoff := time.Now().Add(-ms.TTL)
+	limit := q.Limit
+	page := &Page{Metrics: make([]service.Metrics, 0)}
+	for {
+		if limit > 0 {
+			q.Limit = limit - len(page.Metrics)
+		}
+		inner, err := ms.MetricStorage.ListPage(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, mt := range inner.Metrics {
+			if !isStale(mt, cutoff) {
+				page.Metrics = append(page.Metrics, mt)
+			}
+		}
+		if inner.NextCursor == "" || len(page.Metrics) == limit {
+			page.NextCursor = inner.NextCursor
+			return page, nil
+		}
+		q.Cursor = inner.NextCursor
+	}
+}
+
+func (ms *ExpiringStorage) List(ctx context.Context) (*map[string]service.Metrics, error) {
+	metrics, err := ms.MetricStorage.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-ms.TTL)
+	for id, mt := range *metrics {
+		if isStale(mt, cutoff) {
+			delete(*metrics, id)
+		}
+	}
+	return metrics, nil
+}
+
+// FreeStorage останавливает очистку и освобождает обернутое хранилище.
+func (ms *ExpiringStorage) FreeStorage() error {
+	ms.once.Do(func() {
+		if ms.stop != nil {
+			close(ms.stop)
+			<-ms.done
+		}
+	})
+	return ms.MetricStorage.FreeStorage()
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiringStorage(t *testing.T) {
+	require.NoError(t, logging.InitTestLogger())
+	ctx := context.Background()
+	const ttl = 50 * time.Millisecond
+
+	newStorage := func(t *testing.T, purge bool) (*ExpiringStorage, *MemStorage) {
+		inner := &MemStorage{}
+		ms := &ExpiringStorage{MetricStorage: inner, TTL: ttl, Purge: purge, Interval: 10 * time.Millisecond}
+		require.NoError(t, ms.NewStorage())
+		t.Cleanup(func() { ms.FreeStorage() })
+		return ms, inner
+	}
+
+	t.Run("Stale metrics are hidden", func(t *testing.T) {
+		ms, inner := newStorage(t, false)
+		require.NoError(t, ms.Save(ctx, gauge("old", 1)))
+		time.Sleep(2 * ttl)
+		require.NoError(t, ms.Save(ctx, counter("fresh", 1)))
+
+		_, err := ms.Get(ctx, "old")
+		assert.Equal(t, service.ErrUnknownMetric, err)
+		mt, err := ms.Get(ctx, "fresh")
+		require.NoError(t, err)
+		assert.NotNil(t, mt.UpdatedAt)
+
+		slice, err := ms.ListSlice(ctx)
+		require.NoError(t, err)
+		require.Len(t, slice, 1)
+		assert.Equal(t, "fresh", slice[0].ID)
+		all, err := ms.List(ctx)
+		require.NoError(t, err)
+		assert.Len(t, *all, 1)
+
+		// Без очистки метрика остается в хранилище и возвращается после записи.
+		_, err = inner.Get(ctx, "old")
+		require.NoError(t, err)
+		require.NoError(t, ms.Save(ctx, gauge("old", 2)))
+		mt, err = ms.Get(ctx, "old")
+		require.NoError(t, err)
+		assert.Equal(t, service.GaugeMetricValue(2), *mt.Value)
+	})
+
+	t.Run("Janitor purges stale metrics", func(t *testing.T) {
+		ms, inner := newStorage(t, true)
+		require.NoError(t, ms.Save(ctx, counter("old", 5)))
+
+		assert.Eventually(t, func() bool {
+			_, err := inner.Get(ctx, "old")
+			return err == service.ErrUnknownMetric
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("PurgeExpired keeps fresh and untimed metrics", func(t *testing.T) {
+		inner := &MemStorage{}
+		require.NoError(t, inner.NewStorage())
+		ms := &ExpiringStorage{MetricStorage: inner, TTL: time.Hour}
+
+		past := time.Now().Add(-2 * time.Hour)
+		inner.data["stale"] = stamp(gauge("stale", 1), past)
+		inner.data["untimed"] = gauge("untimed", 1)
+		require.NoError(t, inner.Save(ctx, gauge("fresh", 1)))
+
+		n, err := ms.PurgeExpired(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		all, err := inner.List(ctx)
+		require.NoError(t, err)
+		assert.Len(t, *all, 2)
+		assert.NotContains(t, *all, "stale")
+	})
+
+	t.Run("PurgeExpired keeps metrics updated after listing", func(t *testing.T) {
+		inner := &spyStorage{}
+		require.NoError(t, inner.NewStorage())
+		ms := &ExpiringStorage{MetricStorage: inner, TTL: time.Hour}
+
+		past := time.Now().Add(-2 * time.Hour)
+		inner.data["stale"] = stamp(gauge("stale", 1), past)
+		inner.data["revived"] = stamp(gauge("revived", 1), past)
+		inner.listed = func() {
+			require.NoError(t, inner.MemStorage.Save(ctx, gauge("revived", 2)))
+		}
+
+		n, err := ms.PurgeExpired(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		mt, err := inner.Get(ctx, "revived")
+		require.NoError(t, err)
+		assert.Equal(t, service.GaugeMetricValue(2), *mt.Value)
+	})
+}
+
+func TestExpireWrapper(t *testing.T) {
+	ms, err := Open("memory://", Options{Wrappers: []string{"expire?after=10m&purge=true&interval=30s"}})
+	require.NoError(t, err)
+	require.IsType(t, &ExpiringStorage{}, ms)
+	expiring := ms.(*ExpiringStorage)
+	assert.Equal(t, 10*time.Minute, expiring.TTL)
+	assert.True(t, expiring.Purge)
+	assert.Equal(t, 30*time.Second, expiring.Interval)
+	assert.IsType(t, &MemStorage{}, expiring.MetricStorage)
+
+	_, err = Open("memory://", Options{Wrappers: []string{"expire"}})
+	assert.ErrorIs(t, err, ErrInvalidStorageParam)
+
+	_, err = Open("memory://", Options{Wrappers: []string{"expire?after=1h&purge=maybe"}})
+	assert.ErrorIs(t, err, ErrInvalidStorageParam)
+}
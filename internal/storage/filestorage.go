@@ -3,196 +3,574 @@ package storage
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/dvkhr/metrix.git/internal/logging"
 	"github.com/dvkhr/metrix.git/internal/service"
 )
 
+// FsyncPolicy определяет, когда записи журнала сбрасываются на диск.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways сбрасывает журнал на диск после каждой записи.
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncInterval сбрасывает журнал на диск раз в SyncInterval.
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever оставляет сброс на диск операционной системе.
+	FsyncNever FsyncPolicy = "never"
+)
+
+// Значения по умолчанию для FileStorage.
+const (
+	DefaultWALSyncInterval    = time.Second
+	DefaultWALCompactInterval = time.Minute
+)
+
+// walHeaderSize — размер заголовка записи журнала: длина данных и их CRC32.
+const walHeaderSize = 8
+
+// ErrInvalidFsyncPolicy возвращается для неизвестной политики сброса журнала.
+var ErrInvalidFsyncPolicy = errors.New("invalid fsync policy")
+
+// ParseFsyncPolicy проверяет строковое значение политики сброса журнала.
+// Пустая строка соответствует FsyncInterval.
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch p := FsyncPolicy(s); p {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+		return p, nil
+	case "":
+		return FsyncInterval, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidFsyncPolicy, s)
+}
+
+// walOp — операция записи журнала.
+type walOp string
+
+// Операции журнала. Пустая операция — запись пакета метрик (формат прежних версий).
+const (
+	walOpSave    walOp = ""
+	walOpDelete  walOp = "delete"
+	walOpReset   walOp = "reset"
+	walOpReplace walOp = "replace"
+)
+
+// walRecord — одна запись журнала: пакет метрик или список удаляемых (обнуляемых)
+// метрик, примененный атомарно. Счетчики хранят приращение, поэтому при воспроизведении
+// прибавляются к текущему значению; исключение — замена (walOpReplace), которая записывает
+// метрики целиком вместо сохраненных. Time — время изменения; при воспроизведении оно
+// становится временем изменения затронутых метрик (у записей прежних версий оно пустое).
+type walRecord struct {
+	LSN     uint64            `json:"lsn"`
+	Op      walOp             `json:"op,omitempty"`
+	Time    time.Time         `json:"time"`
+	Metrics []service.Metrics `json:"metrics,omitempty"`
+	IDs     []string          `json:"ids,omitempty"`
+}
+
+// walSnapshot — содержимое файла снимка. LSN — номер последней записи журнала,
+// учтенной в снимке; более ранние записи при восстановлении пропускаются.
+type walSnapshot struct {
+	LSN     uint64                     `json:"lsn"`
+	Metrics map[string]service.Metrics `json:"metrics"`
+}
+
+// FileStorage хранит метрики в памяти и сохраняет каждое изменение в журнал
+// упреждающей записи (WAL) рядом с файлом снимка.
+//
+// Каждое обновление дописывается в конец файла FileStoragePath+".wal" записью вида
+// [длина][CRC32][JSON], поэтому стоимость записи не зависит от числа метрик.
+// Периодически журнал сворачивается в снимок FileStoragePath (атомарная запись через
+// временный файл) и обрезается. При запуске снимок загружается, журнал воспроизводится,
+// а недописанный или поврежденный хвост журнала отбрасывается.
+//
+// Поля:
+//   - FileStoragePath: Путь к файлу снимка.
+//   - Fsync: Политика сброса журнала на диск (по умолчанию FsyncInterval).
+//   - SyncInterval: Период сброса журнала для FsyncInterval.
+//   - CompactInterval: Период свертки журнала в снимок.
 type FileStorage struct {
 	FileStoragePath string
-	file            *os.File
+	Fsync           FsyncPolicy
+	SyncInterval    time.Duration
+	CompactInterval time.Duration
+
+	mu          sync.RWMutex
+	file        walFile
+	size        int64
+	data        map[string]service.Metrics
+	lsn         uint64
+	snapshotLSN uint64
+	unsynced    bool
+	stop        chan struct{}
+	done        chan struct{}
 }
 
-func (ms *FileStorage) NewStorage() error {
-	var err error
-	ms.file, err = os.OpenFile(ms.FileStoragePath, os.O_RDWR|os.O_CREATE, 0666)
-	return err
+// walFile — открытый файл журнала; в тестах подменяется, чтобы имитировать ошибки диска.
+type walFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
 }
 
-func (ms *FileStorage) Save(ctx context.Context, mt service.Metrics) error {
-	defer ms.file.Sync()
+func (ms *FileStorage) walPath() string {
+	return ms.FileStoragePath + ".wal"
+}
 
-	if ms.file == nil {
-		return service.ErrUninitializedStorage
+func (ms *FileStorage) NewStorage() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	policy, err := ParseFsyncPolicy(string(ms.Fsync))
+	if err != nil {
+		return err
+	}
+	ms.Fsync = policy
+	if ms.SyncInterval <= 0 {
+		ms.SyncInterval = DefaultWALSyncInterval
+	}
+	if ms.CompactInterval <= 0 {
+		ms.CompactInterval = DefaultWALCompactInterval
 	}
 
-	if len(mt.ID) == 0 {
-		return service.ErrInvalidMetricName
+	if err := ms.loadSnapshot(); err != nil {
+		return err
 	}
 
-	mtrx, err := ms.List(ctx)
+	file, err := os.OpenFile(ms.walPath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		return err
 	}
-
-	if mt.MType == service.GaugeMetric {
-		(*mtrx)[mt.ID] = mt
-	} else if mt.MType == service.CounterMetric {
-		if (*mtrx)[mt.ID].Delta != nil {
-			*(*mtrx)[mt.ID].Delta += *mt.Delta
-		} else {
-			(*mtrx)[mt.ID] = mt
-		}
-	} else {
-		return service.ErrInvalidMetricName
+	if err := ms.replay(file); err != nil {
+		file.Close()
+		return err
 	}
+	ms.file = file
+
+	ms.stop = make(chan struct{})
+	ms.done = make(chan struct{})
+	go ms.maintenanceLoop()
+	return nil
+}
+
+// loadSnapshot загружает снимок. Поддерживается и прежний формат файла — карта метрик без LSN.
+func (ms *FileStorage) loadSnapshot() error {
+	ms.data = make(map[string]service.Metrics)
+	ms.snapshotLSN, ms.lsn = 0, 0
 
-	data, err := json.MarshalIndent(mtrx, "", "  ")
+	data, err := os.ReadFile(ms.FileStoragePath)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(data) == 0) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	err = ms.file.Truncate(0)
+	snap, err := parseSnapshot(ms.FileStoragePath, data)
 	if err != nil {
 		return err
 	}
+	for id, metric := range snap.Metrics {
+		ms.data[id] = cloneMetric(metric)
+	}
+	ms.snapshotLSN, ms.lsn = snap.LSN, snap.LSN
+	return nil
+}
+
+// parseSnapshot разбирает файл снимка path в любом из двух форматов: walSnapshot
+// (FileStorage) или карта метрик без LSN (SnapshotStorage и прежние версии). Благодаря
+// этому режим -wal можно включать и выключать на одном и том же файле.
+func parseSnapshot(path string, data []byte) (walSnapshot, error) {
+	var snap walSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil || snap.Metrics == nil {
+		snap = walSnapshot{}
+		if err := json.Unmarshal(data, &snap.Metrics); err != nil {
+			return walSnapshot{}, fmt.Errorf("failed to parse snapshot %s: %w", path, err)
+		}
+	}
+	return snap, nil
+}
 
-	_, err = ms.file.Seek(0, 0)
+// replay воспроизводит записи журнала поверх снимка и обрезает поврежденный хвост.
+func (ms *FileStorage) replay(file *os.File) error {
+	data, err := io.ReadAll(file)
 	if err != nil {
 		return err
 	}
 
-	_, err = ms.file.Write(data)
-	return err
+	var off int
+	for off+walHeaderSize <= len(data) {
+		n := int(binary.LittleEndian.Uint32(data[off:]))
+		sum := binary.LittleEndian.Uint32(data[off+4:])
+		end := off + walHeaderSize + n
+		if end > len(data) || crc32.ChecksumIEEE(data[off+walHeaderSize:end]) != sum {
+			break
+		}
+		var rec walRecord
+		if err := json.Unmarshal(data[off+walHeaderSize:end], &rec); err != nil {
+			break
+		}
+		if rec.LSN > ms.snapshotLSN {
+			ms.applyRecord(rec)
+		}
+		if rec.LSN > ms.lsn {
+			ms.lsn = rec.LSN
+		}
+		off = end
+	}
+
+	if off < len(data) {
+		logging.Logg.Warn("Discarding torn WAL tail", "path", ms.walPath(), "offset", off, "bytes", len(data)-off)
+		if err := file.Truncate(int64(off)); err != nil {
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	ms.size = int64(off)
+	return nil
 }
 
-func (ms *FileStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
-	defer ms.file.Sync()
+// existingType возвращает тип сохраненной метрики; вызывающий должен удерживать ms.mu.
+func (ms *FileStorage) existingType(id string) (service.MetricType, bool) {
+	mt, ok := ms.data[id]
+	return mt.MType, ok
+}
 
-	if ms.file == nil {
-		return service.ErrUninitializedStorage
+// apply применяет метрику к карте в памяти; вызывающий должен удерживать ms.mu.
+// Записи журнала прежних версий могли менять тип метрики и не содержать значения,
+// поэтому при воспроизведении такие метрики заменяются целиком.
+func (ms *FileStorage) apply(mt service.Metrics, now time.Time) {
+	old := ms.data[mt.ID]
+	if old.MType != mt.MType || mt.Delta == nil {
+		old = service.Metrics{}
 	}
+	ms.data[mt.ID] = merge(old, mt, now)
+}
 
-	if len(*mt) == 0 {
-		return service.ErrInvalidMetricName
+// applyRecord применяет запись журнала к карте в памяти; вызывающий должен удерживать ms.mu.
+// Записи без времени (прежних версий) получают время воспроизведения.
+func (ms *FileStorage) applyRecord(rec walRecord) {
+	now := rec.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	switch rec.Op {
+	case walOpSave:
+		for _, metric := range rec.Metrics {
+			ms.apply(metric, now)
+		}
+	case walOpDelete:
+		for _, id := range rec.IDs {
+			delete(ms.data, id)
+		}
+	case walOpReplace:
+		for _, metric := range rec.Metrics {
+			ms.data[metric.ID] = stamp(cloneMetric(metric), now)
+		}
+	case walOpReset:
+		for _, id := range rec.IDs {
+			if mt, ok := resetCounter(ms.data[id], now); ok {
+				ms.data[id] = mt
+			}
+		}
 	}
+}
 
-	mtrx, err := ms.List(ctx)
+// appendRecord дописывает запись в журнал, присваивая ей следующий LSN; вызывающий
+// должен удерживать ms.mu и применять запись к памяти только после успешного возврата.
+// При частичной записи, а для FsyncAlways и при ошибке сброса на диск, файл обрезается
+// до прежнего размера, чтобы отклоненное изменение не воспроизвелось после перезапуска.
+func (ms *FileStorage) appendRecord(rec walRecord) error {
+	rec.LSN = ms.lsn + 1
+	payload, err := json.Marshal(rec)
 	if err != nil {
 		return err
 	}
-	for _, metric := range *mt {
-		if metric.MType == service.GaugeMetric {
-			(*mtrx)[metric.ID] = metric
-		} else if metric.MType == service.CounterMetric {
-			if (*mtrx)[metric.ID].Delta != nil {
-				*(*mtrx)[metric.ID].Delta += *metric.Delta
-			} else {
-				(*mtrx)[metric.ID] = metric
+	buf := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	copy(buf[walHeaderSize:], payload)
+
+	if _, err := ms.file.Write(buf); err != nil {
+		ms.file.Truncate(ms.size)
+		return err
+	}
+	if ms.Fsync == FsyncAlways {
+		if err := ms.file.Sync(); err != nil {
+			ms.file.Truncate(ms.size)
+			return err
+		}
+	} else {
+		ms.unsynced = true
+	}
+	ms.size += int64(len(buf))
+	ms.lsn++
+	return nil
+}
+
+// maintenanceLoop сбрасывает журнал на диск и сворачивает его в снимок до вызова FreeStorage.
+func (ms *FileStorage) maintenanceLoop() {
+	defer close(ms.done)
+
+	syncTicker := time.NewTicker(ms.SyncInterval)
+	defer syncTicker.Stop()
+	compactTicker := time.NewTicker(ms.CompactInterval)
+	defer compactTicker.Stop()
+
+	for {
+		select {
+		case <-syncTicker.C:
+			if ms.Fsync != FsyncInterval {
+				continue
+			}
+			ms.mu.Lock()
+			if ms.unsynced {
+				if err := ms.file.Sync(); err != nil {
+					logging.Logg.Error("Failed to sync WAL", "path", ms.walPath(), "error", err)
+				} else {
+					ms.unsynced = false
+				}
 			}
-		} else {
-			return service.ErrInvalidMetricName
+			ms.mu.Unlock()
+		case <-compactTicker.C:
+			ms.mu.Lock()
+			if err := ms.compact(); err != nil {
+				logging.Logg.Error("Failed to compact WAL", "path", ms.walPath(), "error", err)
+			}
+			ms.mu.Unlock()
+		case <-ms.stop:
+			return
 		}
+	}
+}
 
+// compact записывает снимок текущего состояния и обрезает журнал;
+// вызывающий должен удерживать ms.mu. Если сбой произойдет между записью снимка и
+// обрезкой журнала, записи с LSN не больше LSN снимка будут пропущены при восстановлении.
+func (ms *FileStorage) compact() error {
+	if ms.lsn == ms.snapshotLSN && ms.size == 0 {
+		return nil
 	}
 
-	data, err := json.MarshalIndent(mtrx, "", "  ")
+	err := writeFileAtomic(ms.FileStoragePath, func(w io.Writer) error {
+		data, err := json.MarshalIndent(walSnapshot{LSN: ms.lsn, Metrics: ms.data}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	ms.snapshotLSN = ms.lsn
 
-	err = ms.file.Truncate(0)
-	if err != nil {
+	if err := ms.file.Truncate(0); err != nil {
 		return err
 	}
+	ms.size = 0
+	ms.unsynced = false
+	return ms.file.Sync()
+}
 
-	_, err = ms.file.Seek(0, 0)
-	if err != nil {
+func (ms *FileStorage) Save(ctx context.Context, mt service.Metrics) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.file == nil {
+		return service.ErrUninitializedStorage
+	}
+	if err := checkBatch([]service.Metrics{mt}, ms.existingType); err != nil {
+		return err
+	}
+
+	rec := walRecord{Time: time.Now(), Metrics: []service.Metrics{mt}}
+	if err := ms.appendRecord(rec); err != nil {
+		return err
+	}
+	ms.applyRecord(rec)
+	return nil
+}
+
+func (ms *FileStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.file == nil {
+		return service.ErrUninitializedStorage
+	}
+	if len(*mt) == 0 {
+		return service.ErrInvalidMetricName
+	}
+	if err := checkBatch(*mt, ms.existingType); err != nil {
 		return err
 	}
 
-	_, err = ms.file.Write(data)
-	return err
+	rec := walRecord{Time: time.Now(), Metrics: *mt}
+	if err := ms.appendRecord(rec); err != nil {
+		return err
+	}
+	ms.applyRecord(rec)
+	return nil
 }
 
 func (ms *FileStorage) Get(ctx context.Context, metricName string) (*service.Metrics, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
 	if ms.file == nil {
 		return nil, service.ErrUninitializedStorage
 	}
-
 	if len(metricName) == 0 {
 		return nil, service.ErrInvalidMetricName
 	}
-
-	mtrx, err := ms.List(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	if m, ok := (*mtrx)[metricName]; ok {
+	if m, ok := ms.data[metricName]; ok {
 		return &m, nil
 	}
 	return nil, service.ErrUnknownMetric
 }
 
 func (ms *FileStorage) List(ctx context.Context) (*map[string]service.Metrics, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
 	if ms.file == nil {
 		return nil, service.ErrUninitializedStorage
 	}
-	var data []byte
-	var mtrx map[string]service.Metrics
+	mtrx := make(map[string]service.Metrics, len(ms.data))
+	for id, metric := range ms.data {
+		mtrx[id] = metric
+	}
+	return &mtrx, nil
+}
 
-	ms.file.Seek(0, 0)
+func (ms *FileStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 
-	data, err := io.ReadAll(ms.file)
-	if err != nil {
-		return nil, err
+	if ms.file == nil {
+		return nil, service.ErrUninitializedStorage
 	}
-	if len(data) == 0 {
-		mtrx = make(map[string]service.Metrics)
-		return &mtrx, nil
+	metricsSlice := make([]service.Metrics, 0, len(ms.data))
+	for _, metric := range ms.data {
+		metricsSlice = append(metricsSlice, metric)
 	}
+	return metricsSlice, nil
+}
 
-	err = json.Unmarshal(data, &mtrx)
+func (ms *FileStorage) ListPage(ctx context.Context, q ListQuery) (*Page, error) {
+	metrics, err := ms.ListSlice(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &mtrx, nil
+	return listPage(metrics, q)
 }
 
+// FreeStorage останавливает фоновое обслуживание, сворачивает журнал в снимок и закрывает файл.
 func (ms *FileStorage) FreeStorage() error {
-	return ms.file.Close()
+	if ms.stop != nil {
+		close(ms.stop)
+		<-ms.done
+		ms.stop = nil
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.file == nil {
+		return service.ErrUninitializedStorage
+	}
+	return errors.Join(ms.compact(), ms.file.Close())
 }
 
-func (ms *FileStorage) CheckStorage() error {
+func (ms *FileStorage) CheckStorage(ctx context.Context) error {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
 	if ms.file == nil {
 		return service.ErrUninitializedStorage
 	}
 	return nil
 }
-func (ms *FileStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
+
+// Delete удаляет метрики, записывая в журнал только реально существующие.
+func (ms *FileStorage) Delete(ctx context.Context, metricNames ...string) (int, error) {
+	return ms.DeleteWhere(ctx, DeleteFilter{}, metricNames...)
+}
+
+// DeleteWhere удаляет метрики, удовлетворяющие f, записывая в журнал только их.
+func (ms *FileStorage) DeleteWhere(ctx context.Context, f DeleteFilter, metricNames ...string) (int, error) {
+	return ms.modify(walOpDelete, metricNames, func(mt service.Metrics, ok bool) bool { return ok && f.match(mt) })
+}
+
+// Reset обнуляет счетчики, записывая в журнал только существующие счетчики.
+func (ms *FileStorage) Reset(ctx context.Context, metricNames ...string) (int, error) {
+	return ms.modify(walOpReset, metricNames, func(mt service.Metrics, ok bool) bool {
+		return ok && mt.MType == service.CounterMetric
+	})
+}
+
+// Replace записывает метрику вместо сохраненной одной записью журнала, поэтому после
+// перезапуска воспроизводится либо замена целиком, либо прежняя метрика.
+func (ms *FileStorage) Replace(ctx context.Context, mt service.Metrics) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
 	if ms.file == nil {
-		return nil, service.ErrUninitializedStorage
+		return service.ErrUninitializedStorage
+	}
+	if err := checkReplacement(mt); err != nil {
+		return err
+	}
+
+	rec := walRecord{Op: walOpReplace, Time: time.Now(), Metrics: []service.Metrics{mt}}
+	if err := ms.appendRecord(rec); err != nil {
+		return err
 	}
-	var data []byte
-	var mtrx []service.Metrics
+	ms.applyRecord(rec)
+	return nil
+}
 
-	ms.file.Seek(0, 0)
+// modify записывает в журнал и применяет операцию op к метрикам, для которых
+// affected возвращает true. Если таких метрик нет, журнал не изменяется.
+func (ms *FileStorage) modify(op walOp, names []string, affected func(mt service.Metrics, ok bool) bool) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
 
-	data, err := io.ReadAll(ms.file)
+	if ms.file == nil {
+		return 0, service.ErrUninitializedStorage
+	}
+	names, err := uniqueNames(names)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	if len(data) == 0 {
-		mtrx = make([]service.Metrics, 0, len(data))
-		return mtrx, nil
+
+	var ids []string
+	for _, name := range names {
+		if mt, ok := ms.data[name]; affected(mt, ok) {
+			ids = append(ids, name)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
 	}
 
-	err = json.Unmarshal(data, &mtrx)
-	if err != nil {
-		return nil, err
+	rec := walRecord{Op: op, Time: time.Now(), IDs: ids}
+	if err := ms.appendRecord(rec); err != nil {
+		return 0, err
 	}
-	return mtrx, nil
+	ms.applyRecord(rec)
+	return len(ids), nil
 }
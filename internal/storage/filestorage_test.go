@@ -3,10 +3,14 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/dvkhr/metrix.git/internal/logging"
 	"github.com/dvkhr/metrix.git/internal/service"
 	"github.com/stretchr/testify/assert"
 )
@@ -22,6 +26,16 @@ func createTempFile(t *testing.T) (string, func()) {
 	return tempFile, cleanup
 }
 
+// readWALSnapshot читает метрики из файла снимка, записанного при свертке журнала.
+func readWALSnapshot(t *testing.T, path string) map[string]service.Metrics {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	var snap walSnapshot
+	assert.NoError(t, json.Unmarshal(data, &snap))
+	return snap.Metrics
+}
+
 func TestFileNewStorage(t *testing.T) {
 	t.Run("Success: Create and open file", func(t *testing.T) {
 		filePath, cleanup := createTempFile(t)
@@ -34,7 +48,7 @@ func TestFileNewStorage(t *testing.T) {
 
 		assert.NotNil(t, storage.file)
 
-		_, err = os.Stat(filePath)
+		_, err = os.Stat(filePath + ".wal")
 		assert.NoError(t, err)
 	})
 
@@ -89,12 +103,8 @@ func TestFileSave(t *testing.T) {
 		err := storage.Save(ctx, metric)
 		assert.NoError(t, err)
 
-		data, err := os.ReadFile(filePath)
-		assert.NoError(t, err)
-
-		var savedData map[string]service.Metrics
-		err = json.Unmarshal(data, &savedData)
-		assert.NoError(t, err)
+		assert.NoError(t, storage.FreeStorage())
+		savedData := readWALSnapshot(t, filePath)
 
 		assert.Equal(t, 1, len(savedData))
 		assert.Equal(t, gaugeValue, *savedData["test_gauge"].Value)
@@ -128,12 +138,8 @@ func TestFileSave(t *testing.T) {
 		err = storage.Save(ctx, metric2)
 		assert.NoError(t, err)
 
-		data, err := os.ReadFile(filePath)
-		assert.NoError(t, err)
-
-		var savedData map[string]service.Metrics
-		err = json.Unmarshal(data, &savedData)
-		assert.NoError(t, err)
+		assert.NoError(t, storage.FreeStorage())
+		savedData := readWALSnapshot(t, filePath)
 
 		assert.Equal(t, 1, len(savedData))
 		assert.Equal(t, result, *savedData["test_counter"].Delta)
@@ -198,12 +204,8 @@ func TestFileSaveAll(t *testing.T) {
 		err := storage.SaveAll(ctx, &metrics)
 		assert.NoError(t, err)
 
-		data, err := os.ReadFile(filePath)
-		assert.NoError(t, err)
-
-		var savedData map[string]service.Metrics
-		err = json.Unmarshal(data, &savedData)
-		assert.NoError(t, err)
+		assert.NoError(t, storage.FreeStorage())
+		savedData := readWALSnapshot(t, filePath)
 
 		assert.Equal(t, 4, len(savedData))
 		assert.Equal(t, gaugeValue1, *savedData["gauge1"].Value)
@@ -235,12 +237,8 @@ func TestFileSaveAll(t *testing.T) {
 		err = storage.SaveAll(ctx, &metrics)
 		assert.NoError(t, err)
 
-		data, err := os.ReadFile(filePath)
-		assert.NoError(t, err)
-
-		var savedData map[string]service.Metrics
-		err = json.Unmarshal(data, &savedData)
-		assert.NoError(t, err)
+		assert.NoError(t, storage.FreeStorage())
+		savedData := readWALSnapshot(t, filePath)
 
 		assert.Equal(t, 1, len(savedData))
 		assert.Equal(t, result, *savedData["counter1"].Delta)
@@ -295,14 +293,14 @@ func TestFileCheckStorage(t *testing.T) {
 		err := storage.NewStorage()
 		assert.NoError(t, err)
 
-		err = storage.CheckStorage()
+		err = storage.CheckStorage(context.Background())
 		assert.NoError(t, err)
 	})
 
 	t.Run("Error: Uninitialized storage", func(t *testing.T) {
 		storage := &FileStorage{} // file == nil
 
-		err := storage.CheckStorage()
+		err := storage.CheckStorage(context.Background())
 		assert.Error(t, err)
 		assert.Equal(t, service.ErrUninitializedStorage, err)
 	})
@@ -337,9 +335,9 @@ func TestFileListSlice(t *testing.T) {
 		gaugeValue := service.GaugeMetricValue(42.0)
 		counterDelta := service.CounterMetricValue(10)
 
-		metrics := []service.Metrics{
-			{ID: "gauge1", MType: service.GaugeMetric, Value: &gaugeValue},
-			{ID: "counter1", MType: service.CounterMetric, Delta: &counterDelta},
+		metrics := map[string]service.Metrics{
+			"gauge1":   {ID: "gauge1", MType: service.GaugeMetric, Value: &gaugeValue},
+			"counter1": {ID: "counter1", MType: service.CounterMetric, Delta: &counterDelta},
 		}
 
 		content, err := json.Marshal(metrics)
@@ -359,6 +357,8 @@ func TestFileListSlice(t *testing.T) {
 		assert.NotNil(t, result)
 		assert.Equal(t, 2, len(result))
 
+		sort.Slice(result, func(i, j int) bool { return result[i].ID > result[j].ID })
+
 		assert.Equal(t, "gauge1", result[0].ID)
 		assert.Equal(t, service.GaugeMetric, result[0].MType)
 		assert.Equal(t, gaugeValue, *result[0].Value)
@@ -376,10 +376,304 @@ func TestFileListSlice(t *testing.T) {
 		assert.NoError(t, err)
 
 		storage := &FileStorage{FileStoragePath: filePath}
-		_ = storage.NewStorage()
+		err = storage.NewStorage()
+		assert.Error(t, err)
 
 		result, err := storage.ListSlice(ctx)
-		assert.Error(t, err)
+		assert.Equal(t, service.ErrUninitializedStorage, err)
 		assert.Nil(t, result)
 	})
 }
+
+func TestFileWALRecovery(t *testing.T) {
+	ctx := context.Background()
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+
+	saveCounters := func(t *testing.T, filePath string, n int) *FileStorage {
+		storage := &FileStorage{FileStoragePath: filePath, Fsync: FsyncAlways}
+		assert.NoError(t, storage.NewStorage())
+		for i := 0; i < n; i++ {
+			delta := service.CounterMetricValue(1)
+			assert.NoError(t, storage.Save(ctx, service.Metrics{ID: "counter", MType: service.CounterMetric, Delta: &delta}))
+		}
+		return storage
+	}
+
+	t.Run("Success: Replay log without shutdown", func(t *testing.T) {
+		filePath, cleanup := createTempFile(t)
+		defer cleanup()
+
+		saveCounters(t, filePath, 3)
+
+		restored := &FileStorage{FileStoragePath: filePath}
+		assert.NoError(t, restored.NewStorage())
+		defer restored.FreeStorage()
+
+		metric, err := restored.Get(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(3), *metric.Delta)
+	})
+
+	t.Run("Success: Replay delete and reset", func(t *testing.T) {
+		filePath, cleanup := createTempFile(t)
+		defer cleanup()
+
+		storage := saveCounters(t, filePath, 2)
+		value := service.GaugeMetricValue(1)
+		assert.NoError(t, storage.Save(ctx, service.Metrics{ID: "gauge", MType: service.GaugeMetric, Value: &value}))
+		n, err := storage.Reset(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		n, err = storage.Delete(ctx, "gauge")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		restored := &FileStorage{FileStoragePath: filePath}
+		assert.NoError(t, restored.NewStorage())
+		defer restored.FreeStorage()
+
+		metric, err := restored.Get(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(0), *metric.Delta)
+		_, err = restored.Get(ctx, "gauge")
+		assert.Equal(t, service.ErrUnknownMetric, err)
+	})
+
+	t.Run("Success: Replay replace", func(t *testing.T) {
+		filePath, cleanup := createTempFile(t)
+		defer cleanup()
+
+		storage := saveCounters(t, filePath, 2)
+		value := service.GaugeMetricValue(1.5)
+		assert.NoError(t, storage.Replace(ctx, service.Metrics{ID: "counter", MType: service.GaugeMetric, Value: &value}))
+
+		// Замена, которую не удалось сбросить на диск, не применяется и не воспроизводится.
+		storage.mu.Lock()
+		storage.file = failingSync{storage.file.(*os.File)}
+		storage.mu.Unlock()
+		delta := service.CounterMetricValue(7)
+		assert.Error(t, storage.Replace(ctx, service.Metrics{ID: "counter", MType: service.CounterMetric, Delta: &delta}))
+		metric, err := storage.Get(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, service.GaugeMetric, metric.MType)
+
+		restored := &FileStorage{FileStoragePath: filePath}
+		assert.NoError(t, restored.NewStorage())
+		defer restored.FreeStorage()
+
+		metric, err = restored.Get(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, service.GaugeMetric, metric.MType)
+		assert.Equal(t, service.GaugeMetricValue(1.5), *metric.Value)
+		assert.Nil(t, metric.Delta)
+	})
+
+	t.Run("Success: Discard torn tail", func(t *testing.T) {
+		filePath, cleanup := createTempFile(t)
+		defer cleanup()
+
+		saveCounters(t, filePath, 2)
+
+		info, err := os.Stat(filePath + ".wal")
+		assert.NoError(t, err)
+		// Обрезаем последнюю запись посередине, как при сбое во время записи.
+		assert.NoError(t, os.Truncate(filePath+".wal", info.Size()-3))
+
+		restored := &FileStorage{FileStoragePath: filePath, Fsync: FsyncAlways}
+		assert.NoError(t, restored.NewStorage())
+
+		metric, err := restored.Get(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(1), *metric.Delta)
+
+		delta := service.CounterMetricValue(10)
+		assert.NoError(t, restored.Save(ctx, service.Metrics{ID: "counter", MType: service.CounterMetric, Delta: &delta}))
+
+		again := &FileStorage{FileStoragePath: filePath}
+		assert.NoError(t, again.NewStorage())
+		defer again.FreeStorage()
+
+		metric, err = again.Get(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(11), *metric.Delta)
+	})
+
+	t.Run("Success: Discard corrupted record", func(t *testing.T) {
+		filePath, cleanup := createTempFile(t)
+		defer cleanup()
+
+		saveCounters(t, filePath, 2)
+
+		data, err := os.ReadFile(filePath + ".wal")
+		assert.NoError(t, err)
+		data[len(data)-2] ^= 0xff
+		assert.NoError(t, os.WriteFile(filePath+".wal", data, 0666))
+
+		restored := &FileStorage{FileStoragePath: filePath}
+		assert.NoError(t, restored.NewStorage())
+		defer restored.FreeStorage()
+
+		metric, err := restored.Get(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(1), *metric.Delta)
+	})
+
+	t.Run("Success: Skip records already in snapshot", func(t *testing.T) {
+		filePath, cleanup := createTempFile(t)
+		defer cleanup()
+
+		storage := saveCounters(t, filePath, 2)
+		wal, err := os.ReadFile(filePath + ".wal")
+		assert.NoError(t, err)
+
+		assert.NoError(t, storage.FreeStorage())
+		assert.Equal(t, service.CounterMetricValue(2), *readWALSnapshot(t, filePath)["counter"].Delta)
+
+		info, err := os.Stat(filePath + ".wal")
+		assert.NoError(t, err)
+		assert.Zero(t, info.Size())
+
+		// Сбой между записью снимка и обрезкой журнала: журнал остался прежним.
+		assert.NoError(t, os.WriteFile(filePath+".wal", wal, 0666))
+
+		restored := &FileStorage{FileStoragePath: filePath}
+		assert.NoError(t, restored.NewStorage())
+		defer restored.FreeStorage()
+
+		metric, err := restored.Get(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(2), *metric.Delta)
+	})
+
+	t.Run("Success: Periodic compaction", func(t *testing.T) {
+		filePath, cleanup := createTempFile(t)
+		defer cleanup()
+
+		storage := &FileStorage{FileStoragePath: filePath, CompactInterval: 10 * time.Millisecond}
+		assert.NoError(t, storage.NewStorage())
+		defer storage.FreeStorage()
+
+		value := service.GaugeMetricValue(1.5)
+		assert.NoError(t, storage.Save(ctx, service.Metrics{ID: "gauge", MType: service.GaugeMetric, Value: &value}))
+
+		assert.Eventually(t, func() bool {
+			info, err := os.Stat(filePath)
+			return err == nil && info.Size() > 0
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected FsyncPolicy
+		wantErr  bool
+	}{
+		{value: "always", expected: FsyncAlways},
+		{value: "interval", expected: FsyncInterval},
+		{value: "never", expected: FsyncNever},
+		{value: "", expected: FsyncInterval},
+		{value: "sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			policy, err := ParseFsyncPolicy(tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidFsyncPolicy)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, policy)
+		})
+	}
+}
+
+// failingSync — файл журнала, сброс которого на диск завершается ошибкой.
+type failingSync struct {
+	*os.File
+}
+
+func (f failingSync) Sync() error { return errors.New("disk failure") }
+
+func TestFileSyncFailure(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	ctx := context.Background()
+	filePath, cleanup := createTempFile(t)
+	defer cleanup()
+
+	storage := &FileStorage{FileStoragePath: filePath, Fsync: FsyncAlways}
+	assert.NoError(t, storage.NewStorage())
+	delta := service.CounterMetricValue(5)
+	counter := service.Metrics{ID: "PollCount", MType: service.CounterMetric, Delta: &delta}
+	assert.NoError(t, storage.Save(ctx, counter))
+
+	storage.mu.Lock()
+	file := storage.file.(*os.File)
+	storage.file = failingSync{file}
+	storage.mu.Unlock()
+
+	value := service.GaugeMetricValue(1)
+	assert.Error(t, storage.Save(ctx, service.Metrics{ID: "Alloc", MType: service.GaugeMetric, Value: &value}))
+	assert.Error(t, storage.SaveAll(ctx, &[]service.Metrics{counter}))
+
+	_, err := storage.Get(ctx, "Alloc")
+	assert.ErrorIs(t, err, service.ErrUnknownMetric, "rejected write must not reach memory")
+	mt, err := storage.Get(ctx, "PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, service.CounterMetricValue(5), *mt.Delta)
+
+	storage.mu.Lock()
+	storage.file = file
+	storage.mu.Unlock()
+	assert.NoError(t, storage.FreeStorage())
+
+	// Отклоненные записи не должны воспроизвестись из журнала после перезапуска.
+	restored := &FileStorage{FileStoragePath: filePath}
+	assert.NoError(t, restored.NewStorage())
+	defer restored.FreeStorage()
+	_, err = restored.Get(ctx, "Alloc")
+	assert.ErrorIs(t, err, service.ErrUnknownMetric)
+	mt, err = restored.Get(ctx, "PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, service.CounterMetricValue(5), *mt.Delta)
+}
+
+// TestFileSnapshotModeSwitch проверяет, что режим -wal можно включать и выключать
+// на одном файле: SnapshotStorage и FileStorage читают снимки друг друга.
+func TestFileSnapshotModeSwitch(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	ctx := context.Background()
+	filePath, cleanup := createTempFile(t)
+	defer cleanup()
+
+	type modeStorage interface {
+		NewStorage() error
+		Save(ctx context.Context, mt service.Metrics) error
+		Get(ctx context.Context, metricName string) (*service.Metrics, error)
+		FreeStorage() error
+	}
+	modes := []func() modeStorage{
+		func() modeStorage { return &SnapshotStorage{FileStoragePath: filePath, Restore: true} },
+		func() modeStorage { return &FileStorage{FileStoragePath: filePath} },
+		func() modeStorage { return &SnapshotStorage{FileStoragePath: filePath, Restore: true} },
+		func() modeStorage { return &FileStorage{FileStoragePath: filePath} },
+	}
+	for i, mode := range modes {
+		storage := mode()
+		assert.NoError(t, storage.NewStorage(), "mode %d", i)
+		if i > 0 {
+			mt, err := storage.Get(ctx, "PollCount")
+			assert.NoError(t, err, "mode %d", i)
+			assert.Equal(t, service.CounterMetricValue(i), *mt.Delta, "mode %d", i)
+		}
+		delta := service.CounterMetricValue(1)
+		assert.NoError(t, storage.Save(ctx, service.Metrics{ID: "PollCount", MType: service.CounterMetric, Delta: &delta}))
+		assert.NoError(t, storage.FreeStorage())
+	}
+}
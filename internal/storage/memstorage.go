@@ -3,41 +3,133 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/dvkhr/metrix.git/internal/service"
 )
 
+// MemStorage хранит метрики в оперативной памяти.
+// Хранилище безопасно для конкурентного использования: чтения выполняются
+// под разделяемой блокировкой, записи — под исключительной.
 type MemStorage struct {
+	mu   sync.RWMutex
 	data map[string]service.Metrics
 }
 
 func (ms *MemStorage) NewStorage() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
 	ms.data = make(map[string]service.Metrics)
 	return nil
 }
 
 func (ms *MemStorage) Save(ctx context.Context, mt service.Metrics) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
 	if ms.data == nil {
 		return service.ErrUninitializedStorage
 	}
-	if len(mt.ID) == 0 {
-		return service.ErrInvalidMetricName
+	if err := checkBatch([]service.Metrics{mt}, ms.existingType); err != nil {
+		return err
 	}
-	if mt.MType == service.GaugeMetric {
-		ms.data[mt.ID] = mt
-	} else if mt.MType == service.CounterMetric {
-		if ms.data[mt.ID].Delta != nil {
-			*ms.data[mt.ID].Delta += *mt.Delta
-		} else {
-			ms.data[mt.ID] = mt
+	ms.apply(mt, time.Now())
+	return nil
+}
+
+// existingType возвращает тип сохраненной метрики; вызывающий должен удерживать ms.mu.
+func (ms *MemStorage) existingType(id string) (service.MetricType, bool) {
+	mt, ok := ms.data[id]
+	return mt.MType, ok
+}
+
+// apply записывает проверенную метрику в карту без блокировки; вызывающий должен удерживать ms.mu.
+// Значения копируются, поэтому хранилище не разделяет указатели Delta и Value с вызывающим.
+func (ms *MemStorage) apply(mt service.Metrics, now time.Time) {
+	ms.data[mt.ID] = merge(ms.data[mt.ID], mt, now)
+}
+
+// merge возвращает новое состояние метрики после записи mt поверх old: gauge заменяется,
+// приращение счетчика прибавляется к текущему значению. Метрика mt должна быть проверена
+// checkBatch, поэтому old либо пустая, либо того же типа.
+func merge(old, mt service.Metrics, now time.Time) service.Metrics {
+	if mt.MType == service.CounterMetric && old.Delta != nil {
+		sum := *old.Delta + *mt.Delta
+		old.Delta = &sum
+		return stamp(old, now)
+	}
+	return stamp(cloneMetric(mt), now)
+}
+
+// typeConflict возвращает ErrTypeConflict с именем метрики.
+func typeConflict(id string) error {
+	return fmt.Errorf("%w: %q already exists with another type", service.ErrTypeConflict, id)
+}
+
+// checkBatch проверяет пакет перед записью: у каждой метрики должны быть имя, известный тип
+// и значение этого типа (ErrInvalidMetricName), а тип не должен расходиться с уже сохраненной
+// метрикой или с другой метрикой пакета (ErrTypeConflict). existing возвращает тип
+// сохраненной метрики.
+func checkBatch(metrics []service.Metrics, existing func(id string) (service.MetricType, bool)) error {
+	types := make(map[string]service.MetricType, len(metrics))
+	for _, mt := range metrics {
+		switch {
+		case len(mt.ID) == 0:
+			return service.ErrInvalidMetricName
+		case mt.MType == service.GaugeMetric && mt.Value != nil:
+		case mt.MType == service.CounterMetric && mt.Delta != nil:
+		default:
+			return service.ErrInvalidMetricName
 		}
-	} else {
-		return service.ErrInvalidMetricName
+
+		mtype, ok := types[mt.ID]
+		if !ok {
+			mtype, ok = existing(mt.ID)
+		}
+		if ok && mtype != mt.MType {
+			return typeConflict(mt.ID)
+		}
+		types[mt.ID] = mt.MType
 	}
 	return nil
 }
 
+// checkReplacement проверяет метрику для Replace так же, как checkBatch, но без учета
+// типа сохраненной метрики: замена может его сменить.
+func checkReplacement(mt service.Metrics) error {
+	return checkBatch([]service.Metrics{mt}, func(string) (service.MetricType, bool) { return "", false })
+}
+
+// stamp возвращает метрику с временем изменения now.
+func stamp(mt service.Metrics, now time.Time) service.Metrics {
+	mt.UpdatedAt = &now
+	return mt
+}
+
+// cloneMetric возвращает копию метрики с собственными значениями Delta, Value и UpdatedAt.
+func cloneMetric(mt service.Metrics) service.Metrics {
+	if mt.Delta != nil {
+		delta := *mt.Delta
+		mt.Delta = &delta
+	}
+	if mt.Value != nil {
+		value := *mt.Value
+		mt.Value = &value
+	}
+	if mt.UpdatedAt != nil {
+		updatedAt := *mt.UpdatedAt
+		mt.UpdatedAt = &updatedAt
+	}
+	return mt
+}
+
 func (ms *MemStorage) Get(ctx context.Context, metricName string) (*service.Metrics, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
 	if ms.data == nil {
 		return nil, service.ErrUninitializedStorage
 	}
@@ -50,15 +142,26 @@ func (ms *MemStorage) Get(ctx context.Context, metricName string) (*service.Metr
 	return nil, service.ErrUnknownMetric
 }
 
+// List возвращает снимок всех метрик. Изменения возвращенной карты не влияют на хранилище.
 func (ms *MemStorage) List(ctx context.Context) (*map[string]service.Metrics, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
 	if ms.data == nil {
 		return nil, service.ErrUninitializedStorage
 	}
 
-	return &ms.data, nil
+	mtrx := make(map[string]service.Metrics, len(ms.data))
+	for id, metric := range ms.data {
+		mtrx[id] = metric
+	}
+	return &mtrx, nil
 }
 
 func (ms *MemStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
 	if ms.data == nil {
 		return nil, service.ErrUninitializedStorage
 	}
@@ -70,37 +173,138 @@ func (ms *MemStorage) ListSlice(ctx context.Context) ([]service.Metrics, error)
 	return metricsSlice, nil
 }
 
+func (ms *MemStorage) ListPage(ctx context.Context, q ListQuery) (*Page, error) {
+	metrics, err := ms.ListSlice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return listPage(metrics, q)
+}
+
 func (ms *MemStorage) FreeStorage() error {
 	return nil
 }
 
-func (ms *MemStorage) CheckStorage() error {
+func (ms *MemStorage) CheckStorage(ctx context.Context) error {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
 	if ms.data == nil {
 		return service.ErrUninitializedStorage
 	}
 	return nil
 }
 
+// SaveAll сохраняет пакет метрик атомарно: если хотя бы одна метрика некорректна
+// или конфликтует по типу, ни одна метрика пакета не записывается.
 func (ms *MemStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
 	if ms.data == nil {
 		return service.ErrUninitializedStorage
 	}
 	if len(*mt) == 0 {
 		return service.ErrInvalidMetricName
 	}
+	if err := checkBatch(*mt, ms.existingType); err != nil {
+		return err
+	}
+	now := time.Now()
 	for _, metric := range *mt {
-		if metric.MType == service.GaugeMetric {
-			ms.data[metric.ID] = metric
-		} else if metric.MType == service.CounterMetric {
-			if ms.data[metric.ID].Delta != nil {
-				*ms.data[metric.ID].Delta += *metric.Delta
-			} else {
-				ms.data[metric.ID] = metric
-			}
-		} else {
-			return service.ErrInvalidMetricName
+		ms.apply(metric, now)
+	}
+
+	return nil
+}
+
+// uniqueNames возвращает имена метрик без повторов, сохраняя порядок,
+// или ErrInvalidMetricName, если среди них есть пустое.
+func uniqueNames(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	unique := make([]string, 0, len(names))
+	for _, name := range names {
+		if len(name) == 0 {
+			return nil, service.ErrInvalidMetricName
+		}
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique, nil
+}
+
+// resetCounter возвращает копию счетчика с нулевым значением и временем изменения now;
+// ok равен false для gauge.
+func resetCounter(mt service.Metrics, now time.Time) (service.Metrics, bool) {
+	if mt.MType != service.CounterMetric {
+		return mt, false
+	}
+	var zero service.CounterMetricValue
+	mt.Delta = &zero
+	return stamp(mt, now), true
+}
+
+func (ms *MemStorage) Delete(ctx context.Context, metricNames ...string) (int, error) {
+	return ms.DeleteWhere(ctx, DeleteFilter{}, metricNames...)
+}
+
+func (ms *MemStorage) DeleteWhere(ctx context.Context, f DeleteFilter, metricNames ...string) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.data == nil {
+		return 0, service.ErrUninitializedStorage
+	}
+	metricNames, err := uniqueNames(metricNames)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, name := range metricNames {
+		if mt, ok := ms.data[name]; ok && f.match(mt) {
+			delete(ms.data, name)
+			n++
 		}
 	}
+	return n, nil
+}
+
+func (ms *MemStorage) Reset(ctx context.Context, metricNames ...string) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.data == nil {
+		return 0, service.ErrUninitializedStorage
+	}
+	metricNames, err := uniqueNames(metricNames)
+	if err != nil {
+		return 0, err
+	}
 
+	var n int
+	now := time.Now()
+	for _, name := range metricNames {
+		if mt, ok := resetCounter(ms.data[name], now); ok {
+			ms.data[name] = mt
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (ms *MemStorage) Replace(ctx context.Context, mt service.Metrics) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.data == nil {
+		return service.ErrUninitializedStorage
+	}
+	if err := checkReplacement(mt); err != nil {
+		return err
+	}
+	ms.data[mt.ID] = stamp(cloneMetric(mt), time.Now())
 	return nil
 }
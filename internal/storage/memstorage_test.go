@@ -2,6 +2,9 @@ package storage
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/dvkhr/metrix.git/internal/service"
@@ -300,7 +303,7 @@ func TestMemCheckStorage(t *testing.T) {
 	t.Run("Error: Uninitialized storage", func(t *testing.T) {
 		storage := &MemStorage{}
 
-		err := storage.CheckStorage()
+		err := storage.CheckStorage(context.Background())
 		assert.Error(t, err)
 		assert.Equal(t, service.ErrUninitializedStorage, err)
 	})
@@ -309,7 +312,7 @@ func TestMemCheckStorage(t *testing.T) {
 		storage := &MemStorage{}
 		_ = storage.NewStorage()
 
-		err := storage.CheckStorage()
+		err := storage.CheckStorage(context.Background())
 		assert.NoError(t, err)
 	})
 }
@@ -397,3 +400,107 @@ func TestMemSaveAll(t *testing.T) {
 		assert.Equal(t, service.ErrInvalidMetricName, err)
 	})
 }
+
+func TestMemConcurrentAccess(t *testing.T) {
+	ms := MemStorage{}
+	ms.NewStorage()
+	ctx := context.Background()
+
+	const workers = 16
+	const iterations = 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(3)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				delta := service.CounterMetricValue(1)
+				value := service.GaugeMetricValue(float64(w))
+				assert.NoError(t, ms.Save(ctx, service.Metrics{ID: "counter", MType: service.CounterMetric, Delta: &delta}))
+				assert.NoError(t, ms.Save(ctx, service.Metrics{ID: "gauge", MType: service.GaugeMetric, Value: &value}))
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				delta := service.CounterMetricValue(1)
+				batch := []service.Metrics{{ID: "counter", MType: service.CounterMetric, Delta: &delta}}
+				assert.NoError(t, ms.SaveAll(ctx, &batch))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				if m, err := ms.Get(ctx, "counter"); err == nil {
+					_ = *m.Delta
+				}
+				mtrx, err := ms.List(ctx)
+				assert.NoError(t, err)
+				for _, m := range *mtrx {
+					_ = m.ID
+				}
+				_, err = ms.ListSlice(ctx)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	counter, err := ms.Get(ctx, "counter")
+	assert.NoError(t, err)
+	assert.Equal(t, service.CounterMetricValue(2*workers*iterations), *counter.Delta)
+}
+
+func TestMemRestoreMetrics(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	source := &MemStorage{}
+	assert.NoError(t, source.NewStorage())
+	delta, value := service.CounterMetricValue(10), service.GaugeMetricValue(1.5)
+	assert.NoError(t, source.Save(ctx, service.Metrics{ID: "PollCount", MType: service.CounterMetric, Delta: &delta}))
+	assert.NoError(t, source.Save(ctx, service.Metrics{ID: "Alloc", MType: service.GaugeMetric, Value: &value}))
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	assert.NoError(t, service.DumpMetrics(source, f))
+	assert.NoError(t, f.Close())
+
+	restore := func(ms *MemStorage) {
+		f, err := os.Open(path)
+		assert.NoError(t, err)
+		defer f.Close()
+		assert.NoError(t, service.RestoreMetrics(ms, f))
+	}
+
+	t.Run("Restoring twice replaces counters", func(t *testing.T) {
+		ms := &MemStorage{}
+		assert.NoError(t, ms.NewStorage())
+		restore(ms)
+		restore(ms)
+
+		mt, err := ms.Get(ctx, "PollCount")
+		assert.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(10), *mt.Delta)
+		mt, err = ms.Get(ctx, "Alloc")
+		assert.NoError(t, err)
+		assert.Equal(t, service.GaugeMetricValue(1.5), *mt.Value)
+	})
+
+	t.Run("Restoring into a non-empty store", func(t *testing.T) {
+		ms := &MemStorage{}
+		assert.NoError(t, ms.NewStorage())
+		existing := service.CounterMetricValue(25)
+		assert.NoError(t, ms.Save(ctx, service.Metrics{ID: "PollCount", MType: service.CounterMetric, Delta: &existing}))
+		assert.NoError(t, ms.Save(ctx, service.Metrics{ID: "Alloc", MType: service.CounterMetric, Delta: &existing}))
+		restore(ms)
+
+		mt, err := ms.Get(ctx, "PollCount")
+		assert.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(10), *mt.Delta)
+		mt, err = ms.Get(ctx, "Alloc")
+		assert.NoError(t, err, "a metric of another type is replaced")
+		assert.Equal(t, service.GaugeMetric, mt.MType)
+		assert.Equal(t, service.GaugeMetricValue(1.5), *mt.Value)
+	})
+}
@@ -0,0 +1,51 @@
+// Package storage предоставляет реализации хранилищ метрик для различных типов данных.
+package storage
+
+import (
+	"embed"
+	"io/fs"
+
+	"github.com/dvkhr/metrix.git/internal/migrate"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrationsFS embed.FS
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrationsFS embed.FS
+
+// PostgresMigrations возвращает встроенные миграции схемы PostgreSQL.
+func PostgresMigrations() ([]migrate.Migration, error) {
+	sub, err := fs.Sub(postgresMigrationsFS, "migrations/postgres")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.Load(sub)
+}
+
+// NewPostgresMigrator создает мигратор схемы PostgreSQL для соединения db.
+func NewPostgresMigrator(db migrate.DB) (*migrate.Migrator, error) {
+	migrations, err := PostgresMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db, migrate.Postgres, migrations), nil
+}
+
+// SQLiteMigrations возвращает встроенные миграции схемы SQLite.
+func SQLiteMigrations() ([]migrate.Migration, error) {
+	sub, err := fs.Sub(sqliteMigrationsFS, "migrations/sqlite")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.Load(sub)
+}
+
+// NewSQLiteMigrator создает мигратор схемы SQLite для соединения db.
+func NewSQLiteMigrator(db migrate.DB) (*migrate.Migrator, error) {
+	migrations, err := SQLiteMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db, migrate.SQLite, migrations), nil
+}
@@ -0,0 +1,4 @@
+create table if not exists metrix (
+    id varchar(32) primary key,
+    value jsonb not null
+);
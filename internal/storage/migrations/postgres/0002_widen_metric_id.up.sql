@@ -0,0 +1 @@
+alter table metrix alter column id type varchar(255);
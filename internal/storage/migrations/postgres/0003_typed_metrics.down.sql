@@ -0,0 +1,17 @@
+create table metrix_jsonb (
+    id varchar(255) not null,
+    value jsonb not null,
+    constraint metrix_jsonb_pkey primary key (id)
+);
+
+insert into metrix_jsonb (id, value)
+select id,
+       case mtype
+           when 'gauge' then jsonb_build_object('id', id, 'type', mtype, 'value', value)
+           else jsonb_build_object('id', id, 'type', mtype, 'delta', delta)
+       end
+from metrix;
+
+drop table metrix;
+alter table metrix_jsonb rename to metrix;
+alter index metrix_jsonb_pkey rename to metrix_pkey;
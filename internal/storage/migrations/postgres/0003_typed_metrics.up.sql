@@ -0,0 +1,30 @@
+create table metrix_typed (
+    id varchar(255) not null,
+    mtype varchar(16) not null,
+    delta bigint,
+    value double precision,
+    labels jsonb,
+    updated_at timestamptz not null default now(),
+    constraint metrix_typed_pkey primary key (id),
+    constraint metrix_mtype_check check (mtype in ('gauge', 'counter')),
+    constraint metrix_value_check check (
+        (mtype = 'gauge' and value is not null and delta is null)
+        or (mtype = 'counter' and delta is not null and value is null)
+    )
+);
+
+insert into metrix_typed (id, mtype, delta, value)
+select id,
+       value ->> 'type',
+       case when value ->> 'type' = 'counter' then (value ->> 'delta')::bigint end,
+       case when value ->> 'type' = 'gauge' then (value ->> 'value')::double precision end
+from metrix
+where (value ->> 'type' = 'gauge' and jsonb_typeof(value -> 'value') = 'number')
+   or (value ->> 'type' = 'counter' and jsonb_typeof(value -> 'delta') = 'number');
+
+drop table metrix;
+alter table metrix_typed rename to metrix;
+alter index metrix_typed_pkey rename to metrix_pkey;
+
+create index metrix_mtype_id_idx on metrix (mtype, id);
+create index metrix_updated_at_idx on metrix (updated_at);
@@ -0,0 +1,15 @@
+create table if not exists metrix (
+    id text not null primary key,
+    mtype text not null check (mtype in ('gauge', 'counter')),
+    delta integer,
+    value real,
+    labels text,
+    updated_at timestamp not null default current_timestamp,
+    check (
+        (mtype = 'gauge' and value is not null and delta is null)
+        or (mtype = 'counter' and delta is not null and value is null)
+    )
+);
+
+create index if not exists metrix_mtype_id_idx on metrix (mtype, id);
+create index if not exists metrix_updated_at_idx on metrix (updated_at);
@@ -0,0 +1,466 @@
+// Package storage предоставляет реализации хранилищ метрик для различных типов данных.
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// MetricsNotifyChannel — канал LISTEN/NOTIFY, в который PgxStorage публикует id измененных метрик.
+const MetricsNotifyChannel = "metrix_updates"
+
+// maxNotifyPayload — предел размера уведомления; Postgres не принимает payload длиннее 8000 байт.
+const maxNotifyPayload = 7900
+
+// pgxSchemes — схемы DSN, которые выбирают PgxStorage вместо DBStorage.
+var pgxSchemes = []string{"pgx://", "pgxpool://"}
+
+// PgxDSN распознает DSN со схемой pgx:// или pgxpool:// и возвращает его
+// в виде postgres://, понятном драйверу.
+//
+// Возвращаемые значения:
+// - string: DSN для подключения (исходный, если схема не распознана).
+// - bool: true, если DSN требует хранилища на pgxpool.
+func PgxDSN(dsn string) (string, bool) {
+	for _, scheme := range pgxSchemes {
+		if strings.HasPrefix(dsn, scheme) {
+			return "postgres://" + strings.TrimPrefix(dsn, scheme), true
+		}
+	}
+	return dsn, false
+}
+
+// PgxStorage хранит метрики в PostgreSQL через пул соединений pgxpool, минуя database/sql.
+//
+// В отличие от DBStorage, хранилище не проверяет соединение перед каждой операцией:
+// пул сам отбраковывает разорванные соединения и проверяет простаивающие раз в
+// HealthCheckPeriod. Запись и уведомление об изменении отправляются одним пакетом
+// pgx.Batch за один сетевой обмен и выполняются в одной неявной транзакции.
+//
+// Поля:
+//   - DBDSN: Строка подключения к PostgreSQL.
+//   - MaxConns, MinConns: Размер пула (0 — значение из DSN или по умолчанию pgxpool).
+//   - HealthCheckPeriod: Период проверки простаивающих соединений (0 — по умолчанию).
+type PgxStorage struct {
+	DBDSN             string
+	MaxConns          int32
+	MinConns          int32
+	HealthCheckPeriod time.Duration
+
+	pool *pgxpool.Pool
+}
+
+func (ms *PgxStorage) NewStorage() error {
+	ctx := context.Background()
+
+	poolConfig, err := pgxpool.ParseConfig(ms.DBDSN)
+	if err != nil {
+		return err
+	}
+	if ms.MaxConns > 0 {
+		poolConfig.MaxConns = ms.MaxConns
+	}
+	if ms.MinConns > 0 {
+		poolConfig.MinConns = ms.MinConns
+	}
+	if ms.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = ms.HealthCheckPeriod
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return err
+	}
+	err = retry(ctx, "ping", func() error {
+		return pool.Ping(ctx)
+	}, 3)
+	if err != nil {
+		pool.Close()
+		return err
+	}
+
+	// Мигратор работает через database/sql; соединения берутся из того же пула.
+	db := stdlib.OpenDBFromPool(pool)
+	defer db.Close()
+
+	migrator, err := NewPostgresMigrator(db)
+	if err != nil {
+		pool.Close()
+		return err
+	}
+	err = retry(ctx, "migrate", func() error {
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			logging.Logg.Info("Applied schema migration", "version", m.Version, "name", m.Name)
+		}
+		return err
+	}, 3)
+	if err != nil {
+		pool.Close()
+		return err
+	}
+
+	ms.pool = pool
+	return nil
+}
+
+// notifyQuery публикует уведомление об изменении метрик; доставляется после фиксации транзакции.
+const notifyQuery = "select pg_notify($1, $2)"
+
+// notifyPayload возвращает payload уведомления об изменении метрик ids.
+// Если список не помещается в уведомление, возвращается пустой payload — «изменилось все».
+func notifyPayload(ids []string) string {
+	payload, err := json.Marshal(ids)
+	if err != nil || len(payload) > maxNotifyPayload {
+		return ""
+	}
+	return string(payload)
+}
+
+// queueNotify добавляет в пакет уведомление об изменении метрик ids.
+func queueNotify(batch *pgx.Batch, ids []string) {
+	batch.Queue(notifyQuery, MetricsNotifyChannel, notifyPayload(ids))
+}
+
+// sendBatch выполняет пакет и возвращает число строк, затронутых каждым запросом,
+// или первую ошибку, включая ошибку фиксации неявной транзакции.
+func sendBatch(ctx context.Context, conn interface {
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}, batch *pgx.Batch) ([]int64, error) {
+	br := conn.SendBatch(ctx, batch)
+	affected := make([]int64, batch.Len())
+	for i := range affected {
+		tag, err := br.Exec()
+		if err != nil {
+			br.Close()
+			return nil, err
+		}
+		affected[i] = tag.RowsAffected()
+	}
+	return affected, br.Close()
+}
+
+func (ms *PgxStorage) Save(ctx context.Context, mt service.Metrics) error {
+	if ms.pool == nil {
+		return service.ErrUninitializedStorage
+	}
+	if len(mt.ID) == 0 {
+		return service.ErrInvalidMetricName
+	}
+
+	batch := &pgx.Batch{}
+	switch {
+	case mt.MType == service.GaugeMetric && mt.Value != nil:
+		batch.Queue(saveGaugeQuery, mt.ID, string(mt.MType), float64(*mt.Value))
+	case mt.MType == service.CounterMetric && mt.Delta != nil:
+		batch.Queue(saveCounterQuery, mt.ID, string(mt.MType), int64(*mt.Delta))
+	default:
+		return service.ErrInvalidMetricName
+	}
+	queueNotify(batch, []string{mt.ID})
+
+	var affected []int64
+	err := retry(ctx, "save", func() error {
+		var err error
+		affected, err = sendBatch(ctx, ms.pool, batch)
+		return err
+	}, 1)
+	if err != nil {
+		return err
+	}
+	if affected[0] == 0 {
+		return typeConflict(mt.ID)
+	}
+	return nil
+}
+
+// SaveAll записывает пакет метрик одним конвейером pgx.Batch: upsert для каждого типа
+// и уведомление выполняются в явной транзакции, которая откатывается, если часть метрик
+// пакета имеет в базе другой тип (ErrTypeConflict). Как и в DBStorage, ошибка
+// транзакции не повторяется, чтобы не учесть приращения счетчиков дважды.
+func (ms *PgxStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
+	if ms.pool == nil {
+		return service.ErrUninitializedStorage
+	}
+	if len(*mt) == 0 {
+		return service.ErrInvalidMetricName
+	}
+
+	mb, err := aggregateBatch(*mt)
+	if err != nil {
+		return err
+	}
+
+	type upsert struct {
+		mtype service.MetricType
+		ids   []string
+	}
+	var upserts []upsert
+	batch := &pgx.Batch{}
+	if len(mb.gaugeIDs) > 0 {
+		batch.Queue(saveGaugeBatchQuery, mb.gaugeIDs, mb.gaugeValues)
+		upserts = append(upserts, upsert{service.GaugeMetric, mb.gaugeIDs})
+	}
+	if len(mb.counterIDs) > 0 {
+		batch.Queue(saveCounterBatchQuery, mb.counterIDs, mb.counterDeltas)
+		upserts = append(upserts, upsert{service.CounterMetric, mb.counterIDs})
+	}
+	queueNotify(batch, mb.ids())
+
+	err = retry(ctx, "save_batch", func() error {
+		return pgx.BeginFunc(ctx, ms.pool, func(tx pgx.Tx) error {
+			affected, err := sendBatch(ctx, tx, batch)
+			if err != nil {
+				return err
+			}
+			for i, u := range upserts {
+				if affected[i] < int64(len(u.ids)) {
+					var id string
+					if err := tx.QueryRow(ctx, conflictQuery, u.ids, string(u.mtype)).Scan(&id); err != nil {
+						return err
+					}
+					return typeConflict(id)
+				}
+			}
+			return nil
+		})
+	}, 1)
+	if errors.Is(err, service.ErrTypeConflict) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save metrics batch: %w", err)
+	}
+	return nil
+}
+
+func (ms *PgxStorage) Get(ctx context.Context, metricName string) (*service.Metrics, error) {
+	if ms.pool == nil {
+		return nil, service.ErrUninitializedStorage
+	}
+	if len(metricName) == 0 {
+		return nil, service.ErrInvalidMetricName
+	}
+
+	var mtrx service.Metrics
+	err := retry(ctx, "get", func() error {
+		var err error
+		mtrx, err = scanMetric(ms.pool.QueryRow(ctx, getQuery, metricName))
+		return err
+	}, 3)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, service.ErrUnknownMetric
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mtrx, nil
+}
+
+func (ms *PgxStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
+	if ms.pool == nil {
+		return nil, service.ErrUninitializedStorage
+	}
+
+	var metrics []service.Metrics
+	err := retry(ctx, "list", func() error {
+		rows, err := ms.pool.Query(ctx, listQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		metrics = make([]service.Metrics, 0)
+		for rows.Next() {
+			metric, err := scanMetric(rows)
+			if err != nil {
+				return err
+			}
+			metrics = append(metrics, metric)
+		}
+		return rows.Err()
+	}, 3)
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// ListPage выполняет выборку одним запросом, как DBStorage.ListPage.
+func (ms *PgxStorage) ListPage(ctx context.Context, q ListQuery) (*Page, error) {
+	if ms.pool == nil {
+		return nil, service.ErrUninitializedStorage
+	}
+	p, err := q.plan()
+	if err != nil {
+		return nil, err
+	}
+
+	query, args := p.sql(postgresDialect)
+	var metrics []service.Metrics
+	err = retry(ctx, "list_page", func() error {
+		rows, err := ms.pool.Query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		metrics, err = scanMetrics(rows)
+		return err
+	}, 3)
+	if err != nil {
+		return nil, err
+	}
+	return p.page(metrics), nil
+}
+
+func (ms *PgxStorage) List(ctx context.Context) (*map[string]service.Metrics, error) {
+	metrics, err := ms.ListSlice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mtrx := make(map[string]service.Metrics, len(metrics))
+	for _, metric := range metrics {
+		mtrx[metric.ID] = metric
+	}
+	return &mtrx, nil
+}
+
+func (ms *PgxStorage) FreeStorage() error {
+	if ms.pool != nil {
+		ms.pool.Close()
+	}
+	return nil
+}
+
+func (ms *PgxStorage) CheckStorage(ctx context.Context) error {
+	if ms.pool == nil {
+		return service.ErrUninitializedStorage
+	}
+	return retry(ctx, "ping", func() error {
+		return ms.pool.Ping(ctx)
+	}, 3)
+}
+
+func (ms *PgxStorage) Delete(ctx context.Context, metricNames ...string) (int, error) {
+	return ms.DeleteWhere(ctx, DeleteFilter{}, metricNames...)
+}
+
+func (ms *PgxStorage) DeleteWhere(ctx context.Context, f DeleteFilter, metricNames ...string) (int, error) {
+	return ms.modify(ctx, "delete", deleteQuery, metricNames, string(f.MType), f.updatedBefore())
+}
+
+func (ms *PgxStorage) Reset(ctx context.Context, metricNames ...string) (int, error) {
+	return ms.modify(ctx, "reset", resetQuery, metricNames)
+}
+
+// Replace записывает метрику одним upsert (replaceQuery) в одном пакете с уведомлением.
+func (ms *PgxStorage) Replace(ctx context.Context, mt service.Metrics) error {
+	if ms.pool == nil {
+		return service.ErrUninitializedStorage
+	}
+	if err := checkReplacement(mt); err != nil {
+		return err
+	}
+
+	delta, value := replaceArgs(mt)
+	batch := &pgx.Batch{}
+	batch.Queue(replaceQuery, mt.ID, string(mt.MType), delta, value)
+	queueNotify(batch, []string{mt.ID})
+
+	return retry(ctx, "replace", func() error {
+		_, err := sendBatch(ctx, ms.pool, batch)
+		return err
+	}, 3)
+}
+
+// modify выполняет query с аргументами names и args и уведомление одним пакетом pgx.Batch
+// и возвращает число затронутых строк.
+func (ms *PgxStorage) modify(ctx context.Context, op, query string, names []string, args ...any) (int, error) {
+	if ms.pool == nil {
+		return 0, service.ErrUninitializedStorage
+	}
+	names, err := uniqueNames(names)
+	if err != nil || len(names) == 0 {
+		return 0, err
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(query, append([]any{names}, args...)...)
+	queueNotify(batch, names)
+
+	var affected int64
+	err = retry(ctx, op, func() error {
+		br := ms.pool.SendBatch(ctx, batch)
+		tag, err := br.Exec()
+		if err != nil {
+			br.Close()
+			return err
+		}
+		affected = tag.RowsAffected()
+		if _, err := br.Exec(); err != nil {
+			br.Close()
+			return err
+		}
+		return br.Close()
+	}, 1)
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// Listen подписывается на канал MetricsNotifyChannel и вызывает handler для каждого
+// уведомления с id измененных метрик; nil означает, что изменились все метрики.
+// Уведомления публикуются любыми экземплярами PgxStorage и DBStorage, работающими с той же базой.
+// Метод блокируется до отмены ctx или ошибки соединения и держит одно соединение пула.
+func (ms *PgxStorage) Listen(ctx context.Context, handler func(ids []string)) error {
+	if ms.pool == nil {
+		return service.ErrUninitializedStorage
+	}
+
+	conn, err := ms.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	return listen(ctx, conn.Conn(), handler)
+}
+
+// listen выполняет LISTEN на соединении conn и передает уведомления в handler до отмены ctx.
+func listen(ctx context.Context, conn *pgx.Conn, handler func(ids []string)) error {
+	if _, err := conn.Exec(ctx, "listen "+MetricsNotifyChannel); err != nil {
+		return err
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), "unlisten "+MetricsNotifyChannel)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		var ids []string
+		if notification.Payload != "" {
+			if err := json.Unmarshal([]byte(notification.Payload), &ids); err != nil {
+				ids = nil
+			}
+		}
+		handler(ids)
+	}
+}
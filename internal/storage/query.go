@@ -0,0 +1,371 @@
+// Package storage предоставляет реализации хранилищ метрик для различных типов данных.
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+)
+
+// ErrInvalidQuery возвращается ListPage для некорректных параметров выборки: неизвестного
+// способа сравнения имени или сортировки, ошибки в шаблоне, поврежденного курсора.
+var ErrInvalidQuery = errors.New("invalid list query")
+
+// MatchMode — способ сравнения имени метрики с шаблоном ListQuery.Pattern.
+type MatchMode string
+
+const (
+	// MatchPrefix отбирает метрики, имя которых начинается с шаблона.
+	MatchPrefix MatchMode = "prefix"
+
+	// MatchGlob отбирает метрики по шаблону в синтаксисе path.Match: "*", "?", "[a-z]".
+	MatchGlob MatchMode = "glob"
+
+	// MatchRegex отбирает метрики по регулярному выражению. Выражение не привязано
+	// к началу и концу имени. Хранилища PostgreSQL выполняют его на стороне базы,
+	// поэтому следует ограничиваться синтаксисом, общим для RE2 и PostgreSQL.
+	MatchRegex MatchMode = "regex"
+)
+
+// SortOrder — порядок метрик в выборке ListPage.
+type SortOrder string
+
+const (
+	// SortByName упорядочивает метрики по имени (побайтово). Порядок по умолчанию.
+	SortByName SortOrder = "name"
+
+	// SortByNameDesc упорядочивает метрики по имени в обратном порядке.
+	SortByNameDesc SortOrder = "-name"
+
+	// SortByType упорядочивает метрики по типу, а метрики одного типа — по имени.
+	SortByType SortOrder = "type"
+
+	// SortByTypeDesc — обратный порядок SortByType.
+	SortByTypeDesc SortOrder = "-type"
+)
+
+// ListQuery — параметры выборки метрик для ListPage. Нулевое значение выбирает все
+// метрики по имени без ограничения размера страницы.
+//
+// Поля:
+//   - Pattern: Шаблон имени; пустой шаблон не ограничивает выборку.
+//   - Match: Способ сравнения с шаблоном (по умолчанию MatchPrefix).
+//   - Type: Тип метрик; пустой — все типы.
+//   - Limit: Размер страницы; 0 — без ограничения.
+//   - Cursor: Курсор Page.NextCursor предыдущей страницы; пустой — с начала.
+//   - Sort: Порядок метрик (по умолчанию SortByName).
+type ListQuery struct {
+	Pattern string
+	Match   MatchMode
+	Type    service.MetricType
+	Limit   int
+	Cursor  string
+	Sort    SortOrder
+}
+
+// Page — страница выборки ListPage.
+//
+// Поля:
+//   - Metrics: Метрики страницы в порядке ListQuery.Sort.
+//   - NextCursor: Курсор следующей страницы; пуст, если страница последняя.
+type Page struct {
+	Metrics    []service.Metrics
+	NextCursor string
+}
+
+// cursorKey — ключ последней метрики страницы, с которого продолжается выборка.
+type cursorKey struct {
+	mtype service.MetricType
+	id    string
+}
+
+func (k cursorKey) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(string(k.mtype) + ":" + k.id))
+}
+
+func decodeCursor(cursor string) (cursorKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return cursorKey{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	mtype, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return cursorKey{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return cursorKey{mtype: service.MetricType(mtype), id: id}, nil
+}
+
+// listPlan — проверенный ListQuery, готовый к выполнению в памяти или в SQL.
+type listPlan struct {
+	q      ListQuery
+	re     *regexp.Regexp // для MatchGlob и MatchRegex
+	expr   string         // исходный текст re для SQL
+	after  *cursorKey
+	byType bool
+	desc   bool
+}
+
+// plan проверяет запрос и подставляет значения по умолчанию.
+func (q ListQuery) plan() (*listPlan, error) {
+	p := &listPlan{q: q}
+	if p.q.Match == "" {
+		p.q.Match = MatchPrefix
+	}
+	if p.q.Sort == "" {
+		p.q.Sort = SortByName
+	}
+	if p.q.Limit < 0 {
+		return nil, fmt.Errorf("%w: negative limit", ErrInvalidQuery)
+	}
+	if p.q.Type != "" && p.q.Type != service.GaugeMetric && p.q.Type != service.CounterMetric {
+		return nil, fmt.Errorf("%w: unknown metric type %q", ErrInvalidQuery, p.q.Type)
+	}
+
+	switch p.q.Sort {
+	case SortByName, SortByNameDesc, SortByType, SortByTypeDesc:
+		p.byType = p.q.Sort == SortByType || p.q.Sort == SortByTypeDesc
+		p.desc = p.q.Sort == SortByNameDesc || p.q.Sort == SortByTypeDesc
+	default:
+		return nil, fmt.Errorf("%w: unknown sort order %q", ErrInvalidQuery, p.q.Sort)
+	}
+
+	if p.q.Pattern != "" {
+		var err error
+		switch p.q.Match {
+		case MatchPrefix:
+		case MatchGlob:
+			p.expr, err = globToRegexp(p.q.Pattern)
+		case MatchRegex:
+			p.expr = p.q.Pattern
+		default:
+			return nil, fmt.Errorf("%w: unknown match mode %q", ErrInvalidQuery, p.q.Match)
+		}
+		if err == nil && p.expr != "" {
+			p.re, err = regexp.Compile(p.expr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s pattern %q: %v", ErrInvalidQuery, p.q.Match, p.q.Pattern, err)
+		}
+	}
+
+	if p.q.Cursor != "" {
+		key, err := decodeCursor(p.q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		p.after = &key
+	}
+	return p, nil
+}
+
+// globToRegexp переводит шаблон path.Match в эквивалентное регулярное выражение,
+// которое понимают и RE2, и PostgreSQL: "*" и "?" не совпадают с "/", как в path.Match.
+func globToRegexp(glob string) (string, error) {
+	if _, err := path.Match(glob, ""); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			i++
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '[':
+			b.WriteString("[")
+			i++
+			if runes[i] == '^' {
+				b.WriteString("^/")
+				i++
+			}
+			for ; runes[i] != ']'; i++ {
+				if runes[i] == '-' {
+					b.WriteString("-")
+					continue
+				}
+				if runes[i] == '\\' {
+					i++
+				}
+				b.WriteString(quoteClassRune(runes[i]))
+			}
+			b.WriteString("]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String(), nil
+}
+
+// quoteClassRune экранирует символ внутри класса символов регулярного выражения.
+func quoteClassRune(r rune) string {
+	if strings.ContainsRune(`\]^-[`, r) {
+		return `\` + string(r)
+	}
+	return string(r)
+}
+
+// Matcher возвращает проверку, входит ли метрика в выборку по шаблону имени и типу.
+// Курсор, сортировка и размер страницы не учитываются. Ошибка оборачивает ErrInvalidQuery.
+func (q ListQuery) Matcher() (func(service.Metrics) bool, error) {
+	p, err := q.plan()
+	if err != nil {
+		return nil, err
+	}
+	return p.match, nil
+}
+
+// match сообщает, входит ли метрика в выборку без учета курсора.
+func (p *listPlan) match(mt service.Metrics) bool {
+	if p.q.Type != "" && mt.MType != p.q.Type {
+		return false
+	}
+	switch {
+	case p.q.Pattern == "":
+		return true
+	case p.re != nil:
+		return p.re.MatchString(mt.ID)
+	default:
+		return strings.HasPrefix(mt.ID, p.q.Pattern)
+	}
+}
+
+// less сравнивает ключи сортировки метрик a и b без учета направления.
+func (p *listPlan) less(a, b cursorKey) bool {
+	if p.byType && a.mtype != b.mtype {
+		return a.mtype < b.mtype
+	}
+	return a.id < b.id
+}
+
+// follows сообщает, идет ли метрика после курсора в порядке выборки.
+func (p *listPlan) follows(mt service.Metrics) bool {
+	if p.after == nil {
+		return true
+	}
+	key := cursorKey{mtype: mt.MType, id: mt.ID}
+	if p.desc {
+		return p.less(key, *p.after)
+	}
+	return p.less(*p.after, key)
+}
+
+// page отрезает от упорядоченных метрик, прочитанных с запасом в одну, страницу
+// размером Limit и вычисляет курсор следующей.
+func (p *listPlan) page(metrics []service.Metrics) *Page {
+	if p.q.Limit == 0 || len(metrics) <= p.q.Limit {
+		return &Page{Metrics: metrics}
+	}
+	metrics = metrics[:p.q.Limit]
+	last := metrics[len(metrics)-1]
+	return &Page{Metrics: metrics, NextCursor: cursorKey{mtype: last.MType, id: last.ID}.encode()}
+}
+
+// listPage выполняет запрос над полным списком метрик в памяти. Используется
+// хранилищами, которые держат все метрики в памяти, и обертками без собственного индекса.
+func listPage(metrics []service.Metrics, q ListQuery) (*Page, error) {
+	p, err := q.plan()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]service.Metrics, 0)
+	for _, mt := range metrics {
+		if p.match(mt) && p.follows(mt) {
+			selected = append(selected, mt)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		a := cursorKey{mtype: selected[i].MType, id: selected[i].ID}
+		b := cursorKey{mtype: selected[j].MType, id: selected[j].ID}
+		if p.desc {
+			return p.less(b, a)
+		}
+		return p.less(a, b)
+	})
+	return p.page(selected), nil
+}
+
+// sqlDialect описывает различия SQL-диалектов, существенные для запроса выборки.
+type sqlDialect struct {
+	placeholder func(n int) string // параметр запроса с номером n (с единицы)
+	text        string             // приведение параметра к строке для перегруженных функций
+	regexOp     string             // оператор сравнения с регулярным выражением
+	collate     string             // побайтовое сравнение строк
+}
+
+var (
+	postgresDialect = sqlDialect{
+		placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+		text:        "::text",
+		regexOp:     "~",
+		collate:     ` collate "C"`,
+	}
+
+	// sqliteDialect использует функцию regexp, которую регистрирует SQLiteStorage.
+	sqliteDialect = sqlDialect{
+		placeholder: func(int) string { return "?" },
+		regexOp:     "regexp",
+	}
+)
+
+// sql строит запрос выборки в диалекте d. Запрос читает на одну метрику больше Limit,
+// чтобы page могла определить, есть ли следующая страница.
+func (p *listPlan) sql(d sqlDialect) (string, []any) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return d.placeholder(len(args))
+	}
+
+	id := "id" + d.collate
+	var where []string
+	if p.q.Type != "" {
+		where = append(where, "mtype = "+arg(string(p.q.Type)))
+	}
+	switch {
+	case p.q.Pattern == "":
+	case p.re != nil:
+		where = append(where, fmt.Sprintf("id %s %s", d.regexOp, arg(p.expr)))
+	default:
+		where = append(where, fmt.Sprintf("substr(id, 1, length(%s%s)) = %s%s", arg(p.q.Pattern), d.text, arg(p.q.Pattern), d.text))
+	}
+	op, dir := ">", ""
+	if p.desc {
+		op, dir = "<", " desc"
+	}
+	if p.after != nil {
+		if p.byType {
+			where = append(where, fmt.Sprintf("(mtype, %s) %s (%s, %s)", id, op, arg(string(p.after.mtype)), arg(p.after.id)))
+		} else {
+			where = append(where, fmt.Sprintf("%s %s %s", id, op, arg(p.after.id)))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("select id, mtype, delta, value, updated_at from metrix")
+	if len(where) > 0 {
+		b.WriteString(" where " + strings.Join(where, " and "))
+	}
+	b.WriteString(" order by ")
+	if p.byType {
+		b.WriteString("mtype" + dir + ", ")
+	}
+	b.WriteString(id + dir)
+	if p.q.Limit > 0 {
+		b.WriteString(" limit " + arg(p.q.Limit+1))
+	}
+	return b.String(), args
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob    string
+		match   []string
+		noMatch []string
+	}{
+		{glob: "Heap*", match: []string{"Heap", "HeapAlloc"}, noMatch: []string{"xHeap", "Heap/x"}},
+		{glob: "Gc?", match: []string{"GcA"}, noMatch: []string{"Gc", "GcAB"}},
+		{glob: "cpu[0-9]", match: []string{"cpu1"}, noMatch: []string{"cpu", "cpux"}},
+		{glob: "cpu[^0-9]", match: []string{"cpux"}, noMatch: []string{"cpu1", "cpu/"}},
+		{glob: "a.b", match: []string{"a.b"}, noMatch: []string{"axb"}},
+		{glob: `a\*`, match: []string{"a*"}, noMatch: []string{"ab"}},
+		{glob: `[\]x]`, match: []string{"]", "x"}, noMatch: []string{"\\"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			expr, err := globToRegexp(tt.glob)
+			require.NoError(t, err)
+			re := regexp.MustCompile(expr)
+			for _, s := range tt.match {
+				assert.True(t, re.MatchString(s), "%q must match %q (%s)", tt.glob, s, expr)
+			}
+			for _, s := range tt.noMatch {
+				assert.False(t, re.MatchString(s), "%q must not match %q (%s)", tt.glob, s, expr)
+			}
+		})
+	}
+
+	_, err := globToRegexp("cpu[")
+	assert.Error(t, err)
+}
+
+func TestListQueryPlan(t *testing.T) {
+	for name, q := range map[string]ListQuery{
+		"Unknown match mode": {Pattern: "a", Match: "suffix"},
+		"Unknown sort order": {Sort: "value"},
+		"Unknown type":       {Type: "histogram"},
+		"Negative limit":     {Limit: -1},
+		"Bad glob":           {Pattern: "[", Match: MatchGlob},
+		"Bad regex":          {Pattern: "(", Match: MatchRegex},
+		"Malformed cursor":   {Cursor: "%%%"},
+		"Cursor without key": {Cursor: "Zm9v"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := q.plan()
+			assert.ErrorIs(t, err, ErrInvalidQuery)
+		})
+	}
+
+	t.Run("SQL", func(t *testing.T) {
+		p, err := ListQuery{Pattern: "Heap", Type: service.GaugeMetric, Limit: 10, Sort: SortByTypeDesc,
+			Cursor: cursorKey{mtype: service.GaugeMetric, id: "HeapA"}.encode()}.plan()
+		require.NoError(t, err)
+		query, args := p.sql(postgresDialect)
+		assert.Equal(t, `select id, mtype, delta, value, updated_at from metrix where mtype = $1 and substr(id, 1, length($2::text)) = $3::text and (mtype, id collate "C") < ($4, $5) order by mtype desc, id collate "C" desc limit $6`, query)
+		assert.Equal(t, []any{"gauge", "Heap", "Heap", "gauge", "HeapA", 11}, args)
+
+		p, err = ListQuery{Pattern: "^Heap", Match: MatchRegex}.plan()
+		require.NoError(t, err)
+		query, args = p.sql(sqliteDialect)
+		assert.Equal(t, "select id, mtype, delta, value, updated_at from metrix where id regexp ? order by id", query)
+		assert.Equal(t, []any{"^Heap"}, args)
+	})
+}
+
+// TestListPage проверяет, что выборка одинакова во всех хранилищах, включая
+// выполняемую в SQL, и в обертках.
+func TestListPage(t *testing.T) {
+	require.NoError(t, logging.InitTestLogger())
+
+	factories := conformanceFactories()
+	factories["CachedStorage"] = func(t *testing.T) conformanceStorage {
+		return &CachedStorage{MetricStorage: &SQLiteStorage{Path: filepath.Join(t.TempDir(), "metrix.db")}}
+	}
+	factories["ExpiringStorage"] = func(t *testing.T) conformanceStorage {
+		return &ExpiringStorage{MetricStorage: &SQLiteStorage{Path: filepath.Join(t.TempDir(), "metrix.db")}, TTL: time.Hour}
+	}
+
+	ctx := context.Background()
+	for name, factory := range factories {
+		t.Run(name, func(t *testing.T) {
+			storage := factory(t)
+			require.NoError(t, storage.NewStorage())
+			defer storage.FreeStorage()
+
+			value := service.GaugeMetricValue(1)
+			delta := service.CounterMetricValue(1)
+			metrics := []service.Metrics{
+				{ID: "HeapAlloc", MType: service.GaugeMetric, Value: &value},
+				{ID: "HeapIdle", MType: service.GaugeMetric, Value: &value},
+				{ID: "Alloc", MType: service.GaugeMetric, Value: &value},
+				{ID: "cpu1", MType: service.GaugeMetric, Value: &value},
+				{ID: "cpu2", MType: service.GaugeMetric, Value: &value},
+				{ID: "PollCount", MType: service.CounterMetric, Delta: &delta},
+				{ID: "Heap_requests", MType: service.CounterMetric, Delta: &delta},
+			}
+			require.NoError(t, storage.SaveAll(ctx, &metrics))
+
+			ids := func(q ListQuery) []string {
+				t.Helper()
+				page, err := storage.ListPage(ctx, q)
+				require.NoError(t, err)
+				names := make([]string, 0, len(page.Metrics))
+				for _, mt := range page.Metrics {
+					names = append(names, mt.ID)
+				}
+				return names
+			}
+
+			assert.Equal(t, []string{"Alloc", "HeapAlloc", "HeapIdle", "Heap_requests", "PollCount", "cpu1", "cpu2"}, ids(ListQuery{}))
+			assert.Equal(t, []string{"HeapAlloc", "HeapIdle", "Heap_requests"}, ids(ListQuery{Pattern: "Heap"}))
+			assert.Equal(t, []string{"HeapAlloc", "HeapIdle"}, ids(ListQuery{Pattern: "Heap", Type: service.GaugeMetric}))
+			assert.Equal(t, []string{"cpu2", "cpu1"}, ids(ListQuery{Pattern: "cpu[0-9]", Match: MatchGlob, Sort: SortByNameDesc}))
+			assert.Equal(t, []string{"Alloc", "HeapAlloc"}, ids(ListQuery{Pattern: "Alloc$", Match: MatchRegex}))
+			assert.Equal(t, []string{"Heap_requests", "PollCount", "Alloc", "HeapAlloc", "HeapIdle", "cpu1", "cpu2"}, ids(ListQuery{Sort: SortByType}))
+			assert.Empty(t, ids(ListQuery{Pattern: "missing"}))
+
+			// После полного чтения CachedStorage выполняет выборки в памяти.
+			_, err := storage.ListSlice(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"HeapAlloc", "HeapIdle", "Heap_requests"}, ids(ListQuery{Pattern: "Heap"}))
+
+			// Страницы по курсору без пропусков и повторов в каждом порядке.
+			for _, sort := range []SortOrder{SortByName, SortByNameDesc, SortByType, SortByTypeDesc} {
+				all := ids(ListQuery{Sort: sort})
+				var paged []string
+				q := ListQuery{Sort: sort, Limit: 3}
+				for pages := 0; ; pages++ {
+					require.Less(t, pages, len(all), "too many pages for sort %q", sort)
+					page, err := storage.ListPage(ctx, q)
+					require.NoError(t, err)
+					assert.LessOrEqual(t, len(page.Metrics), 3)
+					for _, mt := range page.Metrics {
+						paged = append(paged, mt.ID)
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					q.Cursor = page.NextCursor
+				}
+				assert.Equal(t, all, paged, "sort %q", sort)
+			}
+
+			_, err = storage.ListPage(ctx, ListQuery{Pattern: "(", Match: MatchRegex})
+			assert.ErrorIs(t, err, ErrInvalidQuery)
+		})
+	}
+}
+
+func TestExpiringStorage_ListPage(t *testing.T) {
+	mem := &MemStorage{}
+	require.NoError(t, mem.NewStorage())
+
+	old := time.Now().Add(-time.Hour)
+	value := service.GaugeMetricValue(1)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		mem.data[id] = service.Metrics{ID: id, MType: service.GaugeMetric, Value: &value}
+	}
+	for _, id := range []string{"b", "c"} {
+		mt := mem.data[id]
+		mt.UpdatedAt = &old
+		mem.data[id] = mt
+	}
+	storage := &ExpiringStorage{MetricStorage: mem, TTL: time.Minute}
+
+	// Устаревшие метрики пропускаются, а страница дочитывается до полного размера.
+	page, err := storage.ListPage(context.Background(), ListQuery{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 2)
+	assert.Equal(t, "a", page.Metrics[0].ID)
+	assert.Equal(t, "d", page.Metrics[1].ID)
+	require.NotEmpty(t, page.NextCursor)
+
+	page, err = storage.ListPage(context.Background(), ListQuery{Limit: 2, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 1)
+	assert.Equal(t, "e", page.Metrics[0].ID)
+	assert.Empty(t, page.NextCursor)
+}
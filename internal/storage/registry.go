@@ -0,0 +1,298 @@
+// Package storage предоставляет реализации хранилищ метрик для различных типов данных.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownStorageScheme возвращается, если для схемы URL не зарегистрировано хранилище.
+	ErrUnknownStorageScheme = errors.New("unknown storage scheme")
+	// ErrUnknownStorageWrapper возвращается, если обертка с таким именем не зарегистрирована.
+	ErrUnknownStorageWrapper = errors.New("unknown storage wrapper")
+	// ErrInvalidStorageParam возвращается при некорректном параметре URL хранилища.
+	ErrInvalidStorageParam = errors.New("invalid storage parameter")
+)
+
+// Options — настройки хранилища, заданные флагами и переменными окружения.
+// Параметры запроса в URL хранилища имеют приоритет над ними.
+type Options struct {
+	// StoreInterval и Restore настраивают хранилище со снимками (file://).
+	StoreInterval time.Duration
+	Restore       bool
+	// WAL, WALFsync и WALCompactInterval настраивают журнал упреждающей записи (file://?wal=true).
+	WAL                bool
+	WALFsync           FsyncPolicy
+	WALCompactInterval time.Duration
+	// Driver выбирает клиент PostgreSQL для схемы postgres://: sql или pgx.
+	Driver string
+	// MaxConns, MinConns и HealthCheckPeriod настраивают пул pgx.
+	MaxConns          int32
+	MinConns          int32
+	HealthCheckPeriod time.Duration
+	// SignKey — ключ подписи HashSHA256 для оберток, пересылающих метрики другим серверам.
+	SignKey []byte
+	// Wrappers — обертки в порядке применения: первая оборачивает само хранилище,
+	// последняя оказывается снаружи. Элемент имеет вид "name" или "name?param=value".
+	Wrappers []string
+}
+
+// URL — разобранный адрес хранилища вида scheme://location?param=value.
+type URL struct {
+	// Raw — исходная строка.
+	Raw string
+	// Scheme — схема в нижнем регистре.
+	Scheme string
+	// Location — часть между "://" и "?": путь к файлу, хост базы данных и т.п.
+	Location string
+	// Query — параметры запроса.
+	Query url.Values
+}
+
+// ParseURL разбирает адрес хранилища. Строка подключения PostgreSQL в формате
+// key=value ("host=localhost dbname=metrix") без схемы считается адресом postgres://,
+// чтобы прежние значения флага -d продолжали работать.
+func ParseURL(raw string) (*URL, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		if strings.Contains(raw, "=") {
+			return &URL{Raw: raw, Scheme: "postgres", Location: raw, Query: url.Values{}}, nil
+		}
+		return nil, fmt.Errorf("%w: %q has no scheme", ErrUnknownStorageScheme, raw)
+	}
+
+	location, rawQuery, _ := strings.Cut(rest, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidStorageParam, err)
+	}
+	return &URL{Raw: raw, Scheme: strings.ToLower(scheme), Location: location, Query: query}, nil
+}
+
+// Factory создает неинициализированное хранилище по адресу u; инициализацию
+// выполняет вызывающий код через NewStorage.
+type Factory func(u *URL, opts Options) (MetricStorage, error)
+
+// Wrapper оборачивает хранилище inner дополнительной функциональностью (кэш, репликация и т.п.).
+// params — параметры из описания обертки в Options.Wrappers.
+type Wrapper func(inner MetricStorage, params url.Values, opts Options) (MetricStorage, error)
+
+var registry = struct {
+	mu       sync.RWMutex
+	backends map[string]Factory
+	wrappers map[string]Wrapper
+}{
+	backends: make(map[string]Factory),
+	wrappers: make(map[string]Wrapper),
+}
+
+// Register регистрирует хранилище для схемы URL. Повторная регистрация схемы
+// приводит к панике, как и в database/sql.Register.
+func Register(scheme string, factory Factory) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if _, dup := registry.backends[scheme]; dup {
+		panic("storage: Register called twice for scheme " + scheme)
+	}
+	registry.backends[scheme] = factory
+}
+
+// RegisterWrapper регистрирует обертку хранилища под именем name.
+func RegisterWrapper(name string, wrapper Wrapper) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, dup := registry.wrappers[name]; dup {
+		panic("storage: RegisterWrapper called twice for " + name)
+	}
+	registry.wrappers[name] = wrapper
+}
+
+// Schemes возвращает отсортированный список зарегистрированных схем.
+func Schemes() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return sortedKeys(registry.backends)
+}
+
+// Open создает хранилище по адресу rawURL и применяет к нему обертки из opts.Wrappers.
+// Хранилище возвращается неинициализированным: вызывающий код должен вызвать NewStorage.
+//
+// Параметры:
+// - rawURL: Адрес хранилища, например memory://, file:///var/lib/metrix.json или postgres://...
+// - opts: Настройки, не заданные параметрами URL.
+//
+// Возвращаемые значения:
+// - MetricStorage: Хранилище с примененными обертками.
+// - error: Ошибка разбора адреса, неизвестная схема или обертка.
+func Open(rawURL string, opts Options) (MetricStorage, error) {
+	u, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	registry.mu.RLock()
+	factory, ok := registry.backends[u.Scheme]
+	registry.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q (registered: %s)", ErrUnknownStorageScheme, u.Scheme, strings.Join(Schemes(), ", "))
+	}
+
+	ms, err := factory(u, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s storage: %w", u.Scheme, err)
+	}
+
+	for _, spec := range opts.Wrappers {
+		name, rawParams, _ := strings.Cut(strings.TrimSpace(spec), "?")
+		if name == "" {
+			continue
+		}
+		params, err := url.ParseQuery(rawParams)
+		if err != nil {
+			return nil, fmt.Errorf("%w: wrapper %s: %v", ErrInvalidStorageParam, name, err)
+		}
+
+		registry.mu.RLock()
+		wrapper, ok := registry.wrappers[name]
+		registry.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownStorageWrapper, name)
+		}
+		if ms, err = wrapper(ms, params, opts); err != nil {
+			return nil, fmt.Errorf("failed to apply %s storage wrapper: %w", name, err)
+		}
+	}
+	return ms, nil
+}
+
+// boolParam возвращает булев параметр запроса или def, если параметр не задан.
+func boolParam(query url.Values, name string, def bool) (bool, error) {
+	if !query.Has(name) {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(query.Get(name))
+	if err != nil {
+		return false, fmt.Errorf("%w %s=%q", ErrInvalidStorageParam, name, query.Get(name))
+	}
+	return v, nil
+}
+
+// durationParam возвращает параметр-длительность ("10s", "1m") или def, если параметр не задан.
+func durationParam(query url.Values, name string, def time.Duration) (time.Duration, error) {
+	if !query.Has(name) {
+		return def, nil
+	}
+	v, err := time.ParseDuration(query.Get(name))
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%w %s=%q", ErrInvalidStorageParam, name, query.Get(name))
+	}
+	return v, nil
+}
+
+// Встроенные хранилища.
+//
+//	memory://                          — MemStorage
+//	file:///path/metrics.json          — SnapshotStorage; параметры store_interval, restore
+//	file:///path/metrics.json?wal=true — FileStorage; параметры fsync, compact_interval
+//	postgres://, postgresql://         — DBStorage или PgxStorage (параметр driver=sql|pgx)
+//	pgx://, pgxpool://                 — PgxStorage
+//	sqlite:///path/metrix.db           — SQLiteStorage
+func init() {
+	Register("memory", func(u *URL, opts Options) (MetricStorage, error) {
+		return &MemStorage{}, nil
+	})
+	Register("file", openFile)
+	Register("postgres", openPostgres)
+	Register("postgresql", openPostgres)
+	for _, scheme := range pgxSchemes {
+		Register(strings.TrimSuffix(scheme, "://"), func(u *URL, opts Options) (MetricStorage, error) {
+			dsn, _ := PgxDSN(u.Raw)
+			return newPgxStorage(dsn, opts), nil
+		})
+	}
+	Register("sqlite", func(u *URL, opts Options) (MetricStorage, error) {
+		if u.Location == "" {
+			return nil, fmt.Errorf("%w: sqlite database path is empty", ErrInvalidStorageParam)
+		}
+		return &SQLiteStorage{Path: u.Location}, nil
+	})
+}
+
+func openFile(u *URL, opts Options) (MetricStorage, error) {
+	if u.Location == "" {
+		return nil, fmt.Errorf("%w: file path is empty", ErrInvalidStorageParam)
+	}
+
+	wal, err := boolParam(u.Query, "wal", opts.WAL)
+	if err != nil {
+		return nil, err
+	}
+	if wal {
+		fsync := opts.WALFsync
+		if u.Query.Has("fsync") {
+			if fsync, err = ParseFsyncPolicy(u.Query.Get("fsync")); err != nil {
+				return nil, err
+			}
+		}
+		compact, err := durationParam(u.Query, "compact_interval", opts.WALCompactInterval)
+		if err != nil {
+			return nil, err
+		}
+		return &FileStorage{FileStoragePath: u.Location, Fsync: fsync, CompactInterval: compact}, nil
+	}
+
+	interval, err := durationParam(u.Query, "store_interval", opts.StoreInterval)
+	if err != nil {
+		return nil, err
+	}
+	restore, err := boolParam(u.Query, "restore", opts.Restore)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotStorage{FileStoragePath: u.Location, StoreInterval: interval, Restore: restore}, nil
+}
+
+// openPostgres выбирает клиент PostgreSQL по параметру driver или opts.Driver.
+// Параметр driver удаляется из DSN, чтобы драйвер не передал его серверу.
+func openPostgres(u *URL, opts Options) (MetricStorage, error) {
+	dsn := u.Raw
+	driver := opts.Driver
+	if u.Query.Has("driver") {
+		driver = u.Query.Get("driver")
+		parsed, err := url.Parse(u.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidStorageParam, err)
+		}
+		query := parsed.Query()
+		query.Del("driver")
+		parsed.RawQuery = query.Encode()
+		dsn = parsed.String()
+	}
+
+	switch driver {
+	case "", "sql":
+		return &DBStorage{DBDSN: dsn}, nil
+	case "pgx":
+		return newPgxStorage(dsn, opts), nil
+	default:
+		return nil, fmt.Errorf("%w driver=%q", ErrInvalidStorageParam, driver)
+	}
+}
+
+func newPgxStorage(dsn string, opts Options) *PgxStorage {
+	return &PgxStorage{
+		DBDSN:             dsn,
+		MaxConns:          opts.MaxConns,
+		MinConns:          opts.MinConns,
+		HealthCheckPeriod: opts.HealthCheckPeriod,
+	}
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	t.Run("Success: File URL with params", func(t *testing.T) {
+		u, err := ParseURL("FILE:///var/lib/metrix.json?wal=true&fsync=always")
+		require.NoError(t, err)
+		assert.Equal(t, "file", u.Scheme)
+		assert.Equal(t, "/var/lib/metrix.json", u.Location)
+		assert.Equal(t, "always", u.Query.Get("fsync"))
+	})
+
+	t.Run("Success: Keyword DSN is postgres", func(t *testing.T) {
+		u, err := ParseURL("host=localhost dbname=metrix")
+		require.NoError(t, err)
+		assert.Equal(t, "postgres", u.Scheme)
+	})
+
+	t.Run("Error: No scheme", func(t *testing.T) {
+		_, err := ParseURL("/var/lib/metrix.json")
+		assert.ErrorIs(t, err, ErrUnknownStorageScheme)
+	})
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	tests := []struct {
+		name   string
+		url    string
+		opts   Options
+		assert func(t *testing.T, ms MetricStorage)
+	}{
+		{"Memory", "memory://", Options{}, func(t *testing.T, ms MetricStorage) {
+			assert.IsType(t, &MemStorage{}, ms)
+		}},
+		{"Snapshot from options", "file://" + path, Options{StoreInterval: time.Second, Restore: true}, func(t *testing.T, ms MetricStorage) {
+			require.IsType(t, &SnapshotStorage{}, ms)
+			assert.Equal(t, path, ms.(*SnapshotStorage).FileStoragePath)
+			assert.Equal(t, time.Second, ms.(*SnapshotStorage).StoreInterval)
+			assert.True(t, ms.(*SnapshotStorage).Restore)
+		}},
+		{"Snapshot params override options", "file://" + path + "?store_interval=5s&restore=false", Options{Restore: true}, func(t *testing.T, ms MetricStorage) {
+			require.IsType(t, &SnapshotStorage{}, ms)
+			assert.Equal(t, 5*time.Second, ms.(*SnapshotStorage).StoreInterval)
+			assert.False(t, ms.(*SnapshotStorage).Restore)
+		}},
+		{"WAL", "file://" + path + "?wal=true&fsync=always&compact_interval=30s", Options{}, func(t *testing.T, ms MetricStorage) {
+			require.IsType(t, &FileStorage{}, ms)
+			assert.Equal(t, FsyncAlways, ms.(*FileStorage).Fsync)
+			assert.Equal(t, 30*time.Second, ms.(*FileStorage).CompactInterval)
+		}},
+		{"Postgres via database/sql", "postgres://user@localhost/metrix", Options{}, func(t *testing.T, ms MetricStorage) {
+			require.IsType(t, &DBStorage{}, ms)
+			assert.Equal(t, "postgres://user@localhost/metrix", ms.(*DBStorage).DBDSN)
+		}},
+		{"Postgres keyword DSN", "host=localhost dbname=metrix", Options{}, func(t *testing.T, ms MetricStorage) {
+			assert.IsType(t, &DBStorage{}, ms)
+		}},
+		{"Postgres driver param", "postgres://localhost/metrix?sslmode=disable&driver=pgx", Options{MaxConns: 4}, func(t *testing.T, ms MetricStorage) {
+			require.IsType(t, &PgxStorage{}, ms)
+			assert.Equal(t, "postgres://localhost/metrix?sslmode=disable", ms.(*PgxStorage).DBDSN)
+			assert.Equal(t, int32(4), ms.(*PgxStorage).MaxConns)
+		}},
+		{"Postgres driver option", "postgres://localhost/metrix", Options{Driver: "pgx"}, func(t *testing.T, ms MetricStorage) {
+			assert.IsType(t, &PgxStorage{}, ms)
+		}},
+		{"Pgx scheme", "pgx://localhost/metrix", Options{}, func(t *testing.T, ms MetricStorage) {
+			require.IsType(t, &PgxStorage{}, ms)
+			assert.Equal(t, "postgres://localhost/metrix", ms.(*PgxStorage).DBDSN)
+		}},
+		{"SQLite", "sqlite:///var/lib/metrix.db", Options{}, func(t *testing.T, ms MetricStorage) {
+			require.IsType(t, &SQLiteStorage{}, ms)
+			assert.Equal(t, "/var/lib/metrix.db", ms.(*SQLiteStorage).Path)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms, err := Open(tt.url, tt.opts)
+			require.NoError(t, err)
+			tt.assert(t, ms)
+		})
+	}
+
+	errTests := []struct {
+		name string
+		url  string
+		opts Options
+		err  error
+	}{
+		{"Unknown scheme", "redis://localhost", Options{}, ErrUnknownStorageScheme},
+		{"Empty file path", "file://", Options{}, ErrInvalidStorageParam},
+		{"Invalid duration", "file:///tmp/m.json?store_interval=soon", Options{}, ErrInvalidStorageParam},
+		{"Invalid fsync", "file:///tmp/m.json?wal=1&fsync=sometimes", Options{}, ErrInvalidFsyncPolicy},
+		{"Invalid driver", "postgres://localhost/metrix?driver=odbc", Options{}, ErrInvalidStorageParam},
+		{"Unknown wrapper", "memory://", Options{Wrappers: []string{"missing"}}, ErrUnknownStorageWrapper},
+	}
+	for _, tt := range errTests {
+		t.Run("Error: "+tt.name, func(t *testing.T) {
+			_, err := Open(tt.url, tt.opts)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+// countingStorage — обертка для проверки порядка применения оберток.
+type countingStorage struct {
+	MetricStorage
+	name  string
+	saves *[]string
+}
+
+func (ms *countingStorage) Save(ctx context.Context, mt service.Metrics) error {
+	*ms.saves = append(*ms.saves, ms.name)
+	return ms.MetricStorage.Save(ctx, mt)
+}
+
+func TestOpenWrappers(t *testing.T) {
+	var saves []string
+	RegisterWrapper("test_counting", func(inner MetricStorage, params url.Values, opts Options) (MetricStorage, error) {
+		return &countingStorage{MetricStorage: inner, name: params.Get("name"), saves: &saves}, nil
+	})
+
+	ms, err := Open("memory://", Options{Wrappers: []string{"test_counting?name=inner", " test_counting?name=outer"}})
+	require.NoError(t, err)
+	require.NoError(t, ms.NewStorage())
+
+	value := service.GaugeMetricValue(1)
+	require.NoError(t, ms.Save(context.Background(), service.Metrics{ID: "g", MType: service.GaugeMetric, Value: &value}))
+	assert.Equal(t, []string{"outer", "inner"}, saves)
+
+	assert.Panics(t, func() { Register("memory", nil) })
+	assert.Contains(t, Schemes(), "sqlite")
+}
@@ -0,0 +1,400 @@
+// Package storage предоставляет реализации хранилищ метрик для различных типов данных.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+)
+
+// shardCount — число сегментов карты метрик. Степень двойки, чтобы индекс считался маской.
+const shardCount = 32
+
+// memShard — сегмент карты метрик со своей блокировкой.
+type memShard struct {
+	mu   sync.RWMutex
+	data map[string]service.Metrics
+}
+
+// SnapshotStorage хранит метрики в оперативной памяти и периодически сохраняет
+// их снимок на диск.
+//
+// Карта метрик разбита на сегменты с отдельными блокировками, поэтому запись разных
+// метрик не конкурирует за один мьютекс. Снимок записывается атомарно: сначала во
+// временный файл в том же каталоге, затем переименованием поверх FileStoragePath,
+// так что при сбое на диске остается либо старый, либо новый снимок целиком.
+//
+// Поля:
+//   - FileStoragePath: Путь к файлу снимка.
+//   - StoreInterval: Период записи снимка. При нулевом значении снимок записывается
+//     синхронно после каждой успешной записи в хранилище.
+//   - Restore: Загружать ли метрики из снимка при инициализации.
+type SnapshotStorage struct {
+	FileStoragePath string
+	StoreInterval   time.Duration
+	Restore         bool
+
+	shards   [shardCount]memShard
+	snapMu   sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	ready    bool
+}
+
+func (ms *SnapshotStorage) shard(id string) *memShard {
+	return &ms.shards[shardIndex(id)]
+}
+
+func shardIndex(id string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return h.Sum32() & (shardCount - 1)
+}
+
+// lockShards блокирует сегменты всех метрик пакета в порядке номеров, чтобы конкурентные
+// пакеты не взаимоблокировались, и возвращает функцию разблокировки.
+func (ms *SnapshotStorage) lockShards(metrics []service.Metrics) func() {
+	var locked [shardCount]bool
+	for _, mt := range metrics {
+		locked[shardIndex(mt.ID)] = true
+	}
+	for i := range ms.shards {
+		if locked[i] {
+			ms.shards[i].mu.Lock()
+		}
+	}
+	return func() {
+		for i := range ms.shards {
+			if locked[i] {
+				ms.shards[i].mu.Unlock()
+			}
+		}
+	}
+}
+
+// existingType возвращает тип сохраненной метрики; вызывающий должен удерживать блокировку ее сегмента.
+func (ms *SnapshotStorage) existingType(id string) (service.MetricType, bool) {
+	mt, ok := ms.shard(id).data[id]
+	return mt.MType, ok
+}
+
+func (ms *SnapshotStorage) NewStorage() error {
+	for i := range ms.shards {
+		ms.shards[i].mu.Lock()
+		ms.shards[i].data = make(map[string]service.Metrics)
+		ms.shards[i].mu.Unlock()
+	}
+
+	if ms.Restore {
+		if err := ms.restore(); err != nil {
+			return err
+		}
+	}
+	ms.ready = true
+
+	if ms.StoreInterval > 0 {
+		ms.stop = make(chan struct{})
+		ms.done = make(chan struct{})
+		go ms.snapshotLoop()
+	}
+	return nil
+}
+
+// restore загружает метрики из файла снимка. Отсутствующий файл не считается ошибкой.
+// Снимок, записанный FileStorage, тоже читается; непустой журнал рядом с ним означает,
+// что часть изменений не попала в снимок, и об этом пишется предупреждение.
+func (ms *SnapshotStorage) restore() error {
+	data, err := os.ReadFile(ms.FileStoragePath)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(data) == 0) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	snap, err := parseSnapshot(ms.FileStoragePath, data)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(ms.FileStoragePath + ".wal"); err == nil && info.Size() > 0 {
+		logging.Logg.Warn("Write-ahead log is not empty, changes after the last snapshot are ignored without -wal",
+			"path", ms.FileStoragePath+".wal")
+	}
+	for id, metric := range snap.Metrics {
+		sh := ms.shard(id)
+		sh.data[id] = cloneMetric(metric)
+	}
+	return nil
+}
+
+// snapshotLoop записывает снимок каждые StoreInterval до вызова FreeStorage.
+func (ms *SnapshotStorage) snapshotLoop() {
+	defer close(ms.done)
+
+	ticker := time.NewTicker(ms.StoreInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ms.Snapshot(); err != nil {
+				logging.Logg.Error("Failed to write metrics snapshot", "path", ms.FileStoragePath, "error", err)
+			}
+		case <-ms.stop:
+			return
+		}
+	}
+}
+
+// Snapshot атомарно записывает текущее состояние хранилища в FileStoragePath.
+func (ms *SnapshotStorage) Snapshot() error {
+	ms.snapMu.Lock()
+	defer ms.snapMu.Unlock()
+
+	return writeFileAtomic(ms.FileStoragePath, func(w io.Writer) error {
+		return service.DumpMetrics(ms, w)
+	})
+}
+
+// writeFileAtomic записывает файл path через временный файл в том же каталоге,
+// fsync и переименование, так что читатель видит либо старое, либо новое содержимое целиком.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// afterWrite записывает снимок синхронно, если периодическая запись выключена.
+// Изменение к этому моменту уже применено в памяти, поэтому ошибка снимка только
+// логируется: ответ с ошибкой заставил бы клиента повторить запись и учесть приращения
+// счетчиков дважды. Следующая запись или FreeStorage повторят снимок.
+func (ms *SnapshotStorage) afterWrite(ctx context.Context) {
+	if ms.StoreInterval > 0 {
+		return
+	}
+	if err := ms.Snapshot(); err != nil {
+		logging.Logg.ErrorContext(ctx, "Failed to write metrics snapshot", "path", ms.FileStoragePath, "error", err)
+	}
+}
+
+func (ms *SnapshotStorage) Save(ctx context.Context, mt service.Metrics) error {
+	if !ms.ready {
+		return service.ErrUninitializedStorage
+	}
+	if err := ms.saveBatch([]service.Metrics{mt}); err != nil {
+		return err
+	}
+	ms.afterWrite(ctx)
+	return nil
+}
+
+// saveBatch проверяет пакет и записывает его в сегменты атомарно.
+func (ms *SnapshotStorage) saveBatch(metrics []service.Metrics) error {
+	unlock := ms.lockShards(metrics)
+	defer unlock()
+
+	if err := checkBatch(metrics, ms.existingType); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, mt := range metrics {
+		sh := ms.shard(mt.ID)
+		sh.data[mt.ID] = merge(sh.data[mt.ID], mt, now)
+	}
+	return nil
+}
+
+func (ms *SnapshotStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
+	if !ms.ready {
+		return service.ErrUninitializedStorage
+	}
+	if len(*mt) == 0 {
+		return service.ErrInvalidMetricName
+	}
+	if err := ms.saveBatch(*mt); err != nil {
+		return err
+	}
+	ms.afterWrite(ctx)
+	return nil
+}
+
+func (ms *SnapshotStorage) Get(ctx context.Context, metricName string) (*service.Metrics, error) {
+	if !ms.ready {
+		return nil, service.ErrUninitializedStorage
+	}
+	if len(metricName) == 0 {
+		return nil, service.ErrInvalidMetricName
+	}
+
+	sh := ms.shard(metricName)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if m, ok := sh.data[metricName]; ok {
+		return &m, nil
+	}
+	return nil, service.ErrUnknownMetric
+}
+
+func (ms *SnapshotStorage) List(ctx context.Context) (*map[string]service.Metrics, error) {
+	if !ms.ready {
+		return nil, service.ErrUninitializedStorage
+	}
+
+	mtrx := make(map[string]service.Metrics)
+	for i := range ms.shards {
+		sh := &ms.shards[i]
+		sh.mu.RLock()
+		for id, metric := range sh.data {
+			mtrx[id] = metric
+		}
+		sh.mu.RUnlock()
+	}
+	return &mtrx, nil
+}
+
+func (ms *SnapshotStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
+	if !ms.ready {
+		return nil, service.ErrUninitializedStorage
+	}
+
+	metricsSlice := make([]service.Metrics, 0)
+	for i := range ms.shards {
+		sh := &ms.shards[i]
+		sh.mu.RLock()
+		for _, metric := range sh.data {
+			metricsSlice = append(metricsSlice, metric)
+		}
+		sh.mu.RUnlock()
+	}
+	return metricsSlice, nil
+}
+
+func (ms *SnapshotStorage) ListPage(ctx context.Context, q ListQuery) (*Page, error) {
+	metrics, err := ms.ListSlice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return listPage(metrics, q)
+}
+
+// FreeStorage останавливает периодическую запись и сохраняет финальный снимок.
+func (ms *SnapshotStorage) FreeStorage() error {
+	if !ms.ready {
+		return nil
+	}
+	if ms.stop != nil {
+		ms.stopOnce.Do(func() { close(ms.stop) })
+		<-ms.done
+	}
+	return ms.Snapshot()
+}
+
+func (ms *SnapshotStorage) CheckStorage(ctx context.Context) error {
+	if !ms.ready {
+		return service.ErrUninitializedStorage
+	}
+	return nil
+}
+
+func (ms *SnapshotStorage) Delete(ctx context.Context, metricNames ...string) (int, error) {
+	return ms.DeleteWhere(ctx, DeleteFilter{}, metricNames...)
+}
+
+func (ms *SnapshotStorage) DeleteWhere(ctx context.Context, f DeleteFilter, metricNames ...string) (int, error) {
+	if !ms.ready {
+		return 0, service.ErrUninitializedStorage
+	}
+	metricNames, err := uniqueNames(metricNames)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, name := range metricNames {
+		sh := ms.shard(name)
+		sh.mu.Lock()
+		if mt, ok := sh.data[name]; ok && f.match(mt) {
+			delete(sh.data, name)
+			n++
+		}
+		sh.mu.Unlock()
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	ms.afterWrite(ctx)
+	return n, nil
+}
+
+func (ms *SnapshotStorage) Reset(ctx context.Context, metricNames ...string) (int, error) {
+	if !ms.ready {
+		return 0, service.ErrUninitializedStorage
+	}
+	metricNames, err := uniqueNames(metricNames)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	now := time.Now()
+	for _, name := range metricNames {
+		sh := ms.shard(name)
+		sh.mu.Lock()
+		if mt, ok := resetCounter(sh.data[name], now); ok {
+			sh.data[name] = mt
+			n++
+		}
+		sh.mu.Unlock()
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	ms.afterWrite(ctx)
+	return n, nil
+}
+
+func (ms *SnapshotStorage) Replace(ctx context.Context, mt service.Metrics) error {
+	if !ms.ready {
+		return service.ErrUninitializedStorage
+	}
+	if err := checkReplacement(mt); err != nil {
+		return err
+	}
+
+	sh := ms.shard(mt.ID)
+	sh.mu.Lock()
+	sh.data[mt.ID] = stamp(cloneMetric(mt), time.Now())
+	sh.mu.Unlock()
+	ms.afterWrite(ctx)
+	return nil
+}
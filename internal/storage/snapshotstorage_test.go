@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readSnapshot(t *testing.T, path string) map[string]service.Metrics {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var mtrx map[string]service.Metrics
+	require.NoError(t, json.Unmarshal(data, &mtrx))
+	return mtrx
+}
+
+func TestSnapshotSaveAndGet(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Error: Uninitialized storage", func(t *testing.T) {
+		storage := &SnapshotStorage{}
+		assert.Equal(t, service.ErrUninitializedStorage, storage.Save(ctx, service.Metrics{}))
+		_, err := storage.Get(ctx, "x")
+		assert.Equal(t, service.ErrUninitializedStorage, err)
+	})
+
+	t.Run("Counters accumulate, gauges overwrite", func(t *testing.T) {
+		storage := &SnapshotStorage{FileStoragePath: filepath.Join(t.TempDir(), "metrics.json")}
+		require.NoError(t, storage.NewStorage())
+
+		delta := service.CounterMetricValue(5)
+		value := service.GaugeMetricValue(1.5)
+		batch := []service.Metrics{
+			{ID: "c", MType: service.CounterMetric, Delta: &delta},
+			{ID: "c", MType: service.CounterMetric, Delta: &delta},
+			{ID: "g", MType: service.GaugeMetric, Value: &value},
+		}
+		require.NoError(t, storage.SaveAll(ctx, &batch))
+
+		counter, err := storage.Get(ctx, "c")
+		require.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(10), *counter.Delta)
+		assert.Equal(t, service.CounterMetricValue(5), delta)
+
+		_, err = storage.Get(ctx, "missing")
+		assert.Equal(t, service.ErrUnknownMetric, err)
+
+		all, err := storage.ListSlice(ctx)
+		require.NoError(t, err)
+		assert.Len(t, all, 2)
+	})
+
+	t.Run("Error: Invalid metric type in batch", func(t *testing.T) {
+		storage := &SnapshotStorage{FileStoragePath: filepath.Join(t.TempDir(), "metrics.json")}
+		require.NoError(t, storage.NewStorage())
+
+		value := service.GaugeMetricValue(1)
+		batch := []service.Metrics{
+			{ID: "g", MType: service.GaugeMetric, Value: &value},
+			{ID: "bad", MType: "histogram"},
+		}
+		assert.Equal(t, service.ErrInvalidMetricName, storage.SaveAll(ctx, &batch))
+
+		_, err := storage.Get(ctx, "g")
+		assert.Equal(t, service.ErrUnknownMetric, err)
+	})
+}
+
+func TestSnapshotSyncWrite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	storage := &SnapshotStorage{FileStoragePath: path}
+	require.NoError(t, storage.NewStorage())
+
+	value := service.GaugeMetricValue(42)
+	require.NoError(t, storage.Save(ctx, service.Metrics{ID: "g", MType: service.GaugeMetric, Value: &value}))
+
+	mtrx := readSnapshot(t, path)
+	assert.Equal(t, service.GaugeMetricValue(42), *mtrx["g"].Value)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary snapshot files must not be left behind")
+}
+
+func TestSnapshotSyncWriteFailure(t *testing.T) {
+	require.NoError(t, logging.InitTestLogger())
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	storage := &SnapshotStorage{FileStoragePath: filepath.Join(dir, "missing", "metrics.json")}
+	require.NoError(t, storage.NewStorage())
+
+	// Снимок записать некуда, но запись уже применена в памяти и не должна возвращать ошибку,
+	// иначе повтор клиента учел бы приращение дважды.
+	delta := service.CounterMetricValue(5)
+	require.NoError(t, storage.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+	n, err := storage.Reset(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, storage.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+
+	counter, err := storage.Get(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, service.CounterMetricValue(5), *counter.Delta)
+
+	// Как только каталог появляется, следующая запись сохраняет полный снимок.
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "missing"), 0o755))
+	require.NoError(t, storage.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+	assert.Equal(t, service.CounterMetricValue(10), *readSnapshot(t, storage.FileStoragePath)["c"].Delta)
+}
+
+func TestSnapshotPeriodicAndShutdown(t *testing.T) {
+	if err := logging.InitTestLogger(); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	storage := &SnapshotStorage{FileStoragePath: path, StoreInterval: 20 * time.Millisecond}
+	require.NoError(t, storage.NewStorage())
+
+	delta := service.CounterMetricValue(3)
+	require.NoError(t, storage.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, storage.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+	require.NoError(t, storage.FreeStorage())
+
+	mtrx := readSnapshot(t, path)
+	assert.Equal(t, service.CounterMetricValue(6), *mtrx["c"].Delta)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	first := &SnapshotStorage{FileStoragePath: path, StoreInterval: time.Hour}
+	require.NoError(t, first.NewStorage())
+	delta := service.CounterMetricValue(7)
+	require.NoError(t, first.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+	require.NoError(t, first.FreeStorage())
+
+	t.Run("Restore enabled", func(t *testing.T) {
+		storage := &SnapshotStorage{FileStoragePath: path, Restore: true, StoreInterval: time.Hour}
+		require.NoError(t, storage.NewStorage())
+		defer storage.FreeStorage()
+
+		counter, err := storage.Get(ctx, "c")
+		require.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(7), *counter.Delta)
+	})
+
+	t.Run("Restore disabled", func(t *testing.T) {
+		storage := &SnapshotStorage{FileStoragePath: path, StoreInterval: time.Hour}
+		require.NoError(t, storage.NewStorage())
+
+		_, err := storage.Get(ctx, "c")
+		assert.Equal(t, service.ErrUnknownMetric, err)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		storage := &SnapshotStorage{FileStoragePath: filepath.Join(t.TempDir(), "none.json"), Restore: true}
+		assert.NoError(t, storage.NewStorage())
+	})
+
+	t.Run("Error: Corrupted file", func(t *testing.T) {
+		broken := filepath.Join(t.TempDir(), "broken.json")
+		require.NoError(t, os.WriteFile(broken, []byte("{not json"), 0666))
+
+		storage := &SnapshotStorage{FileStoragePath: broken, Restore: true}
+		assert.Error(t, storage.NewStorage())
+	})
+}
+
+func TestSnapshotConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	storage := &SnapshotStorage{FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"), StoreInterval: time.Millisecond}
+	require.NoError(t, storage.NewStorage())
+
+	const workers = 8
+	const iterations = 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				delta := service.CounterMetricValue(1)
+				assert.NoError(t, storage.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				_, err := storage.List(ctx)
+				assert.NoError(t, err)
+				storage.Get(ctx, "c")
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, storage.FreeStorage())
+
+	mtrx := readSnapshot(t, storage.FileStoragePath)
+	assert.Equal(t, service.CounterMetricValue(workers*iterations), *mtrx["c"].Delta)
+}
@@ -0,0 +1,380 @@
+// Package storage предоставляет реализации хранилищ метрик для различных типов данных.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"modernc.org/sqlite" // Регистрирует драйвер sqlite (без cgo) для database/sql
+)
+
+// sqliteScheme — схема DSN, выбирающая SQLiteStorage: sqlite:///var/lib/metrix.db.
+const sqliteScheme = "sqlite://"
+
+// sqlitePragmas задают режим журнала WAL (читатели не блокируют писателя), ожидание
+// блокировки вместо немедленной ошибки SQLITE_BUSY и захват блокировки записи
+// в начале транзакции.
+const sqlitePragmas = "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)&_txlock=immediate"
+
+// Запросы SQLite. Схема совпадает с PostgreSQL, отличаются плейсхолдеры и функция времени.
+const (
+	sqliteSaveGaugeQuery   = "insert into metrix (id, mtype, value) values (?, 'gauge', ?) on conflict (id) do update set value = excluded.value, updated_at = current_timestamp where " + sameTypeCond
+	sqliteSaveCounterQuery = "insert into metrix (id, mtype, delta) values (?, 'counter', ?) on conflict (id) do update set delta = metrix.delta + excluded.delta, updated_at = current_timestamp where " + sameTypeCond
+	sqliteGetQuery         = "select id, mtype, delta, value, updated_at from metrix where id = ?"
+	sqliteDeleteQuery      = "delete from metrix where id = ?1 and (?2 = '' or mtype = ?2) and (?3 = '' or updated_at < ?3)"
+	sqliteResetQuery       = "update metrix set delta = 0, updated_at = current_timestamp where mtype = 'counter' and id = ?"
+	sqliteReplaceQuery     = "insert into metrix (id, mtype, delta, value) values (?, ?, ?, ?) on conflict (id) do update set mtype = excluded.mtype, delta = excluded.delta, value = excluded.value, labels = excluded.labels, updated_at = current_timestamp"
+)
+
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, sqliteRegexp)
+}
+
+// lastSQLiteRegexp — последнее скомпилированное выражение sqliteRegexp. SQLite вызывает
+// функцию для каждой строки, а выражение в запросе одно, поэтому одной записи достаточно.
+var lastSQLiteRegexp atomic.Pointer[regexp.Regexp]
+
+// sqliteRegexp реализует оператор "X regexp Y" SQLite, который вызывает regexp(Y, X).
+// Выражение записывается в синтаксисе RE2.
+func sqliteRegexp(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, errors.New("regexp: pattern must be text")
+	}
+	var s string
+	switch v := args[1].(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+		return nil, nil
+	default:
+		return nil, errors.New("regexp: value must be text")
+	}
+
+	re := lastSQLiteRegexp.Load()
+	if re == nil || re.String() != pattern {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+		lastSQLiteRegexp.Store(re)
+	}
+	return re.MatchString(s), nil
+}
+
+// SQLitePath распознает DSN вида sqlite:///path/to/file.db и возвращает путь к файлу базы.
+func SQLitePath(dsn string) (string, bool) {
+	if !strings.HasPrefix(dsn, sqliteScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(dsn, sqliteScheme), true
+}
+
+// SQLiteStorage хранит метрики в файле SQLite с той же схемой и миграциями, что и DBStorage.
+// Предназначено для однонодовых установок без PostgreSQL: данные переживают перезапуск
+// и доступны для SQL-запросов. Драйвер написан на чистом Go и не требует cgo.
+type SQLiteStorage struct {
+	Path string
+
+	db              *sql.DB
+	saveGaugeStmt   *sql.Stmt
+	saveCounterStmt *sql.Stmt
+	getStmt         *sql.Stmt
+	listStmt        *sql.Stmt
+}
+
+func (ms *SQLiteStorage) NewStorage() error {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", "file:"+ms.Path+sqlitePragmas)
+	if err != nil {
+		return err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return err
+	}
+
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	applied, err := migrator.Up(ctx)
+	for _, m := range applied {
+		logging.Logg.Info("Applied schema migration", "version", m.Version, "name", m.Name)
+	}
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	for _, p := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&ms.saveGaugeStmt, sqliteSaveGaugeQuery},
+		{&ms.saveCounterStmt, sqliteSaveCounterQuery},
+		{&ms.getStmt, sqliteGetQuery},
+		{&ms.listStmt, listQuery},
+	} {
+		if *p.stmt, err = db.PrepareContext(ctx, p.query); err != nil {
+			db.Close()
+			return err
+		}
+	}
+
+	ms.db = db
+	return nil
+}
+
+func (ms *SQLiteStorage) Save(ctx context.Context, mt service.Metrics) error {
+	if ms.db == nil {
+		return service.ErrUninitializedStorage
+	}
+	if len(mt.ID) == 0 {
+		return service.ErrInvalidMetricName
+	}
+
+	var res sql.Result
+	var err error
+	switch {
+	case mt.MType == service.GaugeMetric && mt.Value != nil:
+		res, err = ms.saveGaugeStmt.ExecContext(ctx, mt.ID, float64(*mt.Value))
+	case mt.MType == service.CounterMetric && mt.Delta != nil:
+		res, err = ms.saveCounterStmt.ExecContext(ctx, mt.ID, int64(*mt.Delta))
+	default:
+		return service.ErrInvalidMetricName
+	}
+	if err != nil {
+		return err
+	}
+	return checkUpserted(res, mt.ID)
+}
+
+// checkUpserted возвращает ErrTypeConflict, если upsert метрики id не затронул строку:
+// условие sameTypeCond не выполнилось, так как метрика сохранена с другим типом.
+func checkUpserted(res sql.Result, id string) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return typeConflict(id)
+	}
+	return nil
+}
+
+// SaveAll записывает пакет в одной транзакции. Повторяющиеся метрики предварительно
+// объединяются так же, как в DBStorage.
+func (ms *SQLiteStorage) SaveAll(ctx context.Context, mt *[]service.Metrics) error {
+	if ms.db == nil {
+		return service.ErrUninitializedStorage
+	}
+	if len(*mt) == 0 {
+		return service.ErrInvalidMetricName
+	}
+
+	batch, err := aggregateBatch(*mt)
+	if err != nil {
+		return err
+	}
+
+	tx, err := ms.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	gaugeStmt := tx.StmtContext(ctx, ms.saveGaugeStmt)
+	for i, id := range batch.gaugeIDs {
+		res, err := gaugeStmt.ExecContext(ctx, id, batch.gaugeValues[i])
+		if err == nil {
+			err = checkUpserted(res, id)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	counterStmt := tx.StmtContext(ctx, ms.saveCounterStmt)
+	for i, id := range batch.counterIDs {
+		res, err := counterStmt.ExecContext(ctx, id, batch.counterDeltas[i])
+		if err == nil {
+			err = checkUpserted(res, id)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (ms *SQLiteStorage) Get(ctx context.Context, metricName string) (*service.Metrics, error) {
+	if ms.db == nil {
+		return nil, service.ErrUninitializedStorage
+	}
+	if len(metricName) == 0 {
+		return nil, service.ErrInvalidMetricName
+	}
+
+	return scanStoredMetric(ms.getStmt.QueryRowContext(ctx, metricName))
+}
+
+func (ms *SQLiteStorage) ListSlice(ctx context.Context) ([]service.Metrics, error) {
+	if ms.db == nil {
+		return nil, service.ErrUninitializedStorage
+	}
+
+	rows, err := ms.listStmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := make([]service.Metrics, 0)
+	for rows.Next() {
+		metric, err := scanMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, rows.Err()
+}
+
+// ListPage выполняет выборку одним запросом. Регулярные выражения проверяет функция
+// regexp, зарегистрированная в драйвере (см. sqliteRegexp).
+func (ms *SQLiteStorage) ListPage(ctx context.Context, q ListQuery) (*Page, error) {
+	if ms.db == nil {
+		return nil, service.ErrUninitializedStorage
+	}
+	p, err := q.plan()
+	if err != nil {
+		return nil, err
+	}
+
+	query, args := p.sql(sqliteDialect)
+	rows, err := ms.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics, err := scanMetrics(rows)
+	if err != nil {
+		return nil, err
+	}
+	return p.page(metrics), nil
+}
+
+func (ms *SQLiteStorage) List(ctx context.Context) (*map[string]service.Metrics, error) {
+	metrics, err := ms.ListSlice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mtrx := make(map[string]service.Metrics, len(metrics))
+	for _, metric := range metrics {
+		mtrx[metric.ID] = metric
+	}
+	return &mtrx, nil
+}
+
+func (ms *SQLiteStorage) Delete(ctx context.Context, metricNames ...string) (int, error) {
+	return ms.DeleteWhere(ctx, DeleteFilter{}, metricNames...)
+}
+
+func (ms *SQLiteStorage) DeleteWhere(ctx context.Context, f DeleteFilter, metricNames ...string) (int, error) {
+	var before string
+	if !f.UpdatedBefore.IsZero() {
+		// current_timestamp хранит время UTC с точностью до секунды, поэтому граница
+		// округляется вниз и свежая метрика не удаляется.
+		before = f.UpdatedBefore.UTC().Format(time.DateTime)
+	}
+	return ms.modify(ctx, sqliteDeleteQuery, metricNames, string(f.MType), before)
+}
+
+func (ms *SQLiteStorage) Reset(ctx context.Context, metricNames ...string) (int, error) {
+	return ms.modify(ctx, sqliteResetQuery, metricNames)
+}
+
+// Replace записывает метрику одним upsert, поэтому строка либо заменяется целиком,
+// либо остается прежней.
+func (ms *SQLiteStorage) Replace(ctx context.Context, mt service.Metrics) error {
+	if ms.db == nil {
+		return service.ErrUninitializedStorage
+	}
+	if err := checkReplacement(mt); err != nil {
+		return err
+	}
+
+	delta, value := replaceArgs(mt)
+	_, err := ms.db.ExecContext(ctx, sqliteReplaceQuery, mt.ID, string(mt.MType), delta, value)
+	return err
+}
+
+// modify выполняет query для каждого id с дополнительными аргументами args в одной транзакции
+// и возвращает число затронутых строк.
+func (ms *SQLiteStorage) modify(ctx context.Context, query string, names []string, args ...any) (int, error) {
+	if ms.db == nil {
+		return 0, service.ErrUninitializedStorage
+	}
+	names, err := uniqueNames(names)
+	if err != nil || len(names) == 0 {
+		return 0, err
+	}
+
+	tx, err := ms.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var affected int64
+	for _, name := range names {
+		res, err := stmt.ExecContext(ctx, append([]any{name}, args...)...)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		affected += n
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+func (ms *SQLiteStorage) FreeStorage() error {
+	if ms.db == nil {
+		return service.ErrUninitializedStorage
+	}
+	return ms.db.Close()
+}
+
+func (ms *SQLiteStorage) CheckStorage(ctx context.Context) error {
+	if ms.db == nil {
+		return service.ErrUninitializedStorage
+	}
+	return ms.db.PingContext(ctx)
+}
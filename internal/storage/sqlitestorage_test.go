@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLitePath(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  string
+		path string
+		ok   bool
+	}{
+		{"Absolute path", "sqlite:///var/lib/metrix.db", "/var/lib/metrix.db", true},
+		{"Relative path", "sqlite://metrix.db", "metrix.db", true},
+		{"Postgres DSN", "postgres://localhost/metrix", "", false},
+		{"Empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, ok := SQLitePath(tt.dsn)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.path, path)
+		})
+	}
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	require.NoError(t, logging.InitTestLogger())
+	ctx := context.Background()
+
+	t.Run("Error: Uninitialized storage", func(t *testing.T) {
+		storage := &SQLiteStorage{}
+		assert.Equal(t, service.ErrUninitializedStorage, storage.Save(ctx, service.Metrics{}))
+		_, err := storage.Get(ctx, "x")
+		assert.Equal(t, service.ErrUninitializedStorage, err)
+		assert.Equal(t, service.ErrUninitializedStorage, storage.CheckStorage(ctx))
+		assert.Equal(t, service.ErrUninitializedStorage, storage.FreeStorage())
+	})
+
+	t.Run("Error: Invalid path", func(t *testing.T) {
+		storage := &SQLiteStorage{Path: filepath.Join(t.TempDir(), "missing", "metrix.db")}
+		assert.Error(t, storage.NewStorage())
+	})
+
+	t.Run("Success: Data survives restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "metrix.db")
+		storage := &SQLiteStorage{Path: path}
+		require.NoError(t, storage.NewStorage())
+
+		delta := service.CounterMetricValue(7)
+		value := service.GaugeMetricValue(3.25)
+		batch := []service.Metrics{
+			{ID: "c", MType: service.CounterMetric, Delta: &delta},
+			{ID: "g", MType: service.GaugeMetric, Value: &value},
+		}
+		require.NoError(t, storage.SaveAll(ctx, &batch))
+		require.NoError(t, storage.FreeStorage())
+
+		reopened := &SQLiteStorage{Path: path}
+		require.NoError(t, reopened.NewStorage())
+		defer reopened.FreeStorage()
+
+		require.NoError(t, reopened.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+		counter, err := reopened.Get(ctx, "c")
+		require.NoError(t, err)
+		assert.Equal(t, service.CounterMetricValue(14), *counter.Delta)
+
+		gauge, err := reopened.Get(ctx, "g")
+		require.NoError(t, err)
+		assert.Equal(t, service.GaugeMetricValue(3.25), *gauge.Value)
+	})
+
+	t.Run("Error: Type change is rejected", func(t *testing.T) {
+		storage := &SQLiteStorage{Path: filepath.Join(t.TempDir(), "metrix.db")}
+		require.NoError(t, storage.NewStorage())
+		defer storage.FreeStorage()
+
+		value := service.GaugeMetricValue(1)
+		delta := service.CounterMetricValue(2)
+		require.NoError(t, storage.Save(ctx, service.Metrics{ID: "m", MType: service.GaugeMetric, Value: &value}))
+		err := storage.Save(ctx, service.Metrics{ID: "m", MType: service.CounterMetric, Delta: &delta})
+		assert.ErrorIs(t, err, service.ErrTypeConflict)
+
+		batch := []service.Metrics{
+			{ID: "n", MType: service.GaugeMetric, Value: &value},
+			{ID: "m", MType: service.CounterMetric, Delta: &delta},
+		}
+		assert.ErrorIs(t, storage.SaveAll(ctx, &batch), service.ErrTypeConflict)
+		_, err = storage.Get(ctx, "n")
+		assert.Equal(t, service.ErrUnknownMetric, err)
+
+		metric, err := storage.Get(ctx, "m")
+		require.NoError(t, err)
+		assert.Equal(t, service.GaugeMetric, metric.MType)
+		assert.Equal(t, service.GaugeMetricValue(1), *metric.Value)
+		assert.Nil(t, metric.Delta)
+	})
+}
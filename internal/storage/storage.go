@@ -0,0 +1,100 @@
+// Package storage предоставляет реализации хранилищ метрик для различных типов данных.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// MetricStorage представляет интерфейс для работы с хранилищем метрик.
+//
+// Он определяет набор методов для сохранения, получения и управления метриками.
+// Реализации этого интерфейса могут использовать различные типы хранилищ,
+// такие как база данных, файловое хранилище или оперативная память.
+//
+// Методы:
+// - Save: Сохраняет одну метрику в хранилище.
+// - SaveAll: Сохраняет массив метрик в хранилище.
+// - Get: Получает метрику по её имени.
+// - List: Возвращает все метрики в виде мапы, где ключ — имя метрики.
+// - ListSlice: Возвращает все метрики в виде слайса.
+// - ListPage: Возвращает страницу метрик, отобранных и упорядоченных по ListQuery.
+// - Delete: Удаляет метрики по именам и возвращает число удаленных.
+// - DeleteWhere: Удаляет метрики по именам, удовлетворяющие DeleteFilter, и возвращает число удаленных.
+// - Reset: Обнуляет счетчики по именам, не удаляя их, и возвращает число обнуленных (gauge пропускаются).
+// - Replace: Атомарно записывает метрику вместо сохраненной с тем же именем, в том числе другого типа.
+// - NewStorage: Инициализирует хранилище.
+// - FreeStorage: Освобождает ресурсы, связанные с хранилищем.
+// - CheckStorage: Проверяет доступность хранилища.
+type MetricStorage interface {
+	Save(ctx context.Context, mt service.Metrics) error
+	SaveAll(ctx context.Context, mt *[]service.Metrics) error
+	Get(ctx context.Context, metricName string) (*service.Metrics, error)
+	List(ctx context.Context) (*map[string]service.Metrics, error)
+	ListSlice(ctx context.Context) ([]service.Metrics, error)
+	ListPage(ctx context.Context, q ListQuery) (*Page, error)
+	Delete(ctx context.Context, metricNames ...string) (int, error)
+	DeleteWhere(ctx context.Context, f DeleteFilter, metricNames ...string) (int, error)
+	Reset(ctx context.Context, metricNames ...string) (int, error)
+	Replace(ctx context.Context, mt service.Metrics) error
+	NewStorage() error
+	FreeStorage() error
+	CheckStorage(ctx context.Context) error
+}
+
+// DeleteFilter — условие удаления для DeleteWhere. Хранилище проверяет его атомарно
+// с самим удалением, поэтому метрика, изменившаяся после чтения, не удаляется по
+// устаревшим данным. Нулевое значение не ограничивает удаление.
+//
+// Поля:
+//   - MType: Тип удаляемых метрик; метрики другого типа остаются. Пустой — любой тип.
+//   - UpdatedBefore: Удаляются только метрики, обновленные раньше этого момента;
+//     нулевое время не ограничивает удаление.
+type DeleteFilter struct {
+	MType         service.MetricType
+	UpdatedBefore time.Time
+}
+
+// match сообщает, удовлетворяет ли метрика условию f.
+func (f DeleteFilter) match(mt service.Metrics) bool {
+	if f.MType != "" && mt.MType != f.MType {
+		return false
+	}
+	return f.UpdatedBefore.IsZero() || mt.UpdatedAt != nil && mt.UpdatedAt.Before(f.UpdatedBefore)
+}
+
+// updatedBefore возвращает аргумент UpdatedBefore для запросов PostgreSQL: NULL, если
+// время не задано.
+func (f DeleteFilter) updatedBefore() any {
+	if f.UpdatedBefore.IsZero() {
+		return nil
+	}
+	return f.UpdatedBefore
+}
+
+// Listener — хранилище, которое сообщает об изменениях метрик, сделанных любыми
+// экземплярами сервера, работающими с тем же хранилищем. Listen блокируется до отмены ctx
+// или ошибки и вызывает handler с id измененных метрик; nil означает «изменилось все».
+type Listener interface {
+	Listen(ctx context.Context, handler func(ids []string)) error
+}
+
+// IsUnavailable сообщает, вызвана ли ошибка недоступностью хранилища (оно не инициализировано
+// или потеряно соединение с базой данных), а не содержимым запроса. Такие операции
+// имеет смысл повторить позже.
+func IsUnavailable(err error) bool {
+	var connectErr *pgconn.ConnectError
+	return errors.Is(err, service.ErrUninitializedStorage) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.As(err, &connectErr) ||
+		isPgTransportError(err)
+}
+
+//mockgen -source=internal/storage/storage.go -destination=internal/mocks/mock_storage.go -package=mocks
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dvkhr/metrix.git/internal/logging"
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conformanceStorage — набор методов, общий для всех хранилищ (см. handlers.MetricStorage).
+type conformanceStorage interface {
+	NewStorage() error
+	Save(ctx context.Context, mt service.Metrics) error
+	SaveAll(ctx context.Context, mt *[]service.Metrics) error
+	Get(ctx context.Context, metricName string) (*service.Metrics, error)
+	List(ctx context.Context) (*map[string]service.Metrics, error)
+	ListSlice(ctx context.Context) ([]service.Metrics, error)
+	ListPage(ctx context.Context, q ListQuery) (*Page, error)
+	Delete(ctx context.Context, metricNames ...string) (int, error)
+	DeleteWhere(ctx context.Context, f DeleteFilter, metricNames ...string) (int, error)
+	Reset(ctx context.Context, metricNames ...string) (int, error)
+	Replace(ctx context.Context, mt service.Metrics) error
+	FreeStorage() error
+	CheckStorage(ctx context.Context) error
+}
+
+// conformanceFactories возвращает конструкторы всех локальных хранилищ.
+func conformanceFactories() map[string]func(t *testing.T) conformanceStorage {
+	return map[string]func(t *testing.T) conformanceStorage{
+		"MemStorage": func(t *testing.T) conformanceStorage {
+			return &MemStorage{}
+		},
+		"SnapshotStorage": func(t *testing.T) conformanceStorage {
+			return &SnapshotStorage{FileStoragePath: filepath.Join(t.TempDir(), "metrics.json")}
+		},
+		"FileStorage": func(t *testing.T) conformanceStorage {
+			return &FileStorage{FileStoragePath: filepath.Join(t.TempDir(), "metrics.json")}
+		},
+		"SQLiteStorage": func(t *testing.T) conformanceStorage {
+			return &SQLiteStorage{Path: filepath.Join(t.TempDir(), "metrix.db")}
+		},
+	}
+}
+
+// TestStorageConformance проверяет одинаковое поведение всех локальных хранилищ.
+func TestStorageConformance(t *testing.T) {
+	require.NoError(t, logging.InitTestLogger())
+
+	ctx := context.Background()
+	for name, factory := range conformanceFactories() {
+		t.Run(name, func(t *testing.T) {
+			storage := factory(t)
+			require.NoError(t, storage.NewStorage())
+			defer storage.FreeStorage()
+			require.NoError(t, storage.CheckStorage(ctx))
+
+			delta := service.CounterMetricValue(3)
+			value := service.GaugeMetricValue(2.5)
+			require.NoError(t, storage.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+			require.NoError(t, storage.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+			require.NoError(t, storage.Save(ctx, service.Metrics{ID: "g", MType: service.GaugeMetric, Value: &value}))
+
+			counter, err := storage.Get(ctx, "c")
+			require.NoError(t, err)
+			assert.Equal(t, service.CounterMetricValue(6), *counter.Delta)
+			require.NotNil(t, counter.UpdatedAt)
+			assert.WithinDuration(t, time.Now(), *counter.UpdatedAt, time.Minute)
+
+			newValue := service.GaugeMetricValue(-1)
+			batch := []service.Metrics{
+				{ID: "c", MType: service.CounterMetric, Delta: &delta},
+				{ID: "g", MType: service.GaugeMetric, Value: &newValue},
+				{ID: "c2", MType: service.CounterMetric, Delta: &delta},
+				{ID: "c2", MType: service.CounterMetric, Delta: &delta},
+			}
+			require.NoError(t, storage.SaveAll(ctx, &batch))
+
+			all, err := storage.List(ctx)
+			require.NoError(t, err)
+			require.Len(t, *all, 3)
+			assert.Equal(t, service.CounterMetricValue(9), *(*all)["c"].Delta)
+			assert.Equal(t, service.CounterMetricValue(6), *(*all)["c2"].Delta)
+			assert.Equal(t, service.GaugeMetricValue(-1), *(*all)["g"].Value)
+
+			slice, err := storage.ListSlice(ctx)
+			require.NoError(t, err)
+			assert.Len(t, slice, 3)
+
+			// Запись метрики с другим типом отклоняется, пакет с конфликтом не применяется целиком.
+			err = storage.Save(ctx, service.Metrics{ID: "g", MType: service.CounterMetric, Delta: &delta})
+			assert.ErrorIs(t, err, service.ErrTypeConflict)
+			conflicting := []service.Metrics{
+				{ID: "new", MType: service.GaugeMetric, Value: &value},
+				{ID: "c", MType: service.GaugeMetric, Value: &value},
+			}
+			assert.ErrorIs(t, storage.SaveAll(ctx, &conflicting), service.ErrTypeConflict)
+			conflicting = []service.Metrics{
+				{ID: "new", MType: service.GaugeMetric, Value: &value},
+				{ID: "new", MType: service.CounterMetric, Delta: &delta},
+			}
+			assert.ErrorIs(t, storage.SaveAll(ctx, &conflicting), service.ErrTypeConflict)
+			assert.Equal(t, service.ErrInvalidMetricName, storage.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric}))
+			all, err = storage.List(ctx)
+			require.NoError(t, err)
+			require.Len(t, *all, 3)
+			assert.Equal(t, service.CounterMetricValue(9), *(*all)["c"].Delta)
+			assert.Equal(t, service.GaugeMetricValue(-1), *(*all)["g"].Value)
+
+			_, err = storage.Get(ctx, "missing")
+			assert.Equal(t, service.ErrUnknownMetric, err)
+			_, err = storage.Get(ctx, "")
+			assert.Equal(t, service.ErrInvalidMetricName, err)
+			assert.Equal(t, service.ErrInvalidMetricName, storage.Save(ctx, service.Metrics{MType: service.GaugeMetric, Value: &value}))
+
+			// Обнуляются только существующие счетчики; gauge и неизвестные имена пропускаются.
+			n, err := storage.Reset(ctx, "c", "c", "g", "missing")
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+			counter, err = storage.Get(ctx, "c")
+			require.NoError(t, err)
+			assert.Equal(t, service.CounterMetricValue(0), *counter.Delta)
+			gauge, err := storage.Get(ctx, "g")
+			require.NoError(t, err)
+			assert.Equal(t, service.GaugeMetricValue(-1), *gauge.Value)
+
+			require.NoError(t, storage.Save(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+			counter, err = storage.Get(ctx, "c")
+			require.NoError(t, err)
+			assert.Equal(t, service.CounterMetricValue(3), *counter.Delta)
+
+			// Замена меняет тип и не учитывает накопленное значение; некорректная замена
+			// отклоняется, не затрагивая сохраненную метрику.
+			replaced := service.GaugeMetricValue(7)
+			require.NoError(t, storage.Replace(ctx, service.Metrics{ID: "c", MType: service.GaugeMetric, Value: &replaced}))
+			gauge, err = storage.Get(ctx, "c")
+			require.NoError(t, err)
+			assert.Equal(t, service.GaugeMetric, gauge.MType)
+			assert.Equal(t, service.GaugeMetricValue(7), *gauge.Value)
+			assert.Nil(t, gauge.Delta)
+			require.NoError(t, storage.Replace(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+			assert.Equal(t, service.ErrInvalidMetricName, storage.Replace(ctx, service.Metrics{ID: "c", MType: service.GaugeMetric}))
+			counter, err = storage.Get(ctx, "c")
+			require.NoError(t, err)
+			assert.Equal(t, service.CounterMetric, counter.MType)
+			assert.Equal(t, service.CounterMetricValue(3), *counter.Delta)
+			assert.Nil(t, counter.Value)
+
+			n, err = storage.Delete(ctx, "c2", "g", "missing")
+			require.NoError(t, err)
+			assert.Equal(t, 2, n)
+			_, err = storage.Get(ctx, "g")
+			assert.Equal(t, service.ErrUnknownMetric, err)
+			slice, err = storage.ListSlice(ctx)
+			require.NoError(t, err)
+			assert.Len(t, slice, 1)
+
+			// Метрика другого типа не удаляется DeleteWhere с фильтром по типу.
+			n, err = storage.DeleteWhere(ctx, DeleteFilter{MType: service.GaugeMetric}, "c")
+			require.NoError(t, err)
+			assert.Equal(t, 0, n)
+			_, err = storage.Get(ctx, "c")
+			require.NoError(t, err)
+			// Метрика, обновленная позже границы UpdatedBefore, тоже остается.
+			n, err = storage.DeleteWhere(ctx, DeleteFilter{UpdatedBefore: time.Now().Add(-time.Hour)}, "c")
+			require.NoError(t, err)
+			assert.Equal(t, 0, n)
+			n, err = storage.DeleteWhere(ctx, DeleteFilter{UpdatedBefore: time.Now().Add(time.Hour)}, "c")
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+			require.NoError(t, storage.Replace(ctx, service.Metrics{ID: "c", MType: service.CounterMetric, Delta: &delta}))
+			n, err = storage.DeleteWhere(ctx, DeleteFilter{MType: service.CounterMetric}, "c")
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+			_, err = storage.Get(ctx, "c")
+			assert.Equal(t, service.ErrUnknownMetric, err)
+
+			n, err = storage.Delete(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 0, n)
+			_, err = storage.Delete(ctx, "")
+			assert.Equal(t, service.ErrInvalidMetricName, err)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Package stream рассылает обновления метрик подписчикам, например клиентам
+// GET "/stream" (Server-Sent Events).
+//
+// Обработчики публикуют метрики в Hub после успешной записи в хранилище. Рассылка не
+// блокирует запись: если подписчик не успевает читать, новые обновления для него
+// отбрасываются и учитываются в метрике самонаблюдения metrix_stream_dropped_total.
+// Поток видит только записи, прошедшие через этот сервер; обновления, сделанные другими
+// серверами с общей базой, в него не попадают.
+package stream
+
+import (
+	"sync"
+
+	"github.com/dvkhr/metrix.git/internal/selfmetrics"
+	"github.com/dvkhr/metrix.git/internal/service"
+)
+
+// DefaultBuffer — число обновлений, которое подписчик может не прочитать, прежде чем
+// новые обновления для него начнут отбрасываться.
+const DefaultBuffer = 256
+
+// Метрики самонаблюдения потока обновлений.
+var (
+	subscribersGauge = selfmetrics.Default.Gauge("metrix_stream_subscribers",
+		"Number of active metric stream subscribers.")
+	droppedUpdates = selfmetrics.Default.Counter("metrix_stream_dropped_total",
+		"Number of metric updates dropped because a stream subscriber was too slow.")
+)
+
+// Hub рассылает опубликованные метрики подписчикам. Нулевое значение не используется,
+// хаб создается через NewHub. Методы Publish и HasSubscribers допускают nil-хаб,
+// чтобы сервер без потока обновлений работал без проверок. Безопасен для одновременного
+// использования.
+type Hub struct {
+	buffer int
+
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewHub создает хаб, в котором у каждого подписчика буфер на buffer обновлений;
+// при buffer <= 0 используется DefaultBuffer.
+func NewHub(buffer int) *Hub {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &Hub{buffer: buffer, subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription — подписка на обновления метрик. Обновления, прошедшие фильтр подписки,
+// приходят в канал C; канал закрывается после Close подписки или хаба.
+type Subscription struct {
+	C <-chan service.Metrics
+
+	hub   *Hub
+	ch    chan service.Metrics
+	match func(service.Metrics) bool
+}
+
+// Subscribe подписывается на обновления метрик, для которых match возвращает true;
+// при match == nil подписка получает все обновления. Подписка на закрытый хаб
+// возвращается с уже закрытым каналом.
+func (h *Hub) Subscribe(match func(service.Metrics) bool) *Subscription {
+	ch := make(chan service.Metrics, h.buffer)
+	s := &Subscription{C: ch, hub: h, ch: ch, match: match}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return s
+	}
+	h.subs[s] = struct{}{}
+	subscribersGauge.Add(1)
+	return s
+}
+
+// Close отменяет подписку и закрывает ее канал. Повторный вызов ничего не делает.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; !ok {
+		return
+	}
+	delete(s.hub.subs, s)
+	subscribersGauge.Add(-1)
+	close(s.ch)
+}
+
+// HasSubscribers сообщает, есть ли у хаба подписчики. Позволяет не читать сохраненные
+// метрики из хранилища, когда публиковать их некому.
+func (h *Hub) HasSubscribers() bool {
+	if h == nil {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs) > 0
+}
+
+// Publish рассылает метрики подписчикам, фильтр которых их пропускает. Не блокируется:
+// если буфер подписчика заполнен, обновление для него отбрасывается.
+func (h *Hub) Publish(metrics ...service.Metrics) {
+	if h == nil {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subs {
+		for _, mt := range metrics {
+			if s.match != nil && !s.match(mt) {
+				continue
+			}
+			select {
+			case s.ch <- mt:
+			default:
+				droppedUpdates.Inc()
+			}
+		}
+	}
+}
+
+// Close закрывает каналы всех подписок; новые подписки сразу получают закрытый канал.
+// Вызывается при остановке сервера, чтобы открытые потоки завершились.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		subscribersGauge.Add(-1)
+		close(s.ch)
+	}
+}
@@ -0,0 +1,93 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(id string, v float64) service.Metrics {
+	value := service.GaugeMetricValue(v)
+	return service.Metrics{ID: id, MType: service.GaugeMetric, Value: &value}
+}
+
+func counter(id string, d int64) service.Metrics {
+	delta := service.CounterMetricValue(d)
+	return service.Metrics{ID: id, MType: service.CounterMetric, Delta: &delta}
+}
+
+// drain возвращает метрики, уже лежащие в канале подписки.
+func drain(s *Subscription) []service.Metrics {
+	var got []service.Metrics
+	for {
+		select {
+		case mt, ok := <-s.C:
+			if !ok {
+				return got
+			}
+			got = append(got, mt)
+		default:
+			return got
+		}
+	}
+}
+
+func TestHub(t *testing.T) {
+	t.Run("Delivers updates to matching subscribers", func(t *testing.T) {
+		h := NewHub(0)
+		all := h.Subscribe(nil)
+		counters := h.Subscribe(func(mt service.Metrics) bool { return mt.MType == service.CounterMetric })
+		assert.True(t, h.HasSubscribers())
+
+		h.Publish(gauge("Alloc", 1), counter("PollCount", 5))
+
+		assert.Equal(t, []service.Metrics{gauge("Alloc", 1), counter("PollCount", 5)}, drain(all))
+		assert.Equal(t, []service.Metrics{counter("PollCount", 5)}, drain(counters))
+	})
+
+	t.Run("Drops updates for slow subscribers", func(t *testing.T) {
+		h := NewHub(2)
+		s := h.Subscribe(nil)
+		dropped := droppedUpdates.Value()
+
+		h.Publish(gauge("a", 1), gauge("b", 2), gauge("c", 3))
+
+		assert.Equal(t, []service.Metrics{gauge("a", 1), gauge("b", 2)}, drain(s))
+		assert.Equal(t, dropped+1, droppedUpdates.Value())
+	})
+
+	t.Run("Close unsubscribes", func(t *testing.T) {
+		h := NewHub(0)
+		s := h.Subscribe(nil)
+		s.Close()
+		s.Close()
+
+		assert.False(t, h.HasSubscribers())
+		_, ok := <-s.C
+		assert.False(t, ok)
+		h.Publish(gauge("a", 1))
+	})
+
+	t.Run("Hub close ends subscriptions", func(t *testing.T) {
+		h := NewHub(0)
+		s := h.Subscribe(nil)
+		h.Close()
+
+		_, ok := <-s.C
+		assert.False(t, ok)
+		s.Close()
+
+		late := h.Subscribe(nil)
+		_, ok = <-late.C
+		assert.False(t, ok)
+		assert.False(t, h.HasSubscribers())
+	})
+
+	t.Run("Nil hub", func(t *testing.T) {
+		var h *Hub
+		require.NotPanics(t, func() { h.Publish(gauge("a", 1)) })
+		assert.False(t, h.HasSubscribers())
+	})
+}
@@ -0,0 +1,174 @@
+// Package tracing предоставляет экспорт трассировок OpenTelemetry по протоколу OTLP/HTTP.
+//
+// Трассировка выключена по умолчанию. Пока Init не вызван с непустым адресом коллектора,
+// Start и Middleware не создают спанов и не выделяют память, поэтому инструментированный
+// код ничего не стоит в режиме без трассировки.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName — имя библиотеки инструментирования в экспортируемых спанах.
+const instrumentationName = "github.com/dvkhr/metrix.git"
+
+// defaultTracesPath — путь приема трассировок коллектором OTLP/HTTP.
+const defaultTracesPath = "/v1/traces"
+
+var (
+	enabled    atomic.Bool
+	propagator = propagation.TraceContext{}
+)
+
+// Init включает экспорт трассировок в коллектор OTLP/HTTP по адресу endpoint.
+//
+// Адрес задается в виде URL ("http://localhost:4318") или пары host:port; если путь
+// не указан, используется "/v1/traces". При пустом адресе трассировка остается выключенной.
+//
+// Возвращаемые значения:
+// - func(context.Context) error: Функция остановки, которая досылает накопленные спаны.
+// - error: Ошибка, если адрес некорректен или экспортер не удалось создать.
+func Init(ctx context.Context, endpoint, serviceName string) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	endpointURL, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpointURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	enabled.Store(true)
+
+	return func(ctx context.Context) error {
+		enabled.Store(false)
+		return provider.Shutdown(ctx)
+	}, nil
+}
+
+// parseEndpoint приводит адрес коллектора к полному URL с путем приема трассировок.
+func parseEndpoint(endpoint string) (string, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultTracesPath
+	}
+	return u.String(), nil
+}
+
+// Enabled сообщает, включен ли экспорт трассировок.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Start начинает дочерний спан с указанным именем.
+// Если трассировка выключена, возвращает исходный контекст и спан из него (no-op).
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !enabled.Load() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End завершает спан, отмечая его ошибкой, если err не nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// statusRecorder — обертка для ResponseWriter, которая запоминает HTTP-статус ответа.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+// WriteHeader перехватывает вызов WriteHeader для записи статуса.
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	if sr.statusCode == 0 {
+		sr.statusCode = statusCode
+	}
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap возвращает исходный ResponseWriter для http.ResponseController.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// Middleware создает серверный спан для каждого HTTP-запроса.
+//
+// Родительский контекст берется из заголовка traceparent, если клиент его передал.
+// Имя спана формируется из метода и шаблона маршрута chi после обработки запроса.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !enabled.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			))
+		defer span.End()
+
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r.WithContext(ctx))
+
+		if sr.statusCode == 0 {
+			sr.statusCode = http.StatusOK
+		}
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName("HTTP " + r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(sr.statusCode))
+		if sr.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sr.statusCode))
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		expected string
+		wantErr  bool
+	}{
+		{endpoint: "localhost:4318", expected: "http://localhost:4318/v1/traces"},
+		{endpoint: "http://collector:4318/", expected: "http://collector:4318/v1/traces"},
+		{endpoint: "https://collector/custom/path", expected: "https://collector/custom/path"},
+		{endpoint: "http://", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			got, err := parseEndpoint(tt.endpoint)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	shutdown, err := Init(context.Background(), "", "test")
+	require.NoError(t, err)
+	defer shutdown(context.Background())
+
+	assert.False(t, Enabled())
+
+	ctx := context.Background()
+	spanCtx, span := Start(ctx, "noop")
+	assert.Equal(t, ctx, spanCtx)
+	assert.False(t, span.IsRecording())
+	End(span, errors.New("ignored"))
+}
+
+func TestExportToCollectorStub(t *testing.T) {
+	var received atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" && r.Method == http.MethodPost {
+			received.Add(1)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	shutdown, err := Init(context.Background(), collector.URL, "metrix-test")
+	require.NoError(t, err)
+	assert.True(t, Enabled())
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/value/{type}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "child")
+		assert.True(t, span.SpanContext().IsValid())
+		End(span, nil)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/value/gauge/x", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	require.NoError(t, shutdown(context.Background()))
+	assert.False(t, Enabled())
+	assert.GreaterOrEqual(t, received.Load(), int32(1))
+}
@@ -0,0 +1,157 @@
+// Package validation проверяет метрики, полученные от клиентов, до записи в хранилище.
+//
+// Метрика считается корректной, если:
+//   - имя не пустое, не длиннее MaxNameLength байт и состоит из латинских букв, цифр
+//     и символов "_", ".", "-", ":" (пробелы и символы шаблонов "*", "?", "[" недопустимы);
+//   - тип — gauge или counter;
+//   - у gauge задано только поле value, у counter — только delta;
+//   - значение gauge конечно (не NaN и не ±Inf);
+//   - приращение counter неотрицательно: счетчик только растет, а обнуляется явно
+//     через административный маршрут /reset/.
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+)
+
+// MaxNameLength — наибольшая длина имени метрики в байтах; совпадает с шириной
+// столбца id в схеме PostgreSQL.
+const MaxNameLength = 255
+
+var (
+	// ErrInvalidName возвращается для пустого, слишком длинного имени или имени с недопустимыми символами.
+	ErrInvalidName = errors.New("invalid metric name")
+
+	// ErrInvalidType возвращается, если тип метрики не gauge и не counter.
+	ErrInvalidType = errors.New("invalid metric type")
+
+	// ErrMissingValue возвращается, если не задано поле значения, соответствующее типу.
+	ErrMissingValue = errors.New("missing metric value")
+
+	// ErrUnexpectedValue возвращается, если задано поле значения другого типа.
+	ErrUnexpectedValue = errors.New("unexpected metric value")
+
+	// ErrNonFiniteValue возвращается для значения gauge, равного NaN или ±Inf.
+	ErrNonFiniteValue = errors.New("gauge value is not finite")
+
+	// ErrNegativeDelta возвращается для отрицательного приращения counter.
+	ErrNegativeDelta = errors.New("counter delta is negative")
+)
+
+// Name проверяет имя метрики.
+func Name(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidName)
+	}
+	if len(name) > MaxNameLength {
+		return fmt.Errorf("%w: name is longer than %d bytes", ErrInvalidName, MaxNameLength)
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i]) {
+			return fmt.Errorf("%w %q: character %q is not allowed", ErrInvalidName, name, name[i])
+		}
+	}
+	return nil
+}
+
+func isNameChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	case c == '_', c == '.', c == '-', c == ':':
+		return true
+	}
+	return false
+}
+
+// Metric проверяет метрику по правилам пакета и возвращает первую найденную ошибку.
+func Metric(mt service.Metrics) error {
+	if err := Name(mt.ID); err != nil {
+		return err
+	}
+	switch mt.MType {
+	case service.GaugeMetric:
+		switch {
+		case mt.Value == nil:
+			return fmt.Errorf("%w: gauge requires value", ErrMissingValue)
+		case mt.Delta != nil:
+			return fmt.Errorf("%w: gauge must not have delta", ErrUnexpectedValue)
+		case math.IsNaN(float64(*mt.Value)) || math.IsInf(float64(*mt.Value), 0):
+			return ErrNonFiniteValue
+		}
+	case service.CounterMetric:
+		switch {
+		case mt.Delta == nil:
+			return fmt.Errorf("%w: counter requires delta", ErrMissingValue)
+		case mt.Value != nil:
+			return fmt.Errorf("%w: counter must not have value", ErrUnexpectedValue)
+		case *mt.Delta < 0:
+			return ErrNegativeDelta
+		}
+	default:
+		return fmt.Errorf("%w %q", ErrInvalidType, mt.MType)
+	}
+	return nil
+}
+
+// Error — ошибка проверки одной метрики пакета.
+//
+// Поля:
+//   - Index: Позиция метрики в пакете (с нуля).
+//   - ID: Имя метрики в том виде, в котором оно пришло от клиента.
+//   - Err: Причина отклонения; сравнивается с ошибками пакета через errors.Is.
+type Error struct {
+	Index int
+	ID    string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("metric #%d %q: %v", e.Index, e.ID, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// MarshalJSON представляет ошибку для ответа клиенту: {"index": 0, "id": "...", "error": "..."}.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Index int    `json:"index"`
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	}{e.Index, e.ID, e.Err.Error()})
+}
+
+// Errors — ошибки проверки пакета метрик в порядке следования метрик.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	switch len(e) {
+	case 0:
+		return "no errors"
+	case 1:
+		return e[0].Error()
+	default:
+		return fmt.Sprintf("%v (and %d more)", e[0], len(e)-1)
+	}
+}
+
+// Batch проверяет пакет метрик. Возвращает корректные метрики в исходном порядке
+// и ошибки отклоненных; если все метрики корректны, errs пуст.
+func Batch(metrics []service.Metrics) (valid []service.Metrics, errs Errors) {
+	valid = make([]service.Metrics, 0, len(metrics))
+	for i, mt := range metrics {
+		if err := Metric(mt); err != nil {
+			errs = append(errs, &Error{Index: i, ID: mt.ID, Err: err})
+			continue
+		}
+		valid = append(valid, mt)
+	}
+	return valid, errs
+}
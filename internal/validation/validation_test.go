@@ -0,0 +1,95 @@
+package validation
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/dvkhr/metrix.git/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauge(id string, v float64) service.Metrics {
+	value := service.GaugeMetricValue(v)
+	return service.Metrics{ID: id, MType: service.GaugeMetric, Value: &value}
+}
+
+func counter(id string, d int64) service.Metrics {
+	delta := service.CounterMetricValue(d)
+	return service.Metrics{ID: id, MType: service.CounterMetric, Delta: &delta}
+}
+
+func TestMetric(t *testing.T) {
+	bothFields := gauge("g", 1)
+	bothFields.Delta = counter("c", 1).Delta
+	counterWithValue := counter("c", 1)
+	counterWithValue.Value = gauge("g", 1).Value
+
+	tests := []struct {
+		name string
+		mt   service.Metrics
+		err  error
+	}{
+		{name: "Gauge", mt: gauge("Alloc", 1.5)},
+		{name: "Counter", mt: counter("PollCount", 0)},
+		{name: "Name charset", mt: gauge("http.requests:total-2_x", 1)},
+		{name: "Max name length", mt: gauge(strings.Repeat("a", MaxNameLength), 1)},
+		{name: "Empty name", mt: gauge("", 1), err: ErrInvalidName},
+		{name: "Long name", mt: gauge(strings.Repeat("a", MaxNameLength+1), 1), err: ErrInvalidName},
+		{name: "Space in name", mt: gauge("heap alloc", 1), err: ErrInvalidName},
+		{name: "Pattern in name", mt: gauge("requests_*", 1), err: ErrInvalidName},
+		{name: "Non-ASCII name", mt: gauge("память", 1), err: ErrInvalidName},
+		{name: "Unknown type", mt: service.Metrics{ID: "h", MType: "histogram"}, err: ErrInvalidType},
+		{name: "Gauge without value", mt: service.Metrics{ID: "g", MType: service.GaugeMetric}, err: ErrMissingValue},
+		{name: "Counter without delta", mt: service.Metrics{ID: "c", MType: service.CounterMetric}, err: ErrMissingValue},
+		{name: "Gauge with delta", mt: bothFields, err: ErrUnexpectedValue},
+		{name: "Counter with value", mt: counterWithValue, err: ErrUnexpectedValue},
+		{name: "NaN", mt: gauge("g", math.NaN()), err: ErrNonFiniteValue},
+		{name: "Inf", mt: gauge("g", math.Inf(-1)), err: ErrNonFiniteValue},
+		{name: "Negative delta", mt: counter("c", -1), err: ErrNegativeDelta},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Metric(tt.mt)
+			if tt.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	metrics := []service.Metrics{
+		gauge("a", 1),
+		counter("bad name", 1),
+		counter("c", 2),
+		gauge("d", math.NaN()),
+	}
+
+	valid, errs := Batch(metrics)
+	require.Len(t, valid, 2)
+	assert.Equal(t, "a", valid[0].ID)
+	assert.Equal(t, "c", valid[1].ID)
+
+	require.Len(t, errs, 2)
+	assert.Equal(t, 1, errs[0].Index)
+	assert.ErrorIs(t, errs[0], ErrInvalidName)
+	assert.Equal(t, 3, errs[1].Index)
+	assert.ErrorIs(t, errs[1], ErrNonFiniteValue)
+	assert.Contains(t, errs.Error(), "and 1 more")
+
+	body, err := json.Marshal(errs)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"index": 1, "id": "bad name", "error": "invalid metric name \"bad name\": character ' ' is not allowed"},
+		{"index": 3, "id": "d", "error": "gauge value is not finite"}
+	]`, string(body))
+
+	valid, errs = Batch(metrics[:1])
+	assert.Len(t, valid, 1)
+	assert.Empty(t, errs)
+}